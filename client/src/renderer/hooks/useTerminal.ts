@@ -158,6 +158,21 @@ export function useTerminal(sessionId: string | null) {
             },
             () => {
               ws.sendResize(terminal.cols, terminal.rows)
+            },
+            (msg) => {
+              if (msg.type === 'exit') {
+                terminal.write(`\r\n\x1b[2m[process exited with code ${msg.exitCode ?? 0}]\x1b[0m\r\n`)
+              } else if (msg.type === 'worker' && msg.event === 'offline') {
+                terminal.write('\r\n\x1b[33m[worker offline, waiting for it to reconnect]\x1b[0m\r\n')
+              } else if (msg.type === 'resume') {
+                terminal.write('\r\n\x1b[2m[resuming session]\x1b[0m\r\n')
+              } else if (msg.type === 'timeout' && msg.event === 'warning') {
+                const minutes = Math.max(1, Math.round((msg.remaining ?? 0) / 60))
+                const what = msg.reason === 'idle' ? 'idle timeout' : 'maximum lifetime'
+                terminal.write(`\r\n\x1b[33m[session reaches its ${what} in about ${minutes} min]\x1b[0m\r\n`)
+              } else if (msg.type === 'timeout' && msg.event === 'expired') {
+                terminal.write('\r\n\x1b[33m[session timed out, stopping it]\x1b[0m\r\n')
+              }
             }
           )
           ws.connect()
@@ -112,5 +112,14 @@ export const api = {
     }
     const token = await getAccessToken()
     return `ws://localhost:8082/api/sessions/${sessionId}/terminal?token=${token}`
+  },
+
+  getMuxWSUrl: async (): Promise<string> => {
+    const res = await request('/sessions')
+    if (!res.ok) {
+      throw new Error('Failed to refresh token for terminal connection')
+    }
+    const token = await getAccessToken()
+    return `ws://localhost:8082/api/terminals?token=${token}`
   }
 }
@@ -0,0 +1,110 @@
+import type { ControlMessage } from './ws'
+
+// Each binary frame on the multiplexed connection starts with a 4-byte big-endian channel ID
+const HEADER_SIZE = 4
+
+export type ChannelHandlers = {
+  onData: (data: Uint8Array) => void
+  onControl?: (msg: ControlMessage) => void
+  onClose?: () => void
+}
+
+type MuxMessage = ControlMessage & {
+  channel?: number
+  sessionId?: string
+  code?: number
+  reason?: string
+  error?: string
+}
+
+// MuxTerminalSocket carries many terminal sessions over one WebSocket.
+// Each subscription gets its own channel ID.
+export class MuxTerminalSocket {
+  private ws: WebSocket | null = null
+  private nextChannel = 1
+  private channels = new Map<number, { sessionId: string; handlers: ChannelHandlers }>()
+
+  constructor(private url: string) {}
+
+  connect(): Promise<void> {
+    return new Promise((resolve, reject) => {
+      this.ws = new WebSocket(this.url)
+      this.ws.binaryType = 'arraybuffer'
+      this.ws.onopen = () => {
+        // Re-attach existing subscriptions after a reconnect
+        for (const [channel, { sessionId }] of this.channels) {
+          this.sendJSON({ type: 'subscribe', channel, sessionId })
+        }
+        resolve()
+      }
+      this.ws.onerror = () => reject(new Error('terminal connection failed'))
+      this.ws.onmessage = (event) => this.dispatch(event.data)
+      this.ws.onclose = () => {
+        for (const { handlers } of this.channels.values()) {
+          handlers.onClose?.()
+        }
+      }
+    })
+  }
+
+  subscribe(sessionId: string, handlers: ChannelHandlers): number {
+    const channel = this.nextChannel++
+    this.channels.set(channel, { sessionId, handlers })
+    this.sendJSON({ type: 'subscribe', channel, sessionId })
+    return channel
+  }
+
+  unsubscribe(channel: number): void {
+    this.channels.delete(channel)
+    this.sendJSON({ type: 'unsubscribe', channel })
+  }
+
+  sendInput(channel: number, data: string): void {
+    const payload = new TextEncoder().encode(data)
+    const frame = new Uint8Array(HEADER_SIZE + payload.length)
+    new DataView(frame.buffer).setUint32(0, channel)
+    frame.set(payload, HEADER_SIZE)
+    if (this.ws?.readyState === WebSocket.OPEN) {
+      this.ws.send(frame.buffer)
+    }
+  }
+
+  sendResize(channel: number, cols: number, rows: number): void {
+    this.sendJSON({ type: 'resize', channel, cols, rows })
+  }
+
+  disconnect(): void {
+    this.channels.clear()
+    this.ws?.close()
+    this.ws = null
+  }
+
+  private sendJSON(msg: object): void {
+    if (this.ws?.readyState === WebSocket.OPEN) {
+      this.ws.send(JSON.stringify(msg))
+    }
+  }
+
+  private dispatch(data: ArrayBuffer | string): void {
+    if (typeof data === 'string') {
+      let msg: MuxMessage
+      try {
+        msg = JSON.parse(data)
+      } catch {
+        return
+      }
+      const entry = msg.channel ? this.channels.get(msg.channel) : undefined
+      if (!entry) return
+      if (msg.type === 'closed') {
+        this.channels.delete(msg.channel!)
+        entry.handlers.onClose?.()
+        return
+      }
+      entry.handlers.onControl?.(msg)
+      return
+    }
+    if (data.byteLength < HEADER_SIZE) return
+    const channel = new DataView(data).getUint32(0)
+    this.channels.get(channel)?.handlers.onData(new Uint8Array(data, HEADER_SIZE))
+  }
+}
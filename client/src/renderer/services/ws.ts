@@ -1,3 +1,22 @@
+export type ViewerInfo = {
+  id: string
+  userId: string
+  device: string
+  connectedAt: string
+}
+
+export type ControlMessage = {
+  type: 'presence' | 'status' | 'exit' | 'worker' | 'resume' | 'timeout' | string
+  event?: string
+  status?: string
+  exitCode?: number
+  workerId?: string
+  reason?: string
+  remaining?: number
+  viewer?: ViewerInfo
+  viewers?: ViewerInfo[]
+}
+
 export class TerminalWebSocket {
   private ws: WebSocket | null = null
   private reconnectAttempts = 0
@@ -8,7 +27,8 @@ export class TerminalWebSocket {
     private url: string,
     private onData: (data: ArrayBuffer) => void,
     private onClose?: () => void,
-    private onOpen?: () => void
+    private onOpen?: () => void,
+    private onControl?: (msg: ControlMessage) => void
   ) {}
 
   connect(): void {
@@ -21,10 +41,22 @@ export class TerminalWebSocket {
     }
 
     this.ws.onmessage = (event) => {
+      // PTY output arrives as binary frames; text frames are JSON control messages
+      if (typeof event.data === 'string') {
+        try {
+          this.onControl?.(JSON.parse(event.data))
+        } catch {}
+        return
+      }
       this.onData(event.data)
     }
 
-    this.ws.onclose = () => {
+    this.ws.onclose = (event) => {
+      // 4xxx codes mean the server ended the session for good (e.g. it was deleted)
+      if (event.code >= 4000 && event.code < 5000) {
+        this.onClose?.()
+        return
+      }
       this.tryReconnect()
     }
 
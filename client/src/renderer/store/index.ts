@@ -6,8 +6,12 @@ export interface Session {
   status: 'creating' | 'running' | 'stopped' | 'error' | 'offline'
   sessionType?: 'worker' | 'container'
   workDir?: string
+  folder?: string
+  tags?: string[]
   claudeSessionId?: string
   createdAt: string
+  viewerCount?: number
+  activity?: 'busy' | 'idle' | 'awaiting-input'
 }
 
 const LOCAL_SESSIONS_KEY = 'moltty:local-sessions'
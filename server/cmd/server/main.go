@@ -13,8 +13,14 @@ import (
 	"github.com/moltty/server/internal/config"
 	"github.com/moltty/server/internal/container"
 	"github.com/moltty/server/internal/database"
+	"github.com/moltty/server/internal/events"
+	"github.com/moltty/server/internal/metrics"
+	"github.com/moltty/server/internal/notify"
 	"github.com/moltty/server/internal/proxy"
+	"github.com/moltty/server/internal/quota"
+	"github.com/moltty/server/internal/recording"
 	"github.com/moltty/server/internal/session"
+	"github.com/moltty/server/internal/usage"
 	"github.com/moltty/server/internal/user"
 	"github.com/moltty/server/internal/worker"
 )
@@ -30,6 +36,14 @@ func main() {
 		&auth.RefreshToken{},
 		&container.WorkerNode{},
 		&worker.Worker{},
+		&notify.Preference{},
+		&notify.PushSubscription{},
+		&worker.ScrollbackChunk{},
+		&worker.ScrollbackTrim{},
+		&recording.Settings{},
+		&session.UserTimeouts{},
+		&quota.UserQuota{},
+		&usage.Interval{},
 	)
 
 	// Repositories
@@ -37,10 +51,96 @@ func main() {
 	sessionRepo := session.NewRepository(db)
 	workerPool := container.NewWorkerPool(db)
 	workerRepo := worker.NewRepository(db)
+	notifyRepo := notify.NewRepository(db)
+	recordingRepo := recording.NewRepository(db, cfg.RecordingDays)
+	quotaRepo := quota.NewRepository(db, quota.Limits{
+		MaxRunningSessions: cfg.MaxRunningSessions,
+		MaxWorkers:         cfg.MaxWorkers,
+		MaxStorageBytes:    int64(cfg.MaxStorageMB) * 1024 * 1024,
+		MaxShareLinks:      cfg.MaxShareLinks,
+	})
+	usageRepo := usage.NewRepository(db)
+	admins := user.NewAdmins(userRepo, cfg.AdminEmails)
+
+	// Notifications
+	notifier := notify.NewNotifier(notifyRepo, userRepo,
+		time.Duration(cfg.NotifyCooldown)*time.Second, cfg.NotifyMaxPerHour)
+	if cfg.SMTPHost != "" {
+		notifier.AddChannel(notify.NewEmailChannel(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPFrom))
+	}
+	var pushChannel *notify.WebPushChannel
+	if cfg.VAPIDPrivateKey != "" {
+		ch, err := notify.NewWebPushChannel(notifyRepo, cfg.VAPIDPrivateKey, cfg.VAPIDSubject)
+		if err != nil {
+			log.Fatalf("invalid VAPID_PRIVATE_KEY: %v", err)
+		}
+		pushChannel = ch
+		notifier.AddChannel(ch)
+	}
+	notifier.AddChannel(notify.NewWebhookChannel())
+
+	// Live event stream
+	broker := events.NewBroker(events.DefaultHistory)
 
 	// Worker hub
 	workerHub := worker.NewHub(workerRepo, sessionRepo, cfg.ScrollbackSize)
+	workerHub.SetEventBroker(broker)
 	workerHub.StartPingLoop(time.Duration(cfg.WorkerPingInterval) * time.Second)
+	workerHub.SetViewerReplay(cfg.ViewerReplay != "raw", cfg.ReplayHistoryLines)
+	if cfg.RelayMemoryBudget > 0 {
+		if err := workerHub.SetMemoryBudget(int64(cfg.RelayMemoryBudget), cfg.SpillDir); err != nil {
+			log.Fatalf("failed to prepare spill dir: %v", err)
+		}
+		workerHub.StartSpillLoop(5 * time.Second)
+	}
+	workerHub.StartRelayGC(time.Minute, time.Duration(cfg.RelayGraceMinutes)*time.Minute)
+	workerHub.SetOutputCoalescing(time.Duration(cfg.CoalesceDelayMs)*time.Millisecond,
+		cfg.CoalesceMaxBytes, time.Duration(cfg.InteractiveMs)*time.Millisecond)
+
+	promptPatterns, err := worker.CompilePromptPatterns(cfg.PromptPatterns)
+	if err != nil {
+		log.Fatalf("invalid ACTIVITY_PROMPT_PATTERNS: %v", err)
+	}
+	workerHub.SetActivityDetection(time.Duration(cfg.ActivityIdleMs)*time.Millisecond, promptPatterns)
+	workerHub.StartActivityLoop(500 * time.Millisecond)
+	workerHub.SetNotifier(notifier, time.Duration(cfg.NotifyIdleAfterBusy)*time.Second)
+	workerHub.SetSessionTimeouts(session.TimeoutPolicy{
+		IdleTimeout: time.Duration(cfg.IdleTimeoutMinutes) * time.Minute,
+		MaxLifetime: time.Duration(cfg.MaxLifetimeMinutes) * time.Minute,
+	}, time.Duration(cfg.TimeoutWarningSecs)*time.Second, time.Duration(cfg.KillGraceSecs)*time.Second)
+	workerHub.StartTimeoutLoop(30 * time.Second)
+	workerHub.SetUsageRepository(usageRepo)
+	workerHub.StartStatsLoop(15 * time.Second)
+
+	// Session recordings
+	recorder, err := recording.NewRecorder(cfg.RecordingDir, recordingRepo, sessionRepo)
+	if err != nil {
+		log.Fatalf("failed to open recording dir: %v", err)
+	}
+	recorder.StartRetention(time.Hour)
+	workerHub.SetRecorder(recorder)
+
+	// Persistent scrollback
+	var scrollbackStore worker.ScrollbackStore
+	switch cfg.ScrollbackStore {
+	case "memory":
+		scrollbackStore = worker.NewMemoryStore()
+	case "disk":
+		scrollbackStore, err = worker.NewDiskStore(cfg.ScrollbackDir, worker.DefaultSegmentSize)
+		if err != nil {
+			log.Fatalf("failed to open scrollback store: %v", err)
+		}
+	case "postgres":
+		scrollbackStore = worker.NewPostgresStore(db, time.Second)
+	case "":
+	default:
+		log.Fatalf("unknown SCROLLBACK_STORE %q", cfg.ScrollbackStore)
+	}
+	if scrollbackStore != nil {
+		workerHub.SetScrollbackStore(scrollbackStore, int64(cfg.ScrollbackMaxBytes),
+			time.Duration(cfg.ScrollbackDays)*24*time.Hour)
+		workerHub.StartScrollbackRetention(10 * time.Minute)
+	}
 
 	// Worker selector
 	workerSelector := worker.NewHubSelector(workerRepo)
@@ -49,13 +149,22 @@ func main() {
 	dockerMgr := container.NewDockerManager(cfg.SessionImage)
 	sessionMgr := session.NewManager(sessionRepo, dockerMgr, workerPool)
 	sessionMgr.SetWorkerSelector(workerSelector)
+	sessionMgr.SetQuotas(quotaRepo)
+	sessionMgr.StartRetention(time.Hour, time.Duration(cfg.ArchiveDays)*24*time.Hour, workerHub)
 
 	// Handlers
 	authHandler := auth.NewHandler(userRepo, db, cfg.JWTSecret)
 	userHandler := user.NewHandler(userRepo)
-	sessionHandler := session.NewHandler(sessionRepo, sessionMgr, workerHub)
+	sessionHandler := session.NewHandler(sessionRepo, sessionMgr, workerHub, broker)
 	wsProxy := proxy.NewWSProxy(sessionRepo, cfg.JWTSecret, workerHub)
 	workerHandler := worker.NewHandler(workerHub, workerRepo, cfg.JWTSecret)
+	workerHandler.SetQuotas(quotaRepo)
+	workerHub.SetQuotas(quotaRepo)
+	notifyHandler := notify.NewHandler(notifyRepo, notifier, pushChannel)
+	recordingHandler := recording.NewHandler(recordingRepo, recorder, sessionRepo)
+	eventsHandler := events.NewHandler(broker)
+	quotaHandler := quota.NewHandler(quotaRepo, workerHub, admins)
+	usageHandler := usage.NewHandler(usageRepo, userRepo, admins)
 
 	// Fiber app
 	app := fiber.New(fiber.Config{
@@ -95,23 +204,70 @@ func main() {
 	api.Use("/sessions/:id/terminal", wsProxy.UpgradeMiddleware())
 	api.Get("/sessions/:id/terminal", wsProxy.Handler())
 
+	// Multiplexed terminal connection carrying many sessions
+	api.Use("/terminals", wsProxy.UpgradeMiddleware())
+	api.Get("/terminals", wsProxy.MuxHandler())
+
+	// Recording replay uses the same query-string token auth as the terminal
+	api.Use("/sessions/:id/replay", wsProxy.UpgradeMiddleware())
+	api.Get("/sessions/:id/replay", recordingHandler.Replay())
+
 	// Protected routes
 	protected := api.Group("", auth.JWTMiddleware(cfg.JWTSecret))
 	protected.Get("/me", userHandler.GetMe)
+	protected.Get("/events", eventsHandler.Stream)
+	protected.Get("/quota", quotaHandler.Get)
+	protected.Get("/quota/users/:userId", quotaHandler.GetUser)
+	protected.Put("/quota/users/:userId", quotaHandler.UpdateUser)
+	protected.Delete("/quota/users/:userId", quotaHandler.DeleteUser)
+	protected.Get("/usage", usageHandler.Report)
 
 	sessions := protected.Group("/sessions")
 	sessions.Get("/", sessionHandler.List)
+	sessions.Get("/activity", sessionHandler.ActivityStream)
+	sessions.Get("/timeouts", sessionHandler.GetUserTimeouts)
+	sessions.Put("/timeouts", sessionHandler.UpdateUserTimeouts)
 	sessions.Post("/", sessionHandler.Create)
-	sessions.Patch("/:id", sessionHandler.Rename)
+	sessions.Get("/:id", sessionHandler.Get)
+	sessions.Patch("/:id", sessionHandler.Update)
+	sessions.Get("/:id/viewers", sessionHandler.Viewers)
+	sessions.Patch("/:id/scrollback", sessionHandler.UpdateScrollback)
+	sessions.Get("/:id/timeouts", sessionHandler.GetTimeouts)
+	sessions.Patch("/:id/timeouts", sessionHandler.UpdateTimeouts)
+	sessions.Get("/:id/search", sessionHandler.Search)
+	sessions.Post("/:id/input", sessionHandler.Input)
+	sessions.Post("/:id/expect", sessionHandler.Expect)
+	sessions.Get("/:id/stream", sessionHandler.Stream)
+	sessions.Post("/:id/archive", sessionHandler.Archive)
+	sessions.Post("/:id/restore", sessionHandler.Restore)
+	sessions.Get("/:id/recording.cast", recordingHandler.Download)
 	sessions.Delete("/:id", sessionHandler.Delete)
 
 	workers := protected.Group("/workers")
 	workers.Get("/", workerHandler.List)
 	workers.Delete("/:id", workerHandler.Delete)
 
+	protected.Get("/recording/settings", recordingHandler.GetSettings)
+	protected.Put("/recording/settings", recordingHandler.UpdateSettings)
+
+	notifications := protected.Group("/notifications")
+	notifications.Get("/preferences", notifyHandler.GetPreferences)
+	notifications.Put("/preferences", notifyHandler.UpdatePreferences)
+	notifications.Get("/vapid-public-key", notifyHandler.VAPIDPublicKey)
+	notifications.Post("/push-subscriptions", notifyHandler.Subscribe)
+	notifications.Delete("/push-subscriptions", notifyHandler.Unsubscribe)
+	notifications.Post("/test", notifyHandler.Test)
+
 	// Serve static web terminal viewer
 	app.Static("/terminal", "./web")
 
+	// Prometheus metrics, for scrapers holding the metrics token
+	if cfg.MetricsToken != "" {
+		app.Get("/metrics", metrics.Handler(cfg.MetricsToken))
+	} else {
+		log.Printf("METRICS_TOKEN not set, /metrics disabled")
+	}
+
 	// Health check
 	app.Get("/health", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{"status": "ok"})
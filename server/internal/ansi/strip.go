@@ -0,0 +1,163 @@
+package ansi
+
+import "sort"
+
+// Parser states for Stripper.
+const (
+	stateGround = iota
+	stateEscape // after ESC
+	stateCSI    // inside ESC [ ... final byte
+	stateString // inside OSC/DCS/SOS/PM/APC, terminated by BEL or ST
+	stateStrEsc // saw ESC inside a string, expecting '\' for ST
+	stateCharset
+)
+
+// Stripper removes ANSI/VT escape sequences and non-printing control bytes from a
+// byte stream, leaving the text a user would read. Parser state is kept between
+// calls so sequences split across output chunks are still removed.
+type Stripper struct {
+	state int
+}
+
+// Strip appends the printable content of src to dst and returns the extended slice.
+// Newlines and tabs are kept; carriage returns and other C0 controls are dropped.
+func (s *Stripper) Strip(dst, src []byte) []byte {
+	for _, b := range src {
+		if s.keep(b) {
+			dst = append(dst, b)
+		}
+	}
+	return dst
+}
+
+// StripOffsets is like Strip but also records in o, for each byte kept, its
+// position in the stream, given that src starts at stream offset base. Positions
+// in o count from the start of dst.
+func (s *Stripper) StripOffsets(dst []byte, o *Offsets, src []byte, base int64) []byte {
+	for i, b := range src {
+		if s.keep(b) {
+			o.add(len(dst), base+int64(i))
+			dst = append(dst, b)
+		}
+	}
+	return dst
+}
+
+// keep advances the parser by one byte and reports whether the byte is printable text.
+func (s *Stripper) keep(b byte) bool {
+	switch s.state {
+	case stateEscape:
+		switch {
+		case b == '[':
+			s.state = stateCSI
+		case b == ']' || b == 'P' || b == 'X' || b == '^' || b == '_':
+			s.state = stateString
+		case b == '(' || b == ')' || b == '*' || b == '+' || b == '#' || b == '%':
+			s.state = stateCharset
+		default:
+			s.state = stateGround
+		}
+		return false
+	case stateCSI:
+		if b >= 0x40 && b <= 0x7e {
+			s.state = stateGround
+		}
+		return false
+	case stateString:
+		switch b {
+		case 0x07:
+			s.state = stateGround
+		case 0x1b:
+			s.state = stateStrEsc
+		}
+		return false
+	case stateStrEsc:
+		if b == '\\' {
+			s.state = stateGround
+		} else {
+			s.state = stateString
+		}
+		return false
+	case stateCharset:
+		s.state = stateGround
+		return false
+	}
+
+	switch {
+	case b == 0x1b:
+		s.state = stateEscape
+		return false
+	case b == '\n' || b == '\t':
+		return true
+	case b < 0x20 || b == 0x7f:
+		return false
+	}
+	return true
+}
+
+// Strip returns the printable content of b with all escape sequences removed.
+func Strip(b []byte) []byte {
+	var s Stripper
+	return s.Strip(make([]byte, 0, len(b)), b)
+}
+
+// Offsets maps positions in stripped text back to the input it was stripped from.
+// Only the start of each run of kept bytes is recorded, so its size grows with the
+// number of escape sequences rather than with the text.
+type Offsets struct {
+	text []int   // where each run starts in the stripped text
+	raw  []int64 // where each run starts in the input
+}
+
+// add records that byte t of the text is at offset r of the input. Bytes must be
+// added in order.
+func (o *Offsets) add(t int, r int64) {
+	if n := len(o.text); n > 0 && r-o.raw[n-1] == int64(t-o.text[n-1]) {
+		return // continues the current run
+	}
+	o.text = append(o.text, t)
+	o.raw = append(o.raw, r)
+}
+
+// Raw returns the input offset of byte i of the stripped text.
+func (o *Offsets) Raw(i int) int64 {
+	k := sort.Search(len(o.text), func(k int) bool { return o.text[k] > i }) - 1
+	return o.raw[k] + int64(i-o.text[k])
+}
+
+// Drop forgets the first n bytes of the text, for when they are removed from the
+// front of it. Byte n becomes byte 0.
+func (o *Offsets) Drop(n int) {
+	if n <= 0 {
+		return
+	}
+	k := sort.Search(len(o.text), func(k int) bool { return o.text[k] > n }) - 1
+	if k < 0 {
+		k = 0
+	}
+	if len(o.text) > 0 && o.text[k] < n {
+		// Byte n is inside run k; the run now starts there.
+		o.raw[k] += int64(n - o.text[k])
+		o.text[k] = n
+	}
+	o.text = append(o.text[:0], o.text[k:]...)
+	o.raw = append(o.raw[:0], o.raw[k:]...)
+	for i := range o.text {
+		o.text[i] -= n
+	}
+}
+
+// Reset forgets all offsets.
+func (o *Offsets) Reset() {
+	o.text = o.text[:0]
+	o.raw = o.raw[:0]
+}
+
+// StripWithOffsets is like Strip but also returns where each byte of the stripped text
+// is in b. This lets matches in the text be mapped back to the raw stream.
+func StripWithOffsets(b []byte) ([]byte, *Offsets) {
+	var s Stripper
+	offsets := &Offsets{}
+	text := s.StripOffsets(make([]byte, 0, len(b)), offsets, b, 0)
+	return text, offsets
+}
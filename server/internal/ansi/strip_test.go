@@ -0,0 +1,103 @@
+package ansi
+
+import "testing"
+
+func TestStrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{"plain text", []string{"hello\tworld\n"}, "hello\tworld\n"},
+		{"sgr and cursor movement", []string{"\x1b[1;32mok\x1b[0m\x1b[2K\r\n"}, "ok\n"},
+		{"osc title with bel", []string{"\x1b]0;title\x07text"}, "text"},
+		{"osc with st", []string{"\x1b]8;;http://x\x1b\\link\x1b]8;;\x1b\\"}, "link"},
+		{"charset selection", []string{"\x1b(0qqq\x1b(B"}, "qqq"},
+		{"controls", []string{"a\x07\x08b\x7f"}, "ab"},
+		{"sequence split across chunks", []string{"a\x1b", "[3", "1mb\x1b]0;t", "itle\x07c"}, "abc"},
+		{"utf-8", []string{"✔ done"}, "✔ done"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s Stripper
+			var got []byte
+			for _, c := range tt.chunks {
+				got = s.Strip(got, []byte(c))
+			}
+			if string(got) != tt.want {
+				t.Errorf("Strip() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripWithOffsets(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		text  string
+		runs  int
+	}{
+		{"no escapes", "hello\nworld", "hello\nworld", 1},
+		{"leading escape", "\x1b[1mbold", "bold", 1},
+		{"escapes between runs", "a\x1b[31mbc\x1b[0m\r\nd", "abc\nd", 3},
+		{"only escapes", "\x1b[2J\x1b[H", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, offsets := StripWithOffsets([]byte(tt.input))
+			if string(text) != tt.text {
+				t.Fatalf("text = %q, want %q", text, tt.text)
+			}
+			if len(offsets.text) != tt.runs {
+				t.Errorf("recorded %d runs, want %d", len(offsets.text), tt.runs)
+			}
+
+			// Every byte of the text maps back to the same byte of the input, in order.
+			prev := int64(-1)
+			for i := range text {
+				raw := offsets.Raw(i)
+				if raw <= prev || tt.input[raw] != text[i] {
+					t.Errorf("Raw(%d) = %d, which holds %q, want %q after %d", i, raw, tt.input[raw], text[i], prev)
+				}
+				prev = raw
+			}
+		})
+	}
+}
+
+func TestOffsetsAcrossChunks(t *testing.T) {
+	chunks := []string{"ab\x1b[3", "1mcd\r\n", "ef"}
+	var s Stripper
+	var o Offsets
+	var text []byte
+	var base int64 = 100
+	for _, c := range chunks {
+		text = s.StripOffsets(text, &o, []byte(c), base)
+		base += int64(len(c))
+	}
+	if string(text) != "abcd\nef" {
+		t.Fatalf("text = %q, want %q", text, "abcd\nef")
+	}
+
+	want := []int64{100, 101, 107, 108, 110, 111, 112}
+	tests := []struct {
+		drop int
+		want []int64
+	}{
+		{drop: 0, want: want},
+		{drop: 1, want: want[1:]},
+		{drop: 3, want: want[3:]},
+		{drop: 5, want: want[5:]},
+		{drop: 7, want: nil},
+	}
+	for _, tt := range tests {
+		d := Offsets{text: append([]int(nil), o.text...), raw: append([]int64(nil), o.raw...)}
+		d.Drop(tt.drop)
+		for i, w := range tt.want {
+			if got := d.Raw(i); got != w {
+				t.Errorf("after Drop(%d), Raw(%d) = %d, want %d", tt.drop, i, got, w)
+			}
+		}
+	}
+}
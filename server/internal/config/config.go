@@ -3,19 +3,58 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
-	Port              string
-	DatabaseURL       string
-	JWTSecret         string
-	ChiselServerURL   string
-	GoogleClientID    string
-	GoogleSecret      string
-	GoogleRedirect    string
-	SessionImage      string
-	ScrollbackSize    int
+	Port               string
+	DatabaseURL        string
+	JWTSecret          string
+	ChiselServerURL    string
+	GoogleClientID     string
+	GoogleSecret       string
+	GoogleRedirect     string
+	SessionImage       string
+	ScrollbackSize     int
+	ViewerReplay       string
+	ReplayHistoryLines int
+	RelayMemoryBudget  int
+	SpillDir           string
+	RelayGraceMinutes  int
+	CoalesceDelayMs    int
+	CoalesceMaxBytes   int
+	InteractiveMs      int
+	ScrollbackStore    string
+	ScrollbackDir      string
+	ScrollbackMaxBytes int
+	ScrollbackDays     int
+	RecordingDir       string
+	RecordingDays      int
+	ArchiveDays        int
+	IdleTimeoutMinutes int
+	MaxLifetimeMinutes int
+	TimeoutWarningSecs int
+	KillGraceSecs      int
 	WorkerPingInterval int
+	ActivityIdleMs     int
+	PromptPatterns     []string
+	AdminEmails        []string
+	MaxRunningSessions int
+	MaxWorkers         int
+	MaxStorageMB       int
+	MaxShareLinks      int
+	MetricsToken       string
+
+	SMTPHost            string
+	SMTPPort            int
+	SMTPUsername        string
+	SMTPPassword        string
+	SMTPFrom            string
+	VAPIDPrivateKey     string
+	VAPIDSubject        string
+	NotifyCooldown      int
+	NotifyMaxPerHour    int
+	NotifyIdleAfterBusy int
 }
 
 func Load() *Config {
@@ -29,7 +68,45 @@ func Load() *Config {
 		GoogleRedirect:     getEnv("GOOGLE_REDIRECT_URL", "http://localhost:8080/api/auth/google/callback"),
 		SessionImage:       getEnv("SESSION_IMAGE", "moltty-session:latest"),
 		ScrollbackSize:     getEnvInt("SCROLLBACK_SIZE", 1024*1024),
+		ViewerReplay:       getEnv("VIEWER_REPLAY", "snapshot"),
+		ReplayHistoryLines: getEnvInt("REPLAY_HISTORY_LINES", 1000),
+		RelayMemoryBudget:  getEnvInt("RELAY_MEMORY_BUDGET", 256*1024*1024), // bytes; 0 disables spilling
+		SpillDir:           getEnv("SPILL_DIR", "./data/spill"),
+		RelayGraceMinutes:  getEnvInt("RELAY_GRACE_MINUTES", 10),
+		CoalesceDelayMs:    getEnvInt("OUTPUT_COALESCE_MS", 5), // 0 disables coalescing
+		CoalesceMaxBytes:   getEnvInt("OUTPUT_COALESCE_BYTES", 32*1024),
+		InteractiveMs:      getEnvInt("OUTPUT_INTERACTIVE_MS", 150),
+		ScrollbackStore:    getEnv("SCROLLBACK_STORE", ""), // memory, disk or postgres; empty disables persistence
+		ScrollbackDir:      getEnv("SCROLLBACK_DIR", "./data/scrollback"),
+		ScrollbackMaxBytes: getEnvInt("SCROLLBACK_MAX_BYTES", 16*1024*1024),
+		ScrollbackDays:     getEnvInt("SCROLLBACK_RETENTION_DAYS", 30),
+		RecordingDir:       getEnv("RECORDING_DIR", "./data/recordings"),
+		RecordingDays:      getEnvInt("RECORDING_RETENTION_DAYS", 30),
+		ArchiveDays:        getEnvInt("ARCHIVE_RETENTION_DAYS", 30),      // purge archived and deleted sessions; 0 keeps them
+		IdleTimeoutMinutes: getEnvInt("SESSION_IDLE_TIMEOUT_MINUTES", 0), // 0 disables
+		MaxLifetimeMinutes: getEnvInt("SESSION_MAX_LIFETIME_MINUTES", 0), // 0 disables
+		TimeoutWarningSecs: getEnvInt("SESSION_TIMEOUT_WARNING_SECONDS", 300),
+		KillGraceSecs:      getEnvInt("SESSION_KILL_GRACE_SECONDS", 15),
 		WorkerPingInterval: getEnvInt("WORKER_PING_INTERVAL", 30),
+		ActivityIdleMs:     getEnvInt("ACTIVITY_IDLE_MS", 2000),
+		PromptPatterns:     getEnvList("ACTIVITY_PROMPT_PATTERNS", "\n"),
+		AdminEmails:        getEnvList("ADMIN_EMAILS", ","),
+		MaxRunningSessions: getEnvInt("QUOTA_MAX_RUNNING_SESSIONS", 0), // per user; 0 is unlimited
+		MaxWorkers:         getEnvInt("QUOTA_MAX_WORKERS", 0),
+		MaxStorageMB:       getEnvInt("QUOTA_MAX_STORAGE_MB", 0), // scrollback and recordings; checked when sessions start
+		MaxShareLinks:      getEnvInt("QUOTA_MAX_SHARE_LINKS", 0),
+		MetricsToken:       getEnv("METRICS_TOKEN", ""), // bearer token for /metrics; empty disables the endpoint
+
+		SMTPHost:            getEnv("SMTP_HOST", ""),
+		SMTPPort:            getEnvInt("SMTP_PORT", 25),
+		SMTPUsername:        getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:        getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:            getEnv("SMTP_FROM", "moltty@localhost"),
+		VAPIDPrivateKey:     getEnv("VAPID_PRIVATE_KEY", ""),
+		VAPIDSubject:        getEnv("VAPID_SUBJECT", "mailto:admin@localhost"),
+		NotifyCooldown:      getEnvInt("NOTIFY_COOLDOWN", 300),
+		NotifyMaxPerHour:    getEnvInt("NOTIFY_MAX_PER_HOUR", 20),
+		NotifyIdleAfterBusy: getEnvInt("NOTIFY_IDLE_AFTER_BUSY", 30),
 	}
 }
 
@@ -40,6 +117,17 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+// getEnvList splits a variable on sep, dropping empty entries. Returns nil if unset.
+func getEnvList(key, sep string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(key), sep) {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
 func getEnvInt(key string, fallback int) int {
 	if v := os.Getenv(key); v != "" {
 		if i, err := strconv.Atoi(v); err == nil {
@@ -0,0 +1,174 @@
+// Package events publishes user-scoped changes to sessions and workers so that
+// clients can follow them live instead of polling.
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Type string
+
+const (
+	SessionCreated  Type = "session.created"
+	SessionRenamed  Type = "session.renamed"
+	SessionUpdated  Type = "session.updated" // folder or tags changed
+	SessionStatus   Type = "session.status"
+	SessionActivity Type = "session.activity"
+	SessionResumed  Type = "session.resumed"
+	SessionArchived Type = "session.archived"
+	SessionRestored Type = "session.restored"
+	SessionDeleted  Type = "session.deleted"
+	WorkerOnline    Type = "worker.online"
+	WorkerOffline   Type = "worker.offline"
+	WorkerUpdated   Type = "worker.updated"
+)
+
+// DefaultHistory is how many recent events a broker keeps for resuming clients.
+const DefaultHistory = 4096
+
+// subscriberBuffer is how many events a subscriber may fall behind before it is
+// dropped. A dropped subscriber reconnects and resumes from its cursor.
+const subscriberBuffer = 256
+
+// Event is a change visible to one user. IDs increase monotonically, also across
+// server restarts, and serve as the resume cursor.
+type Event struct {
+	ID        uint64         `json:"id"`
+	Type      Type           `json:"type"`
+	UserID    uuid.UUID      `json:"-"`
+	SessionID string         `json:"sessionId,omitempty"`
+	WorkerID  string         `json:"workerId,omitempty"`
+	At        time.Time      `json:"at"`
+	Data      map[string]any `json:"data,omitempty"`
+}
+
+// Subscription receives a user's events. C is closed if the subscriber falls too
+// far behind or unsubscribes.
+type Subscription struct {
+	C      <-chan Event
+	ch     chan Event
+	userID uuid.UUID
+	closed bool
+}
+
+// Broker fans events out to subscribers and keeps a ring of recent events so that
+// a client can resume after a disconnect.
+type Broker struct {
+	mu      sync.Mutex
+	seq     uint64
+	ring    []Event
+	next    int // ring index of the next event
+	full    bool
+	firstID uint64 // first ID issued by this broker
+	subs    map[uuid.UUID]map[*Subscription]struct{}
+}
+
+// NewBroker returns a broker that remembers the last history events.
+func NewBroker(history int) *Broker {
+	if history <= 0 {
+		history = DefaultHistory
+	}
+	// Start from the clock so cursors handed out before a restart are older than
+	// every new event and are recognised as stale.
+	seq := uint64(time.Now().UnixMicro())
+	return &Broker{
+		seq:     seq,
+		firstID: seq + 1,
+		ring:    make([]Event, history),
+		subs:    make(map[uuid.UUID]map[*Subscription]struct{}),
+	}
+}
+
+// Publish assigns the event an ID and delivers it to the user's subscribers. It is
+// safe to call on a nil broker.
+func (b *Broker) Publish(ev Event) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	ev.ID = b.seq
+	if ev.At.IsZero() {
+		ev.At = time.Now()
+	}
+	b.ring[b.next] = ev
+	b.next = (b.next + 1) % len(b.ring)
+	if b.next == 0 {
+		b.full = true
+	}
+
+	for sub := range b.subs[ev.UserID] {
+		select {
+		case sub.ch <- ev:
+		default:
+			b.dropLocked(sub)
+		}
+	}
+}
+
+// Subscribe streams a user's events published after cursor. A zero cursor starts
+// with new events only. The returned backlog holds the user's retained events
+// after the cursor; ok is false if events after the cursor are no longer
+// retained, in which case the client should reload its state.
+func (b *Broker) Subscribe(userID uuid.UUID, cursor uint64) (sub *Subscription, backlog []Event, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Event, subscriberBuffer)
+	sub = &Subscription{C: ch, ch: ch, userID: userID}
+	if b.subs[userID] == nil {
+		b.subs[userID] = make(map[*Subscription]struct{})
+	}
+	b.subs[userID][sub] = struct{}{}
+
+	if cursor == 0 || cursor >= b.seq {
+		return sub, nil, true
+	}
+	oldest := b.firstID
+	if b.full {
+		oldest = b.ring[b.next].ID
+	}
+	ok = cursor+1 >= oldest
+	b.each(func(ev Event) {
+		if ev.ID > cursor && ev.UserID == userID {
+			backlog = append(backlog, ev)
+		}
+	})
+	return sub, backlog, ok
+}
+
+// Unsubscribe stops a subscription and closes its channel.
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dropLocked(sub)
+}
+
+func (b *Broker) dropLocked(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.ch)
+	delete(b.subs[sub.userID], sub)
+	if len(b.subs[sub.userID]) == 0 {
+		delete(b.subs, sub.userID)
+	}
+}
+
+// each calls fn for the retained events, oldest first.
+func (b *Broker) each(fn func(Event)) {
+	if b.full {
+		for _, ev := range b.ring[b.next:] {
+			fn(ev)
+		}
+	}
+	for _, ev := range b.ring[:b.next] {
+		fn(ev)
+	}
+}
@@ -0,0 +1,95 @@
+package events
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestBrokerSubscribeCursor(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+	// Six events in a ring of four: events 1 and 2 are no longer retained.
+	owners := []uuid.UUID{alice, bob, alice, alice, bob, alice}
+
+	tests := []struct {
+		name    string
+		cursor  int // 0 for none, otherwise the last event the client saw (1-based); -1 for a cursor from before a restart
+		user    uuid.UUID
+		backlog []int
+		ok      bool
+	}{
+		{name: "no cursor", cursor: 0, user: alice, ok: true},
+		{name: "up to date", cursor: 6, user: alice, ok: true},
+		{name: "ahead of the broker", cursor: 9, user: alice, ok: true},
+		{name: "resume within the ring", cursor: 3, user: alice, backlog: []int{4, 6}, ok: true},
+		{name: "only the user's events", cursor: 3, user: bob, backlog: []int{5}, ok: true},
+		{name: "oldest retained event next", cursor: 2, user: alice, backlog: []int{3, 4, 6}, ok: true},
+		{name: "events lost", cursor: 1, user: alice, backlog: []int{3, 4, 6}, ok: false},
+		{name: "cursor from before a restart", cursor: -1, user: alice, backlog: []int{3, 4, 6}, ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBroker(4)
+			for _, owner := range owners {
+				b.Publish(Event{Type: SessionStatus, UserID: owner})
+			}
+			first := b.firstID
+
+			var cursor uint64
+			switch {
+			case tt.cursor > 0:
+				cursor = first + uint64(tt.cursor) - 1
+			case tt.cursor < 0:
+				cursor = first - 1000
+			}
+			sub, backlog, ok := b.Subscribe(tt.user, cursor)
+			defer b.Unsubscribe(sub)
+
+			var got []int
+			for _, ev := range backlog {
+				got = append(got, int(ev.ID-first)+1)
+				if ev.UserID != tt.user {
+					t.Errorf("backlog has event %d of another user", ev.ID)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.backlog) || ok != tt.ok {
+				t.Errorf("Subscribe() = %v, %v, want %v, %v", got, ok, tt.backlog, tt.ok)
+			}
+		})
+	}
+}
+
+func TestBrokerDelivery(t *testing.T) {
+	b := NewBroker(16)
+	alice, bob := uuid.New(), uuid.New()
+	sub, _, _ := b.Subscribe(alice, 0)
+
+	b.Publish(Event{Type: SessionCreated, UserID: bob})
+	b.Publish(Event{Type: SessionCreated, UserID: alice, SessionID: "s1"})
+	ev := <-sub.C
+	if ev.SessionID != "s1" || ev.At.IsZero() {
+		t.Errorf("received %+v, want alice's event with a time", ev)
+	}
+
+	// A subscriber that falls too far behind is dropped.
+	for range subscriberBuffer + 1 {
+		b.Publish(Event{Type: SessionStatus, UserID: alice})
+	}
+	n := 0
+	for range sub.C {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("received %d events before the drop, want %d", n, subscriberBuffer)
+	}
+	b.Unsubscribe(sub) // already dropped; must not panic
+	if len(b.subs) != 0 {
+		t.Errorf("%d users still subscribed", len(b.subs))
+	}
+}
+
+func TestBrokerNil(t *testing.T) {
+	var b *Broker
+	b.Publish(Event{Type: SessionCreated})
+}
@@ -0,0 +1,91 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+type Handler struct {
+	broker *Broker
+}
+
+func NewHandler(broker *Broker) *Handler {
+	return &Handler{broker: broker}
+}
+
+func getUserID(c *fiber.Ctx) uuid.UUID {
+	token := c.Locals("user").(*jwt.Token)
+	claims := token.Claims.(jwt.MapClaims)
+	id, _ := uuid.Parse(claims["sub"].(string))
+	return id
+}
+
+// Stream sends the user's session and worker events as Server-Sent Events. Each
+// event's id is its cursor; reconnecting with Last-Event-ID (or the after query
+// parameter) replays what was missed. If the missed events are no longer retained
+// a "reset" event is sent first and the client should reload its lists.
+func (h *Handler) Stream(c *fiber.Ctx) error {
+	userID := getUserID(c)
+
+	var cursor uint64
+	if v := c.Get("Last-Event-ID", c.Query("after")); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid cursor"})
+		}
+		cursor = n
+	}
+
+	sub, backlog, ok := h.broker.Subscribe(userID, cursor)
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer h.broker.Unsubscribe(sub)
+
+		heartbeat := time.NewTicker(15 * time.Second)
+		defer heartbeat.Stop()
+
+		if !ok {
+			fmt.Fprintf(w, "event: reset\ndata: {}\n\n")
+		}
+		for _, ev := range backlog {
+			writeEvent(w, ev)
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		for {
+			select {
+			case ev, open := <-sub.C:
+				if !open {
+					// Fell behind; the client reconnects and resumes from its cursor.
+					return
+				}
+				writeEvent(w, ev)
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func writeEvent(w *bufio.Writer, ev Event) {
+	data, _ := json.Marshal(ev)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+}
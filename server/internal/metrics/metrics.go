@@ -0,0 +1,189 @@
+// Package metrics keeps process-wide counters and gauges and serves them in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	v atomic.Int64
+}
+
+// Add increases the counter by n.
+func (c *Counter) Add(n int64) { c.v.Add(n) }
+
+// Inc increases the counter by one.
+func (c *Counter) Inc() { c.v.Add(1) }
+
+// Value returns the current count.
+func (c *Counter) Value() int64 { return c.v.Load() }
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	v atomic.Int64
+}
+
+// Set replaces the gauge's value.
+func (g *Gauge) Set(n int64) { g.v.Store(n) }
+
+// Add changes the gauge by n, which may be negative.
+func (g *Gauge) Add(n int64) { g.v.Add(n) }
+
+// Value returns the current value.
+func (g *Gauge) Value() int64 { return g.v.Load() }
+
+// GaugeVec is a family of gauges distinguished by the value of one label.
+type GaugeVec struct {
+	label  string
+	mu     sync.Mutex
+	gauges map[string]*Gauge
+}
+
+// With returns the gauge for a label value, creating it if needed.
+func (v *GaugeVec) With(value string) *Gauge {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	g, ok := v.gauges[value]
+	if !ok {
+		g = &Gauge{}
+		v.gauges[value] = g
+	}
+	return g
+}
+
+// Delete drops the gauge for a label value.
+func (v *GaugeVec) Delete(value string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.gauges, value)
+}
+
+type metric struct {
+	name, help, kind string
+	counter          *Counter
+	gauge            *Gauge
+	vec              *GaugeVec
+}
+
+// Registry holds named metrics.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+// Default is the registry used by the package-level constructors and Handler.
+var Default = &Registry{}
+
+func (r *Registry) add(m *metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// NewCounter registers a counter.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{}
+	r.add(&metric{name: name, help: help, kind: "counter", counter: c})
+	return c
+}
+
+// NewGauge registers a gauge.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{}
+	r.add(&metric{name: name, help: help, kind: "gauge", gauge: g})
+	return g
+}
+
+// NewGaugeVec registers a family of gauges keyed by label.
+func (r *Registry) NewGaugeVec(name, help, label string) *GaugeVec {
+	v := &GaugeVec{label: label, gauges: make(map[string]*Gauge)}
+	r.add(&metric{name: name, help: help, kind: "gauge", vec: v})
+	return v
+}
+
+// NewCounter registers a counter on the default registry.
+func NewCounter(name, help string) *Counter { return Default.NewCounter(name, help) }
+
+// NewGauge registers a gauge on the default registry.
+func NewGauge(name, help string) *Gauge { return Default.NewGauge(name, help) }
+
+// NewGaugeVec registers a gauge family on the default registry.
+func NewGaugeVec(name, help, label string) *GaugeVec { return Default.NewGaugeVec(name, help, label) }
+
+// WriteTo writes all metrics in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]*metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name < metrics[j].name })
+
+	cw := &countingWriter{w: w}
+	for _, m := range metrics {
+		fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		switch {
+		case m.counter != nil:
+			fmt.Fprintf(cw, "%s %d\n", m.name, m.counter.Value())
+		case m.gauge != nil:
+			fmt.Fprintf(cw, "%s %d\n", m.name, m.gauge.Value())
+		case m.vec != nil:
+			m.vec.mu.Lock()
+			values := make([]string, 0, len(m.vec.gauges))
+			for v := range m.vec.gauges {
+				values = append(values, v)
+			}
+			sort.Strings(values)
+			for _, v := range values {
+				fmt.Fprintf(cw, "%s{%s=%q} %d\n", m.name, m.vec.label, v, m.vec.gauges[v].Value())
+			}
+			m.vec.mu.Unlock()
+		}
+		if cw.err != nil {
+			break
+		}
+	}
+	return cw.n, cw.err
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+// Handler serves the default registry to requests carrying token as a bearer
+// token.
+func Handler(token string) fiber.Handler {
+	want := []byte("Bearer " + token)
+	return func(c *fiber.Ctx) error {
+		if subtle.ConstantTimeCompare([]byte(c.Get(fiber.HeaderAuthorization)), want) != 1 {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid metrics token"})
+		}
+		c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4")
+		w := bufio.NewWriter(c.Response().BodyWriter())
+		if _, err := Default.WriteTo(w); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestHandlerRequiresToken(t *testing.T) {
+	app := fiber.New()
+	app.Get("/metrics", Handler("s3cret"))
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{name: "no token", want: fiber.StatusUnauthorized},
+		{name: "wrong token", header: "Bearer nope", want: fiber.StatusUnauthorized},
+		{name: "token without scheme", header: "s3cret", want: fiber.StatusUnauthorized},
+		{name: "valid token", header: "Bearer s3cret", want: fiber.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			if tt.header != "" {
+				req.Header.Set(fiber.HeaderAuthorization, tt.header)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// EmailChannel sends notifications over SMTP. Authentication is skipped when no
+// username is configured, which suits local SMTP sinks such as Mailpit.
+type EmailChannel struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+}
+
+func NewEmailChannel(host string, port int, username, password, from string) *EmailChannel {
+	return &EmailChannel{
+		addr:     net.JoinHostPort(host, fmt.Sprint(port)),
+		host:     host,
+		username: username,
+		password: password,
+		from:     from,
+	}
+}
+
+func (c *EmailChannel) Name() string { return "email" }
+
+func (c *EmailChannel) Enabled(r Recipient) bool {
+	return r.Preference.EmailEnabled && r.Email != ""
+}
+
+func (c *EmailChannel) Send(ctx context.Context, r Recipient, ev Event) error {
+	var auth smtp.Auth
+	if c.username != "" {
+		auth = smtp.PlainAuth("", c.username, c.password, c.host)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(c.addr, auth, c.from, []string{r.Email}, c.message(r.Email, ev))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *EmailChannel) message(to string, ev Event) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", c.from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", sanitizeHeader(ev.Title))
+	fmt.Fprintf(&b, "Date: %s\r\n", ev.At.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	fmt.Fprintf(&b, "X-Moltty-Event: %s\r\n", ev.Kind)
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(ev.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// sanitizeHeader strips line breaks so user-controlled text can't inject headers.
+func sanitizeHeader(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
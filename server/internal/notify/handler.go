@@ -0,0 +1,143 @@
+package notify
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+type Handler struct {
+	repo     *Repository
+	notifier *Notifier
+	push     *WebPushChannel
+}
+
+func NewHandler(repo *Repository, notifier *Notifier, push *WebPushChannel) *Handler {
+	return &Handler{repo: repo, notifier: notifier, push: push}
+}
+
+type subscriptionRequest struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+func getUserID(c *fiber.Ctx) uuid.UUID {
+	token := c.Locals("user").(*jwt.Token)
+	claims := token.Claims.(jwt.MapClaims)
+	id, _ := uuid.Parse(claims["sub"].(string))
+	return id
+}
+
+// GetPreferences returns the user's notification settings and the channels the server supports.
+func (h *Handler) GetPreferences(c *fiber.Ctx) error {
+	pref, err := h.repo.FindPreference(getUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to load preferences"})
+	}
+	return c.JSON(fiber.Map{
+		"preferences": pref,
+		"channels":    h.notifier.Channels(),
+	})
+}
+
+// UpdatePreferences replaces the user's notification settings.
+func (h *Handler) UpdatePreferences(c *fiber.Ctx) error {
+	userID := getUserID(c)
+
+	pref, err := h.repo.FindPreference(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to load preferences"})
+	}
+	if err := c.BodyParser(pref); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	pref.UserID = userID
+
+	if pref.WebhookURL != "" {
+		if err := checkPublicURL(c.Context(), pref.WebhookURL, "http", "https"); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "webhookUrl must be an http(s) URL of a public host"})
+		}
+	}
+	if (pref.QuietHoursStart == "") != (pref.QuietHoursEnd == "") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "quietHoursStart and quietHoursEnd must be set together"})
+	}
+	for _, v := range []string{pref.QuietHoursStart, pref.QuietHoursEnd} {
+		if _, ok := parseClock(v); v != "" && !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "quiet hours must be HH:MM"})
+		}
+	}
+	if pref.Timezone == "" {
+		pref.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(pref.Timezone); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "unknown timezone"})
+	}
+
+	if err := h.repo.SavePreference(pref); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to save preferences"})
+	}
+	return c.JSON(fiber.Map{"preferences": pref})
+}
+
+// VAPIDPublicKey returns the application server key browsers need to subscribe.
+func (h *Handler) VAPIDPublicKey(c *fiber.Ctx) error {
+	if h.push == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "web push is not configured"})
+	}
+	return c.JSON(fiber.Map{"publicKey": h.push.PublicKey()})
+}
+
+// Subscribe registers a browser push subscription for the user.
+func (h *Handler) Subscribe(c *fiber.Ctx) error {
+	if h.push == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "web push is not configured"})
+	}
+
+	var req subscriptionRequest
+	if err := c.BodyParser(&req); err != nil || req.Endpoint == "" || req.Keys.P256dh == "" || req.Keys.Auth == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "endpoint and keys are required"})
+	}
+	if err := checkPublicURL(c.Context(), req.Endpoint, "https"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "endpoint must be an https URL of a public host"})
+	}
+
+	sub := &PushSubscription{
+		UserID:   getUserID(c),
+		Endpoint: req.Endpoint,
+		P256dh:   req.Keys.P256dh,
+		Auth:     req.Keys.Auth,
+	}
+	if err := h.repo.UpsertSubscription(sub); errors.Is(err, ErrSubscriptionTaken) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to save subscription"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"id": sub.ID})
+}
+
+// Unsubscribe removes a browser push subscription.
+func (h *Handler) Unsubscribe(c *fiber.Ctx) error {
+	var req subscriptionRequest
+	if err := c.BodyParser(&req); err != nil || req.Endpoint == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "endpoint is required"})
+	}
+	if err := h.repo.DeleteSubscription(getUserID(c), req.Endpoint); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to delete subscription"})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// Test sends a test notification on every channel the user enabled.
+func (h *Handler) Test(c *fiber.Ctx) error {
+	results, err := h.notifier.SendTest(getUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to send test notification"})
+	}
+	return c.JSON(fiber.Map{"results": results})
+}
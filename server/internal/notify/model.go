@@ -0,0 +1,129 @@
+package notify
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Kind identifies what a notification is about.
+type Kind string
+
+const (
+	KindSessionExited Kind = "session-exited"
+	KindWorkerOffline Kind = "worker-offline"
+	KindSessionIdle   Kind = "session-idle"
+	KindTest          Kind = "test"
+)
+
+// Event is something a user may want to be told about.
+type Event struct {
+	Kind      Kind      `json:"kind"`
+	UserID    uuid.UUID `json:"userId"`
+	SessionID uuid.UUID `json:"sessionId,omitempty"`
+	WorkerID  uuid.UUID `json:"workerId,omitempty"`
+	Title     string    `json:"title"`
+	Body      string    `json:"body"`
+	At        time.Time `json:"at"`
+}
+
+// subject returns the session or worker the event concerns, for rate limiting.
+func (e Event) subject() uuid.UUID {
+	if e.SessionID != uuid.Nil {
+		return e.SessionID
+	}
+	return e.WorkerID
+}
+
+// Preference holds a user's notification settings.
+type Preference struct {
+	UserID          uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	EmailEnabled    bool      `gorm:"column:email_enabled" json:"emailEnabled"`
+	Email           string    `gorm:"column:email" json:"email"` // overrides the account email when set
+	PushEnabled     bool      `gorm:"column:push_enabled" json:"pushEnabled"`
+	WebhookEnabled  bool      `gorm:"column:webhook_enabled" json:"webhookEnabled"`
+	WebhookURL      string    `gorm:"column:webhook_url" json:"webhookUrl"`
+	OnExitNonZero   bool      `gorm:"column:on_exit_non_zero" json:"onExitNonZero"`
+	OnWorkerOffline bool      `gorm:"column:on_worker_offline" json:"onWorkerOffline"`
+	OnSessionIdle   bool      `gorm:"column:on_session_idle" json:"onSessionIdle"`
+	QuietHoursStart string    `gorm:"column:quiet_hours_start" json:"quietHoursStart"` // "HH:MM", empty disables
+	QuietHoursEnd   string    `gorm:"column:quiet_hours_end" json:"quietHoursEnd"`     // "HH:MM"
+	Timezone        string    `gorm:"column:timezone" json:"timezone"`                 // IANA name, defaults to UTC
+	CreatedAt       time.Time `json:"-"`
+	UpdatedAt       time.Time `json:"-"`
+}
+
+// DefaultPreference returns the settings used for users who never saved any.
+// All triggers are on, but no channel is enabled until the user opts in.
+func DefaultPreference(userID uuid.UUID) *Preference {
+	return &Preference{
+		UserID:          userID,
+		OnExitNonZero:   true,
+		OnWorkerOffline: true,
+		OnSessionIdle:   true,
+		Timezone:        "UTC",
+	}
+}
+
+// Wants reports whether the user has the trigger for kind turned on.
+func (p *Preference) Wants(kind Kind) bool {
+	switch kind {
+	case KindSessionExited:
+		return p.OnExitNonZero
+	case KindWorkerOffline:
+		return p.OnWorkerOffline
+	case KindSessionIdle:
+		return p.OnSessionIdle
+	}
+	return true
+}
+
+// InQuietHours reports whether t falls inside the user's quiet hours.
+// Windows that wrap past midnight (e.g. 22:00-07:00) are supported.
+func (p *Preference) InQuietHours(t time.Time) bool {
+	start, okStart := parseClock(p.QuietHoursStart)
+	end, okEnd := parseClock(p.QuietHoursEnd)
+	if !okStart || !okEnd || start == end {
+		return false
+	}
+
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := t.In(loc)
+	now := local.Hour()*60 + local.Minute()
+
+	if start < end {
+		return now >= start && now < end
+	}
+	return now >= start || now < end
+}
+
+// parseClock parses "HH:MM" into minutes after midnight.
+func parseClock(s string) (int, bool) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+// PushSubscription is a browser Web Push subscription registered by a user.
+type PushSubscription struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;index;not null"`
+	Endpoint  string    `gorm:"uniqueIndex;not null"`
+	P256dh    string    `gorm:"column:p256dh;not null"`
+	Auth      string    `gorm:"not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (s *PushSubscription) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
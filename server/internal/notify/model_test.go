@@ -0,0 +1,43 @@
+package notify
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // quiet hours are tested in a named time zone
+)
+
+func TestPreferenceInQuietHours(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2026, 3, 10, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name       string
+		start, end string
+		timezone   string
+		t          time.Time
+		want       bool
+	}{
+		{"disabled", "", "", "UTC", at(3, 0), false},
+		{"only a start", "22:00", "", "UTC", at(23, 0), false},
+		{"empty window", "08:00", "08:00", "UTC", at(8, 0), false},
+		{"inside a daytime window", "09:00", "17:00", "UTC", at(12, 30), true},
+		{"at the start", "09:00", "17:00", "UTC", at(9, 0), true},
+		{"at the end", "09:00", "17:00", "UTC", at(17, 0), false},
+		{"before a daytime window", "09:00", "17:00", "UTC", at(8, 59), false},
+		{"late in an overnight window", "22:00", "07:00", "UTC", at(23, 15), true},
+		{"early in an overnight window", "22:00", "07:00", "UTC", at(6, 59), true},
+		{"outside an overnight window", "22:00", "07:00", "UTC", at(12, 0), false},
+		{"in the user's time zone", "22:00", "07:00", "America/New_York", at(3, 0), true},
+		{"outside in the user's time zone", "22:00", "07:00", "America/New_York", at(12, 0), false},
+		{"unknown time zone uses UTC", "22:00", "07:00", "Nowhere/City", at(23, 0), true},
+		{"malformed clock", "25:00", "07:00", "UTC", at(3, 0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Preference{QuietHoursStart: tt.start, QuietHoursEnd: tt.end, Timezone: tt.timezone}
+			if got := p.InQuietHours(tt.t); got != tt.want {
+				t.Errorf("InQuietHours(%s) = %v, want %v", tt.t.Format("15:04"), got, tt.want)
+			}
+		})
+	}
+}
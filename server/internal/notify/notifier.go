@@ -0,0 +1,238 @@
+package notify
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/moltty/server/internal/user"
+)
+
+// Recipient is who a notification is delivered to.
+type Recipient struct {
+	UserID     uuid.UUID
+	Email      string
+	Preference *Preference
+}
+
+// Channel delivers notifications over one medium (email, push, webhook, ...).
+type Channel interface {
+	Name() string
+	// Enabled reports whether the recipient has opted in to this channel.
+	Enabled(r Recipient) bool
+	Send(ctx context.Context, r Recipient, ev Event) error
+}
+
+// deliveryWorkers is how many events are delivered concurrently, so that a slow
+// channel for one user does not hold up everyone else's notifications.
+const deliveryWorkers = 8
+
+// Notifier routes events to the channels each user has enabled, applying the
+// user's triggers, quiet hours and rate limits. Delivery is asynchronous.
+type Notifier struct {
+	repo     *Repository
+	userRepo *user.Repository
+	channels []Channel
+	limiter  *rateLimiter
+	queue    chan Event
+}
+
+func NewNotifier(repo *Repository, userRepo *user.Repository, cooldown time.Duration, maxPerHour int) *Notifier {
+	n := &Notifier{
+		repo:     repo,
+		userRepo: userRepo,
+		limiter:  newRateLimiter(cooldown, maxPerHour),
+		queue:    make(chan Event, 256),
+	}
+	for range deliveryWorkers {
+		go n.run()
+	}
+	return n
+}
+
+// AddChannel registers a delivery channel.
+func (n *Notifier) AddChannel(ch Channel) {
+	n.channels = append(n.channels, ch)
+	log.Printf("notify: %s channel enabled", ch.Name())
+}
+
+// Channels returns the names of the registered channels.
+func (n *Notifier) Channels() []string {
+	names := make([]string, len(n.channels))
+	for i, ch := range n.channels {
+		names[i] = ch.Name()
+	}
+	return names
+}
+
+// Notify queues an event for delivery. It never blocks; events are dropped if the
+// queue is full.
+func (n *Notifier) Notify(ev Event) {
+	if ev.At.IsZero() {
+		ev.At = time.Now()
+	}
+	select {
+	case n.queue <- ev:
+	default:
+		log.Printf("notify: queue full, dropping %s for user %s", ev.Kind, ev.UserID)
+	}
+}
+
+func (n *Notifier) run() {
+	for ev := range n.queue {
+		n.dispatch(ev)
+	}
+}
+
+func (n *Notifier) dispatch(ev Event) {
+	r, err := n.recipient(ev.UserID)
+	if err != nil {
+		log.Printf("notify: failed to load recipient %s: %v", ev.UserID, err)
+		return
+	}
+
+	if !r.Preference.Wants(ev.Kind) || r.Preference.InQuietHours(ev.At) {
+		return
+	}
+	if !n.limiter.Allow(ev, time.Now()) {
+		log.Printf("notify: rate limited %s for user %s", ev.Kind, ev.UserID)
+		return
+	}
+
+	n.deliver(r, ev)
+}
+
+// SendTest delivers a test notification on every enabled channel, bypassing triggers,
+// quiet hours and rate limits. Returns per-channel errors keyed by channel name.
+func (n *Notifier) SendTest(userID uuid.UUID) (map[string]string, error) {
+	r, err := n.recipient(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ev := Event{
+		Kind:   KindTest,
+		UserID: userID,
+		Title:  "Moltty test notification",
+		Body:   "Notifications are working.",
+		At:     time.Now(),
+	}
+	return n.deliver(r, ev), nil
+}
+
+// deliver sends ev on every channel the recipient enabled, in parallel, and
+// returns the outcome by channel.
+func (n *Notifier) deliver(r Recipient, ev Event) map[string]string {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]string)
+	)
+	for _, ch := range n.channels {
+		if !ch.Enabled(r) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			err := ch.Send(ctx, r, ev)
+			cancel()
+
+			result := "ok"
+			if err != nil {
+				log.Printf("notify: %s delivery to user %s failed: %v", ch.Name(), r.UserID, err)
+				result = err.Error()
+			}
+			mu.Lock()
+			results[ch.Name()] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (n *Notifier) recipient(userID uuid.UUID) (Recipient, error) {
+	pref, err := n.repo.FindPreference(userID)
+	if err != nil {
+		return Recipient{}, err
+	}
+
+	email := pref.Email
+	if email == "" {
+		u, err := n.userRepo.FindByID(userID)
+		if err != nil {
+			return Recipient{}, err
+		}
+		email = u.Email
+	}
+
+	return Recipient{UserID: userID, Email: email, Preference: pref}, nil
+}
+
+// rateLimiter enforces a cooldown per (user, kind, subject) and a per-user hourly cap.
+type rateLimiter struct {
+	mu         sync.Mutex
+	cooldown   time.Duration
+	maxPerHour int
+	last       map[string]time.Time
+	sent       map[uuid.UUID][]time.Time
+}
+
+func newRateLimiter(cooldown time.Duration, maxPerHour int) *rateLimiter {
+	return &rateLimiter{
+		cooldown:   cooldown,
+		maxPerHour: maxPerHour,
+		last:       make(map[string]time.Time),
+		sent:       make(map[uuid.UUID][]time.Time),
+	}
+}
+
+// Allow reports whether ev may be sent now, and records it if so.
+func (l *rateLimiter) Allow(ev Event, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := ev.UserID.String() + "/" + string(ev.Kind) + "/" + ev.subject().String()
+	if last, ok := l.last[key]; ok && now.Sub(last) < l.cooldown {
+		return false
+	}
+
+	l.prune(now)
+	recent := l.sent[ev.UserID]
+	if l.maxPerHour > 0 && len(recent) >= l.maxPerHour {
+		return false
+	}
+
+	l.last[key] = now
+	l.sent[ev.UserID] = append(recent, now)
+	return true
+}
+
+// prune drops cooldown entries and send times that can no longer block anything,
+// so that users who stopped receiving notifications don't stay in the maps.
+func (l *rateLimiter) prune(now time.Time) {
+	for k, t := range l.last {
+		if now.Sub(t) >= l.cooldown {
+			delete(l.last, k)
+		}
+	}
+
+	window := now.Add(-time.Hour)
+	for userID, times := range l.sent {
+		recent := times[:0]
+		for _, t := range times {
+			if t.After(window) {
+				recent = append(recent, t)
+			}
+		}
+		if len(recent) == 0 {
+			delete(l.sent, userID)
+		} else {
+			l.sent[userID] = recent
+		}
+	}
+}
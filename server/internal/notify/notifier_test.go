@@ -0,0 +1,85 @@
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRateLimiter(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+	s1, s2 := uuid.New(), uuid.New()
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	type attempt struct {
+		after time.Duration // since start
+		ev    Event
+		want  bool
+	}
+	tests := []struct {
+		name       string
+		cooldown   time.Duration
+		maxPerHour int
+		attempts   []attempt
+	}{
+		{
+			name:     "cooldown per subject",
+			cooldown: 10 * time.Minute,
+			attempts: []attempt{
+				{0, Event{UserID: alice, Kind: KindSessionIdle, SessionID: s1}, true},
+				{time.Minute, Event{UserID: alice, Kind: KindSessionIdle, SessionID: s1}, false},
+				{time.Minute, Event{UserID: alice, Kind: KindSessionIdle, SessionID: s2}, true},
+				{time.Minute, Event{UserID: alice, Kind: KindSessionExited, SessionID: s1}, true},
+				{time.Minute, Event{UserID: bob, Kind: KindSessionIdle, SessionID: s1}, true},
+				{10 * time.Minute, Event{UserID: alice, Kind: KindSessionIdle, SessionID: s1}, true},
+			},
+		},
+		{
+			name:       "hourly cap per user",
+			maxPerHour: 2,
+			attempts: []attempt{
+				{0, Event{UserID: alice, Kind: KindSessionIdle, SessionID: s1}, true},
+				{time.Minute, Event{UserID: alice, Kind: KindSessionIdle, SessionID: s2}, true},
+				{2 * time.Minute, Event{UserID: alice, Kind: KindWorkerOffline, WorkerID: s1}, false},
+				{2 * time.Minute, Event{UserID: bob, Kind: KindSessionIdle, SessionID: s1}, true},
+				{time.Hour + 30*time.Second, Event{UserID: alice, Kind: KindWorkerOffline, WorkerID: s1}, true},
+				{time.Hour + 30*time.Second, Event{UserID: alice, Kind: KindWorkerOffline, WorkerID: s2}, false},
+			},
+		},
+		{
+			name:       "rejected events don't count",
+			cooldown:   time.Hour,
+			maxPerHour: 2,
+			attempts: []attempt{
+				{0, Event{UserID: alice, Kind: KindSessionIdle, SessionID: s1}, true},
+				{time.Minute, Event{UserID: alice, Kind: KindSessionIdle, SessionID: s1}, false},
+				{2 * time.Minute, Event{UserID: alice, Kind: KindSessionIdle, SessionID: s1}, false},
+				{3 * time.Minute, Event{UserID: alice, Kind: KindSessionIdle, SessionID: s2}, true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(tt.cooldown, tt.maxPerHour)
+			for i, a := range tt.attempts {
+				if got := l.Allow(a.ev, start.Add(a.after)); got != a.want {
+					t.Errorf("attempt %d: Allow() = %v, want %v", i, got, a.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterPrune(t *testing.T) {
+	l := newRateLimiter(10*time.Minute, 5)
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	for range 3 {
+		l.Allow(Event{UserID: uuid.New(), Kind: KindSessionIdle, SessionID: uuid.New()}, start)
+	}
+
+	l.Allow(Event{UserID: uuid.New(), Kind: KindSessionIdle, SessionID: uuid.New()}, start.Add(2*time.Hour))
+	if len(l.last) != 1 || len(l.sent) != 1 {
+		t.Errorf("after an hour: %d cooldowns and %d users tracked, want 1 and 1", len(l.last), len(l.sent))
+	}
+}
@@ -0,0 +1,73 @@
+package notify
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrSubscriptionTaken is returned when a push endpoint is registered to another
+// user with different keys.
+var ErrSubscriptionTaken = errors.New("push endpoint belongs to another user")
+
+type Repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) *Repository {
+	return &Repository{db: db}
+}
+
+// FindPreference returns the user's saved settings, or the defaults if none were saved.
+func (r *Repository) FindPreference(userID uuid.UUID) (*Preference, error) {
+	var p Preference
+	err := r.db.First(&p, "user_id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return DefaultPreference(userID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *Repository) SavePreference(p *Preference) error {
+	return r.db.Save(p).Error
+}
+
+func (r *Repository) FindSubscriptions(userID uuid.UUID) ([]PushSubscription, error) {
+	var subs []PushSubscription
+	err := r.db.Where("user_id = ?", userID).Find(&subs).Error
+	return subs, err
+}
+
+// UpsertSubscription stores a subscription. An endpoint registered to another user
+// only moves to this user if the request carries the same keys, which only the
+// subscribed browser knows; otherwise ErrSubscriptionTaken is returned.
+func (r *Repository) UpsertSubscription(s *PushSubscription) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var existing PushSubscription
+		err := tx.Where("endpoint = ?", s.Endpoint).First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tx.Create(s).Error
+		}
+		if err != nil {
+			return err
+		}
+		if existing.UserID != s.UserID && (existing.P256dh != s.P256dh || existing.Auth != s.Auth) {
+			return ErrSubscriptionTaken
+		}
+		existing.UserID, existing.P256dh, existing.Auth = s.UserID, s.P256dh, s.Auth
+		*s = existing
+		return tx.Save(s).Error
+	})
+}
+
+func (r *Repository) DeleteSubscription(userID uuid.UUID, endpoint string) error {
+	return r.db.Where("user_id = ? AND endpoint = ?", userID, endpoint).Delete(&PushSubscription{}).Error
+}
+
+func (r *Repository) DeleteSubscriptionByID(id uuid.UUID) error {
+	return r.db.Delete(&PushSubscription{}, "id = ?", id).Error
+}
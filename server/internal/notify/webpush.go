@@ -0,0 +1,246 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// pushRecordSize is the aes128gcm record size advertised in the payload header.
+const pushRecordSize = 4096
+
+// errSubscriptionGone is returned when the push service reports the subscription expired.
+var errSubscriptionGone = errors.New("push subscription gone")
+
+// WebPushChannel delivers notifications to browser push subscriptions using
+// VAPID authentication (RFC 8292) and aes128gcm payload encryption (RFC 8291).
+type WebPushChannel struct {
+	repo       *Repository
+	client     *http.Client
+	subject    string
+	publicKey  []byte // uncompressed P-256 point
+	signingKey *ecdsa.PrivateKey
+}
+
+// NewWebPushChannel creates a push channel from a base64url-encoded VAPID private key
+// (the raw 32-byte P-256 scalar, as produced by common web-push tooling).
+func NewWebPushChannel(repo *Repository, privateKey, subject string) (*WebPushChannel, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(trimPadding(privateKey))
+	if err != nil {
+		return nil, fmt.Errorf("decode VAPID private key: %w", err)
+	}
+	key, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse VAPID private key: %w", err)
+	}
+
+	pub := key.PublicKey().Bytes()
+	signingKey := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(pub[1:33]),
+			Y:     new(big.Int).SetBytes(pub[33:]),
+		},
+		D: new(big.Int).SetBytes(raw),
+	}
+
+	return &WebPushChannel{
+		repo:       repo,
+		client:     newPublicClient(),
+		subject:    subject,
+		publicKey:  pub,
+		signingKey: signingKey,
+	}, nil
+}
+
+func (c *WebPushChannel) Name() string { return "webpush" }
+
+func (c *WebPushChannel) Enabled(r Recipient) bool {
+	return r.Preference.PushEnabled
+}
+
+// PublicKey returns the base64url application server key browsers subscribe with.
+func (c *WebPushChannel) PublicKey() string {
+	return base64.RawURLEncoding.EncodeToString(c.publicKey)
+}
+
+func (c *WebPushChannel) Send(ctx context.Context, r Recipient, ev Event) error {
+	subs, err := c.repo.FindSubscriptions(r.UserID)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, sub := range subs {
+		err := c.push(ctx, &sub, payload)
+		if errors.Is(err, errSubscriptionGone) {
+			c.repo.DeleteSubscriptionByID(sub.ID)
+			continue
+		}
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (c *WebPushChannel) push(ctx context.Context, sub *PushSubscription, payload []byte) error {
+	body, err := encryptPayload(sub, payload)
+	if err != nil {
+		return err
+	}
+
+	endpoint, err := url.Parse(sub.Endpoint)
+	if err != nil {
+		return err
+	}
+	token, err := c.vapidToken(endpoint.Scheme + "://" + endpoint.Host)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", "86400")
+	req.Header.Set("Urgency", "high")
+	req.Header.Set("Authorization", fmt.Sprintf("vapid t=%s, k=%s", token, c.PublicKey()))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return errSubscriptionGone
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("push service returned %s", resp.Status)
+	}
+	return nil
+}
+
+// vapidToken signs the VAPID JWT for a push service origin.
+func (c *WebPushChannel) vapidToken(audience string) (string, error) {
+	claims := jwt.MapClaims{
+		"aud": audience,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": c.subject,
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(c.signingKey)
+}
+
+// encryptPayload encrypts payload for a subscription as a single aes128gcm record.
+func encryptPayload(sub *PushSubscription, payload []byte) ([]byte, error) {
+	uaPublicRaw, err := base64.RawURLEncoding.DecodeString(trimPadding(sub.P256dh))
+	if err != nil {
+		return nil, fmt.Errorf("decode p256dh: %w", err)
+	}
+	authSecret, err := base64.RawURLEncoding.DecodeString(trimPadding(sub.Auth))
+	if err != nil {
+		return nil, fmt.Errorf("decode auth secret: %w", err)
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(uaPublicRaw)
+	if err != nil {
+		return nil, fmt.Errorf("parse p256dh: %w", err)
+	}
+
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+
+	sharedSecret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	// RFC 8291 section 3.4: combine the ECDH secret with the subscription's auth secret.
+	prkKey, err := hkdf.Extract(sha256.New, sharedSecret, authSecret)
+	if err != nil {
+		return nil, err
+	}
+	keyInfo := "WebPush: info\x00" + string(uaPublicRaw) + string(asPublic)
+	ikm, err := hkdf.Expand(sha256.New, prkKey, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	// RFC 8188 section 2.2: derive the content encryption key and nonce.
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// A single final record: payload followed by the 0x02 delimiter.
+	plaintext := append(append([]byte{}, payload...), 0x02)
+	if len(plaintext)+gcm.Overhead() > pushRecordSize {
+		return nil, fmt.Errorf("push payload too large (%d bytes)", len(payload))
+	}
+
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, pushRecordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+
+	return gcm.Seal(header, nonce, plaintext, nil), nil
+}
+
+// trimPadding allows keys encoded with standard base64url padding.
+func trimPadding(s string) string {
+	for len(s) > 0 && s[len(s)-1] == '=' {
+		s = s[:len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/moltty/server/internal/session"
+	"github.com/moltty/server/internal/worker"
+)
+
+// muxRequest is a JSON control message from a multiplexed viewer.
+type muxRequest struct {
+	Type      string `json:"type"`      // subscribe, unsubscribe, resize
+	Channel   uint32 `json:"channel"`   // client-chosen, non-zero
+	SessionID string `json:"sessionId"` // session to attach (for "subscribe")
+	Cols      int    `json:"cols"`      // terminal columns (for "resize")
+	Rows      int    `json:"rows"`      // terminal rows (for "resize")
+}
+
+// maxMuxChannels caps the subscriptions on one multiplexed connection.
+const maxMuxChannels = 64
+
+// muxChannel is one session subscription on a multiplexed connection.
+type muxChannel struct {
+	sessionID uuid.UUID
+	viewer    *worker.ViewerConn
+}
+
+// muxHub is the part of the worker hub a multiplexed connection uses.
+type muxHub interface {
+	AttachViewer(sessionID uuid.UUID, vc *worker.ViewerConn) error
+	UnregisterViewer(sessionID uuid.UUID, vc *worker.ViewerConn)
+	SendInput(sessionID uuid.UUID, data string) error
+	SendResize(sessionID uuid.UUID, cols, rows int) error
+}
+
+// messageWriter is the part of a websocket connection replies are written to.
+type messageWriter interface {
+	WriteMessage(messageType int, data []byte) error
+}
+
+// muxConn is the state of one multiplexed viewer connection. Its methods, other
+// than channelClosed, must only be called from the connection's read loop.
+type muxConn struct {
+	hub         muxHub
+	findSession func(id uuid.UUID) (*session.Session, error)
+	conn        *websocket.Conn // handed to channel viewers
+	out         messageWriter   // conn, or a fake in tests
+	userID      uuid.UUID
+	device      string
+
+	writeMu  sync.Mutex
+	channels map[uint32]*muxChannel
+	// Viewers whose channel the hub closed, to be dropped from channels by the
+	// read loop, which owns the map.
+	closed chan *worker.ViewerConn
+}
+
+// MuxHandler serves a single viewer WebSocket that carries many worker sessions.
+//
+// The client subscribes with {"type":"subscribe","channel":N,"sessionId":"..."}
+// and receives the session's repaint, output and control messages on channel N.
+// Binary frames in both directions start with the 4-byte big-endian channel ID;
+// JSON messages carry a "channel" field. Resize requests name their channel, and
+// {"type":"unsubscribe","channel":N} detaches.
+func (p *WSProxy) MuxHandler() fiber.Handler {
+	return websocket.New(func(c *websocket.Conn) {
+		userID, _ := uuid.Parse(c.Locals("userID").(string))
+		device, _ := c.Locals("device").(string)
+
+		m := &muxConn{
+			hub:         p.hub,
+			findSession: p.sessionRepo.FindByID,
+			conn:        c,
+			out:         c,
+			userID:      userID,
+			device:      device,
+			channels:    make(map[uint32]*muxChannel),
+			closed:      make(chan *worker.ViewerConn, maxMuxChannels),
+		}
+		defer m.close()
+
+		for {
+			msgType, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			m.handle(msgType, data)
+		}
+	})
+}
+
+// handle processes one message from the client.
+func (m *muxConn) handle(msgType int, data []byte) {
+	m.dropClosed()
+
+	if msgType == websocket.BinaryMessage {
+		if len(data) < worker.ChannelHeaderSize {
+			return
+		}
+		channel := binary.BigEndian.Uint32(data)
+		ch, ok := m.channels[channel]
+		if !ok {
+			return
+		}
+		if err := m.hub.SendInput(ch.sessionID, base64.StdEncoding.EncodeToString(data[worker.ChannelHeaderSize:])); err != nil {
+			m.fail(channel, err.Error())
+		}
+		return
+	}
+
+	var req muxRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		m.fail(0, "invalid message")
+		return
+	}
+	if req.Channel == 0 {
+		m.fail(0, "channel must be non-zero")
+		return
+	}
+
+	switch req.Type {
+	case "subscribe":
+		m.subscribe(req)
+
+	case "unsubscribe":
+		if ch, ok := m.channels[req.Channel]; ok {
+			m.hub.UnregisterViewer(ch.sessionID, ch.viewer)
+			delete(m.channels, req.Channel)
+		}
+
+	case "resize":
+		if ch, ok := m.channels[req.Channel]; ok {
+			if err := m.hub.SendResize(ch.sessionID, req.Cols, req.Rows); err != nil {
+				m.fail(req.Channel, err.Error())
+			}
+		}
+
+	default:
+		log.Printf("mux: unknown message type %q", req.Type)
+		m.fail(req.Channel, "unknown message type")
+	}
+}
+
+// subscribe attaches a session to a new channel.
+func (m *muxConn) subscribe(req muxRequest) {
+	if _, exists := m.channels[req.Channel]; exists {
+		m.fail(req.Channel, "channel already in use")
+		return
+	}
+	if len(m.channels) >= maxMuxChannels {
+		m.fail(req.Channel, "too many channels")
+		return
+	}
+	sessionID, err := uuid.Parse(req.SessionID)
+	if err != nil {
+		m.fail(req.Channel, "invalid session id")
+		return
+	}
+	sess, err := m.findSession(sessionID)
+	if err != nil || sess.UserID != m.userID {
+		m.fail(req.Channel, "session not found")
+		return
+	}
+	if sess.SessionType != session.SessionTypeWorker {
+		m.fail(req.Channel, worker.ErrNotWorkerSession.Error())
+		return
+	}
+
+	// Acknowledge first so the repaint that follows lands on a known channel.
+	m.reply(worker.ViewerMessage{Type: worker.ViewerMsgSubscribed, Channel: req.Channel, SessionID: sessionID.String()})
+	vc := worker.NewChannelViewer(m.userID, m.device, m.conn, &m.writeMu, req.Channel)
+	vc.SetOnClose(func() { m.channelClosed(vc) })
+	if err := m.hub.AttachViewer(sessionID, vc); err != nil {
+		code := worker.CloseSessionNotFound
+		if errors.Is(err, worker.ErrNotWorkerSession) {
+			code = worker.CloseNotWorkerSession
+		}
+		m.reply(worker.ViewerMessage{Type: worker.ViewerMsgClosed, Channel: req.Channel, Code: code, Reason: err.Error()})
+		return
+	}
+	m.channels[req.Channel] = &muxChannel{sessionID: sessionID, viewer: vc}
+}
+
+// channelClosed queues a viewer whose channel the hub closed. It is called by the
+// hub, from any goroutine.
+func (m *muxConn) channelClosed(vc *worker.ViewerConn) {
+	select {
+	case m.closed <- vc:
+	default:
+	}
+}
+
+// dropClosed forgets the channels the hub closed, unless they were reused since.
+func (m *muxConn) dropClosed() {
+	for {
+		select {
+		case vc := <-m.closed:
+			if ch, ok := m.channels[vc.Channel]; ok && ch.viewer == vc {
+				delete(m.channels, vc.Channel)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// close detaches every channel's viewer.
+func (m *muxConn) close() {
+	for _, ch := range m.channels {
+		m.hub.UnregisterViewer(ch.sessionID, ch.viewer)
+	}
+}
+
+func (m *muxConn) reply(msg worker.ViewerMessage) {
+	data, _ := json.Marshal(msg)
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	m.out.WriteMessage(websocket.TextMessage, data)
+}
+
+func (m *muxConn) fail(channel uint32, err string) {
+	m.reply(worker.ViewerMessage{Type: worker.ViewerMsgError, Channel: channel, Error: err})
+}
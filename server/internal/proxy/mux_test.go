@@ -0,0 +1,257 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
+	"github.com/moltty/server/internal/session"
+	"github.com/moltty/server/internal/worker"
+)
+
+// fakeMuxHub records what a multiplexed connection asks of the hub.
+type fakeMuxHub struct {
+	attachErr error
+	attached  map[*worker.ViewerConn]uuid.UUID
+	inputs    []string // "<session> <base64 data>"
+	resizes   []string // "<session> <cols>x<rows>"
+}
+
+func (h *fakeMuxHub) AttachViewer(sessionID uuid.UUID, vc *worker.ViewerConn) error {
+	if h.attachErr != nil {
+		return h.attachErr
+	}
+	h.attached[vc] = sessionID
+	return nil
+}
+
+func (h *fakeMuxHub) UnregisterViewer(_ uuid.UUID, vc *worker.ViewerConn) {
+	delete(h.attached, vc)
+}
+
+func (h *fakeMuxHub) SendInput(sessionID uuid.UUID, data string) error {
+	h.inputs = append(h.inputs, sessionID.String()+" "+data)
+	return nil
+}
+
+func (h *fakeMuxHub) SendResize(sessionID uuid.UUID, cols, rows int) error {
+	h.resizes = append(h.resizes, fmt.Sprintf("%s %dx%d", sessionID, cols, rows))
+	return nil
+}
+
+// fakeReplies records the control messages sent to the client.
+type fakeReplies struct {
+	msgs []worker.ViewerMessage
+}
+
+func (f *fakeReplies) WriteMessage(_ int, data []byte) error {
+	var msg worker.ViewerMessage
+	json.Unmarshal(data, &msg)
+	f.msgs = append(f.msgs, msg)
+	return nil
+}
+
+// take returns and forgets the replies so far.
+func (f *fakeReplies) take() []worker.ViewerMessage {
+	msgs := f.msgs
+	f.msgs = nil
+	return msgs
+}
+
+// newTestMux returns a connection for userID that knows the given sessions.
+func newTestMux(userID uuid.UUID, sessions ...*session.Session) (*muxConn, *fakeMuxHub, *fakeReplies) {
+	hub := &fakeMuxHub{attached: make(map[*worker.ViewerConn]uuid.UUID)}
+	out := &fakeReplies{}
+	m := &muxConn{
+		hub: hub,
+		findSession: func(id uuid.UUID) (*session.Session, error) {
+			for _, s := range sessions {
+				if s.ID == id {
+					return s, nil
+				}
+			}
+			return nil, errors.New("record not found")
+		},
+		out:      out,
+		userID:   userID,
+		channels: make(map[uint32]*muxChannel),
+		closed:   make(chan *worker.ViewerConn, maxMuxChannels),
+	}
+	return m, hub, out
+}
+
+func sendJSON(m *muxConn, req muxRequest) {
+	data, _ := json.Marshal(req)
+	m.handle(websocket.TextMessage, data)
+}
+
+func TestMuxSubscribe(t *testing.T) {
+	userID := uuid.New()
+	own := &session.Session{ID: uuid.New(), UserID: userID, SessionType: session.SessionTypeWorker}
+	other := &session.Session{ID: uuid.New(), UserID: uuid.New(), SessionType: session.SessionTypeWorker}
+	container := &session.Session{ID: uuid.New(), UserID: userID, SessionType: "container"}
+
+	tests := []struct {
+		name      string
+		req       muxRequest
+		attachErr error
+		want      worker.ViewerMessage
+		attached  bool
+	}{
+		{
+			name:     "own worker session",
+			req:      muxRequest{Type: "subscribe", Channel: 7, SessionID: own.ID.String()},
+			want:     worker.ViewerMessage{Type: worker.ViewerMsgSubscribed, Channel: 7, SessionID: own.ID.String()},
+			attached: true,
+		},
+		{
+			name: "zero channel",
+			req:  muxRequest{Type: "subscribe", SessionID: own.ID.String()},
+			want: worker.ViewerMessage{Type: worker.ViewerMsgError, Error: "channel must be non-zero"},
+		},
+		{
+			name: "invalid session id",
+			req:  muxRequest{Type: "subscribe", Channel: 1, SessionID: "nope"},
+			want: worker.ViewerMessage{Type: worker.ViewerMsgError, Channel: 1, Error: "invalid session id"},
+		},
+		{
+			name: "another user's session",
+			req:  muxRequest{Type: "subscribe", Channel: 1, SessionID: other.ID.String()},
+			want: worker.ViewerMessage{Type: worker.ViewerMsgError, Channel: 1, Error: "session not found"},
+		},
+		{
+			name: "container session",
+			req:  muxRequest{Type: "subscribe", Channel: 1, SessionID: container.ID.String()},
+			want: worker.ViewerMessage{Type: worker.ViewerMsgError, Channel: 1, Error: worker.ErrNotWorkerSession.Error()},
+		},
+		{
+			name:      "hub refuses",
+			req:       muxRequest{Type: "subscribe", Channel: 2, SessionID: own.ID.String()},
+			attachErr: worker.ErrSessionNotFound,
+			want: worker.ViewerMessage{Type: worker.ViewerMsgClosed, Channel: 2,
+				Code: worker.CloseSessionNotFound, Reason: worker.ErrSessionNotFound.Error()},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, hub, out := newTestMux(userID, own, other, container)
+			hub.attachErr = tt.attachErr
+
+			sendJSON(m, tt.req)
+
+			replies := out.take()
+			if got := replies[len(replies)-1]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("last reply = %+v, want %+v", got, tt.want)
+			}
+			if _, ok := m.channels[tt.req.Channel]; ok != tt.attached {
+				t.Errorf("channel bound = %v, want %v", ok, tt.attached)
+			}
+			if len(hub.attached) != len(m.channels) {
+				t.Errorf("hub has %d viewers for %d channels", len(hub.attached), len(m.channels))
+			}
+		})
+	}
+}
+
+func TestMuxChannelFraming(t *testing.T) {
+	userID := uuid.New()
+	a := &session.Session{ID: uuid.New(), UserID: userID, SessionType: session.SessionTypeWorker}
+	b := &session.Session{ID: uuid.New(), UserID: userID, SessionType: session.SessionTypeWorker}
+	m, hub, out := newTestMux(userID, a, b)
+	sendJSON(m, muxRequest{Type: "subscribe", Channel: 1, SessionID: a.ID.String()})
+	sendJSON(m, muxRequest{Type: "subscribe", Channel: 2, SessionID: b.ID.String()})
+	out.take()
+
+	frame := func(channel uint32, data string) []byte {
+		buf := binary.BigEndian.AppendUint32(nil, channel)
+		return append(buf, data...)
+	}
+	m.handle(websocket.BinaryMessage, frame(2, "ls\r"))
+	m.handle(websocket.BinaryMessage, frame(1, "hi"))
+	m.handle(websocket.BinaryMessage, frame(9, "unbound"))
+	m.handle(websocket.BinaryMessage, []byte{0, 1})
+	sendJSON(m, muxRequest{Type: "resize", Channel: 1, Cols: 120, Rows: 40})
+	sendJSON(m, muxRequest{Type: "resize", Channel: 9, Cols: 1, Rows: 1})
+
+	wantInputs := []string{b.ID.String() + " bHMN", a.ID.String() + " aGk="}
+	if !reflect.DeepEqual(hub.inputs, wantInputs) {
+		t.Errorf("inputs = %q, want %q", hub.inputs, wantInputs)
+	}
+	if want := []string{a.ID.String() + " 120x40"}; !reflect.DeepEqual(hub.resizes, want) {
+		t.Errorf("resizes = %q, want %q", hub.resizes, want)
+	}
+	if replies := out.take(); len(replies) != 0 {
+		t.Errorf("unexpected replies %+v", replies)
+	}
+}
+
+func TestMuxChannelLimits(t *testing.T) {
+	userID := uuid.New()
+	sess := &session.Session{ID: uuid.New(), UserID: userID, SessionType: session.SessionTypeWorker}
+	m, _, out := newTestMux(userID, sess)
+
+	for i := 1; i <= maxMuxChannels; i++ {
+		sendJSON(m, muxRequest{Type: "subscribe", Channel: uint32(i), SessionID: sess.ID.String()})
+	}
+	out.take()
+
+	sendJSON(m, muxRequest{Type: "subscribe", Channel: 1, SessionID: sess.ID.String()})
+	sendJSON(m, muxRequest{Type: "subscribe", Channel: maxMuxChannels + 1, SessionID: sess.ID.String()})
+	want := []worker.ViewerMessage{
+		{Type: worker.ViewerMsgError, Channel: 1, Error: "channel already in use"},
+		{Type: worker.ViewerMsgError, Channel: maxMuxChannels + 1, Error: "too many channels"},
+	}
+	if got := out.take(); !reflect.DeepEqual(got, want) {
+		t.Errorf("replies = %+v, want %+v", got, want)
+	}
+
+	// Unsubscribing frees a slot.
+	sendJSON(m, muxRequest{Type: "unsubscribe", Channel: 3})
+	sendJSON(m, muxRequest{Type: "subscribe", Channel: maxMuxChannels + 1, SessionID: sess.ID.String()})
+	if got := out.take(); len(got) != 1 || got[0].Type != worker.ViewerMsgSubscribed {
+		t.Errorf("replies after unsubscribing = %+v, want one subscribed", got)
+	}
+	if len(m.channels) != maxMuxChannels {
+		t.Errorf("%d channels, want %d", len(m.channels), maxMuxChannels)
+	}
+}
+
+func TestMuxClosedChannels(t *testing.T) {
+	userID := uuid.New()
+	sess := &session.Session{ID: uuid.New(), UserID: userID, SessionType: session.SessionTypeWorker}
+	m, hub, _ := newTestMux(userID, sess)
+	sendJSON(m, muxRequest{Type: "subscribe", Channel: 1, SessionID: sess.ID.String()})
+	sendJSON(m, muxRequest{Type: "subscribe", Channel: 2, SessionID: sess.ID.String()})
+	first, second := m.channels[1].viewer, m.channels[2].viewer
+
+	// The hub closes channel 1. Channel 2 is rebound before the hub's close of
+	// its old viewer is noticed.
+	delete(hub.attached, first)
+	m.channelClosed(first)
+	sendJSON(m, muxRequest{Type: "unsubscribe", Channel: 2})
+	sendJSON(m, muxRequest{Type: "subscribe", Channel: 2, SessionID: sess.ID.String()})
+	rebound := m.channels[2].viewer
+	m.channelClosed(second)
+	m.handle(websocket.BinaryMessage, []byte{0, 0, 0, 1, 'x'})
+
+	if _, ok := m.channels[1]; ok {
+		t.Error("closed channel 1 still bound")
+	}
+	if ch, ok := m.channels[2]; !ok || ch.viewer != rebound {
+		t.Error("rebound channel 2 was dropped")
+	}
+	if len(hub.inputs) != 0 {
+		t.Errorf("input reached the hub on a closed channel: %q", hub.inputs)
+	}
+
+	// Closing the connection detaches what is left.
+	m.close()
+	if len(hub.attached) != 0 {
+		t.Errorf("hub still has %d viewers after close", len(hub.attached))
+	}
+}
@@ -3,9 +3,11 @@ package proxy
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
+	"time"
 
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
@@ -48,6 +50,13 @@ func (p *WSProxy) UpgradeMiddleware() fiber.Handler {
 		claims := token.Claims.(jwt.MapClaims)
 		c.Locals("userID", claims["sub"].(string))
 
+		// Label shown to other viewers; clients may pass ?device=, otherwise use the user agent.
+		device := c.Query("device")
+		if device == "" {
+			device = c.Get(fiber.HeaderUserAgent)
+		}
+		c.Locals("device", device)
+
 		if websocket.IsWebSocketUpgrade(c) {
 			return c.Next()
 		}
@@ -70,21 +79,31 @@ func (p *WSProxy) Handler() fiber.Handler {
 		sess, err := p.sessionRepo.FindByID(sessionID)
 		if err != nil || sess.UserID != userID {
 			log.Printf("session not found or unauthorized")
+			closeWith(c, worker.CloseSessionNotFound, "session not found")
 			return
 		}
 
 		// Route based on session type
 		if sess.SessionType == session.SessionTypeWorker {
-			p.relayViaHub(c, sess)
+			p.relayViaHub(c, sess, userID)
 		} else {
 			p.relayViaContainer(c, sess)
 		}
 	})
 }
 
-// relayViaHub registers a viewer and relays I/O through the worker hub.
-func (p *WSProxy) relayViaHub(c *websocket.Conn, sess *session.Session) {
-	vc := p.hub.RegisterViewer(sess.ID, c)
+// relayViaHub registers userID as a viewer and relays I/O through the worker hub.
+func (p *WSProxy) relayViaHub(c *websocket.Conn, sess *session.Session, userID uuid.UUID) {
+	device, _ := c.Locals("device").(string)
+	vc, err := p.hub.RegisterViewer(sess.ID, userID, device, c)
+	if err != nil {
+		code := worker.CloseSessionNotFound
+		if errors.Is(err, worker.ErrNotWorkerSession) {
+			code = worker.CloseNotWorkerSession
+		}
+		closeWith(c, code, err.Error())
+		return
+	}
 	defer p.hub.UnregisterViewer(sess.ID, vc)
 
 	for {
@@ -101,17 +120,26 @@ func (p *WSProxy) relayViaHub(c *websocket.Conn, sess *session.Session) {
 				Rows int    `json:"rows"`
 			}
 			if err := json.Unmarshal(data, &msg); err == nil && msg.Type == "resize" {
-				p.hub.SendResize(sess.ID, msg.Cols, msg.Rows)
+				if err := p.hub.SendResize(sess.ID, msg.Cols, msg.Rows); err != nil {
+					vc.SendError(err)
+				}
 				continue
 			}
 		}
 
 		// Binary message = terminal input, encode to base64 and send to hub
 		encoded := base64.StdEncoding.EncodeToString(data)
-		p.hub.SendInput(sess.ID, encoded)
+		if err := p.hub.SendInput(sess.ID, encoded); err != nil {
+			vc.SendError(err)
+		}
 	}
 }
 
+// closeWith sends a close frame with the given code and reason.
+func closeWith(c *websocket.Conn, code int, reason string) {
+	c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+}
+
 // relayViaContainer directly proxies to the container PTY bridge (existing behavior).
 func (p *WSProxy) relayViaContainer(c *websocket.Conn, sess *session.Session) {
 	if sess.Status != session.StatusRunning {
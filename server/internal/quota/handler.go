@@ -0,0 +1,106 @@
+package quota
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/moltty/server/internal/user"
+)
+
+type Handler struct {
+	repo   *Repository
+	meter  Meter
+	admins *user.Admins
+}
+
+func NewHandler(repo *Repository, meter Meter, admins *user.Admins) *Handler {
+	return &Handler{repo: repo, meter: meter, admins: admins}
+}
+
+func getUserID(c *fiber.Ctx) uuid.UUID {
+	token := c.Locals("user").(*jwt.Token)
+	claims := token.Claims.(jwt.MapClaims)
+	id, _ := uuid.Parse(claims["sub"].(string))
+	return id
+}
+
+// Get returns the caller's limits and current usage.
+func (h *Handler) Get(c *fiber.Ctx) error {
+	return h.respond(c, getUserID(c))
+}
+
+// GetUser returns another user's limits, overrides and usage. Admins only.
+func (h *Handler) GetUser(c *fiber.Ctx) error {
+	userID, ferr := h.targetUser(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+	return h.respond(c, userID)
+}
+
+// UpdateUser sets a user's quota overrides. Null fields fall back to the server
+// defaults and zero lifts a limit. Admins only.
+func (h *Handler) UpdateUser(c *fiber.Ctx) error {
+	userID, ferr := h.targetUser(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	var q UserQuota
+	if err := c.BodyParser(&q); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	if negative(q.MaxRunningSessions) || negative(q.MaxWorkers) || negative(q.MaxShareLinks) ||
+		(q.MaxStorageBytes != nil && *q.MaxStorageBytes < 0) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "limits must not be negative"})
+	}
+	q.UserID = userID
+	if err := h.repo.SaveOverride(&q); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to save quota"})
+	}
+	return h.respond(c, userID)
+}
+
+// DeleteUser removes a user's overrides. Admins only.
+func (h *Handler) DeleteUser(c *fiber.Ctx) error {
+	userID, ferr := h.targetUser(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+	if err := h.repo.DeleteOverride(userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to delete quota"})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// targetUser checks that the caller is an admin and parses the :userId param.
+func (h *Handler) targetUser(c *fiber.Ctx) (uuid.UUID, *fiber.Error) {
+	if !h.admins.IsAdmin(getUserID(c)) {
+		return uuid.Nil, fiber.NewError(fiber.StatusForbidden, "admin access required")
+	}
+	userID, err := uuid.Parse(c.Params("userId"))
+	if err != nil {
+		return uuid.Nil, fiber.NewError(fiber.StatusBadRequest, "invalid user id")
+	}
+	return userID, nil
+}
+
+func (h *Handler) respond(c *fiber.Ctx, userID uuid.UUID) error {
+	override, err := h.repo.FindOverride(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to load quota"})
+	}
+	usage, err := h.meter.QuotaUsage(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to measure usage"})
+	}
+	return c.JSON(fiber.Map{
+		"limits":   Resolve(h.repo.Defaults(), override),
+		"override": override,
+		"usage":    usage,
+	})
+}
+
+func negative(v *int) bool {
+	return v != nil && *v < 0
+}
@@ -0,0 +1,134 @@
+// Package quota limits how many resources each user may hold. Limits come from the
+// server configuration and can be overridden per user.
+package quota
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Resources a quota limits.
+const (
+	RunningSessions = "runningSessions"
+	Workers         = "workers"
+	Storage         = "storage" // scrollback and recordings, in bytes
+	ShareLinks      = "shareLinks"
+)
+
+// Limits are the quotas that apply to a user. Zero means unlimited.
+type Limits struct {
+	MaxRunningSessions int   `json:"maxRunningSessions"`
+	MaxWorkers         int   `json:"maxWorkers"`
+	MaxStorageBytes    int64 `json:"maxStorageBytes"`
+	MaxShareLinks      int   `json:"maxShareLinks"`
+}
+
+// UserQuota overrides the server's limits for one user. Nil fields use the server
+// default; zero lifts the limit.
+type UserQuota struct {
+	UserID             uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	MaxRunningSessions *int      `json:"maxRunningSessions"`
+	MaxWorkers         *int      `json:"maxWorkers"`
+	MaxStorageBytes    *int64    `json:"maxStorageBytes"`
+	MaxShareLinks      *int      `json:"maxShareLinks"`
+	UpdatedAt          time.Time `json:"updatedAt"`
+}
+
+// Resolve applies a user's overrides, which may be nil, on top of the defaults.
+func Resolve(defaults Limits, user *UserQuota) Limits {
+	l := defaults
+	if user == nil {
+		return l
+	}
+	if user.MaxRunningSessions != nil {
+		l.MaxRunningSessions = *user.MaxRunningSessions
+	}
+	if user.MaxWorkers != nil {
+		l.MaxWorkers = *user.MaxWorkers
+	}
+	if user.MaxStorageBytes != nil {
+		l.MaxStorageBytes = *user.MaxStorageBytes
+	}
+	if user.MaxShareLinks != nil {
+		l.MaxShareLinks = *user.MaxShareLinks
+	}
+	return l
+}
+
+// Usage is how much of each resource a user holds.
+type Usage struct {
+	RunningSessions int   `json:"runningSessions"`
+	Workers         int   `json:"workers"`
+	StorageBytes    int64 `json:"storageBytes"`
+	ShareLinks      int   `json:"shareLinks"` // always 0 until sessions can be shared by link
+}
+
+// Meter reports a user's current usage.
+type Meter interface {
+	QuotaUsage(userID uuid.UUID) (Usage, error)
+}
+
+// ExceededError reports that an action would take a user over a quota.
+type ExceededError struct {
+	Resource string
+	Limit    int64
+}
+
+func (e *ExceededError) Error() string {
+	switch e.Resource {
+	case RunningSessions:
+		return fmt.Sprintf("quota exceeded: at most %d running sessions", e.Limit)
+	case Workers:
+		return fmt.Sprintf("quota exceeded: at most %d workers", e.Limit)
+	case Storage:
+		return fmt.Sprintf("quota exceeded: storage is limited to %d bytes", e.Limit)
+	case ShareLinks:
+		return fmt.Sprintf("quota exceeded: at most %d share links", e.Limit)
+	}
+	return "quota exceeded: " + e.Resource
+}
+
+// StatusCode is the HTTP status for the error: 429 for the running-session limit,
+// which frees up as sessions exit, and 403 for the others.
+func (e *ExceededError) StatusCode() int {
+	if e.Resource == RunningSessions {
+		return fiber.StatusTooManyRequests
+	}
+	return fiber.StatusForbidden
+}
+
+// AllowSession checks that the user may start another session.
+func (l Limits) AllowSession(u Usage) error {
+	if l.MaxRunningSessions > 0 && u.RunningSessions >= l.MaxRunningSessions {
+		return &ExceededError{Resource: RunningSessions, Limit: int64(l.MaxRunningSessions)}
+	}
+	return l.AllowStorage(u)
+}
+
+// AllowWorker checks that the user may register another worker.
+func (l Limits) AllowWorker(u Usage) error {
+	if l.MaxWorkers > 0 && u.Workers >= l.MaxWorkers {
+		return &ExceededError{Resource: Workers, Limit: int64(l.MaxWorkers)}
+	}
+	return nil
+}
+
+// AllowStorage checks that the user has storage left for new output. It is checked
+// when sessions start; running sessions are not stopped when they pass the limit.
+func (l Limits) AllowStorage(u Usage) error {
+	if l.MaxStorageBytes > 0 && u.StorageBytes >= l.MaxStorageBytes {
+		return &ExceededError{Resource: Storage, Limit: l.MaxStorageBytes}
+	}
+	return nil
+}
+
+// AllowShareLink checks that the user may create another share link.
+func (l Limits) AllowShareLink(u Usage) error {
+	if l.MaxShareLinks > 0 && u.ShareLinks >= l.MaxShareLinks {
+		return &ExceededError{Resource: ShareLinks, Limit: int64(l.MaxShareLinks)}
+	}
+	return nil
+}
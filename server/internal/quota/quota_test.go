@@ -0,0 +1,95 @@
+package quota
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestResolve(t *testing.T) {
+	defaults := Limits{MaxRunningSessions: 5, MaxWorkers: 2, MaxStorageBytes: 1 << 30, MaxShareLinks: 10}
+	zero, three := 0, 3
+	var big int64 = 5 << 30
+
+	tests := []struct {
+		name string
+		user *UserQuota
+		want Limits
+	}{
+		{"no overrides", nil, defaults},
+		{"empty overrides", &UserQuota{}, defaults},
+		{
+			name: "raised and lowered",
+			user: &UserQuota{MaxRunningSessions: &three, MaxStorageBytes: &big},
+			want: Limits{MaxRunningSessions: 3, MaxWorkers: 2, MaxStorageBytes: 5 << 30, MaxShareLinks: 10},
+		},
+		{
+			name: "zero lifts a limit",
+			user: &UserQuota{MaxWorkers: &zero, MaxShareLinks: &zero},
+			want: Limits{MaxRunningSessions: 5, MaxStorageBytes: 1 << 30},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Resolve(defaults, tt.user); got != tt.want {
+				t.Errorf("Resolve() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitsAllow(t *testing.T) {
+	limits := Limits{MaxRunningSessions: 2, MaxWorkers: 1, MaxStorageBytes: 1000, MaxShareLinks: 3}
+
+	tests := []struct {
+		name     string
+		limits   Limits
+		check    func(Limits, Usage) error
+		usage    Usage
+		resource string // empty if allowed
+	}{
+		{"session under the limit", limits, Limits.AllowSession, Usage{RunningSessions: 1, StorageBytes: 999}, ""},
+		{"session at the limit", limits, Limits.AllowSession, Usage{RunningSessions: 2}, RunningSessions},
+		{"session without storage", limits, Limits.AllowSession, Usage{StorageBytes: 1000}, Storage},
+		{"worker under the limit", limits, Limits.AllowWorker, Usage{RunningSessions: 5}, ""},
+		{"worker at the limit", limits, Limits.AllowWorker, Usage{Workers: 1}, Workers},
+		{"storage over the limit", limits, Limits.AllowStorage, Usage{StorageBytes: 5000}, Storage},
+		{"share link at the limit", limits, Limits.AllowShareLink, Usage{ShareLinks: 3}, ShareLinks},
+		{"unlimited", Limits{}, Limits.AllowSession, Usage{RunningSessions: 100, StorageBytes: 1 << 40}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.check(tt.limits, tt.usage)
+			if tt.resource == "" {
+				if err != nil {
+					t.Errorf("got %v, want allowed", err)
+				}
+				return
+			}
+			var exceeded *ExceededError
+			if !errors.As(err, &exceeded) || exceeded.Resource != tt.resource {
+				t.Errorf("got %v, want %s exceeded", err, tt.resource)
+			}
+		})
+	}
+}
+
+func TestExceededErrorStatusCode(t *testing.T) {
+	tests := []struct {
+		resource string
+		want     int
+	}{
+		{RunningSessions, fiber.StatusTooManyRequests},
+		{Workers, fiber.StatusForbidden},
+		{Storage, fiber.StatusForbidden},
+		{ShareLinks, fiber.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.resource, func(t *testing.T) {
+			if got := (&ExceededError{Resource: tt.resource}).StatusCode(); got != tt.want {
+				t.Errorf("StatusCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
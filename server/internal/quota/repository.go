@@ -0,0 +1,53 @@
+package quota
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type Repository struct {
+	db       *gorm.DB
+	defaults Limits
+}
+
+func NewRepository(db *gorm.DB, defaults Limits) *Repository {
+	return &Repository{db: db, defaults: defaults}
+}
+
+// Defaults returns the server-wide limits.
+func (r *Repository) Defaults() Limits {
+	return r.defaults
+}
+
+// FindOverride returns the user's overrides, or nil if there are none.
+func (r *Repository) FindOverride(userID uuid.UUID) (*UserQuota, error) {
+	var q UserQuota
+	err := r.db.First(&q, "user_id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// Limits returns the limits that apply to a user.
+func (r *Repository) Limits(userID uuid.UUID) (Limits, error) {
+	override, err := r.FindOverride(userID)
+	if err != nil {
+		return r.defaults, err
+	}
+	return Resolve(r.defaults, override), nil
+}
+
+func (r *Repository) SaveOverride(q *UserQuota) error {
+	return r.db.Save(q).Error
+}
+
+// DeleteOverride puts a user back on the server defaults.
+func (r *Repository) DeleteOverride(userID uuid.UUID) error {
+	return r.db.Delete(&UserQuota{}, "user_id = ?", userID).Error
+}
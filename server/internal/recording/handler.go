@@ -0,0 +1,164 @@
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/moltty/server/internal/session"
+)
+
+type Handler struct {
+	repo        *Repository
+	recorder    *Recorder
+	sessionRepo *session.Repository
+}
+
+func NewHandler(repo *Repository, recorder *Recorder, sessionRepo *session.Repository) *Handler {
+	return &Handler{repo: repo, recorder: recorder, sessionRepo: sessionRepo}
+}
+
+func getUserID(c *fiber.Ctx) uuid.UUID {
+	token := c.Locals("user").(*jwt.Token)
+	claims := token.Claims.(jwt.MapClaims)
+	id, _ := uuid.Parse(claims["sub"].(string))
+	return id
+}
+
+// GetSettings returns the user's recording settings.
+func (h *Handler) GetSettings(c *fiber.Ctx) error {
+	settings, err := h.repo.FindSettings(getUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to load settings"})
+	}
+	return c.JSON(settings)
+}
+
+// UpdateSettings opts the user in or out of recording and sets how long recordings are kept.
+func (h *Handler) UpdateSettings(c *fiber.Ctx) error {
+	userID := getUserID(c)
+
+	settings, err := h.repo.FindSettings(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to load settings"})
+	}
+	if err := c.BodyParser(settings); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	if settings.RetentionDays <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "retentionDays must be positive"})
+	}
+	settings.UserID = userID
+
+	if err := h.repo.SaveSettings(settings); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to save settings"})
+	}
+	return c.JSON(settings)
+}
+
+// Download serves a session's recording as an asciicast v2 file.
+func (h *Handler) Download(c *fiber.Ctx) error {
+	userID := getUserID(c)
+	sessionID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid session id"})
+	}
+
+	sess, err := h.sessionRepo.FindByID(sessionID)
+	if err != nil || sess.UserID != userID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
+	}
+
+	h.recorder.Flush(sess.ID)
+	path := h.recorder.Path(sess.ID)
+	if _, err := os.Stat(path); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "no recording for this session"})
+	}
+
+	if err := c.SendFile(path); err != nil {
+		return err
+	}
+	// Set after SendFile, which derives the content type from the file extension.
+	c.Set(fiber.HeaderContentType, "application/x-asciicast")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s.cast"`, sess.ID))
+	return nil
+}
+
+// Replay streams a session's recording to a viewer over WebSocket with its original
+// timing. Query parameters: speed (multiplier), idleLimit (max seconds of idle time
+// between events) and t (start position in seconds). The viewer may send pause,
+// resume, speed and seek control messages as JSON text frames.
+func (h *Handler) Replay() fiber.Handler {
+	return websocket.New(func(c *websocket.Conn) {
+		userID, _ := uuid.Parse(c.Locals("userID").(string))
+
+		sessionID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			log.Printf("replay: invalid session id: %v", err)
+			return
+		}
+
+		sess, err := h.sessionRepo.FindByID(sessionID)
+		if err != nil || sess.UserID != userID {
+			log.Printf("replay: session not found or unauthorized")
+			return
+		}
+
+		h.recorder.Flush(sess.ID)
+		cast, err := LoadCast(h.recorder.Path(sess.ID))
+		if err != nil {
+			c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "no recording for this session"))
+			return
+		}
+
+		speed, _ := strconv.ParseFloat(c.Query("speed", "1"), 64)
+		idleLimit, _ := strconv.ParseFloat(c.Query("idleLimit", "0"), 64)
+		start, _ := strconv.ParseFloat(c.Query("t", "0"), 64)
+		cast.CompressIdle(idleLimit)
+
+		player := NewPlayer(cast, &wsSink{conn: c}, speed)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				msgType, data, err := c.ReadMessage()
+				if err != nil {
+					return
+				}
+				if msgType != websocket.TextMessage {
+					continue
+				}
+				var ctl ReplayControl
+				if err := json.Unmarshal(data, &ctl); err == nil {
+					player.Control(ctl)
+				}
+			}
+		}()
+
+		if err := player.Run(start, done); err != nil {
+			log.Printf("replay: session %s: %v", sess.ID, err)
+		}
+	})
+}
+
+// wsSink writes replay output to a viewer WebSocket using the live terminal framing:
+// binary frames for output, JSON text frames for control messages.
+type wsSink struct {
+	conn *websocket.Conn
+}
+
+func (s *wsSink) WriteOutput(data []byte) error {
+	return s.conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+func (s *wsSink) WriteControl(msg ReplayMessage) error {
+	data, _ := json.Marshal(msg)
+	return s.conn.WriteMessage(websocket.TextMessage, data)
+}
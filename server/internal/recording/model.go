@@ -0,0 +1,16 @@
+package recording
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Settings holds a user's session recording preferences.
+type Settings struct {
+	UserID        uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	Enabled       bool      `gorm:"column:enabled" json:"enabled"`
+	RetentionDays int       `gorm:"column:retention_days;not null" json:"retentionDays"`
+	CreatedAt     time.Time `json:"-"`
+	UpdatedAt     time.Time `json:"-"`
+}
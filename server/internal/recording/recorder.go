@@ -0,0 +1,343 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/moltty/server/internal/session"
+	"gorm.io/gorm"
+)
+
+// Default terminal size written to the header when no resize has been seen yet.
+const (
+	defaultCols = 80
+	defaultRows = 24
+)
+
+// Header is the first line of an asciicast v2 file.
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// castWriter is an open recording for one session.
+type castWriter struct {
+	file    *os.File
+	buf     *bufio.Writer
+	started time.Time
+	pending []byte // trailing bytes of an incomplete UTF-8 sequence
+}
+
+// Recorder writes session output and resize events to asciicast v2 files, one per
+// session, in a directory outside the database. Recording is opt-in per user.
+type Recorder struct {
+	dir         string
+	repo        *Repository
+	sessionRepo *session.Repository
+
+	mu      sync.Mutex
+	writers map[uuid.UUID]*castWriter // nil entry: session checked and not recorded
+	sizes   map[uuid.UUID][2]int      // last known cols, rows of sessions in writers
+}
+
+func NewRecorder(dir string, repo *Repository, sessionRepo *session.Repository) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("create recording dir: %w", err)
+	}
+	r := &Recorder{
+		dir:         dir,
+		repo:        repo,
+		sessionRepo: sessionRepo,
+		writers:     make(map[uuid.UUID]*castWriter),
+		sizes:       make(map[uuid.UUID][2]int),
+	}
+	go r.flushLoop(time.Second)
+	return r, nil
+}
+
+// Path returns where a session's recording is stored.
+func (r *Recorder) Path(sessionID uuid.UUID) string {
+	return filepath.Join(r.dir, sessionID.String()+".cast")
+}
+
+// Start begins (or continues) recording a session if its owner opted in. A session
+// that is resumed keeps appending to the same file.
+func (r *Recorder) Start(sessionID, userID uuid.UUID, title string) {
+	enabled := r.enabled(userID)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closeLocked(sessionID)
+	r.openLocked(sessionID, enabled, title)
+}
+
+// Output records a chunk of PTY output. Sessions already running when the server
+// started are picked up here on their first output.
+func (r *Recorder) Output(sessionID, userID uuid.UUID, data []byte) {
+	r.mu.Lock()
+	w, checked := r.writers[sessionID]
+	if !checked {
+		// Look the settings up without holding the lock every session shares.
+		r.mu.Unlock()
+		enabled := r.enabled(userID)
+		r.mu.Lock()
+		if w, checked = r.writers[sessionID]; !checked {
+			w = r.openLocked(sessionID, enabled, "")
+		}
+	}
+	defer r.mu.Unlock()
+	if w == nil {
+		return
+	}
+
+	data = append(w.pending, data...)
+	cut := incompleteUTF8Suffix(data)
+	w.pending = append([]byte(nil), data[len(data)-cut:]...)
+	if len(data) > cut {
+		r.writeEvent(w, "o", string(data[:len(data)-cut]))
+	}
+}
+
+// Resize records a terminal size change of a started session.
+func (r *Recorder) Resize(sessionID uuid.UUID, cols, rows int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, started := r.writers[sessionID]; !started {
+		return
+	}
+	r.sizes[sessionID] = [2]int{cols, rows}
+	if w := r.writers[sessionID]; w != nil {
+		r.writeEvent(w, "r", fmt.Sprintf("%dx%d", cols, rows))
+	}
+}
+
+// Stop closes a session's recording.
+func (r *Recorder) Stop(sessionID uuid.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closeLocked(sessionID)
+	delete(r.writers, sessionID)
+	delete(r.sizes, sessionID)
+}
+
+// Flush writes buffered events for a session to disk.
+func (r *Recorder) Flush(sessionID uuid.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if w := r.writers[sessionID]; w != nil {
+		w.buf.Flush()
+	}
+}
+
+// Delete stops and removes a session's recording.
+func (r *Recorder) Delete(sessionID uuid.UUID) error {
+	r.Stop(sessionID)
+
+	err := os.Remove(r.Path(sessionID))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Size returns the size in bytes of a session's recording.
+func (r *Recorder) Size(sessionID uuid.UUID) int64 {
+	r.Flush(sessionID)
+	info, err := os.Stat(r.Path(sessionID))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// enabled reports whether a user opted in to recording.
+func (r *Recorder) enabled(userID uuid.UUID) bool {
+	settings, err := r.repo.FindSettings(userID)
+	if err != nil {
+		log.Printf("recording: failed to load settings for user %s: %v", userID, err)
+		return false
+	}
+	return settings.Enabled
+}
+
+// openLocked opens a session's recording file if its owner opted in, and remembers
+// the decision either way. Callers must hold r.mu.
+func (r *Recorder) openLocked(sessionID uuid.UUID, enabled bool, title string) *castWriter {
+	r.writers[sessionID] = nil
+	if !enabled {
+		return nil
+	}
+
+	path := r.Path(sessionID)
+	started, ok := readStart(path)
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		log.Printf("recording: failed to open %s: %v", path, err)
+		return nil
+	}
+
+	w := &castWriter{file: f, buf: bufio.NewWriter(f), started: started}
+	if !ok {
+		w.started = time.Now()
+		size, known := r.sizes[sessionID]
+		if !known {
+			size = [2]int{defaultCols, defaultRows}
+		}
+		header, _ := json.Marshal(Header{
+			Version:   2,
+			Width:     size[0],
+			Height:    size[1],
+			Timestamp: w.started.Unix(),
+			Title:     title,
+			Env:       map[string]string{"TERM": "xterm-256color"},
+		})
+		w.buf.Write(header)
+		w.buf.WriteByte('\n')
+	}
+
+	r.writers[sessionID] = w
+	return w
+}
+
+// closeLocked flushes and closes a session's recording file. Callers must hold r.mu.
+func (r *Recorder) closeLocked(sessionID uuid.UUID) {
+	w := r.writers[sessionID]
+	if w == nil {
+		return
+	}
+	if len(w.pending) > 0 {
+		r.writeEvent(w, "o", string(w.pending))
+	}
+	w.buf.Flush()
+	w.file.Close()
+	r.writers[sessionID] = nil
+}
+
+func (r *Recorder) writeEvent(w *castWriter, code, data string) {
+	line, _ := json.Marshal([]interface{}{
+		time.Since(w.started).Seconds(),
+		code,
+		data,
+	})
+	w.buf.Write(line)
+	w.buf.WriteByte('\n')
+}
+
+func (r *Recorder) flushLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		r.mu.Lock()
+		for _, w := range r.writers {
+			if w != nil {
+				w.buf.Flush()
+			}
+		}
+		r.mu.Unlock()
+	}
+}
+
+// StartRetention periodically removes expired recordings.
+func (r *Recorder) StartRetention(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			r.Sweep()
+		}
+	}()
+}
+
+// Sweep deletes recordings older than their owner's retention period, and recordings
+// whose session no longer exists.
+func (r *Recorder) Sweep() {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		log.Printf("recording: failed to list recordings: %v", err)
+		return
+	}
+
+	for _, e := range entries {
+		id, err := uuid.Parse(strings.TrimSuffix(e.Name(), ".cast"))
+		if err != nil {
+			continue
+		}
+
+		// Soft-deleted sessions keep their recording until they are purged.
+		sess, err := r.sessionRepo.FindByIDUnscoped(id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			r.Delete(id)
+			continue
+		}
+		if err != nil {
+			log.Printf("recording: failed to look up session %s: %v", id, err)
+			continue
+		}
+
+		settings, err := r.repo.FindSettings(sess.UserID)
+		if err != nil || settings.RetentionDays <= 0 {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) > time.Duration(settings.RetentionDays)*24*time.Hour {
+			log.Printf("recording: removing expired recording for session %s", id)
+			r.Delete(id)
+		}
+	}
+}
+
+// readStart returns the start time recorded in an existing file's header.
+func readStart(path string) (time.Time, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil {
+		return time.Time{}, false
+	}
+	var h Header
+	if err := json.Unmarshal(line, &h); err != nil || h.Version != 2 {
+		return time.Time{}, false
+	}
+	return time.Unix(h.Timestamp, 0), true
+}
+
+// incompleteUTF8Suffix returns how many trailing bytes of b form the start of a
+// multi-byte UTF-8 sequence that hasn't been completed yet.
+func incompleteUTF8Suffix(b []byte) int {
+	for i := 1; i <= utf8.UTFMax-1 && i <= len(b); i++ {
+		c := b[len(b)-i]
+		if c < 0x80 {
+			return 0
+		}
+		if utf8.RuneStart(c) {
+			if utf8.FullRune(b[len(b)-i:]) {
+				return 0
+			}
+			return i
+		}
+	}
+	return 0
+}
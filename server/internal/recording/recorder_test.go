@@ -0,0 +1,149 @@
+package recording
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// newTestRecorder returns a recorder without settings or session lookups. Sessions
+// must be opened with open before they are recorded.
+func newTestRecorder(t *testing.T) *Recorder {
+	t.Helper()
+	return &Recorder{
+		dir:     t.TempDir(),
+		writers: make(map[uuid.UUID]*castWriter),
+		sizes:   make(map[uuid.UUID][2]int),
+	}
+}
+
+func (r *Recorder) open(sessionID uuid.UUID, enabled bool, title string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closeLocked(sessionID)
+	r.openLocked(sessionID, enabled, title)
+}
+
+func castEvents(c *Cast) [][2]string {
+	var events [][2]string
+	for _, ev := range c.Events {
+		events = append(events, [2]string{ev.Code, ev.Data})
+	}
+	return events
+}
+
+func TestRecorderWritesCast(t *testing.T) {
+	r := newTestRecorder(t)
+	id := uuid.New()
+
+	r.Resize(id, 100, 30) // not started yet: ignored
+	r.open(id, true, "build")
+	r.Output(id, uuid.Nil, []byte("caf\xc3"))
+	r.Output(id, uuid.Nil, []byte("\xa9 ok\r\n"))
+	r.Resize(id, 120, 40)
+	r.Output(id, uuid.Nil, []byte("\xe2\x82")) // cut off by Stop
+	r.Stop(id)
+
+	cast, err := LoadCast(r.Path(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := cast.Header
+	if h.Version != 2 || h.Width != defaultCols || h.Height != defaultRows || h.Title != "build" || h.Timestamp == 0 {
+		t.Errorf("header = %+v", h)
+	}
+	// A split character is held back until it is complete; one left incomplete
+	// is written when the recording stops.
+	want := [][2]string{{"o", "caf"}, {"o", "é ok\r\n"}, {"r", "120x40"}, {"o", "\ufffd\ufffd"}}
+	if got := castEvents(cast); !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+	for i := 1; i < len(cast.Events); i++ {
+		if cast.Events[i].Time < cast.Events[i-1].Time {
+			t.Errorf("event times go backwards: %v", eventTimes(cast))
+		}
+	}
+}
+
+func TestRecorderResumeAppends(t *testing.T) {
+	r := newTestRecorder(t)
+	id := uuid.New()
+
+	r.open(id, true, "first run")
+	r.Output(id, uuid.Nil, []byte("one\r\n"))
+	r.Stop(id)
+	r.open(id, true, "second run")
+	r.Output(id, uuid.Nil, []byte("two\r\n"))
+	if size := r.Size(id); size == 0 {
+		t.Error("Size() = 0 for an open recording")
+	}
+	r.Stop(id)
+
+	cast, err := LoadCast(r.Path(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cast.Header.Title != "first run" {
+		t.Errorf("title = %q, want the first run's header to be kept", cast.Header.Title)
+	}
+	if got, want := castEvents(cast), [][2]string{{"o", "one\r\n"}, {"o", "two\r\n"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
+
+func TestRecorderOptOut(t *testing.T) {
+	r := newTestRecorder(t)
+	id := uuid.New()
+
+	r.open(id, false, "")
+	r.Output(id, uuid.Nil, []byte("secret"))
+	r.Resize(id, 100, 30)
+	r.Stop(id)
+
+	if _, err := os.Stat(r.Path(id)); !os.IsNotExist(err) {
+		t.Errorf("recording written for a session that isn't recorded: %v", err)
+	}
+	if err := r.Delete(id); err != nil {
+		t.Errorf("Delete() of a missing recording = %v", err)
+	}
+}
+
+func TestRecorderDelete(t *testing.T) {
+	r := newTestRecorder(t)
+	id := uuid.New()
+	r.open(id, true, "")
+	r.Output(id, uuid.Nil, []byte("x"))
+
+	if err := r.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(r.Path(id)); !os.IsNotExist(err) {
+		t.Errorf("recording still exists: %v", err)
+	}
+	if r.Size(id) != 0 {
+		t.Error("Size() of a deleted recording is not zero")
+	}
+}
+
+func TestIncompleteUTF8Suffix(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"", 0},
+		{"ascii", 0},
+		{"caf\xc3\xa9", 0},
+		{"caf\xc3", 1},
+		{"\xe2\x82", 2},
+		{"a\xf0\x9f\x98", 3},
+		{"\xf0\x9f\x98\x80", 0},
+		{"\x80\x80", 0}, // stray continuation bytes are not held back
+	}
+	for _, tt := range tests {
+		if got := incompleteUTF8Suffix([]byte(tt.in)); got != tt.want {
+			t.Errorf("incompleteUTF8Suffix(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
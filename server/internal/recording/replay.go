@@ -0,0 +1,279 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// replayChunkSize bounds the size of a single binary frame when fast-forwarding.
+const replayChunkSize = 64 * 1024
+
+// castEvent is one event line of an asciicast v2 file.
+type castEvent struct {
+	Time float64
+	Code string // "o" output, "r" resize
+	Data string
+}
+
+// Cast is a parsed asciicast v2 recording.
+type Cast struct {
+	Header Header
+	Events []castEvent
+}
+
+// Duration returns the time of the last event.
+func (c *Cast) Duration() float64 {
+	if len(c.Events) == 0 {
+		return 0
+	}
+	return c.Events[len(c.Events)-1].Time
+}
+
+// LoadCast reads an asciicast v2 file. Malformed event lines are skipped.
+func LoadCast(path string) (*Cast, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("empty recording")
+	}
+	var cast Cast
+	if err := json.Unmarshal(scanner.Bytes(), &cast.Header); err != nil || cast.Header.Version != 2 {
+		return nil, fmt.Errorf("not an asciicast v2 file")
+	}
+
+	for scanner.Scan() {
+		var raw []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &raw); err != nil || len(raw) != 3 {
+			continue
+		}
+		t, ok1 := raw[0].(float64)
+		code, ok2 := raw[1].(string)
+		data, ok3 := raw[2].(string)
+		if !ok1 || !ok2 || !ok3 {
+			continue
+		}
+		cast.Events = append(cast.Events, castEvent{Time: t, Code: code, Data: data})
+	}
+	return &cast, scanner.Err()
+}
+
+// CompressIdle shortens every gap between events to at most limit seconds.
+func (c *Cast) CompressIdle(limit float64) {
+	if limit <= 0 {
+		return
+	}
+	var shift, prev float64
+	for i := range c.Events {
+		orig := c.Events[i].Time
+		if gap := orig - prev; gap > limit {
+			shift += gap - limit
+		}
+		prev = orig
+		c.Events[i].Time = orig - shift
+	}
+}
+
+// ReplayControl is a control message sent by a replay viewer.
+type ReplayControl struct {
+	Type  string  `json:"type"`  // pause, resume, speed, seek
+	Speed float64 `json:"speed"` // playback multiplier (for "speed")
+	Time  float64 `json:"time"`  // seconds into the recording (for "seek")
+}
+
+// ReplayMessage is a JSON control message sent to a replay viewer. Output is sent
+// as binary frames, exactly like the live terminal.
+type ReplayMessage struct {
+	Type     string  `json:"type"`               // replay, resize
+	Event    string  `json:"event,omitempty"`    // start, paused, resumed, speed, seeked, end (for "replay")
+	Time     float64 `json:"time"`               // current position in seconds
+	Duration float64 `json:"duration,omitempty"` // total length (for "start")
+	Speed    float64 `json:"speed,omitempty"`    // current speed multiplier
+	Cols     int     `json:"cols,omitempty"`     // terminal size (for "start" and "resize")
+	Rows     int     `json:"rows,omitempty"`
+}
+
+// ReplaySink receives replay output. Implementations must be safe for a single writer.
+type ReplaySink interface {
+	WriteOutput(data []byte) error
+	WriteControl(msg ReplayMessage) error
+}
+
+// Player streams a Cast to a sink with its original timing, adjusted by a speed
+// multiplier, and responds to pause, resume, speed and seek controls.
+type Player struct {
+	cast     *Cast
+	sink     ReplaySink
+	speed    float64
+	controls chan ReplayControl
+}
+
+func NewPlayer(cast *Cast, sink ReplaySink, speed float64) *Player {
+	if speed <= 0 {
+		speed = 1
+	}
+	return &Player{
+		cast:     cast,
+		sink:     sink,
+		speed:    speed,
+		controls: make(chan ReplayControl, 16),
+	}
+}
+
+// Control queues a control message for the player.
+func (p *Player) Control(msg ReplayControl) {
+	select {
+	case p.controls <- msg:
+	default:
+	}
+}
+
+// Run plays the recording from start seconds until the sink fails or done is closed.
+// Reaching the end sends an "end" event; the viewer can still seek back afterwards.
+func (p *Player) Run(start float64, done <-chan struct{}) error {
+	cols, rows := p.cast.Header.Width, p.cast.Header.Height
+	if err := p.sink.WriteControl(ReplayMessage{
+		Type: "replay", Event: "start", Duration: p.cast.Duration(), Speed: p.speed, Cols: cols, Rows: rows,
+	}); err != nil {
+		return err
+	}
+
+	next, err := p.seek(start)
+	if err != nil {
+		return err
+	}
+	position := start
+	anchor := time.Now() // wall time at which playback was at position
+	paused, ended := false, false
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		// Schedule the next event.
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if !paused && next < len(p.cast.Events) {
+			wait := time.Duration((p.cast.Events[next].Time - position) / p.speed * float64(time.Second))
+			timer.Reset(wait - time.Since(anchor))
+		}
+		if !paused && !ended && next >= len(p.cast.Events) {
+			ended = true
+			if err := p.sink.WriteControl(ReplayMessage{Type: "replay", Event: "end", Time: p.cast.Duration()}); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-done:
+			return nil
+
+		case <-timer.C:
+			ev := p.cast.Events[next]
+			if err := p.emit(ev); err != nil {
+				return err
+			}
+			next++
+			anchor = anchor.Add(time.Duration((ev.Time - position) / p.speed * float64(time.Second)))
+			position = ev.Time
+
+		case ctl := <-p.controls:
+			// Bring position up to date before changing anything.
+			if !paused {
+				position += time.Since(anchor).Seconds() * p.speed
+				if next < len(p.cast.Events) {
+					position = min(position, p.cast.Events[next].Time)
+				} else {
+					position = min(position, p.cast.Duration())
+				}
+			}
+			anchor = time.Now()
+
+			var event string
+			switch ctl.Type {
+			case "pause":
+				paused, event = true, "paused"
+			case "resume":
+				paused, event = false, "resumed"
+			case "speed":
+				if ctl.Speed > 0 {
+					p.speed, event = ctl.Speed, "speed"
+				}
+			case "seek":
+				if next, err = p.seek(ctl.Time); err != nil {
+					return err
+				}
+				position, event, ended = max(ctl.Time, 0), "seeked", false
+			}
+			if event != "" {
+				if err := p.sink.WriteControl(ReplayMessage{Type: "replay", Event: event, Time: position, Speed: p.speed}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// seek resets the viewer's terminal and fast-forwards all events up to t, returning
+// the index of the first event after t.
+func (p *Player) seek(t float64) (int, error) {
+	var out strings.Builder
+	cols, rows := p.cast.Header.Width, p.cast.Header.Height
+
+	next := 0
+	for next < len(p.cast.Events) && p.cast.Events[next].Time <= t {
+		ev := p.cast.Events[next]
+		switch ev.Code {
+		case "o":
+			out.WriteString(ev.Data)
+		case "r":
+			fmt.Sscanf(ev.Data, "%dx%d", &cols, &rows)
+		}
+		next++
+	}
+
+	if err := p.sink.WriteControl(ReplayMessage{Type: "resize", Time: t, Cols: cols, Rows: rows}); err != nil {
+		return 0, err
+	}
+	// RIS: full terminal reset before repainting from the start.
+	if err := p.sink.WriteOutput([]byte("\x1bc")); err != nil {
+		return 0, err
+	}
+
+	data := []byte(out.String())
+	for len(data) > 0 {
+		n := min(len(data), replayChunkSize)
+		if err := p.sink.WriteOutput(data[:n]); err != nil {
+			return 0, err
+		}
+		data = data[n:]
+	}
+	return next, nil
+}
+
+func (p *Player) emit(ev castEvent) error {
+	switch ev.Code {
+	case "o":
+		return p.sink.WriteOutput([]byte(ev.Data))
+	case "r":
+		var cols, rows int
+		if _, err := fmt.Sscanf(ev.Data, "%dx%d", &cols, &rows); err == nil {
+			return p.sink.WriteControl(ReplayMessage{Type: "resize", Time: ev.Time, Cols: cols, Rows: rows})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package recording
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSink collects what a Player sends.
+type testSink struct {
+	mu       sync.Mutex
+	output   []byte
+	controls []ReplayMessage
+	ended    chan struct{}
+}
+
+func newTestSink() *testSink {
+	return &testSink{ended: make(chan struct{}, 1)}
+}
+
+func (s *testSink) WriteOutput(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.output = append(s.output, data...)
+	return nil
+}
+
+func (s *testSink) WriteControl(msg ReplayMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.controls = append(s.controls, msg)
+	if msg.Event == "end" {
+		s.ended <- struct{}{}
+	}
+	return nil
+}
+
+func eventTimes(c *Cast) []float64 {
+	times := make([]float64, len(c.Events))
+	for i, ev := range c.Events {
+		times[i] = ev.Time
+	}
+	return times
+}
+
+func TestCastCompressIdle(t *testing.T) {
+	tests := []struct {
+		name  string
+		times []float64
+		limit float64
+		want  []float64
+	}{
+		{"disabled", []float64{1, 10, 100}, 0, []float64{1, 10, 100}},
+		{"short gaps kept", []float64{0.5, 1, 2.5}, 2, []float64{0.5, 1, 2.5}},
+		{"long gaps shortened", []float64{1, 11, 12, 40}, 2, []float64{1, 3, 4, 6}},
+		{"leading gap", []float64{30, 31}, 5, []float64{5, 6}},
+		{"no events", nil, 1, []float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Cast{}
+			for _, tm := range tt.times {
+				c.Events = append(c.Events, castEvent{Time: tm, Code: "o"})
+			}
+			c.CompressIdle(tt.limit)
+			if got := eventTimes(c); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("times = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadCast(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.cast")
+	data := `{"version":2,"width":80,"height":24}
+[0.5,"o","hello"]
+not json
+[1,"o"]
+[1.5,"r","100x30"]
+[2,"o",42]
+[3,"o","world"]
+`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadCast(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []castEvent{{0.5, "o", "hello"}, {1.5, "r", "100x30"}, {3, "o", "world"}}
+	if !reflect.DeepEqual(c.Events, want) {
+		t.Errorf("events = %v, want %v", c.Events, want)
+	}
+	if c.Header.Width != 80 || c.Header.Height != 24 || c.Duration() != 3 {
+		t.Errorf("header = %+v, duration %v", c.Header, c.Duration())
+	}
+}
+
+func TestPlayerSeek(t *testing.T) {
+	cast := &Cast{
+		Header: Header{Version: 2, Width: 80, Height: 24},
+		Events: []castEvent{
+			{1, "o", "one "},
+			{2, "r", "100x30"},
+			{3, "o", "three "},
+			{4, "o", "four"},
+		},
+	}
+	tests := []struct {
+		name       string
+		time       float64
+		next       int
+		output     string
+		cols, rows int
+	}{
+		{"before the first event", 0, 0, "", 80, 24},
+		{"at an event", 1, 1, "one ", 80, 24},
+		{"after a resize", 3.5, 3, "one three ", 100, 30},
+		{"past the end", 10, 4, "one three four", 100, 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := newTestSink()
+			p := NewPlayer(cast, sink, 1)
+			next, err := p.seek(tt.time)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next != tt.next {
+				t.Errorf("next = %d, want %d", next, tt.next)
+			}
+			if got, want := string(sink.output), "\x1bc"+tt.output; got != want {
+				t.Errorf("output = %q, want %q", got, want)
+			}
+			resize := sink.controls[0]
+			if resize.Type != "resize" || resize.Cols != tt.cols || resize.Rows != tt.rows {
+				t.Errorf("control = %+v, want resize to %dx%d", resize, tt.cols, tt.rows)
+			}
+		})
+	}
+}
+
+func TestPlayerRun(t *testing.T) {
+	cast := &Cast{
+		Header: Header{Version: 2, Width: 80, Height: 24},
+		Events: []castEvent{{0.01, "o", "a"}, {0.02, "o", "b"}, {0.03, "o", "c"}},
+	}
+	sink := newTestSink()
+	p := NewPlayer(cast, sink, 10)
+	done := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() { errc <- p.Run(0.015, done) }()
+
+	select {
+	case <-sink.ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("playback did not end")
+	}
+	close(done)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if got, want := string(sink.output), "\x1bcabc"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if start := sink.controls[0]; start.Event != "start" || start.Duration != 0.03 || start.Speed != 10 {
+		t.Errorf("first control = %+v, want start", start)
+	}
+}
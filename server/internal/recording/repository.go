@@ -0,0 +1,35 @@
+package recording
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type Repository struct {
+	db                   *gorm.DB
+	defaultRetentionDays int
+}
+
+func NewRepository(db *gorm.DB, defaultRetentionDays int) *Repository {
+	return &Repository{db: db, defaultRetentionDays: defaultRetentionDays}
+}
+
+// FindSettings returns the user's saved settings. Users who never saved any are not
+// recorded and get the server's default retention.
+func (r *Repository) FindSettings(userID uuid.UUID) (*Settings, error) {
+	var s Settings
+	err := r.db.First(&s, "user_id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &Settings{UserID: userID, RetentionDays: r.defaultRetentionDays}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (r *Repository) SaveSettings(s *Settings) error {
+	return r.db.Save(s).Error
+}
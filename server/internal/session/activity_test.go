@@ -0,0 +1,56 @@
+package session
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/moltty/server/internal/events"
+)
+
+func TestActivityStreamFiltersBroker(t *testing.T) {
+	broker := events.NewBroker(16)
+	userID := uuid.New()
+	sessionID := uuid.New()
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	cursor := func() uint64 {
+		sub, _, _ := broker.Subscribe(userID, 0)
+		defer broker.Unsubscribe(sub)
+		broker.Publish(events.Event{Type: events.WorkerOnline, UserID: userID})
+		return (<-sub.C).ID
+	}()
+
+	for _, ev := range []events.Event{
+		{Type: events.SessionStatus, UserID: userID, SessionID: sessionID.String(), Data: map[string]any{"status": StatusRunning}},
+		{Type: events.SessionActivity, UserID: uuid.New(), SessionID: sessionID.String(), At: at, Data: map[string]any{"state": ActivityBusy}},
+		{Type: events.SessionActivity, UserID: userID, SessionID: sessionID.String(), At: at, Data: map[string]any{"state": ActivityAwaitingInput}},
+		{Type: events.SessionRenamed, UserID: userID, SessionID: sessionID.String()},
+	} {
+		broker.Publish(ev)
+	}
+
+	sub, backlog, ok := broker.Subscribe(userID, cursor)
+	defer broker.Unsubscribe(sub)
+	if !ok || len(backlog) != 3 {
+		t.Fatalf("Subscribe() backlog = %d events, ok = %v; want 3, true", len(backlog), ok)
+	}
+
+	var out strings.Builder
+	w := bufio.NewWriter(&out)
+	written := 0
+	for _, ev := range backlog {
+		if writeActivity(w, ev) {
+			written++
+		}
+	}
+	w.Flush()
+
+	want := "id: " + strconv.FormatUint(cursor+3, 10) + "\nevent: activity\ndata: {\"sessionId\":\"" + sessionID.String() +
+		"\",\"state\":\"awaiting-input\",\"at\":\"2026-01-02T03:04:05Z\"}\n\n"
+	if written != 1 || out.String() != want {
+		t.Errorf("wrote %d events:\n%s\nwant:\n%s", written, out.String(), want)
+	}
+}
@@ -0,0 +1,109 @@
+package session
+
+import (
+	"bytes"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+
+	"github.com/moltty/server/internal/ansi"
+)
+
+// expectWindow bounds how much stripped output an expect keeps for matching. A
+// match must fit within the most recent expectWindow bytes of text.
+const expectWindow = 64 * 1024
+
+// ExpectMatch is the first match of an expect pattern in a session's output.
+type ExpectMatch struct {
+	Match  string   `json:"match"`  // the matched text, escapes stripped
+	Groups []string `json:"groups"` // capture groups; unmatched groups are empty
+	Offset int64    `json:"offset"` // stream offset of the match in the raw output
+	End    int64    `json:"end"`    // stream offset just past the match
+}
+
+// outputMatcher strips escape sequences from streamed output and looks for a
+// pattern in the text seen so far. Patterns that can't span lines are only
+// matched against the current line, so each chunk is scanned from the start of
+// the line it continues rather than across the whole window.
+type outputMatcher struct {
+	re        *regexp.Regexp
+	lineLocal bool // matches never include a newline
+	strip     ansi.Stripper
+	text      []byte
+	offsets   ansi.Offsets // stream offset of each byte in text
+}
+
+func newOutputMatcher(re *regexp.Regexp) *outputMatcher {
+	return &outputMatcher{re: re, lineLocal: lineLocal(re)}
+}
+
+// feed adds a chunk of output starting at stream offset base and returns the
+// first non-empty match, if any.
+func (m *outputMatcher) feed(data []byte, base int64) *ExpectMatch {
+	m.text = m.strip.StripOffsets(m.text, &m.offsets, data, base)
+
+	for _, loc := range m.re.FindAllSubmatchIndex(m.text, -1) {
+		if loc[0] == loc[1] {
+			continue // ignore empty matches
+		}
+		match := &ExpectMatch{
+			Match:  string(m.text[loc[0]:loc[1]]),
+			Groups: make([]string, 0, len(loc)/2-1),
+			Offset: m.offsets.Raw(loc[0]),
+			End:    m.offsets.Raw(loc[1]-1) + 1,
+		}
+		for i := 2; i < len(loc); i += 2 {
+			if loc[i] >= 0 {
+				match.Groups = append(match.Groups, string(m.text[loc[i]:loc[i+1]]))
+			} else {
+				match.Groups = append(match.Groups, "")
+			}
+		}
+		return match
+	}
+
+	// Keep only the tail a later match could still start in.
+	drop := len(m.text) - expectWindow
+	if m.lineLocal {
+		drop = max(drop, bytes.LastIndexByte(m.text, '\n')+1)
+	}
+	if drop > 0 {
+		m.text = append(m.text[:0], m.text[drop:]...)
+		m.offsets.Drop(drop)
+	}
+	return nil
+}
+
+// lineLocal reports whether every match of re lies within one line: the pattern
+// can't match a newline and isn't anchored to the start of the text, which would
+// stop matching once earlier lines are dropped.
+func lineLocal(re *regexp.Regexp) bool {
+	prog, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return false
+	}
+	var local func(*syntax.Regexp) bool
+	local = func(r *syntax.Regexp) bool {
+		switch r.Op {
+		case syntax.OpAnyChar, syntax.OpBeginText:
+			return false
+		case syntax.OpLiteral:
+			if slices.Contains(r.Rune, '\n') {
+				return false
+			}
+		case syntax.OpCharClass:
+			for i := 0; i < len(r.Rune); i += 2 {
+				if r.Rune[i] <= '\n' && '\n' <= r.Rune[i+1] {
+					return false
+				}
+			}
+		}
+		for _, sub := range r.Sub {
+			if !local(sub) {
+				return false
+			}
+		}
+		return true
+	}
+	return local(prog)
+}
@@ -0,0 +1,136 @@
+package session
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestOutputMatcherFeed(t *testing.T) {
+	type chunk struct {
+		data string
+		base int64
+	}
+	tests := []struct {
+		name    string
+		pattern string
+		chunks  []chunk
+		want    *ExpectMatch
+		wantAt  int // index of the chunk that produces the match
+	}{
+		{
+			name:    "plain match",
+			pattern: `\$ $`,
+			chunks:  []chunk{{"build ok\r\nuser@host:~$ ", 100}},
+			want:    &ExpectMatch{Match: "$ ", Groups: []string{}, Offset: 121, End: 123},
+		},
+		{
+			name:    "match across escapes",
+			pattern: `ready`,
+			chunks:  []chunk{{"\x1b[32mre\x1b[1mady\x1b[0m", 0}},
+			want:    &ExpectMatch{Match: "ready", Groups: []string{}, Offset: 5, End: 14},
+		},
+		{
+			name:    "match split across chunks",
+			pattern: `exit code (\d+)`,
+			chunks:  []chunk{{"done, exit co", 0}, {"de 17\n", 13}},
+			want:    &ExpectMatch{Match: "exit code 17", Groups: []string{"17"}, Offset: 6, End: 18},
+			wantAt:  1,
+		},
+		{
+			name:    "escape split across chunks",
+			pattern: `ab`,
+			chunks:  []chunk{{"a\x1b[3", 0}, {"1mb", 4}},
+			want:    &ExpectMatch{Match: "ab", Groups: []string{}, Offset: 0, End: 7},
+			wantAt:  1,
+		},
+		{
+			name:    "unmatched optional group",
+			pattern: `(error|warning)(: (\w+))?`,
+			chunks:  []chunk{{"got warning\n", 0}},
+			want:    &ExpectMatch{Match: "warning", Groups: []string{"warning", "", ""}, Offset: 4, End: 11},
+		},
+		{
+			name:    "empty matches are ignored",
+			pattern: `x*`,
+			chunks:  []chunk{{"abc", 0}, {"axxb", 3}},
+			want:    &ExpectMatch{Match: "xx", Groups: []string{}, Offset: 4, End: 6},
+			wantAt:  1,
+		},
+		{
+			name:    "no match",
+			pattern: `never`,
+			chunks:  []chunk{{"some output\n", 0}, {"more output\n", 12}},
+			wantAt:  -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newOutputMatcher(regexp.MustCompile(tt.pattern))
+			for i, c := range tt.chunks {
+				got := m.feed([]byte(c.data), c.base)
+				if i != tt.wantAt {
+					if got != nil {
+						t.Fatalf("chunk %d matched %+v early", i, got)
+					}
+					continue
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("feed() = %+v, want %+v", got, tt.want)
+				}
+				return
+			}
+		})
+	}
+}
+
+func TestOutputMatcherWindow(t *testing.T) {
+	m := newOutputMatcher(regexp.MustCompile(`start.*end`))
+	m.feed([]byte("start"), 0)
+	filler := strings.Repeat("x", expectWindow)
+	m.feed([]byte(filler), 5)
+	if len(m.text) > expectWindow {
+		t.Fatalf("kept %d bytes of text, want at most %d", len(m.text), expectWindow)
+	}
+	if got, want := m.offsets.Raw(len(m.text)-1), int64(4+len(filler)); got != want {
+		t.Fatalf("last byte of text maps to %d, want %d", got, want)
+	}
+	if got := m.feed([]byte("end"), int64(5+len(filler))); got != nil {
+		t.Errorf("matched %q outside the window", got.Match)
+	}
+}
+
+func TestOutputMatcherKeepsTail(t *testing.T) {
+	tests := []struct {
+		name      string
+		pattern   string
+		lineLocal bool
+		wantText  string // text kept after feeding the output without a match
+	}{
+		{name: "single line", pattern: `exit \d+`, lineLocal: true, wantText: "partial"},
+		{name: "multiline anchor", pattern: `(?m)^\$ $`, lineLocal: true, wantText: "partial"},
+		{name: "newline literal", pattern: `one\ntwo`, wantText: "first\nsecond\npartial"},
+		{name: "dot matches newline", pattern: `(?s)a.b`, wantText: "first\nsecond\npartial"},
+		{name: "negated class", pattern: `[^x]+z`, wantText: "first\nsecond\npartial"},
+		{name: "anchored to the start", pattern: `^exit`, wantText: "first\nsecond\npartial"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newOutputMatcher(regexp.MustCompile(tt.pattern))
+			if m.lineLocal != tt.lineLocal {
+				t.Errorf("lineLocal = %v, want %v", m.lineLocal, tt.lineLocal)
+			}
+			if got := m.feed([]byte("first\r\nsecond\r\n\x1b[1mpartial"), 10); got != nil {
+				t.Fatalf("unexpected match %+v", got)
+			}
+			if string(m.text) != tt.wantText {
+				t.Errorf("kept %q, want %q", m.text, tt.wantText)
+			}
+			// The kept text still maps to the right stream offsets.
+			if got := m.offsets.Raw(len(m.text) - 7); got != 29 {
+				t.Errorf("\"partial\" maps to offset %d, want 29", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package session
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Listing and tag limits.
+const (
+	maxListLimit  = 200
+	maxTags       = 20
+	maxTagLength  = 64
+	maxFolderSize = 255
+)
+
+// parseListFilter reads the filters, sort order and page of a session listing
+// from the query string: status (comma-separated), worker, tag (comma-separated,
+// all must match), folder, workDir (prefix), q (name search), archived (true or
+// only for archived sessions, all for both), sort (created, activity or recent), limit
+// and cursor.
+func parseListFilter(c *fiber.Ctx) (ListFilter, error) {
+	var f ListFilter
+	for _, v := range splitList(c.Query("status")) {
+		f.Statuses = append(f.Statuses, Status(v))
+	}
+	if v := c.Query("worker"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			return f, errors.New("invalid worker id")
+		}
+		f.WorkerID = &id
+	}
+	f.Tags = splitList(c.Query("tag"))
+	if c.Context().QueryArgs().Has("folder") {
+		folder := strings.TrimSpace(c.Query("folder"))
+		f.Folder = &folder
+	}
+	f.WorkDirPrefix = c.Query("workDir")
+	f.Query = strings.TrimSpace(c.Query("q"))
+	switch v := c.Query("archived"); v {
+	case "", "false":
+		f.Archived = ArchivedExclude
+	case "true", ArchivedOnly:
+		f.Archived = ArchivedOnly
+	case ArchivedInclude:
+		f.Archived = ArchivedInclude
+	default:
+		return f, errors.New("archived must be true, only or all")
+	}
+
+	switch f.Sort = c.Query("sort", SortCreated); f.Sort {
+	case SortCreated, SortActivity, SortRecent:
+	default:
+		return f, errors.New("sort must be created, activity or recent")
+	}
+
+	f.Limit = c.QueryInt("limit")
+	if f.Limit < 0 {
+		return f, errors.New("invalid limit")
+	}
+	f.Limit = min(f.Limit, maxListLimit)
+	if v := c.Query("cursor"); v != "" {
+		cursor, err := decodeCursor(v)
+		if err != nil {
+			return f, errors.New("invalid cursor")
+		}
+		f.After = cursor
+	}
+	return f, nil
+}
+
+// splitList splits a comma-separated query value, dropping empty entries.
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func encodeCursor(c ListCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*ListCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c ListCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// normalizeTags trims and de-duplicates tags, keeping their order.
+func normalizeTags(tags []string) ([]string, error) {
+	out := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		if len(t) > maxTagLength || strings.Contains(t, ",") {
+			return nil, errors.New("tags must be at most 64 characters and contain no commas")
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	if len(out) > maxTags {
+		return nil, errors.New("a session can have at most 20 tags")
+	}
+	return out, nil
+}
+
+// normalizeFolder trims a folder name and checks its length.
+func normalizeFolder(folder string) (string, error) {
+	folder = strings.TrimSpace(folder)
+	if len(folder) > maxFolderSize {
+		return "", errors.New("folder must be at most 255 characters")
+	}
+	return folder, nil
+}
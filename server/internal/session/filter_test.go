@@ -0,0 +1,164 @@
+package session
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// parseQuery runs parseListFilter on a request with the given query string.
+func parseQuery(t *testing.T, query string) (ListFilter, error) {
+	t.Helper()
+	var f ListFilter
+	var err error
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		f, err = parseListFilter(c)
+		return nil
+	})
+	if _, testErr := app.Test(httptest.NewRequest("GET", "/?"+query, nil)); testErr != nil {
+		t.Fatal(testErr)
+	}
+	return f, err
+}
+
+func TestParseListFilter(t *testing.T) {
+	workerID := uuid.New()
+	folder := "work"
+	noFolder := ""
+	cursor := ListCursor{At: time.Date(2026, 5, 1, 9, 30, 0, 123456789, time.UTC), ID: uuid.New()}
+
+	tests := []struct {
+		name    string
+		query   string
+		want    ListFilter
+		wantErr bool
+	}{
+		{
+			name:  "defaults",
+			query: "",
+			want:  ListFilter{Sort: SortCreated},
+		},
+		{
+			name:  "filters",
+			query: "status=running,+offline,&worker=" + workerID.String() + "&tag=a,b&folder=+work+&workDir=/src&q=+api+",
+			want: ListFilter{
+				Statuses:      []Status{StatusRunning, StatusOffline},
+				WorkerID:      &workerID,
+				Tags:          []string{"a", "b"},
+				Folder:        &folder,
+				WorkDirPrefix: "/src",
+				Query:         "api",
+				Sort:          SortCreated,
+			},
+		},
+		{
+			name:  "empty folder selects unfiled sessions",
+			query: "folder=",
+			want:  ListFilter{Folder: &noFolder, Sort: SortCreated},
+		},
+		{
+			name:  "archived only",
+			query: "archived=true&sort=recent",
+			want:  ListFilter{Archived: ArchivedOnly, Sort: SortRecent},
+		},
+		{
+			name:  "archived included",
+			query: "archived=all&sort=activity",
+			want:  ListFilter{Archived: ArchivedInclude, Sort: SortActivity},
+		},
+		{
+			name:  "page",
+			query: "limit=500&cursor=" + encodeCursor(cursor),
+			want:  ListFilter{Sort: SortCreated, Limit: maxListLimit, After: &cursor},
+		},
+		{name: "invalid worker", query: "worker=nope", wantErr: true},
+		{name: "invalid archived", query: "archived=maybe", wantErr: true},
+		{name: "invalid sort", query: "sort=name", wantErr: true},
+		{name: "negative limit", query: "limit=-1", wantErr: true},
+		{name: "invalid cursor", query: "cursor=!!!", wantErr: true},
+		{name: "cursor that isn't JSON", query: "cursor=bm90IGpzb24", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseQuery(t, tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseListFilter() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.After != nil && tt.want.After != nil && got.After.At.Equal(tt.want.After.At) {
+				got.After.At = tt.want.After.At
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseListFilter() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListCursorRoundTrip(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+	changed := created.Add(time.Hour)
+	output := created.Add(2 * time.Hour)
+	sess := Session{ID: uuid.New(), CreatedAt: created, ActivityChangedAt: &changed, LastOutputAt: &output}
+
+	tests := []struct {
+		sort string
+		want time.Time
+	}{
+		{SortCreated, created},
+		{SortActivity, changed},
+		{SortRecent, output},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			c := sess.Cursor(tt.sort)
+			got, err := decodeCursor(encodeCursor(c))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != sess.ID || !got.At.Equal(tt.want) {
+				t.Errorf("cursor = %v %v, want %v %v", got.At, got.ID, tt.want, sess.ID)
+			}
+		})
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	long := strings.Repeat("x", maxTagLength+1)
+	tests := []struct {
+		name    string
+		tags    []string
+		want    []string
+		wantErr bool
+	}{
+		{"trimmed and de-duplicated", []string{" a ", "b", "a", "", "  "}, []string{"a", "b"}, false},
+		{"none", nil, []string{}, false},
+		{"comma", []string{"a,b"}, nil, true},
+		{"too long", []string{long}, nil, true},
+		{"too many", tooManyTags(), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeTags(tt.tags)
+			if (err != nil) != tt.wantErr || (!tt.wantErr && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("normalizeTags() = %q, %v, want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func tooManyTags() []string {
+	tags := make([]string, maxTags+1)
+	for i := range tags {
+		tags[i] = uuid.NewString()
+	}
+	return tags
+}
@@ -1,32 +1,87 @@
 package session
 
 import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/moltty/server/internal/events"
+	"github.com/moltty/server/internal/quota"
 )
 
 type Handler struct {
 	repo    *Repository
 	manager *Manager
 	hub     WorkerHub
+	events  *events.Broker
 }
 
-func NewHandler(repo *Repository, manager *Manager, hub WorkerHub) *Handler {
-	return &Handler{repo: repo, manager: manager, hub: hub}
+func NewHandler(repo *Repository, manager *Manager, hub WorkerHub, broker *events.Broker) *Handler {
+	return &Handler{repo: repo, manager: manager, hub: hub, events: broker}
 }
 
 type createRequest struct {
-	Name            string `json:"name"`
-	SessionType     string `json:"sessionType"`     // "worker" or "container", defaults to "worker"
-	ClaudeSessionID string `json:"claudeSessionId"` // optional: resume a specific Claude session
-	WorkDir         string `json:"workDir"`          // optional: working directory
+	Name            string   `json:"name"`
+	SessionType     string   `json:"sessionType"`     // "worker" or "container", defaults to "worker"
+	ClaudeSessionID string   `json:"claudeSessionId"` // optional: resume a specific Claude session
+	WorkDir         string   `json:"workDir"`         // optional: working directory
+	Folder          string   `json:"folder"`          // optional: folder or project the session belongs to
+	Tags            []string `json:"tags"`            // optional
+}
+
+// timeoutsRequest sets idle timeout and maximum lifetime in minutes. A null value
+// reverts to the inherited policy; zero disables the limit.
+type timeoutsRequest struct {
+	IdleTimeoutMinutes *int `json:"idleTimeoutMinutes"`
+	MaxLifetimeMinutes *int `json:"maxLifetimeMinutes"`
+}
+
+func (r timeoutsRequest) valid() bool {
+	return (r.IdleTimeoutMinutes == nil || *r.IdleTimeoutMinutes >= 0) &&
+		(r.MaxLifetimeMinutes == nil || *r.MaxLifetimeMinutes >= 0)
+}
+
+// updateRequest changes a session's name, folder or tags. Omitted fields are kept.
+type updateRequest struct {
+	Name   *string   `json:"name"`
+	Folder *string   `json:"folder"`
+	Tags   *[]string `json:"tags"`
+}
+
+// scrollbackRequest sets per-session persistent scrollback limits. A null value
+// reverts to the server default.
+type scrollbackRequest struct {
+	MaxBytes      *int64 `json:"maxBytes"`
+	RetentionDays *int   `json:"retentionDays"`
+}
+
+// inputRequest sends literal text followed by named keys to a session.
+type inputRequest struct {
+	Text string   `json:"text"`
+	Keys []string `json:"keys"` // e.g. "Enter", "Up", "C-c"
 }
 
-type renameRequest struct {
-	Name string `json:"name"`
+// expectRequest waits for a pattern to appear in a session's output.
+type expectRequest struct {
+	Pattern    string `json:"pattern"`
+	Timeout    int    `json:"timeout"` // seconds, defaults to 30
+	IgnoreCase bool   `json:"ignoreCase"`
+	Since      *int64 `json:"since"` // stream offset to search from; defaults to new output only
 }
 
+const (
+	defaultExpectTimeout = 30 * time.Second
+	maxExpectTimeout     = 5 * time.Minute
+)
+
 func getUserID(c *fiber.Ctx) uuid.UUID {
 	token := c.Locals("user").(*jwt.Token)
 	claims := token.Claims.(jwt.MapClaims)
@@ -34,28 +89,91 @@ func getUserID(c *fiber.Ctx) uuid.UUID {
 	return id
 }
 
+// List returns the user's sessions, most recent first. See parseListFilter for the
+// supported query parameters. When limit is set and more sessions follow, the
+// X-Next-Cursor header holds the cursor of the next page.
 func (h *Handler) List(c *fiber.Ctx) error {
 	userID := getUserID(c)
-	sessions, err := h.repo.FindByUserID(userID)
+	filter, err := parseListFilter(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	limit := filter.Limit
+	if limit > 0 {
+		filter.Limit++ // fetch one extra to learn whether another page follows
+	}
+	sessions, err := h.repo.List(userID, filter)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to list sessions"})
 	}
+	timeouts, err := h.repo.FindUserTimeouts(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to load timeouts"})
+	}
+	if limit > 0 && len(sessions) > limit {
+		sessions = sessions[:limit]
+		c.Set("X-Next-Cursor", encodeCursor(sessions[limit-1].Cursor(filter.Sort)))
+	}
 
 	result := make([]fiber.Map, len(sessions))
-	for i, s := range sessions {
-		result[i] = fiber.Map{
-			"id":          s.ID,
-			"name":        s.Name,
-			"status":      s.Status,
-			"sessionType": s.SessionType,
-			"workDir":     s.WorkDir,
-			"createdAt":   s.CreatedAt,
-		}
+	for i := range sessions {
+		result[i] = h.sessionEntry(&sessions[i], timeouts)
 	}
 
 	return c.JSON(result)
 }
 
+// Get returns one session with its activity counters.
+func (h *Handler) Get(c *fiber.Ctx) error {
+	userID := getUserID(c)
+	sessionID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid session id"})
+	}
+
+	sess, err := h.repo.FindByID(sessionID)
+	if err != nil || sess.UserID != userID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
+	}
+	timeouts, err := h.repo.FindUserTimeouts(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to load timeouts"})
+	}
+	return c.JSON(h.sessionEntry(sess, timeouts))
+}
+
+// sessionEntry describes a session in list and detail responses. Activity
+// counters are flushed periodically by the hub, so they may lag slightly.
+func (h *Handler) sessionEntry(s *Session, timeouts *UserTimeouts) fiber.Map {
+	viewers := h.hub.Viewers(s.ID)
+	return fiber.Map{
+		"id":             s.ID,
+		"name":           s.Name,
+		"status":         s.Status,
+		"sessionType":    s.SessionType,
+		"workDir":        s.WorkDir,
+		"folder":         s.Folder,
+		"tags":           tagsOrEmpty(s.Tags),
+		"workerId":       s.WorkerID,
+		"createdAt":      s.CreatedAt,
+		"startedAt":      s.StartedAt,
+		"archivedAt":     s.ArchivedAt,
+		"activity":       s.ActivityState,
+		"activityAt":     s.ActivityChangedAt,
+		"lastInputAt":    s.LastInputAt,
+		"lastOutputAt":   s.LastOutputAt,
+		"lastActiveAt":   s.LastActiveAt(),
+		"bytesIn":        s.BytesIn,
+		"bytesOut":       s.BytesOut,
+		"runningSeconds": int64(s.RunningSeconds),
+		"resumeCount":    s.ResumeCount,
+		"viewerCount":    len(viewers),
+		"viewers":        viewers,
+		"timeout":        h.hub.TimeoutStatus(s, timeouts),
+	}
+}
+
 func (h *Handler) Create(c *fiber.Ctx) error {
 	userID := getUserID(c)
 
@@ -67,39 +185,145 @@ func (h *Handler) Create(c *fiber.Ctx) error {
 	if req.Name == "" {
 		req.Name = "New Session"
 	}
+	folder, err := normalizeFolder(req.Folder)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
 
 	// Default to worker session type
 	if req.SessionType == "" || req.SessionType == "worker" {
 		sess, err := h.manager.CreateWorkerSession(c.Context(), userID, req.Name, req.ClaudeSessionID, req.WorkDir, h.hub)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+			return c.Status(createErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
 		}
+		if err := h.setGrouping(sess, folder, tags); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to store session tags"})
+		}
+		h.publish(events.SessionCreated, sess)
 		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 			"id":          sess.ID,
 			"name":        sess.Name,
 			"status":      sess.Status,
 			"sessionType": sess.SessionType,
 			"workDir":     sess.WorkDir,
+			"folder":      sess.Folder,
+			"tags":        tagsOrEmpty(sess.Tags),
 			"createdAt":   sess.CreatedAt,
 		})
 	}
 
 	// Container session type
-	sess, err := h.manager.CreateSession(c.Context(), userID, req.Name)
+	sess, err := h.manager.CreateSession(c.Context(), userID, req.Name, h.hub)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return c.Status(createErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := h.setGrouping(sess, folder, tags); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to store session tags"})
 	}
+	h.publish(events.SessionCreated, sess)
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"id":          sess.ID,
 		"name":        sess.Name,
 		"status":      sess.Status,
 		"sessionType": sess.SessionType,
+		"folder":      sess.Folder,
+		"tags":        tagsOrEmpty(sess.Tags),
 		"createdAt":   sess.CreatedAt,
 	})
 }
 
-func (h *Handler) Rename(c *fiber.Ctx) error {
+// createErrorStatus returns the HTTP status for a failed session creation.
+func createErrorStatus(err error) int {
+	var exceeded *quota.ExceededError
+	if errors.As(err, &exceeded) {
+		return exceeded.StatusCode()
+	}
+	return fiber.StatusInternalServerError
+}
+
+// setGrouping stores the folder and tags of a newly created session.
+func (h *Handler) setGrouping(sess *Session, folder string, tags []string) error {
+	if folder == "" && len(tags) == 0 {
+		return nil
+	}
+	sess.Folder = folder
+	sess.Tags = tags
+	return h.repo.Update(sess)
+}
+
+// tagsOrEmpty returns tags, or an empty list for sessions that have none.
+func tagsOrEmpty(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+// Update renames a session or changes its folder and tags.
+func (h *Handler) Update(c *fiber.Ctx) error {
+	userID := getUserID(c)
+	sessionID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid session id"})
+	}
+
+	sess, err := h.repo.FindByID(sessionID)
+	if err != nil || sess.UserID != userID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
+	}
+
+	var req updateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	if req.Name == nil && req.Folder == nil && req.Tags == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "name, folder or tags is required"})
+	}
+
+	renamed := false
+	if req.Name != nil {
+		if *req.Name == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "name must not be empty"})
+		}
+		renamed = *req.Name != sess.Name
+		sess.Name = *req.Name
+	}
+	if req.Folder != nil {
+		if sess.Folder, err = normalizeFolder(*req.Folder); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if req.Tags != nil {
+		if sess.Tags, err = normalizeTags(*req.Tags); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+
+	if err := h.repo.Update(sess); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to update session"})
+	}
+	if renamed {
+		h.publish(events.SessionRenamed, sess)
+	}
+	if req.Folder != nil || req.Tags != nil {
+		h.publish(events.SessionUpdated, sess)
+	}
+
+	return c.JSON(fiber.Map{
+		"id":     sess.ID,
+		"name":   sess.Name,
+		"folder": sess.Folder,
+		"tags":   tagsOrEmpty(sess.Tags),
+	})
+}
+
+// UpdateScrollback sets a session's persistent scrollback size and retention limits.
+func (h *Handler) UpdateScrollback(c *fiber.Ctx) error {
 	userID := getUserID(c)
 	sessionID, err := uuid.Parse(c.Params("id"))
 	if err != nil {
@@ -111,17 +335,533 @@ func (h *Handler) Rename(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
 	}
 
-	var req renameRequest
-	if err := c.BodyParser(&req); err != nil || req.Name == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "name is required"})
+	var req scrollbackRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	if (req.MaxBytes != nil && *req.MaxBytes <= 0) || (req.RetentionDays != nil && *req.RetentionDays <= 0) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "limits must be positive"})
+	}
+
+	sess.ScrollbackMaxBytes = req.MaxBytes
+	sess.ScrollbackRetentionDays = req.RetentionDays
+	if err := h.repo.Update(sess); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to update session"})
+	}
+
+	return c.JSON(fiber.Map{
+		"id":            sess.ID,
+		"maxBytes":      sess.ScrollbackMaxBytes,
+		"retentionDays": sess.ScrollbackRetentionDays,
+	})
+}
+
+// GetTimeouts returns a session's idle timeout and maximum lifetime and how much
+// time it has left.
+func (h *Handler) GetTimeouts(c *fiber.Ctx) error {
+	userID := getUserID(c)
+	sessionID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid session id"})
+	}
+
+	sess, err := h.repo.FindByID(sessionID)
+	if err != nil || sess.UserID != userID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
+	}
+	return h.timeoutsResponse(c, sess)
+}
+
+// UpdateTimeouts sets a session's own idle timeout and maximum lifetime.
+func (h *Handler) UpdateTimeouts(c *fiber.Ctx) error {
+	userID := getUserID(c)
+	sessionID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid session id"})
+	}
+
+	sess, err := h.repo.FindByID(sessionID)
+	if err != nil || sess.UserID != userID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
 	}
 
-	sess.Name = req.Name
+	var req timeoutsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	if !req.valid() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "timeouts must not be negative"})
+	}
+
+	sess.IdleTimeoutMinutes = req.IdleTimeoutMinutes
+	sess.MaxLifetimeMinutes = req.MaxLifetimeMinutes
 	if err := h.repo.Update(sess); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to rename session"})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to update session"})
+	}
+	return h.timeoutsResponse(c, sess)
+}
+
+func (h *Handler) timeoutsResponse(c *fiber.Ctx, sess *Session) error {
+	timeouts, err := h.repo.FindUserTimeouts(sess.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to load timeouts"})
+	}
+	return c.JSON(fiber.Map{
+		"id":                 sess.ID,
+		"idleTimeoutMinutes": sess.IdleTimeoutMinutes,
+		"maxLifetimeMinutes": sess.MaxLifetimeMinutes,
+		"timeout":            h.hub.TimeoutStatus(sess, timeouts),
+	})
+}
+
+// GetUserTimeouts returns the user's default idle timeout and maximum lifetime.
+func (h *Handler) GetUserTimeouts(c *fiber.Ctx) error {
+	timeouts, err := h.repo.FindUserTimeouts(getUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to load timeouts"})
+	}
+	return c.JSON(timeouts)
+}
+
+// UpdateUserTimeouts sets the idle timeout and maximum lifetime for the user's
+// sessions that don't set their own.
+func (h *Handler) UpdateUserTimeouts(c *fiber.Ctx) error {
+	userID := getUserID(c)
+
+	var req timeoutsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	if !req.valid() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "timeouts must not be negative"})
+	}
+
+	timeouts := &UserTimeouts{
+		UserID:             userID,
+		IdleTimeoutMinutes: req.IdleTimeoutMinutes,
+		MaxLifetimeMinutes: req.MaxLifetimeMinutes,
+	}
+	if err := h.repo.SaveUserTimeouts(timeouts); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to save timeouts"})
+	}
+	return c.JSON(timeouts)
+}
+
+// Search finds text in a session's terminal history with escape sequences stripped.
+// Query parameters: q (required), regex (treat q as a regular expression),
+// ignoreCase, context (lines around each match, default 2) and limit (default 100).
+func (h *Handler) Search(c *fiber.Ctx) error {
+	userID := getUserID(c)
+	sessionID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid session id"})
+	}
+
+	sess, err := h.repo.FindByID(sessionID)
+	if err != nil || sess.UserID != userID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
 	}
 
-	return c.JSON(fiber.Map{"id": sess.ID, "name": sess.Name})
+	q := c.Query("q")
+	if q == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "q is required"})
+	}
+	pattern := q
+	if !c.QueryBool("regex") {
+		pattern = regexp.QuoteMeta(q)
+	}
+	if c.QueryBool("ignoreCase") {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid regex: " + err.Error()})
+	}
+
+	contextLines := min(max(c.QueryInt("context", 2), 0), 20)
+	limit := min(max(c.QueryInt("limit", 100), 1), 1000)
+
+	history, base, err := h.hub.History(sess.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to load history"})
+	}
+
+	matches, truncated := SearchHistory(history, base, re, contextLines, limit)
+	return c.JSON(fiber.Map{
+		"matches":      matches,
+		"truncated":    truncated,
+		"historyBytes": len(history),
+	})
+}
+
+// ActivityStream streams activity changes for the user's sessions as Server-Sent
+// Events. It is the session.activity slice of the user's event stream, so it
+// resumes the same way: each event's id is its cursor, reconnecting with
+// Last-Event-ID (or the after query parameter) replays what was missed, and a
+// "reset" event is sent first if the missed events are no longer retained.
+func (h *Handler) ActivityStream(c *fiber.Ctx) error {
+	userID := getUserID(c)
+
+	var cursor uint64
+	if v := c.Get("Last-Event-ID", c.Query("after")); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid cursor"})
+		}
+		cursor = n
+	}
+
+	sub, backlog, ok := h.events.Subscribe(userID, cursor)
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer h.events.Unsubscribe(sub)
+
+		heartbeat := time.NewTicker(15 * time.Second)
+		defer heartbeat.Stop()
+
+		if !ok {
+			fmt.Fprintf(w, "event: reset\ndata: {}\n\n")
+		}
+		for _, ev := range backlog {
+			writeActivity(w, ev)
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		for {
+			select {
+			case ev, open := <-sub.C:
+				if !open {
+					// Fell behind; the client reconnects and resumes from its cursor.
+					return
+				}
+				if !writeActivity(w, ev) {
+					continue
+				}
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// activityEvent converts a session.activity event from the broker. ok is false
+// for other events.
+func activityEvent(ev events.Event) (ae ActivityEvent, ok bool) {
+	if ev.Type != events.SessionActivity {
+		return ae, false
+	}
+	sessionID, err := uuid.Parse(ev.SessionID)
+	if err != nil {
+		return ae, false
+	}
+	state, ok := ev.Data["state"].(ActivityState)
+	if !ok {
+		return ae, false
+	}
+	return ActivityEvent{SessionID: sessionID, State: state, At: ev.At}, true
+}
+
+// writeActivity writes ev as an "activity" event if it is one and reports
+// whether it did.
+func writeActivity(w *bufio.Writer, ev events.Event) bool {
+	ae, ok := activityEvent(ev)
+	if !ok {
+		return false
+	}
+	data, _ := json.Marshal(ae)
+	fmt.Fprintf(w, "id: %d\nevent: activity\ndata: %s\n\n", ev.ID, data)
+	return true
+}
+
+// Input types text and named keys into a running worker session. It returns the
+// output stream offset from before the input was sent, which can be passed to
+// Expect as since to wait for the response.
+func (h *Handler) Input(c *fiber.Ctx) error {
+	userID := getUserID(c)
+	sessionID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid session id"})
+	}
+
+	sess, err := h.repo.FindByID(sessionID)
+	if err != nil || sess.UserID != userID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
+	}
+	if sess.SessionType != SessionTypeWorker {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "input is only supported for worker sessions"})
+	}
+	if sess.Status != StatusRunning {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "session is not running"})
+	}
+
+	var req inputRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	input := req.Text
+	for _, name := range req.Keys {
+		key, err := KeyBytes(name)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		input += key
+	}
+	if input == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "text or keys is required"})
+	}
+
+	offset, err := h.hub.OutputOffset(sess.ID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
+	}
+	if err := h.hub.SendInput(sess.ID, base64.StdEncoding.EncodeToString([]byte(input))); err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"offset": offset, "bytes": len(input)})
+}
+
+// Expect waits until a regular expression matches a worker session's output with
+// escape sequences stripped. Only output after since (or, by default, output
+// produced after the request arrives) is searched. It responds 408 if nothing
+// matched before the timeout and reports the exit if the session ends first.
+func (h *Handler) Expect(c *fiber.Ctx) error {
+	userID := getUserID(c)
+	sessionID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid session id"})
+	}
+
+	sess, err := h.repo.FindByID(sessionID)
+	if err != nil || sess.UserID != userID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
+	}
+	if sess.SessionType != SessionTypeWorker {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "expect is only supported for worker sessions"})
+	}
+
+	var req expectRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	if req.Pattern == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "pattern is required"})
+	}
+	pattern := req.Pattern
+	if req.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid regex: " + err.Error()})
+	}
+	timeout := defaultExpectTimeout
+	if req.Timeout > 0 {
+		timeout = min(time.Duration(req.Timeout)*time.Second, maxExpectTimeout)
+	}
+	from := int64(-1)
+	if req.Since != nil {
+		from = max(*req.Since, 0)
+	}
+
+	events, unsubscribe, err := h.hub.FollowOutput(sess.ID, from)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
+	}
+	defer unsubscribe()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	matcher := newOutputMatcher(re)
+	var offset int64
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
+			}
+			switch ev.Type {
+			case OutputEventData:
+				offset = ev.Offset + int64(len(ev.Data))
+				if m := matcher.feed(ev.Data, ev.Offset); m != nil {
+					return c.JSON(fiber.Map{
+						"matched": true,
+						"match":   m.Match,
+						"groups":  m.Groups,
+						"offset":  m.Offset,
+						"end":     m.End,
+					})
+				}
+			case OutputEventExit:
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error":    "session exited before the pattern matched",
+					"matched":  false,
+					"exitCode": ev.ExitCode,
+					"offset":   offset,
+				})
+			}
+		case <-timer.C:
+			return c.Status(fiber.StatusRequestTimeout).JSON(fiber.Map{
+				"error":   "timed out waiting for pattern",
+				"matched": false,
+				"offset":  offset,
+			})
+		}
+	}
+}
+
+// Stream sends a worker session's output and status changes as Server-Sent Events,
+// for clients that cannot use WebSockets. Query parameters: strip (remove escape
+// sequences), lines (emit complete lines, implies strip) and from (stream offset
+// to start at; by default only new output is sent). A Last-Event-ID header or
+// lastEventId parameter resumes after a previous event.
+func (h *Handler) Stream(c *fiber.Ctx) error {
+	userID := getUserID(c)
+	sessionID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid session id"})
+	}
+
+	sess, err := h.repo.FindByID(sessionID)
+	if err != nil || sess.UserID != userID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
+	}
+	if sess.SessionType != SessionTypeWorker {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "streaming is only supported for worker sessions"})
+	}
+
+	from := int64(-1)
+	if v := c.Query("from"); v != "" {
+		if from, err = strconv.ParseInt(v, 10, 64); err != nil || from < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid from offset"})
+		}
+	}
+	lastID := c.Get("Last-Event-ID", c.Query("lastEventId"))
+	if lastID != "" {
+		if from, err = strconv.ParseInt(lastID, 10, 64); err != nil || from < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid Last-Event-ID"})
+		}
+	}
+
+	events, unsubscribe, err := h.hub.FollowOutput(sess.ID, from)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
+	}
+	enc := &streamEncoder{
+		strip: c.QueryBool("strip") || c.QueryBool("lines"),
+		lines: c.QueryBool("lines"),
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer unsubscribe()
+
+		heartbeat := time.NewTicker(15 * time.Second)
+		defer heartbeat.Stop()
+
+		writeEvent(w, "status", "", fiber.Map{"status": sess.Status})
+		for {
+			select {
+			case ev, ok := <-events:
+				if !ok {
+					writeEvent(w, "closed", "", fiber.Map{"reason": "session deleted"})
+					w.Flush()
+					return
+				}
+				enc.encode(w, ev)
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// Viewers returns who is currently watching a session.
+func (h *Handler) Viewers(c *fiber.Ctx) error {
+	userID := getUserID(c)
+	sessionID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid session id"})
+	}
+
+	sess, err := h.repo.FindByID(sessionID)
+	if err != nil || sess.UserID != userID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
+	}
+
+	viewers := h.hub.Viewers(sess.ID)
+	return c.JSON(fiber.Map{
+		"viewerCount": len(viewers),
+		"viewers":     viewers,
+	})
+}
+
+// Archive stops a session and hides it from the default listing.
+func (h *Handler) Archive(c *fiber.Ctx) error {
+	userID := getUserID(c)
+	sessionID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid session id"})
+	}
+
+	sess, err := h.repo.FindByID(sessionID)
+	if err != nil || sess.UserID != userID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
+	}
+	if sess.ArchivedAt != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "session is already archived"})
+	}
+
+	if err := h.manager.ArchiveSession(c.Context(), sess, h.hub); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to archive session"})
+	}
+	h.publish(events.SessionArchived, sess)
+
+	return c.JSON(fiber.Map{"id": sess.ID, "archivedAt": sess.ArchivedAt})
+}
+
+// Restore returns an archived session to the default listing.
+func (h *Handler) Restore(c *fiber.Ctx) error {
+	userID := getUserID(c)
+	sessionID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid session id"})
+	}
+
+	sess, err := h.repo.FindByID(sessionID)
+	if err != nil || sess.UserID != userID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
+	}
+	if sess.ArchivedAt == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "session is not archived"})
+	}
+
+	if err := h.manager.RestoreSession(sess); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to restore session"})
+	}
+	h.publish(events.SessionRestored, sess)
+
+	return c.JSON(fiber.Map{"id": sess.ID, "status": sess.Status})
 }
 
 func (h *Handler) Delete(c *fiber.Ctx) error {
@@ -146,5 +886,27 @@ func (h *Handler) Delete(c *fiber.Ctx) error {
 		}
 	}
 
+	h.publish(events.SessionDeleted, sess)
+
 	return c.SendStatus(fiber.StatusNoContent)
 }
+
+// publish sends a session change to the owner's event stream.
+func (h *Handler) publish(typ events.Type, sess *Session) {
+	ev := events.Event{
+		Type:      typ,
+		UserID:    sess.UserID,
+		SessionID: sess.ID.String(),
+	}
+	if typ != events.SessionDeleted {
+		ev.Data = map[string]any{
+			"name":        sess.Name,
+			"status":      sess.Status,
+			"sessionType": sess.SessionType,
+			"workDir":     sess.WorkDir,
+			"folder":      sess.Folder,
+			"tags":        tagsOrEmpty(sess.Tags),
+		}
+	}
+	h.events.Publish(ev)
+}
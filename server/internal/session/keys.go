@@ -0,0 +1,63 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+)
+
+// namedKeys maps key names accepted by the input endpoint to the bytes a terminal
+// sends for them. Names are matched case-insensitively.
+var namedKeys = map[string]string{
+	"enter":     "\r",
+	"tab":       "\t",
+	"escape":    "\x1b",
+	"esc":       "\x1b",
+	"backspace": "\x7f",
+	"space":     " ",
+	"up":        "\x1b[A",
+	"down":      "\x1b[B",
+	"right":     "\x1b[C",
+	"left":      "\x1b[D",
+	"home":      "\x1b[H",
+	"end":       "\x1b[F",
+	"pageup":    "\x1b[5~",
+	"pagedown":  "\x1b[6~",
+	"insert":    "\x1b[2~",
+	"delete":    "\x1b[3~",
+	"f1":        "\x1bOP",
+	"f2":        "\x1bOQ",
+	"f3":        "\x1bOR",
+	"f4":        "\x1bOS",
+	"f5":        "\x1b[15~",
+	"f6":        "\x1b[17~",
+	"f7":        "\x1b[18~",
+	"f8":        "\x1b[19~",
+	"f9":        "\x1b[20~",
+	"f10":       "\x1b[21~",
+	"f11":       "\x1b[23~",
+	"f12":       "\x1b[24~",
+}
+
+// KeyBytes returns the terminal input for a named key such as "Enter", "Up" or
+// "F5". Control combinations are written "C-c" or "Ctrl-C".
+func KeyBytes(name string) (string, error) {
+	key := strings.ToLower(name)
+	if seq, ok := namedKeys[key]; ok {
+		return seq, nil
+	}
+	for _, prefix := range []string{"c-", "ctrl-", "ctrl+"} {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok || len(rest) != 1 {
+			continue
+		}
+		switch c := rest[0]; {
+		case c >= 'a' && c <= 'z':
+			return string(rune(c - 'a' + 1)), nil
+		case c == '@' || c == ' ':
+			return "\x00", nil
+		case c >= '[' && c <= '_':
+			return string(rune(c - '@')), nil
+		}
+	}
+	return "", fmt.Errorf("unknown key %q", name)
+}
@@ -8,12 +8,22 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/moltty/server/internal/container"
+	"github.com/moltty/server/internal/quota"
 )
 
 // WorkerHub is the interface the manager uses to interact with the worker hub.
 type WorkerHub interface {
 	SpawnSession(sessionID, workerID uuid.UUID, command, workDir string)
-	KillSession(sessionID uuid.UUID)
+	KillSession(sessionID uuid.UUID) error
+	Viewers(sessionID uuid.UUID) []ViewerInfo
+	History(sessionID uuid.UUID) ([]byte, int64, error)
+	RemoveSession(sessionID uuid.UUID)
+	PurgeSessionData(sessionID uuid.UUID)
+	SendInput(sessionID uuid.UUID, data string) error
+	FollowOutput(sessionID uuid.UUID, from int64) (<-chan OutputEvent, func(), error)
+	OutputOffset(sessionID uuid.UUID) (int64, error)
+	TimeoutStatus(sess *Session, user *UserTimeouts) TimeoutStatus
+	StorageUsed(userID uuid.UUID) (int64, error)
 }
 
 // WorkerSelector selects an online worker for a user.
@@ -26,6 +36,7 @@ type Manager struct {
 	docker         *container.DockerManager
 	workerPool     *container.WorkerPool
 	workerSelector WorkerSelector
+	quotas         *quota.Repository
 }
 
 func NewManager(repo *Repository, docker *container.DockerManager, workerPool *container.WorkerPool) *Manager {
@@ -40,8 +51,42 @@ func (m *Manager) SetWorkerSelector(ws WorkerSelector) {
 	m.workerSelector = ws
 }
 
+// SetQuotas enables per-user quotas on running sessions and storage.
+func (m *Manager) SetQuotas(q *quota.Repository) {
+	m.quotas = q
+}
+
+// checkQuota returns a *quota.ExceededError if the user may not start another
+// session. Concurrent requests may overshoot a limit by a session or two.
+func (m *Manager) checkQuota(userID uuid.UUID, hub WorkerHub) error {
+	if m.quotas == nil {
+		return nil
+	}
+	limits, err := m.quotas.Limits(userID)
+	if err != nil {
+		return fmt.Errorf("load quota: %w", err)
+	}
+
+	var usage quota.Usage
+	if limits.MaxRunningSessions > 0 {
+		if usage.RunningSessions, err = m.repo.CountRunning(userID); err != nil {
+			return fmt.Errorf("count running sessions: %w", err)
+		}
+	}
+	if limits.MaxStorageBytes > 0 {
+		if usage.StorageBytes, err = hub.StorageUsed(userID); err != nil {
+			return fmt.Errorf("measure storage: %w", err)
+		}
+	}
+	return limits.AllowSession(usage)
+}
+
 // CreateSession creates a new session with a container on a worker node (container mode).
-func (m *Manager) CreateSession(ctx context.Context, userID uuid.UUID, name string) (*Session, error) {
+func (m *Manager) CreateSession(ctx context.Context, userID uuid.UUID, name string, hub WorkerHub) (*Session, error) {
+	if err := m.checkQuota(userID, hub); err != nil {
+		return nil, err
+	}
+
 	sess := &Session{
 		UserID:      userID,
 		Name:        name,
@@ -93,6 +138,9 @@ func (m *Manager) CreateWorkerSession(ctx context.Context, userID uuid.UUID, nam
 	if m.workerSelector == nil {
 		return nil, fmt.Errorf("no worker selector configured")
 	}
+	if err := m.checkQuota(userID, hub); err != nil {
+		return nil, err
+	}
 
 	workerID, err := m.workerSelector.SelectWorker(userID)
 	if err != nil {
@@ -124,17 +172,14 @@ func (m *Manager) CreateWorkerSession(ctx context.Context, userID uuid.UUID, nam
 	return sess, nil
 }
 
-// ResumeSession resumes an offline session on a worker.
-func (m *Manager) ResumeSession(ctx context.Context, sess *Session, hub WorkerHub) error {
-	if sess.WorkerID == nil {
-		return fmt.Errorf("session has no worker")
-	}
-	hub.SpawnSession(sess.ID, *sess.WorkerID, "claude --continue", sess.WorkDir)
-	return nil
-}
-
 // DestroySession stops the container and removes the session.
 func (m *Manager) DestroySession(ctx context.Context, sess *Session) error {
+	m.stopContainer(ctx, sess)
+	return m.repo.Delete(sess.ID)
+}
+
+// stopContainer stops a container session's container, if it has one.
+func (m *Manager) stopContainer(ctx context.Context, sess *Session) {
 	if sess.ContainerID != "" && sess.WorkerHost != "" {
 		worker, err := m.workerPool.FindByHost(sess.WorkerHost)
 		if err == nil {
@@ -146,14 +191,83 @@ func (m *Manager) DestroySession(ctx context.Context, sess *Session) error {
 			}
 		}
 	}
-
-	return m.repo.Delete(sess.ID)
 }
 
-// DestroyWorkerSession kills a worker session and removes it.
+// DestroyWorkerSession kills a worker session and soft-deletes it, disconnecting its
+// viewers. Its scrollback and recording are kept until the retention purge.
 func (m *Manager) DestroyWorkerSession(ctx context.Context, sess *Session, hub WorkerHub) error {
-	hub.KillSession(sess.ID)
-	return m.repo.Delete(sess.ID)
+	if err := hub.KillSession(sess.ID); err != nil {
+		return err
+	}
+	if err := m.repo.Delete(sess.ID); err != nil {
+		return err
+	}
+	hub.RemoveSession(sess.ID)
+	return nil
+}
+
+// ArchiveSession stops a session's process and hides the session from the default
+// listing. Its scrollback and recording are kept until it is restored or purged.
+func (m *Manager) ArchiveSession(ctx context.Context, sess *Session, hub WorkerHub) error {
+	if sess.SessionType == SessionTypeWorker {
+		if sess.Status == StatusRunning || sess.Status == StatusCreating {
+			if err := hub.KillSession(sess.ID); err != nil {
+				return err
+			}
+		}
+	} else {
+		m.stopContainer(ctx, sess)
+		sess.Status = StatusStopped
+	}
+
+	now := time.Now()
+	sess.ArchivedAt = &now
+	return m.repo.Update(sess)
+}
+
+// RestoreSession brings an archived session back into the default listing.
+func (m *Manager) RestoreSession(sess *Session) error {
+	sess.ArchivedAt = nil
+	return m.repo.Update(sess)
+}
+
+// StartRetention periodically purges sessions that were archived or deleted more
+// than maxAge ago, together with their stored scrollback and recordings.
+func (m *Manager) StartRetention(interval, maxAge time.Duration, hub WorkerHub) {
+	if maxAge <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			m.purgeExpired(maxAge, hub)
+		}
+	}()
+}
+
+func (m *Manager) purgeExpired(maxAge time.Duration, hub WorkerHub) {
+	sessions, err := m.repo.FindPurgeable(time.Now().Add(-maxAge))
+	if err != nil {
+		log.Printf("session: failed to find expired sessions: %v", err)
+		return
+	}
+
+	purged := 0
+	for _, sess := range sessions {
+		if sess.SessionType == SessionTypeWorker {
+			hub.RemoveSession(sess.ID)
+		}
+		if err := m.repo.Purge(sess.ID); err != nil {
+			log.Printf("session: failed to purge session %s: %v", sess.ID, err)
+			continue
+		}
+		hub.PurgeSessionData(sess.ID)
+		purged++
+	}
+	if purged > 0 {
+		log.Printf("session: purged %d archived or deleted sessions", purged)
+	}
 }
 
 // HealthCheck verifies the container is reachable. Used after creation.
@@ -17,6 +17,16 @@ const (
 	StatusOffline  Status = "offline"
 )
 
+// ActivityState describes what the process in a running session is doing, as inferred
+// from its output.
+type ActivityState string
+
+const (
+	ActivityBusy          ActivityState = "busy"
+	ActivityIdle          ActivityState = "idle"
+	ActivityAwaitingInput ActivityState = "awaiting-input"
+)
+
 type SessionType string
 
 const (
@@ -25,20 +35,42 @@ const (
 )
 
 type Session struct {
-	ID            uuid.UUID   `gorm:"type:uuid;primaryKey"`
-	UserID        uuid.UUID   `gorm:"type:uuid;index;not null"`
-	Name          string      `gorm:"not null"`
-	SessionType   SessionType `gorm:"column:session_type;not null;default:'container'"`
-	ContainerID   string      `gorm:"column:container_id"`
-	WorkerHost    string      `gorm:"column:worker_host"`
-	ContainerPort int         `gorm:"column:container_port"`
-	WorkerID      *uuid.UUID  `gorm:"type:uuid;index"`
-	WorkDir       string      `gorm:"column:work_dir"`
-	Command       string      `gorm:"column:command"`
-	ExitCode      *int        `gorm:"column:exit_code"`
-	Status        Status      `gorm:"not null;default:'creating'"`
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
+	ID                uuid.UUID     `gorm:"type:uuid;primaryKey"`
+	UserID            uuid.UUID     `gorm:"type:uuid;index;not null"`
+	Name              string        `gorm:"not null"`
+	SessionType       SessionType   `gorm:"column:session_type;not null;default:'container'"`
+	ContainerID       string        `gorm:"column:container_id"`
+	WorkerHost        string        `gorm:"column:worker_host"`
+	ContainerPort     int           `gorm:"column:container_port"`
+	WorkerID          *uuid.UUID    `gorm:"type:uuid;index"`
+	WorkDir           string        `gorm:"column:work_dir"`
+	Folder            string        `gorm:"column:folder;index"`
+	Tags              []string      `gorm:"type:jsonb;serializer:json;index:idx_sessions_tags,type:gin"`
+	Command           string        `gorm:"column:command"`
+	ExitCode          *int          `gorm:"column:exit_code"`
+	Status            Status        `gorm:"not null;default:'creating'"`
+	ActivityState     ActivityState `gorm:"column:activity_state"`
+	ActivityChangedAt *time.Time    `gorm:"column:activity_changed_at"`
+	// Persistent scrollback limits; nil uses the server defaults.
+	ScrollbackMaxBytes      *int64 `gorm:"column:scrollback_max_bytes"`
+	ScrollbackRetentionDays *int   `gorm:"column:scrollback_retention_days"`
+	// Timeouts; nil uses the owner's or the server's policy, zero disables.
+	IdleTimeoutMinutes *int       `gorm:"column:idle_timeout_minutes"`
+	MaxLifetimeMinutes *int       `gorm:"column:max_lifetime_minutes"`
+	StartedAt          *time.Time `gorm:"column:started_at"`       // when the current process started
+	FirstStartedAt     *time.Time `gorm:"column:first_started_at"` // when the session first started; the max lifetime runs from here across resumes
+	// Activity counters, flushed periodically by the worker hub.
+	LastOutputAt   *time.Time `gorm:"column:last_output_at"`
+	LastInputAt    *time.Time `gorm:"column:last_input_at"`
+	BytesIn        int64      `gorm:"column:bytes_in;not null;default:0"`
+	BytesOut       int64      `gorm:"column:bytes_out;not null;default:0"`
+	RunningSeconds float64    `gorm:"column:running_seconds;not null;default:0"`
+	ResumeCount    int        `gorm:"column:resume_count;not null;default:0"`
+	// ArchivedAt hides the session from the default listing until it is restored.
+	ArchivedAt *time.Time `gorm:"column:archived_at;index"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	DeletedAt  gorm.DeletedAt `gorm:"index"`
 }
 
 func (s *Session) BeforeCreate(tx *gorm.DB) error {
@@ -47,3 +79,63 @@ func (s *Session) BeforeCreate(tx *gorm.DB) error {
 	}
 	return nil
 }
+
+// LastActiveAt returns when the session last had input or output, or when it was
+// created if it never had any.
+func (s *Session) LastActiveAt() time.Time {
+	last := s.CreatedAt
+	for _, t := range []*time.Time{s.LastOutputAt, s.LastInputAt} {
+		if t != nil && t.After(last) {
+			last = *t
+		}
+	}
+	return last
+}
+
+// ActivityStats are activity counters gathered since they were last stored. Zero
+// times leave the stored value unchanged.
+type ActivityStats struct {
+	BytesIn      int64
+	BytesOut     int64
+	Running      time.Duration
+	LastInputAt  time.Time
+	LastOutputAt time.Time
+}
+
+// IsZero reports whether there is nothing to store.
+func (a ActivityStats) IsZero() bool {
+	return a == ActivityStats{}
+}
+
+// ViewerInfo describes a viewer attached to a session's terminal.
+type ViewerInfo struct {
+	ID          uuid.UUID `json:"id"`
+	UserID      uuid.UUID `json:"userId"`
+	Device      string    `json:"device"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+// ActivityEvent reports a change in a session's activity state.
+type ActivityEvent struct {
+	SessionID uuid.UUID     `json:"sessionId"`
+	State     ActivityState `json:"state"`
+	At        time.Time     `json:"at"`
+}
+
+// Output event types.
+const (
+	OutputEventData   = "output" // a chunk of terminal output
+	OutputEventStatus = "status" // the session's status changed
+	OutputEventExit   = "exit"   // the session's process exited
+)
+
+// OutputEvent is delivered to followers of a session's output. Offset is the
+// position of Data in the session's output stream; a jump between consecutive
+// chunks means the follower fell behind and output was skipped.
+type OutputEvent struct {
+	Type     string `json:"type"`
+	Offset   int64  `json:"offset"`
+	Data     []byte `json:"-"`
+	Status   Status `json:"status,omitempty"`
+	ExitCode *int   `json:"exitCode,omitempty"`
+}
@@ -1,6 +1,11 @@
 package session
 
 import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
@@ -26,29 +31,234 @@ func (r *Repository) FindByID(id uuid.UUID) (*Session, error) {
 	return &s, nil
 }
 
+// FindByIDUnscoped finds a session even if it was soft-deleted.
+func (r *Repository) FindByIDUnscoped(id uuid.UUID) (*Session, error) {
+	var s Session
+	err := r.db.Unscoped().First(&s, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
 func (r *Repository) FindByUserID(userID uuid.UUID) ([]Session, error) {
 	var sessions []Session
 	err := r.db.Where("user_id = ?", userID).Order("created_at desc").Find(&sessions).Error
 	return sessions, err
 }
 
+// Sort orders for List.
+const (
+	SortCreated  = "created"  // newest first
+	SortActivity = "activity" // most recent activity change first
+	SortRecent   = "recent"   // most recent input or output first
+)
+
+// Archive visibility for List.
+const (
+	ArchivedExclude = "" // only sessions that aren't archived
+	ArchivedOnly    = "only"
+	ArchivedInclude = "all"
+)
+
+// ListFilter narrows and pages a user's sessions. Zero values don't filter.
+type ListFilter struct {
+	Statuses      []Status
+	WorkerID      *uuid.UUID
+	Tags          []string // sessions must carry every tag
+	Folder        *string
+	WorkDirPrefix string
+	Query         string // case-insensitive substring of the name
+	Archived      string // one of the Archived constants
+	Sort          string
+	Limit         int         // 0 returns every match
+	After         *ListCursor // continue after this position
+}
+
+// ListCursor is the position of the last session of a page.
+type ListCursor struct {
+	At time.Time `json:"t"`
+	ID uuid.UUID `json:"id"`
+}
+
+// sortKey returns the column expression List orders by.
+func sortKey(sort string) string {
+	switch sort {
+	case SortActivity:
+		return "COALESCE(activity_changed_at, created_at)"
+	case SortRecent:
+		// GREATEST ignores NULLs, so sessions without input or output sort by
+		// creation.
+		return "GREATEST(created_at, last_output_at, last_input_at)"
+	}
+	return "created_at"
+}
+
+// Cursor returns the position of s in a listing ordered by sort.
+func (s *Session) Cursor(sort string) ListCursor {
+	switch {
+	case sort == SortActivity && s.ActivityChangedAt != nil:
+		return ListCursor{At: *s.ActivityChangedAt, ID: s.ID}
+	case sort == SortRecent:
+		return ListCursor{At: s.LastActiveAt(), ID: s.ID}
+	}
+	return ListCursor{At: s.CreatedAt, ID: s.ID}
+}
+
+// List returns a user's sessions matching f, most recent first.
+func (r *Repository) List(userID uuid.UUID, f ListFilter) ([]Session, error) {
+	q := r.db.Where("user_id = ?", userID)
+	switch f.Archived {
+	case ArchivedExclude:
+		q = q.Where("archived_at IS NULL")
+	case ArchivedOnly:
+		q = q.Where("archived_at IS NOT NULL")
+	}
+	if len(f.Statuses) > 0 {
+		q = q.Where("status IN ?", f.Statuses)
+	}
+	if f.WorkerID != nil {
+		q = q.Where("worker_id = ?", *f.WorkerID)
+	}
+	if len(f.Tags) > 0 {
+		tags, _ := json.Marshal(f.Tags)
+		q = q.Where("tags @> ?::jsonb", string(tags))
+	}
+	if f.Folder != nil {
+		q = q.Where("folder = ?", *f.Folder)
+	}
+	if f.WorkDirPrefix != "" {
+		q = q.Where("work_dir LIKE ? ESCAPE '\\'", escapeLike(f.WorkDirPrefix)+"%")
+	}
+	if f.Query != "" {
+		q = q.Where("name ILIKE ? ESCAPE '\\'", "%"+escapeLike(f.Query)+"%")
+	}
+
+	key := sortKey(f.Sort)
+	if f.After != nil {
+		q = q.Where("("+key+", id) < (?, ?)", f.After.At, f.After.ID)
+	}
+	q = q.Order(key + " desc").Order("id desc")
+	if f.Limit > 0 {
+		q = q.Limit(f.Limit)
+	}
+
+	var sessions []Session
+	err := q.Find(&sessions).Error
+	return sessions, err
+}
+
+// escapeLike escapes the LIKE wildcards in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(s)
+}
+
 func (r *Repository) FindByWorkerID(workerID uuid.UUID) ([]Session, error) {
 	var sessions []Session
 	err := r.db.Where("worker_id = ?", workerID).Find(&sessions).Error
 	return sessions, err
 }
 
+// FindRunningWorkerSessions returns every running worker session.
+func (r *Repository) FindRunningWorkerSessions() ([]Session, error) {
+	var sessions []Session
+	err := r.db.Where("session_type = ? AND status = ?", SessionTypeWorker, StatusRunning).Find(&sessions).Error
+	return sessions, err
+}
+
+// CountRunning returns how many of a user's sessions are running or starting.
+func (r *Repository) CountRunning(userID uuid.UUID) (int, error) {
+	var n int64
+	err := r.db.Model(&Session{}).
+		Where("user_id = ? AND status IN ?", userID, []Status{StatusRunning, StatusCreating}).
+		Count(&n).Error
+	return int(n), err
+}
+
 // FindResumable returns offline sessions for a given worker that can be auto-resumed.
 func (r *Repository) FindResumable(workerID uuid.UUID) ([]Session, error) {
 	var sessions []Session
-	err := r.db.Where("worker_id = ? AND status = ?", workerID, StatusOffline).Find(&sessions).Error
+	err := r.db.Where("worker_id = ? AND status = ? AND archived_at IS NULL", workerID, StatusOffline).Find(&sessions).Error
 	return sessions, err
 }
 
+// hubColumns are written by the worker hub with targeted updates (UpdateActivity,
+// AddActivityStats, IncrementResumes). Update leaves them alone so that saving a
+// session loaded earlier doesn't overwrite newer values.
+var hubColumns = []string{
+	"activity_state", "activity_changed_at",
+	"last_output_at", "last_input_at",
+	"bytes_in", "bytes_out", "running_seconds", "resume_count",
+}
+
+// Update saves a session, except for the columns the hub maintains.
 func (r *Repository) Update(s *Session) error {
-	return r.db.Save(s).Error
+	return r.db.Omit(hubColumns...).Save(s).Error
 }
 
+// AddActivityStats adds activity counters to a session's totals.
+func (r *Repository) AddActivityStats(id uuid.UUID, a ActivityStats) error {
+	cols := map[string]interface{}{
+		"bytes_in":        gorm.Expr("bytes_in + ?", a.BytesIn),
+		"bytes_out":       gorm.Expr("bytes_out + ?", a.BytesOut),
+		"running_seconds": gorm.Expr("running_seconds + ?", a.Running.Seconds()),
+	}
+	if !a.LastInputAt.IsZero() {
+		cols["last_input_at"] = a.LastInputAt
+	}
+	if !a.LastOutputAt.IsZero() {
+		cols["last_output_at"] = a.LastOutputAt
+	}
+	return r.db.Model(&Session{}).Where("id = ?", id).UpdateColumns(cols).Error
+}
+
+// IncrementResumes counts a resume of a session.
+func (r *Repository) IncrementResumes(id uuid.UUID) error {
+	return r.db.Model(&Session{}).Where("id = ?", id).
+		UpdateColumn("resume_count", gorm.Expr("resume_count + 1")).Error
+}
+
+// UpdateActivity stores a session's activity state without touching other columns.
+func (r *Repository) UpdateActivity(id uuid.UUID, state ActivityState, at time.Time) error {
+	return r.db.Model(&Session{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+		"activity_state":      state,
+		"activity_changed_at": at,
+	}).Error
+}
+
+// FindUserTimeouts returns a user's timeout overrides. Users who never saved any
+// get an empty override that uses the server defaults.
+func (r *Repository) FindUserTimeouts(userID uuid.UUID) (*UserTimeouts, error) {
+	var t UserTimeouts
+	err := r.db.First(&t, "user_id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &UserTimeouts{UserID: userID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *Repository) SaveUserTimeouts(t *UserTimeouts) error {
+	return r.db.Save(t).Error
+}
+
+// Delete soft-deletes a session. The row is kept for auditing until it is purged.
 func (r *Repository) Delete(id uuid.UUID) error {
 	return r.db.Delete(&Session{}, "id = ?", id).Error
 }
+
+// FindPurgeable returns sessions archived or deleted before the given time.
+func (r *Repository) FindPurgeable(before time.Time) ([]Session, error) {
+	var sessions []Session
+	err := r.db.Unscoped().
+		Where("archived_at < ? OR deleted_at < ?", before, before).
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// Purge permanently removes a session, including soft-deleted ones.
+func (r *Repository) Purge(id uuid.UUID) error {
+	return r.db.Unscoped().Delete(&Session{}, "id = ?", id).Error
+}
@@ -0,0 +1,216 @@
+package session
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// dryRunRepository returns a repository that builds statements without a database
+// and a function returning the SQL built since the last call.
+func dryRunRepository(t *testing.T) (*Repository, func() string) {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sql []string
+	capture := func(tx *gorm.DB) { sql = append(sql, tx.Statement.SQL.String()) }
+	db.Callback().Query().After("gorm:query").Register("test:capture", capture)
+	db.Callback().Update().After("gorm:update").Register("test:capture", capture)
+	db.Callback().Delete().After("gorm:delete").Register("test:capture", capture)
+	return NewRepository(db), func() string {
+		s := strings.Join(sql, "; ")
+		sql = nil
+		return s
+	}
+}
+
+func TestRepositorySoftDelete(t *testing.T) {
+	repo, built := dryRunRepository(t)
+	id := uuid.New()
+
+	tests := []struct {
+		name    string
+		run     func()
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "delete keeps the row",
+			run:     func() { repo.Delete(id) },
+			want:    []string{`UPDATE "sessions" SET "deleted_at"=`},
+			notWant: []string{"DELETE"},
+		},
+		{
+			name: "purge removes the row",
+			run:  func() { repo.Purge(id) },
+			want: []string{`DELETE FROM "sessions" WHERE id = `},
+		},
+		{
+			name: "deleted sessions are hidden",
+			run:  func() { repo.FindByID(id) },
+			want: []string{`"sessions"."deleted_at" IS NULL`},
+		},
+		{
+			name:    "unscoped lookups see deleted sessions",
+			run:     func() { repo.FindByIDUnscoped(id) },
+			notWant: []string{"deleted_at"},
+		},
+		{
+			name:    "purgeable sessions include deleted ones",
+			run:     func() { repo.FindPurgeable(time.Now()) },
+			want:    []string{"archived_at < $1 OR deleted_at < $2"},
+			notWant: []string{"IS NULL"},
+		},
+		{
+			name: "listing hides archived sessions by default",
+			run:  func() { repo.List(id, ListFilter{}) },
+			want: []string{"archived_at IS NULL", `"sessions"."deleted_at" IS NULL`},
+		},
+		{
+			name:    "listing archived sessions only",
+			run:     func() { repo.List(id, ListFilter{Archived: ArchivedOnly}) },
+			want:    []string{"archived_at IS NOT NULL", `"sessions"."deleted_at" IS NULL`},
+			notWant: []string{"archived_at IS NULL"},
+		},
+		{
+			name:    "listing everything",
+			run:     func() { repo.List(id, ListFilter{Archived: ArchivedInclude}) },
+			want:    []string{`"sessions"."deleted_at" IS NULL`},
+			notWant: []string{"archived_at"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			built()
+			tt.run()
+			sql := built()
+			if sql == "" {
+				t.Fatal("no statement built")
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(sql, s) {
+					t.Errorf("%s\nlacks %q", sql, s)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(sql, s) {
+					t.Errorf("%s\ncontains %q", sql, s)
+				}
+			}
+		})
+	}
+}
+
+// killRecorder is a WorkerHub that records the sessions it is asked to kill.
+type killRecorder struct {
+	WorkerHub
+	killed []uuid.UUID
+}
+
+func (h *killRecorder) KillSession(sessionID uuid.UUID) error {
+	h.killed = append(h.killed, sessionID)
+	return nil
+}
+
+func TestArchiveAndRestore(t *testing.T) {
+	tests := []struct {
+		name       string
+		sess       Session
+		wantKill   bool
+		wantStatus Status
+	}{
+		{
+			name:       "running worker session",
+			sess:       Session{SessionType: SessionTypeWorker, Status: StatusRunning},
+			wantKill:   true,
+			wantStatus: StatusRunning, // stopped by the worker's exit message
+		},
+		{
+			name:       "offline worker session",
+			sess:       Session{SessionType: SessionTypeWorker, Status: StatusOffline},
+			wantStatus: StatusOffline,
+		},
+		{
+			name:       "container session",
+			sess:       Session{SessionType: "container", Status: StatusRunning},
+			wantStatus: StatusStopped,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, built := dryRunRepository(t)
+			m := NewManager(repo, nil, nil)
+			hub := &killRecorder{}
+			sess := tt.sess
+			sess.ID = uuid.New()
+
+			if err := m.ArchiveSession(context.Background(), &sess, hub); err != nil {
+				t.Fatal(err)
+			}
+			if (len(hub.killed) == 1) != tt.wantKill {
+				t.Errorf("killed %v, want kill = %v", hub.killed, tt.wantKill)
+			}
+			if sess.ArchivedAt == nil || sess.Status != tt.wantStatus {
+				t.Errorf("archived at %v with status %s, want archived with status %s", sess.ArchivedAt, sess.Status, tt.wantStatus)
+			}
+			if sql := built(); !strings.Contains(sql, `"archived_at"=`) {
+				t.Errorf("archiving built %s", sql)
+			}
+
+			built()
+			if err := m.RestoreSession(&sess); err != nil {
+				t.Fatal(err)
+			}
+			if sess.ArchivedAt != nil {
+				t.Error("restored session is still archived")
+			}
+			if sql := built(); !strings.Contains(sql, `"archived_at"=$`) {
+				t.Errorf("restoring built %s", sql)
+			}
+		})
+	}
+}
+
+func TestRepositoryActivityStats(t *testing.T) {
+	repo, built := dryRunRepository(t)
+	id := uuid.New()
+
+	repo.AddActivityStats(id, ActivityStats{BytesIn: 3, BytesOut: 40, Running: time.Minute})
+	sql := built()
+	for _, s := range []string{`"bytes_in"=bytes_in + $`, `"bytes_out"=bytes_out + $`, `"running_seconds"=running_seconds + $`} {
+		if !strings.Contains(sql, s) {
+			t.Errorf("%s\nlacks %q", sql, s)
+		}
+	}
+	if strings.Contains(sql, "last_input_at") || strings.Contains(sql, "last_output_at") {
+		t.Errorf("%s\noverwrites last activity times it doesn't have", sql)
+	}
+
+	repo.AddActivityStats(id, ActivityStats{LastInputAt: time.Now(), LastOutputAt: time.Now()})
+	if sql := built(); !strings.Contains(sql, `"last_input_at"=$`) || !strings.Contains(sql, `"last_output_at"=$`) {
+		t.Errorf("%s\nlacks the last activity times", sql)
+	}
+
+	// Saving a session doesn't overwrite the counters the hub maintains.
+	repo.Update(&Session{ID: id, Name: "renamed"})
+	sql = built()
+	if !strings.Contains(sql, `"name"=$`) {
+		t.Fatalf("Update built %s", sql)
+	}
+	for _, col := range hubColumns {
+		if strings.Contains(sql, `"`+col+`"`) {
+			t.Errorf("Update writes hub column %s", col)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package session
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/moltty/server/internal/ansi"
+)
+
+// SearchMatch is one hit in a session's terminal history.
+type SearchMatch struct {
+	Line   int      `json:"line"`   // 0-based line number in the stripped history
+	Column int      `json:"column"` // byte column of the match within the line
+	Offset int64    `json:"offset"` // stream offset of the match in the raw history
+	Length int      `json:"length"` // length of the match in the raw history, escapes included
+	Text   string   `json:"text"`   // the matching line, escapes stripped
+	Match  string   `json:"match"`  // the matched text
+	Before []string `json:"before"` // context lines preceding the match
+	After  []string `json:"after"`  // context lines following the match
+}
+
+// SearchHistory finds re in raw terminal output, which starts at stream offset base,
+// after stripping ANSI/VT escape sequences. Matching is done line by line. It returns
+// at most limit matches and whether more were found.
+func SearchHistory(raw []byte, base int64, re *regexp.Regexp, contextLines, limit int) ([]SearchMatch, bool) {
+	text, offsets := ansi.StripWithOffsets(raw)
+	lines := bytes.Split(text, []byte("\n"))
+
+	matches := []SearchMatch{}
+	lineStart := 0
+	for i, line := range lines {
+		for _, loc := range re.FindAllIndex(line, -1) {
+			if loc[0] == loc[1] {
+				continue // ignore empty matches
+			}
+			if len(matches) == limit {
+				return matches, true
+			}
+
+			start := offsets.Raw(lineStart + loc[0])
+			end := offsets.Raw(lineStart+loc[1]-1) + 1
+			matches = append(matches, SearchMatch{
+				Line:   i,
+				Column: loc[0],
+				Offset: base + start,
+				Length: int(end - start),
+				Text:   string(line),
+				Match:  string(line[loc[0]:loc[1]]),
+				Before: contextSlice(lines, i-contextLines, i),
+				After:  contextSlice(lines, i+1, i+1+contextLines),
+			})
+		}
+		lineStart += len(line) + 1
+	}
+	return matches, false
+}
+
+// contextSlice returns lines[from:to] as strings, clamped to the valid range.
+func contextSlice(lines [][]byte, from, to int) []string {
+	from = max(from, 0)
+	to = min(to, len(lines))
+	out := make([]string, 0, max(to-from, 0))
+	for i := from; i < to; i++ {
+		out = append(out, string(lines[i]))
+	}
+	return out
+}
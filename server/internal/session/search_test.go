@@ -0,0 +1,71 @@
+package session
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestSearchHistory(t *testing.T) {
+	raw := []byte("$ make\r\n\x1b[31merror\x1b[0m: missing file\r\nok\r\n\x1b[1mError\x1b[0m again\r\n")
+
+	tests := []struct {
+		name      string
+		pattern   string
+		base      int64
+		context   int
+		limit     int
+		want      []SearchMatch
+		truncated bool
+	}{
+		{
+			name:    "match inside escapes",
+			pattern: `error: \w+`,
+			base:    1000,
+			limit:   10,
+			want: []SearchMatch{{
+				Line: 1, Column: 0, Offset: 1013, Length: 18,
+				Text: "error: missing file", Match: "error: missing",
+				Before: []string{}, After: []string{},
+			}},
+		},
+		{
+			name:    "context lines",
+			pattern: `ok`,
+			context: 1,
+			limit:   10,
+			want: []SearchMatch{{
+				Line: 2, Column: 0, Offset: 38, Length: 2,
+				Text: "ok", Match: "ok",
+				Before: []string{"error: missing file"}, After: []string{"Error again"},
+			}},
+		},
+		{
+			name:      "limit",
+			pattern:   `(?i)error`,
+			limit:     1,
+			want:      []SearchMatch{{Line: 1, Column: 0, Offset: 13, Length: 5, Text: "error: missing file", Match: "error", Before: []string{}, After: []string{}}},
+			truncated: true,
+		},
+		{
+			name:    "no match",
+			pattern: `warning`,
+			limit:   10,
+			want:    []SearchMatch{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, truncated := SearchHistory(raw, tt.base, regexp.MustCompile(tt.pattern), tt.context, tt.limit)
+			if !reflect.DeepEqual(got, tt.want) || truncated != tt.truncated {
+				t.Errorf("SearchHistory() = %+v, %v\nwant %+v, %v", got, truncated, tt.want, tt.truncated)
+			}
+			for _, m := range got {
+				start := m.Offset - tt.base
+				if end := start + int64(m.Length); end > int64(len(raw)) || raw[end-1] != m.Match[len(m.Match)-1] {
+					t.Errorf("match %q does not end at offset %d", m.Match, m.Offset+int64(m.Length))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/moltty/server/internal/ansi"
+)
+
+// maxStreamLine bounds how much text line mode buffers before emitting a partial
+// line.
+const maxStreamLine = 64 * 1024
+
+// streamEncoder writes a session's output events as Server-Sent Events. Output
+// event ids are stream offsets, so a client can resume with Last-Event-ID.
+//
+// Raw output is sent base64-encoded. With strip, escape sequences are removed and
+// the text is sent as a string; in line mode only complete lines are sent, one
+// event each.
+type streamEncoder struct {
+	strip   bool
+	lines   bool
+	next    int64 // stream offset of the next expected output byte
+	started bool
+
+	stripper ansi.Stripper
+	text     []byte
+	offsets  ansi.Offsets // stream offset of each byte in text
+}
+
+type streamChunk struct {
+	Offset int64  `json:"offset"`
+	Data   []byte `json:"data,omitempty"`
+	Text   string `json:"text,omitempty"`
+}
+
+func (e *streamEncoder) encode(w *bufio.Writer, ev OutputEvent) {
+	switch ev.Type {
+	case OutputEventData:
+		if e.started && ev.Offset > e.next {
+			// The client fell behind the scrollback window.
+			writeEvent(w, "skipped", "", fiber.Map{"from": e.next, "to": ev.Offset})
+			e.resetText()
+		}
+		e.started = true
+		e.next = ev.Offset + int64(len(ev.Data))
+		e.encodeOutput(w, ev)
+	case OutputEventStatus:
+		writeEvent(w, "status", "", fiber.Map{"status": ev.Status, "offset": ev.Offset})
+	case OutputEventExit:
+		writeEvent(w, "exit", "", fiber.Map{"exitCode": ev.ExitCode, "offset": ev.Offset})
+	}
+}
+
+func (e *streamEncoder) encodeOutput(w *bufio.Writer, ev OutputEvent) {
+	end := ev.Offset + int64(len(ev.Data))
+	id := fmt.Sprint(end)
+	if !e.strip && !e.lines {
+		writeEvent(w, "output", id, streamChunk{Offset: ev.Offset, Data: ev.Data})
+		return
+	}
+	if !e.lines {
+		text := e.stripper.Strip(nil, ev.Data)
+		if len(text) > 0 {
+			writeEvent(w, "output", id, streamChunk{Offset: ev.Offset, Text: string(text)})
+		}
+		return
+	}
+
+	e.text = e.stripper.StripOffsets(e.text, &e.offsets, ev.Data, ev.Offset)
+	start := 0
+	for {
+		rest := e.text[start:]
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 && len(rest) < maxStreamLine {
+			break
+		}
+		n := i + 1
+		if i < 0 {
+			n = len(rest)
+		}
+		line := streamChunk{Offset: e.offsets.Raw(start), Text: string(bytes.TrimSuffix(rest[:n], []byte("\n")))}
+		// Resume after the line's last byte so a reconnect starts with the next line.
+		writeEvent(w, "line", fmt.Sprint(e.offsets.Raw(start+n-1)+1), line)
+		start += n
+		if start == len(e.text) {
+			break
+		}
+	}
+	if start > 0 {
+		e.text = append(e.text[:0], e.text[start:]...)
+		e.offsets.Drop(start)
+	}
+}
+
+func (e *streamEncoder) resetText() {
+	e.stripper = ansi.Stripper{}
+	e.text = e.text[:0]
+	e.offsets.Reset()
+}
+
+// writeEvent writes one SSE event with a JSON payload.
+func writeEvent(w *bufio.Writer, event, id string, payload any) {
+	data, _ := json.Marshal(payload)
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
@@ -0,0 +1,100 @@
+package session
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestStreamEncoder(t *testing.T) {
+	data := func(offset int64, s string) OutputEvent {
+		return OutputEvent{Type: OutputEventData, Offset: offset, Data: []byte(s)}
+	}
+	exitCode := 3
+
+	tests := []struct {
+		name   string
+		strip  bool
+		lines  bool
+		events []OutputEvent
+		want   string
+	}{
+		{
+			name:   "raw output",
+			events: []OutputEvent{data(10, "\x1b[1mhi")},
+			want:   "id: 16\nevent: output\ndata: {\"offset\":10,\"data\":\"G1sxbWhp\"}\n\n",
+		},
+		{
+			name:   "stripped output",
+			strip:  true,
+			events: []OutputEvent{data(0, "\x1b[32mok\x1b[0m\r\n"), data(13, "\x1b[K")},
+			want:   "id: 13\nevent: output\ndata: {\"offset\":0,\"text\":\"ok\\n\"}\n\n",
+		},
+		{
+			name:   "escape split across events",
+			strip:  true,
+			events: []OutputEvent{data(0, "a\x1b["), data(3, "31mb")},
+			want: "id: 3\nevent: output\ndata: {\"offset\":0,\"text\":\"a\"}\n\n" +
+				"id: 7\nevent: output\ndata: {\"offset\":3,\"text\":\"b\"}\n\n",
+		},
+		{
+			name:   "complete lines",
+			lines:  true,
+			events: []OutputEvent{data(100, "one\r\ntw"), data(107, "o\nthree")},
+			want: "id: 105\nevent: line\ndata: {\"offset\":100,\"text\":\"one\"}\n\n" +
+				"id: 109\nevent: line\ndata: {\"offset\":105,\"text\":\"two\"}\n\n",
+		},
+		{
+			name:   "escape split after a complete line",
+			lines:  true,
+			events: []OutputEvent{data(0, "ok\n\x1b["), data(5, "1mnext\n")},
+			want: "id: 3\nevent: line\ndata: {\"offset\":0,\"text\":\"ok\"}\n\n" +
+				"id: 12\nevent: line\ndata: {\"offset\":7,\"text\":\"next\"}\n\n",
+		},
+		{
+			name:   "gap in the stream",
+			lines:  true,
+			events: []OutputEvent{data(0, "partial"), data(50, "new\n")},
+			want: "event: skipped\ndata: {\"from\":7,\"to\":50}\n\n" +
+				"id: 54\nevent: line\ndata: {\"offset\":50,\"text\":\"new\"}\n\n",
+		},
+		{
+			name: "status and exit",
+			events: []OutputEvent{
+				{Type: OutputEventStatus, Offset: 5, Status: StatusStopped},
+				{Type: OutputEventExit, Offset: 5, ExitCode: &exitCode},
+			},
+			want: "event: status\ndata: {\"offset\":5,\"status\":\"stopped\"}\n\n" +
+				"event: exit\ndata: {\"exitCode\":3,\"offset\":5}\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			w := bufio.NewWriter(&out)
+			e := &streamEncoder{strip: tt.strip, lines: tt.lines}
+			for _, ev := range tt.events {
+				e.encode(w, ev)
+			}
+			w.Flush()
+			if got := out.String(); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamEncoderLongLine(t *testing.T) {
+	var out strings.Builder
+	w := bufio.NewWriter(&out)
+	e := &streamEncoder{lines: true}
+	e.encode(w, OutputEvent{Type: OutputEventData, Data: []byte(strings.Repeat("x", maxStreamLine+10))})
+	w.Flush()
+
+	if n := strings.Count(out.String(), "event: line\n"); n != 1 {
+		t.Errorf("got %d line events, want 1", n)
+	}
+	if len(e.text) != 0 {
+		t.Errorf("kept %d bytes after emitting a partial line", len(e.text))
+	}
+}
@@ -0,0 +1,106 @@
+package session
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Reasons a session times out.
+const (
+	TimeoutIdle     = "idle"
+	TimeoutLifetime = "lifetime"
+)
+
+// TimeoutPolicy limits how long a running session may go without input or output
+// and how long it may run in total. A zero duration disables the limit. The total
+// spans resumes: a session resumed after its worker reconnects keeps the lifetime
+// it started with.
+type TimeoutPolicy struct {
+	IdleTimeout time.Duration
+	MaxLifetime time.Duration
+}
+
+// UserTimeouts overrides the server's session timeouts for one user. Nil fields
+// use the server default; zero disables the limit.
+type UserTimeouts struct {
+	UserID             uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	IdleTimeoutMinutes *int      `json:"idleTimeoutMinutes"`
+	MaxLifetimeMinutes *int      `json:"maxLifetimeMinutes"`
+	UpdatedAt          time.Time `json:"updatedAt"`
+}
+
+// ResolveTimeouts applies a user's overrides (which may be nil) and then the
+// session's own settings on top of the server defaults.
+func ResolveTimeouts(defaults TimeoutPolicy, user *UserTimeouts, sess *Session) TimeoutPolicy {
+	p := defaults
+	if user != nil {
+		p.IdleTimeout = minutesOr(user.IdleTimeoutMinutes, p.IdleTimeout)
+		p.MaxLifetime = minutesOr(user.MaxLifetimeMinutes, p.MaxLifetime)
+	}
+	p.IdleTimeout = minutesOr(sess.IdleTimeoutMinutes, p.IdleTimeout)
+	p.MaxLifetime = minutesOr(sess.MaxLifetimeMinutes, p.MaxLifetime)
+	return p
+}
+
+func minutesOr(minutes *int, fallback time.Duration) time.Duration {
+	if minutes == nil {
+		return fallback
+	}
+	return time.Duration(*minutes) * time.Minute
+}
+
+// TimeoutStatus reports a session's limits and how long it has left. Durations
+// are in seconds.
+type TimeoutStatus struct {
+	IdleTimeout       int64      `json:"idleTimeout"` // 0 if disabled
+	MaxLifetime       int64      `json:"maxLifetime"` // 0 if disabled
+	IdleRemaining     *int64     `json:"idleRemaining,omitempty"`
+	LifetimeRemaining *int64     `json:"lifetimeRemaining,omitempty"`
+	Deadline          *time.Time `json:"deadline,omitempty"` // when the first limit fires
+	Reason            string     `json:"reason,omitempty"`   // which limit fires first
+}
+
+// Status computes the remaining time of a running session that was last active
+// at lastActive. Sessions that aren't running have no deadline.
+func (p TimeoutPolicy) Status(sess *Session, lastActive, now time.Time) TimeoutStatus {
+	st := TimeoutStatus{
+		IdleTimeout: int64(p.IdleTimeout / time.Second),
+		MaxLifetime: int64(p.MaxLifetime / time.Second),
+	}
+	if sess.Status != StatusRunning {
+		return st
+	}
+
+	consider := func(deadline time.Time, reason string) *int64 {
+		if st.Deadline == nil || deadline.Before(*st.Deadline) {
+			st.Deadline = &deadline
+			st.Reason = reason
+		}
+		remaining := int64(max(deadline.Sub(now), 0) / time.Second)
+		return &remaining
+	}
+	if p.IdleTimeout > 0 {
+		st.IdleRemaining = consider(lastActive.Add(p.IdleTimeout), TimeoutIdle)
+	}
+	if started := sess.lifetimeStart(); p.MaxLifetime > 0 && started != nil {
+		st.LifetimeRemaining = consider(started.Add(p.MaxLifetime), TimeoutLifetime)
+	}
+	return st
+}
+
+// LifetimeExpired reports whether a session, running or not, has used up its
+// maximum lifetime.
+func (p TimeoutPolicy) LifetimeExpired(sess *Session, now time.Time) bool {
+	started := sess.lifetimeStart()
+	return p.MaxLifetime > 0 && started != nil && !now.Before(started.Add(p.MaxLifetime))
+}
+
+// lifetimeStart returns when a session's lifetime began: its first start, so that
+// resumes don't restart it.
+func (s *Session) lifetimeStart() *time.Time {
+	if s.FirstStartedAt != nil {
+		return s.FirstStartedAt
+	}
+	return s.StartedAt
+}
@@ -0,0 +1,197 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func intPtr(n int) *int { return &n }
+
+func TestResolveTimeouts(t *testing.T) {
+	defaults := TimeoutPolicy{IdleTimeout: time.Hour, MaxLifetime: 24 * time.Hour}
+	tests := []struct {
+		name string
+		user *UserTimeouts
+		sess Session
+		want TimeoutPolicy
+	}{
+		{
+			name: "server defaults",
+			want: defaults,
+		},
+		{
+			name: "user overrides",
+			user: &UserTimeouts{IdleTimeoutMinutes: intPtr(30)},
+			want: TimeoutPolicy{IdleTimeout: 30 * time.Minute, MaxLifetime: 24 * time.Hour},
+		},
+		{
+			name: "user disables a limit",
+			user: &UserTimeouts{MaxLifetimeMinutes: intPtr(0)},
+			want: TimeoutPolicy{IdleTimeout: time.Hour},
+		},
+		{
+			name: "session overrides the user",
+			user: &UserTimeouts{IdleTimeoutMinutes: intPtr(30), MaxLifetimeMinutes: intPtr(60)},
+			sess: Session{IdleTimeoutMinutes: intPtr(5)},
+			want: TimeoutPolicy{IdleTimeout: 5 * time.Minute, MaxLifetime: time.Hour},
+		},
+		{
+			name: "session disables a limit",
+			sess: Session{IdleTimeoutMinutes: intPtr(0), MaxLifetimeMinutes: intPtr(10)},
+			want: TimeoutPolicy{MaxLifetime: 10 * time.Minute},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ResolveTimeouts(defaults, tt.user, &tt.sess); got != tt.want {
+				t.Errorf("ResolveTimeouts() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeoutPolicyStatus(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) *time.Time {
+		t := now.Add(-d)
+		return &t
+	}
+	secs := func(n int64) *int64 { return &n }
+
+	tests := []struct {
+		name       string
+		policy     TimeoutPolicy
+		sess       Session
+		lastActive time.Time
+		idle       *int64
+		lifetime   *int64
+		reason     string
+	}{
+		{
+			name:       "not running",
+			policy:     TimeoutPolicy{IdleTimeout: time.Hour},
+			sess:       Session{Status: StatusOffline},
+			lastActive: now,
+		},
+		{
+			name:       "idle fires first",
+			policy:     TimeoutPolicy{IdleTimeout: time.Hour, MaxLifetime: 24 * time.Hour},
+			sess:       Session{Status: StatusRunning, StartedAt: ago(time.Hour)},
+			lastActive: now.Add(-20 * time.Minute),
+			idle:       secs(40 * 60),
+			lifetime:   secs(23 * 3600),
+			reason:     TimeoutIdle,
+		},
+		{
+			name:       "lifetime fires first",
+			policy:     TimeoutPolicy{IdleTimeout: time.Hour, MaxLifetime: 2 * time.Hour},
+			sess:       Session{Status: StatusRunning, StartedAt: ago(110 * time.Minute)},
+			lastActive: now,
+			idle:       secs(3600),
+			lifetime:   secs(10 * 60),
+			reason:     TimeoutLifetime,
+		},
+		{
+			name:       "lifetime runs from the first start",
+			policy:     TimeoutPolicy{MaxLifetime: 2 * time.Hour},
+			sess:       Session{Status: StatusRunning, StartedAt: ago(time.Minute), FirstStartedAt: ago(time.Hour)},
+			lastActive: now,
+			lifetime:   secs(3600),
+			reason:     TimeoutLifetime,
+		},
+		{
+			name:       "overdue",
+			policy:     TimeoutPolicy{IdleTimeout: time.Minute},
+			sess:       Session{Status: StatusRunning},
+			lastActive: now.Add(-time.Hour),
+			idle:       secs(0),
+			reason:     TimeoutIdle,
+		},
+		{
+			name:       "no limits",
+			sess:       Session{Status: StatusRunning, StartedAt: ago(time.Hour)},
+			lastActive: now,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := tt.policy.Status(&tt.sess, tt.lastActive, now)
+			if !equalPtr(st.IdleRemaining, tt.idle) {
+				t.Errorf("IdleRemaining = %v, want %v", deref(st.IdleRemaining), deref(tt.idle))
+			}
+			if !equalPtr(st.LifetimeRemaining, tt.lifetime) {
+				t.Errorf("LifetimeRemaining = %v, want %v", deref(st.LifetimeRemaining), deref(tt.lifetime))
+			}
+			if st.Reason != tt.reason {
+				t.Errorf("Reason = %q, want %q", st.Reason, tt.reason)
+			}
+			if (st.Deadline == nil) != (tt.reason == "") {
+				t.Errorf("Deadline = %v with reason %q", st.Deadline, st.Reason)
+			}
+		})
+	}
+}
+
+func equalPtr(a, b *int64) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+func deref(p *int64) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+func TestLifetimeSpansResumes(t *testing.T) {
+	now := time.Now()
+	ago := func(d time.Duration) *time.Time { t := now.Add(-d); return &t }
+	policy := TimeoutPolicy{MaxLifetime: 2 * time.Hour}
+
+	tests := []struct {
+		name   string
+		policy TimeoutPolicy
+		sess   Session
+		want   bool
+	}{
+		{
+			name:   "within the lifetime",
+			policy: policy,
+			sess:   Session{Status: StatusOffline, StartedAt: ago(time.Hour), FirstStartedAt: ago(time.Hour)},
+		},
+		{
+			name:   "resumed recently but first started long ago",
+			policy: policy,
+			sess:   Session{Status: StatusOffline, StartedAt: ago(time.Minute), FirstStartedAt: ago(3 * time.Hour)},
+			want:   true,
+		},
+		{
+			name:   "started before first starts were recorded",
+			policy: policy,
+			sess:   Session{Status: StatusOffline, StartedAt: ago(3 * time.Hour)},
+			want:   true,
+		},
+		{
+			name:   "exactly at the limit",
+			policy: policy,
+			sess:   Session{Status: StatusOffline, FirstStartedAt: ago(2 * time.Hour)},
+			want:   true,
+		},
+		{
+			name:   "never started",
+			policy: policy,
+			sess:   Session{Status: StatusOffline},
+		},
+		{
+			name: "no lifetime limit",
+			sess: Session{Status: StatusOffline, FirstStartedAt: ago(100 * time.Hour)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.LifetimeExpired(&tt.sess, now); got != tt.want {
+				t.Errorf("LifetimeExpired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package usage
+
+import (
+	"encoding/csv"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/moltty/server/internal/user"
+)
+
+type Handler struct {
+	repo     *Repository
+	userRepo *user.Repository
+	admins   *user.Admins
+}
+
+func NewHandler(repo *Repository, userRepo *user.Repository, admins *user.Admins) *Handler {
+	return &Handler{repo: repo, userRepo: userRepo, admins: admins}
+}
+
+func getUserID(c *fiber.Ctx) uuid.UUID {
+	token := c.Locals("user").(*jwt.Token)
+	claims := token.Claims.(jwt.MapClaims)
+	id, _ := uuid.Parse(claims["sub"].(string))
+	return id
+}
+
+// Report returns session time and relayed bytes grouped by the comma-separated
+// groupBy dimensions (user, worker, day; day by default) for the range from..to.
+// Users see their own usage; admins see everyone's, or one user's with user=<id>.
+// format=csv, or an Accept header preferring text/csv, returns CSV instead of JSON.
+func (h *Handler) Report(c *fiber.Ctx) error {
+	callerID := getUserID(c)
+	dims, err := parseGroupBy(c.Query("groupBy"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	now := time.Now()
+	from, to, err := parseRange(c.Query("from"), c.Query("to"), now)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var filter *uuid.UUID
+	if v := c.Query("user"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid user id"})
+		}
+		filter = &id
+	}
+	if !h.admins.IsAdmin(callerID) {
+		if filter != nil && *filter != callerID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "admin access required"})
+		}
+		filter = &callerID
+	}
+
+	ivs, err := h.repo.Find(from, to, filter)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to load usage"})
+	}
+	rows := Aggregate(ivs, from, to, now, dims)
+	h.addEmails(rows)
+
+	if wantsCSV(c) {
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="usage.csv"`)
+		return writeCSV(c, rows, dims)
+	}
+	return c.JSON(fiber.Map{
+		"from":    from,
+		"to":      to,
+		"groupBy": dims,
+		"rows":    rows,
+	})
+}
+
+// addEmails fills in the email of each row's user.
+func (h *Handler) addEmails(rows []Row) {
+	emails := make(map[string]string)
+	for i := range rows {
+		id := rows[i].UserID
+		if id == "" {
+			continue
+		}
+		email, ok := emails[id]
+		if !ok {
+			if u, err := h.userRepo.FindByID(uuid.MustParse(id)); err == nil {
+				email = u.Email
+			}
+			emails[id] = email
+		}
+		rows[i].UserEmail = email
+	}
+}
+
+func writeCSV(c *fiber.Ctx, rows []Row, dims []string) error {
+	w := csv.NewWriter(c)
+	var header []string
+	for _, d := range dims {
+		switch d {
+		case ByDay:
+			header = append(header, "day")
+		case ByUser:
+			header = append(header, "user_id", "user_email")
+		case ByWorker:
+			header = append(header, "worker_id")
+		}
+	}
+	header = append(header, "sessions", "seconds", "hours", "bytes_in", "bytes_out")
+	w.Write(header)
+
+	for _, r := range rows {
+		var rec []string
+		for _, d := range dims {
+			switch d {
+			case ByDay:
+				rec = append(rec, r.Day)
+			case ByUser:
+				rec = append(rec, r.UserID, r.UserEmail)
+			case ByWorker:
+				rec = append(rec, r.WorkerID)
+			}
+		}
+		rec = append(rec,
+			strconv.Itoa(r.Sessions),
+			strconv.FormatInt(r.Seconds, 10),
+			strconv.FormatFloat(r.Hours, 'f', 2, 64),
+			strconv.FormatInt(r.BytesIn, 10),
+			strconv.FormatInt(r.BytesOut, 10),
+		)
+		w.Write(rec)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// wantsCSV reports whether the client asked for CSV.
+func wantsCSV(c *fiber.Ctx) bool {
+	switch strings.ToLower(c.Query("format")) {
+	case "csv":
+		return true
+	case "json":
+		return false
+	}
+	return c.Accepts(fiber.MIMEApplicationJSON, "text/csv") == "text/csv"
+}
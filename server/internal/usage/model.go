@@ -0,0 +1,53 @@
+// Package usage meters how long sessions run and how much they relay, and reports
+// it per user, worker and day.
+package usage
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Reasons an interval ended.
+const (
+	EndExited    = "exited"    // the session's process exited
+	EndOffline   = "offline"   // the session's worker disconnected
+	EndRestarted = "restarted" // the session started again without reporting an exit
+	EndDay       = "day"       // the interval was split at midnight UTC
+	EndLost      = "lost"      // the hub lost track of the session, e.g. after a server restart
+)
+
+// Interval is a stretch of time a session ran on a worker, with the bytes relayed
+// in it. Intervals are split at midnight UTC, so each one falls on a single day.
+type Interval struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	SessionID uuid.UUID  `gorm:"type:uuid;index;not null" json:"sessionId"`
+	UserID    uuid.UUID  `gorm:"type:uuid;index;not null" json:"userId"`
+	WorkerID  uuid.UUID  `gorm:"type:uuid;index" json:"workerId"`
+	StartedAt time.Time  `gorm:"index;not null" json:"startedAt"`
+	EndedAt   *time.Time `gorm:"index" json:"endedAt"` // nil while the session runs
+	EndReason string     `json:"endReason,omitempty"`
+	BytesIn   int64      `gorm:"not null;default:0" json:"bytesIn"`
+	BytesOut  int64      `gorm:"not null;default:0" json:"bytesOut"`
+}
+
+func (iv *Interval) BeforeCreate(tx *gorm.DB) error {
+	if iv.ID == uuid.Nil {
+		iv.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName keeps the table name readable; gorm would call it "intervals".
+func (Interval) TableName() string {
+	return "usage_intervals"
+}
+
+// end returns when the interval ended, or now if it is still open.
+func (iv *Interval) end(now time.Time) time.Time {
+	if iv.EndedAt != nil {
+		return *iv.EndedAt
+	}
+	return now
+}
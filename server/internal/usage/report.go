@@ -0,0 +1,170 @@
+package usage
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Dimensions a report can be grouped by.
+const (
+	ByUser   = "user"
+	ByWorker = "worker"
+	ByDay    = "day"
+)
+
+// maxReportRange caps how much history one report covers.
+const maxReportRange = 366 * 24 * time.Hour
+
+// Row is the usage of one group. Fields the report isn't grouped by are zero.
+type Row struct {
+	Day       string  `json:"day,omitempty"` // YYYY-MM-DD, UTC
+	UserID    string  `json:"userId,omitempty"`
+	UserEmail string  `json:"userEmail,omitempty"`
+	WorkerID  string  `json:"workerId,omitempty"`
+	Sessions  int     `json:"sessions"`
+	Seconds   int64   `json:"seconds"`
+	Hours     float64 `json:"hours"`
+	BytesIn   int64   `json:"bytesIn"`
+	BytesOut  int64   `json:"bytesOut"`
+}
+
+// parseGroupBy reads a comma-separated list of dimensions. Empty means by day.
+func parseGroupBy(v string) ([]string, error) {
+	if v == "" {
+		return []string{ByDay}, nil
+	}
+	var dims []string
+	seen := make(map[string]bool)
+	for _, d := range strings.Split(v, ",") {
+		d = strings.TrimSpace(d)
+		switch d {
+		case ByUser, ByWorker, ByDay:
+		default:
+			return nil, errors.New("groupBy must list user, worker or day")
+		}
+		if !seen[d] {
+			seen[d] = true
+			dims = append(dims, d)
+		}
+	}
+	return dims, nil
+}
+
+// parseRange reads the from and to query values, as RFC 3339 times or YYYY-MM-DD
+// dates (UTC). A date in to includes the whole day. Defaults to the last 30 days.
+func parseRange(fromStr, toStr string, now time.Time) (from, to time.Time, err error) {
+	to = now
+	if toStr != "" {
+		if to, err = parseTime(toStr, true); err != nil {
+			return from, to, errors.New("invalid to")
+		}
+	}
+	from = to.AddDate(0, 0, -30)
+	if fromStr != "" {
+		if from, err = parseTime(fromStr, false); err != nil {
+			return from, to, errors.New("invalid from")
+		}
+	}
+	if !from.Before(to) {
+		return from, to, errors.New("from must be before to")
+	}
+	if to.Sub(from) > maxReportRange {
+		return from, to, errors.New("range must not exceed 366 days")
+	}
+	return from, to, nil
+}
+
+func parseTime(v string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return t, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// Aggregate sums intervals clipped to [from, to) into rows grouped by dims. Bytes
+// of an interval that only partly overlaps the range are counted in full.
+func Aggregate(ivs []Interval, from, to, now time.Time, dims []string) []Row {
+	type key struct {
+		day    string
+		user   uuid.UUID
+		worker uuid.UUID
+	}
+	type group struct {
+		row      Row
+		seconds  float64
+		sessions map[uuid.UUID]bool
+	}
+	groups := make(map[key]*group)
+
+	for i := range ivs {
+		iv := &ivs[i]
+		start, end := iv.StartedAt, iv.end(now)
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.Before(start) {
+			continue
+		}
+
+		var k key
+		for _, d := range dims {
+			switch d {
+			case ByDay:
+				k.day = start.UTC().Format(time.DateOnly)
+			case ByUser:
+				k.user = iv.UserID
+			case ByWorker:
+				k.worker = iv.WorkerID
+			}
+		}
+		g, ok := groups[k]
+		if !ok {
+			g = &group{row: Row{Day: k.day}, sessions: make(map[uuid.UUID]bool)}
+			if k.user != uuid.Nil {
+				g.row.UserID = k.user.String()
+			}
+			if k.worker != uuid.Nil {
+				g.row.WorkerID = k.worker.String()
+			}
+			groups[k] = g
+		}
+		g.sessions[iv.SessionID] = true
+		g.seconds += end.Sub(start).Seconds()
+		g.row.BytesIn += iv.BytesIn
+		g.row.BytesOut += iv.BytesOut
+	}
+
+	out := make([]Row, 0, len(groups))
+	for _, g := range groups {
+		g.row.Sessions = len(g.sessions)
+		g.row.Seconds = int64(g.seconds)
+		g.row.Hours = math.Round(g.seconds/36) / 100
+		out = append(out, g.row)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.UserID != b.UserID {
+			return a.UserID < b.UserID
+		}
+		return a.WorkerID < b.WorkerID
+	})
+	return out
+}
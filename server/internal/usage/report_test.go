@@ -0,0 +1,148 @@
+package usage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParseRange(t *testing.T) {
+	now := time.Date(2026, 6, 15, 10, 0, 0, 0, time.UTC)
+	day := func(m time.Month, d int) time.Time { return time.Date(2026, m, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name     string
+		from, to string
+		wantFrom time.Time
+		wantTo   time.Time
+		wantErr  bool
+	}{
+		{name: "last 30 days", wantFrom: now.AddDate(0, 0, -30), wantTo: now},
+		{name: "dates include the last day", from: "2026-06-01", to: "2026-06-10", wantFrom: day(6, 1), wantTo: day(6, 11)},
+		{name: "30 days before to", to: "2026-05-31", wantFrom: day(5, 2), wantTo: day(6, 1)},
+		{
+			name:     "times",
+			from:     "2026-06-01T08:00:00Z",
+			to:       "2026-06-01T12:30:00+02:00",
+			wantFrom: time.Date(2026, 6, 1, 8, 0, 0, 0, time.UTC),
+			wantTo:   time.Date(2026, 6, 1, 10, 30, 0, 0, time.UTC),
+		},
+		{name: "invalid from", from: "June", wantErr: true},
+		{name: "invalid to", to: "2026-13-01", wantErr: true},
+		{name: "from after to", from: "2026-06-10", to: "2026-06-01", wantErr: true},
+		{name: "same instant", from: "2026-06-01T00:00:00Z", to: "2026-06-01T00:00:00Z", wantErr: true},
+		{name: "too long", from: "2025-01-01", to: "2026-06-01", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, err := parseRange(tt.from, tt.to, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRange() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (!from.Equal(tt.wantFrom) || !to.Equal(tt.wantTo)) {
+				t.Errorf("parseRange() = %v, %v, want %v, %v", from, to, tt.wantFrom, tt.wantTo)
+			}
+		})
+	}
+}
+
+func TestParseGroupBy(t *testing.T) {
+	tests := []struct {
+		v       string
+		want    []string
+		wantErr bool
+	}{
+		{"", []string{ByDay}, false},
+		{"user", []string{ByUser}, false},
+		{"day, worker,day", []string{ByDay, ByWorker}, false},
+		{"session", nil, true},
+		{"user,", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.v, func(t *testing.T) {
+			got, err := parseGroupBy(tt.v)
+			if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseGroupBy(%q) = %v, %v, want %v, error %v", tt.v, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	alice := uuid.MustParse("00000000-0000-0000-0000-00000000000a")
+	bob := uuid.MustParse("00000000-0000-0000-0000-00000000000b")
+	w1 := uuid.MustParse("00000000-0000-0000-0000-000000000001")
+	w2 := uuid.MustParse("00000000-0000-0000-0000-000000000002")
+	s1, s2, s3 := uuid.New(), uuid.New(), uuid.New()
+
+	at := func(d, h int) time.Time { return time.Date(2026, 6, d, h, 0, 0, 0, time.UTC) }
+	ended := func(d, h int) *time.Time { t := at(d, h); return &t }
+	ivs := []Interval{
+		{SessionID: s1, UserID: alice, WorkerID: w1, StartedAt: at(1, 10), EndedAt: ended(1, 12), BytesIn: 10, BytesOut: 100},
+		{SessionID: s1, UserID: alice, WorkerID: w1, StartedAt: at(1, 13), EndedAt: ended(1, 14), BytesIn: 5, BytesOut: 50},
+		{SessionID: s2, UserID: alice, WorkerID: w2, StartedAt: at(2, 0), EndedAt: ended(2, 3), BytesOut: 30},
+		{SessionID: s3, UserID: bob, WorkerID: w1, StartedAt: at(2, 22)},                      // still running
+		{SessionID: s3, UserID: bob, WorkerID: w1, StartedAt: at(1, 0), EndedAt: ended(1, 1)}, // before the range
+	}
+	from, to, now := at(1, 9), at(3, 0), at(2, 23)
+
+	tests := []struct {
+		name string
+		dims []string
+		want []Row
+	}{
+		{
+			name: "by day",
+			dims: []string{ByDay},
+			want: []Row{
+				{Day: "2026-06-01", Sessions: 1, Seconds: 3 * 3600, Hours: 3, BytesIn: 15, BytesOut: 150},
+				{Day: "2026-06-02", Sessions: 2, Seconds: 4 * 3600, Hours: 4, BytesOut: 30},
+			},
+		},
+		{
+			name: "by user",
+			dims: []string{ByUser},
+			want: []Row{
+				{UserID: alice.String(), Sessions: 2, Seconds: 6 * 3600, Hours: 6, BytesIn: 15, BytesOut: 180},
+				{UserID: bob.String(), Sessions: 1, Seconds: 3600, Hours: 1},
+			},
+		},
+		{
+			name: "by worker and user",
+			dims: []string{ByWorker, ByUser},
+			want: []Row{
+				{UserID: alice.String(), WorkerID: w1.String(), Sessions: 1, Seconds: 3 * 3600, Hours: 3, BytesIn: 15, BytesOut: 150},
+				{UserID: alice.String(), WorkerID: w2.String(), Sessions: 1, Seconds: 3 * 3600, Hours: 3, BytesOut: 30},
+				{UserID: bob.String(), WorkerID: w1.String(), Sessions: 1, Seconds: 3600, Hours: 1},
+			},
+		},
+		{
+			name: "everything",
+			dims: nil,
+			want: []Row{
+				{Sessions: 3, Seconds: 7 * 3600, Hours: 7, BytesIn: 15, BytesOut: 180},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Aggregate(ivs, from, to, now, tt.dims); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Aggregate() =\n  %+v\nwant\n  %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregateClipsToRange(t *testing.T) {
+	start := time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(90 * time.Minute)
+	ivs := []Interval{{SessionID: uuid.New(), UserID: uuid.New(), StartedAt: start, EndedAt: &end, BytesIn: 7}}
+
+	rows := Aggregate(ivs, start.Add(30*time.Minute), start.Add(time.Hour), end, []string{ByDay})
+	want := []Row{{Day: "2026-06-01", Sessions: 1, Seconds: 1800, Hours: 0.5, BytesIn: 7}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("Aggregate() = %+v, want %+v", rows, want)
+	}
+}
@@ -0,0 +1,90 @@
+package usage
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type Repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) *Repository {
+	return &Repository{db: db}
+}
+
+// Start opens an interval for a session that started running, ending any interval
+// still open for it.
+func (r *Repository) Start(sessionID, userID, workerID uuid.UUID, at time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := endOpen(tx.Where("session_id = ?", sessionID), at, EndRestarted); err != nil {
+			return err
+		}
+		return tx.Create(&Interval{SessionID: sessionID, UserID: userID, WorkerID: workerID, StartedAt: at}).Error
+	})
+}
+
+// End closes a session's open interval.
+func (r *Repository) End(sessionID uuid.UUID, at time.Time, reason string) error {
+	return endOpen(r.db.Where("session_id = ?", sessionID), at, reason)
+}
+
+// EndWorker closes the open intervals of every session on a worker.
+func (r *Repository) EndWorker(workerID uuid.UUID, at time.Time, reason string) error {
+	return endOpen(r.db.Where("worker_id = ?", workerID), at, reason)
+}
+
+func endOpen(scope *gorm.DB, at time.Time, reason string) error {
+	return scope.Model(&Interval{}).Where("ended_at IS NULL").
+		Updates(map[string]interface{}{"ended_at": at, "end_reason": reason}).Error
+}
+
+// AddBytes adds relayed bytes to a session's open interval. It reports false if
+// the session has no open interval.
+func (r *Repository) AddBytes(sessionID uuid.UUID, in, out int64) (bool, error) {
+	res := r.db.Model(&Interval{}).Where("session_id = ? AND ended_at IS NULL", sessionID).
+		UpdateColumns(map[string]interface{}{
+			"bytes_in":  gorm.Expr("bytes_in + ?", in),
+			"bytes_out": gorm.Expr("bytes_out + ?", out),
+		})
+	return res.RowsAffected > 0, res.Error
+}
+
+// Create stores a new interval.
+func (r *Repository) Create(iv *Interval) error {
+	return r.db.Create(iv).Error
+}
+
+// FindOpenBefore returns the open intervals that started before t.
+func (r *Repository) FindOpenBefore(t time.Time) ([]Interval, error) {
+	var ivs []Interval
+	err := r.db.Where("ended_at IS NULL AND started_at < ?", t).Find(&ivs).Error
+	return ivs, err
+}
+
+// Split ends an open interval at t and, if cont is set, continues the session in a
+// new interval from t.
+func (r *Repository) Split(iv *Interval, t time.Time, reason string, cont bool) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := endOpen(tx.Where("id = ?", iv.ID), t, reason); err != nil {
+			return err
+		}
+		if !cont {
+			return nil
+		}
+		return tx.Create(&Interval{SessionID: iv.SessionID, UserID: iv.UserID, WorkerID: iv.WorkerID, StartedAt: t}).Error
+	})
+}
+
+// Find returns the intervals overlapping [from, to), optionally for one user only.
+func (r *Repository) Find(from, to time.Time, userID *uuid.UUID) ([]Interval, error) {
+	q := r.db.Where("started_at < ? AND (ended_at IS NULL OR ended_at > ?)", to, from)
+	if userID != nil {
+		q = q.Where("user_id = ?", *userID)
+	}
+	var ivs []Interval
+	err := q.Order("started_at").Find(&ivs).Error
+	return ivs, err
+}
@@ -0,0 +1,34 @@
+package user
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Admins decides which users may view and manage other users' quotas and usage.
+// Admins are configured by email.
+type Admins struct {
+	repo   *Repository
+	emails map[string]bool
+}
+
+func NewAdmins(repo *Repository, emails []string) *Admins {
+	a := &Admins{repo: repo, emails: make(map[string]bool, len(emails))}
+	for _, e := range emails {
+		a.emails[strings.ToLower(e)] = true
+	}
+	return a
+}
+
+// IsAdmin reports whether the user is an admin.
+func (a *Admins) IsAdmin(userID uuid.UUID) bool {
+	if len(a.emails) == 0 {
+		return false
+	}
+	u, err := a.repo.FindByID(userID)
+	if err != nil {
+		return false
+	}
+	return a.emails[strings.ToLower(u.Email)]
+}
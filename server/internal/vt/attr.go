@@ -0,0 +1,221 @@
+package vt
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Color is a terminal color: the default color, one of the 256 indexed colors, or
+// a 24-bit RGB value.
+type Color uint32
+
+const (
+	ColorDefault Color = 0
+
+	colorIndexed Color = 1 << 24
+	colorRGB     Color = 2 << 24
+	colorKind    Color = 3 << 24
+)
+
+// Indexed returns palette color n (0-15 are the ANSI colors).
+func Indexed(n uint8) Color { return colorIndexed | Color(n) }
+
+// RGB returns a 24-bit color.
+func RGB(r, g, b uint8) Color { return colorRGB | Color(r)<<16 | Color(g)<<8 | Color(b) }
+
+// Flags are the boolean character attributes.
+type Flags uint16
+
+const (
+	Bold Flags = 1 << iota
+	Dim
+	Italic
+	Underline
+	Blink
+	Inverse
+	Hidden
+	Strike
+)
+
+// Attr is the set of graphic rendition attributes (SGR) applied to a character.
+// The zero value is the terminal default.
+type Attr struct {
+	FG    Color
+	BG    Color
+	Flags Flags
+}
+
+// IsDefault reports whether a has no attributes set.
+func (a Attr) IsDefault() bool {
+	return a == Attr{}
+}
+
+// ApplySGR updates a with the parameters of an SGR (CSI ... m) sequence. Each param
+// is a main value followed by any colon-separated sub-parameters.
+func (a *Attr) ApplySGR(params [][]int) {
+	if len(params) == 0 {
+		*a = Attr{}
+		return
+	}
+
+	for i := 0; i < len(params); i++ {
+		p := params[i]
+		switch n := p[0]; {
+		case n == 0:
+			*a = Attr{}
+		case n == 1:
+			a.Flags |= Bold
+		case n == 2:
+			a.Flags |= Dim
+		case n == 3:
+			a.Flags |= Italic
+		case n == 4:
+			if len(p) > 1 && p[1] == 0 {
+				a.Flags &^= Underline
+			} else {
+				a.Flags |= Underline
+			}
+		case n == 5 || n == 6:
+			a.Flags |= Blink
+		case n == 7:
+			a.Flags |= Inverse
+		case n == 8:
+			a.Flags |= Hidden
+		case n == 9:
+			a.Flags |= Strike
+		case n == 21:
+			a.Flags |= Underline
+		case n == 22:
+			a.Flags &^= Bold | Dim
+		case n == 23:
+			a.Flags &^= Italic
+		case n == 24:
+			a.Flags &^= Underline
+		case n == 25:
+			a.Flags &^= Blink
+		case n == 27:
+			a.Flags &^= Inverse
+		case n == 28:
+			a.Flags &^= Hidden
+		case n == 29:
+			a.Flags &^= Strike
+		case n >= 30 && n <= 37:
+			a.FG = Indexed(uint8(n - 30))
+		case n == 38:
+			var c Color
+			c, i = extendedColor(params, i)
+			if c != ColorDefault {
+				a.FG = c
+			}
+		case n == 39:
+			a.FG = ColorDefault
+		case n >= 40 && n <= 47:
+			a.BG = Indexed(uint8(n - 40))
+		case n == 48:
+			var c Color
+			c, i = extendedColor(params, i)
+			if c != ColorDefault {
+				a.BG = c
+			}
+		case n == 49:
+			a.BG = ColorDefault
+		case n == 58:
+			// Underline color: not tracked, but skip its arguments.
+			_, i = extendedColor(params, i)
+		case n >= 90 && n <= 97:
+			a.FG = Indexed(uint8(n - 90 + 8))
+		case n >= 100 && n <= 107:
+			a.BG = Indexed(uint8(n - 100 + 8))
+		}
+	}
+}
+
+// extendedColor parses a 38/48/58 color starting at params[i], in either the
+// colon form (38:5:n, 38:2::r:g:b) or the semicolon form (38;5;n, 38;2;r;g;b).
+// It returns the color and the index of the last parameter consumed.
+func extendedColor(params [][]int, i int) (Color, int) {
+	if sub := params[i][1:]; len(sub) > 0 {
+		switch sub[0] {
+		case 5:
+			if len(sub) >= 2 {
+				return Indexed(uint8(sub[1])), i
+			}
+		case 2:
+			// 38:2:<colorspace>:r:g:b or 38:2:r:g:b
+			rgb := sub[1:]
+			if len(rgb) >= 4 {
+				rgb = rgb[1:]
+			}
+			if len(rgb) >= 3 {
+				return RGB(uint8(rgb[0]), uint8(rgb[1]), uint8(rgb[2])), i
+			}
+		}
+		return ColorDefault, i
+	}
+
+	if i+1 >= len(params) {
+		return ColorDefault, i
+	}
+	switch params[i+1][0] {
+	case 5:
+		if i+2 < len(params) {
+			return Indexed(uint8(params[i+2][0])), i + 2
+		}
+		return ColorDefault, len(params) - 1
+	case 2:
+		if i+4 < len(params) {
+			return RGB(uint8(params[i+2][0]), uint8(params[i+3][0]), uint8(params[i+4][0])), i + 4
+		}
+		return ColorDefault, len(params) - 1
+	}
+	return ColorDefault, i + 1
+}
+
+// SGR returns the escape sequence that sets exactly these attributes, starting from a reset.
+func (a Attr) SGR() string {
+	var b strings.Builder
+	b.WriteString("\x1b[0")
+
+	flags := []struct {
+		flag Flags
+		code string
+	}{
+		{Bold, "1"}, {Dim, "2"}, {Italic, "3"}, {Underline, "4"},
+		{Blink, "5"}, {Inverse, "7"}, {Hidden, "8"}, {Strike, "9"},
+	}
+	for _, f := range flags {
+		if a.Flags&f.flag != 0 {
+			b.WriteByte(';')
+			b.WriteString(f.code)
+		}
+	}
+
+	writeColor(&b, a.FG, 30, 90, "38")
+	writeColor(&b, a.BG, 40, 100, "48")
+
+	b.WriteByte('m')
+	return b.String()
+}
+
+func writeColor(b *strings.Builder, c Color, base, brightBase int, ext string) {
+	switch c & colorKind {
+	case colorIndexed:
+		n := int(c & 0xff)
+		b.WriteByte(';')
+		switch {
+		case n < 8:
+			b.WriteString(strconv.Itoa(base + n))
+		case n < 16:
+			b.WriteString(strconv.Itoa(brightBase + n - 8))
+		default:
+			b.WriteString(ext + ";5;" + strconv.Itoa(n))
+		}
+	case colorRGB:
+		b.WriteString(";" + ext + ";2;")
+		b.WriteString(strconv.Itoa(int(c>>16) & 0xff))
+		b.WriteByte(';')
+		b.WriteString(strconv.Itoa(int(c>>8) & 0xff))
+		b.WriteByte(';')
+		b.WriteString(strconv.Itoa(int(c) & 0xff))
+	}
+}
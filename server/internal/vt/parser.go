@@ -0,0 +1,288 @@
+package vt
+
+import "unicode/utf8"
+
+// Limits on sequence sizes; anything longer is truncated.
+const (
+	maxParams    = 16
+	maxSubParams = 6
+	maxOSC       = 4096
+)
+
+// Parser states.
+const (
+	stateGround = iota
+	stateEscape
+	stateEscapeIntermediate
+	stateCSI
+	stateCSIIgnore
+	stateOSC
+	stateOSCEscape
+	stateString // DCS, SOS, PM, APC: consumed and ignored
+	stateStringEscape
+)
+
+// Performer receives the actions decoded by a Parser.
+type Performer interface {
+	// Print is called for each printable character.
+	Print(r rune)
+	// Execute is called for C0 control bytes.
+	Execute(b byte)
+	// CSI is called for a complete control sequence. private is the leading '?', '>',
+	// '<' or '=' marker, or 0. Each param is a value followed by any colon
+	// sub-parameters; omitted values are 0. The slices are only valid during the call.
+	CSI(private byte, params [][]int, intermediates []byte, final byte)
+	// ESC is called for a complete escape sequence other than CSI, OSC and strings.
+	ESC(intermediates []byte, final byte)
+	// OSC is called with the payload of an operating system command.
+	OSC(data []byte)
+}
+
+// Parser is a VT500-style escape sequence state machine with UTF-8 decoding. State
+// is kept between Feed calls, so sequences split across chunks are handled.
+type Parser struct {
+	state int
+
+	private       byte
+	intermediates []byte
+	paramVals     [maxParams][maxSubParams]int
+	paramLens     [maxParams]int
+	nParams       int
+	params        [maxParams][]int
+
+	osc []byte
+
+	utf8Buf [utf8.UTFMax]byte
+	utf8Len int
+}
+
+// Ground reports whether the parser is between sequences and characters, i.e. the
+// stream can be cut at this point without splitting an escape sequence or a
+// multi-byte character.
+func (p *Parser) Ground() bool {
+	return p.state == stateGround && p.utf8Len == 0
+}
+
+// Feed runs data through the state machine, reporting actions to h.
+func (p *Parser) Feed(data []byte, h Performer) {
+	for _, b := range data {
+		p.step(b, h)
+	}
+}
+
+// Advance feeds data until the parser returns to the ground state or data runs
+// out, and returns the number of bytes consumed.
+func (p *Parser) Advance(data []byte, h Performer) int {
+	for i, b := range data {
+		p.step(b, h)
+		if p.state == stateGround && p.utf8Len == 0 {
+			return i + 1
+		}
+	}
+	return len(data)
+}
+
+func (p *Parser) step(b byte, h Performer) {
+	// CAN and SUB abort any sequence; ESC always starts a new one (except where it
+	// may begin a string terminator).
+	switch {
+	case b == 0x18 || b == 0x1a:
+		p.state = stateGround
+		p.utf8Len = 0
+		return
+	case b == 0x1b && p.state != stateOSC && p.state != stateString:
+		p.utf8Len = 0
+		p.state = stateEscape
+		p.intermediates = p.intermediates[:0]
+		return
+	}
+
+	switch p.state {
+	case stateGround:
+		p.ground(b, h)
+
+	case stateEscape:
+		switch {
+		case b < 0x20:
+			h.Execute(b)
+		case b == '[':
+			p.state = stateCSI
+			p.private = 0
+			p.nParams = 0
+			p.paramLens[0] = 0
+		case b == ']':
+			p.state = stateOSC
+			p.osc = p.osc[:0]
+		case b == 'P' || b == 'X' || b == '^' || b == '_':
+			p.state = stateString
+		case b >= 0x20 && b <= 0x2f:
+			p.intermediates = append(p.intermediates, b)
+			p.state = stateEscapeIntermediate
+		default:
+			p.state = stateGround
+			h.ESC(p.intermediates, b)
+		}
+
+	case stateEscapeIntermediate:
+		switch {
+		case b < 0x20:
+			h.Execute(b)
+		case b <= 0x2f:
+			p.intermediates = append(p.intermediates, b)
+		default:
+			p.state = stateGround
+			h.ESC(p.intermediates, b)
+		}
+
+	case stateCSI:
+		p.csi(b, h)
+
+	case stateCSIIgnore:
+		switch {
+		case b < 0x20:
+			h.Execute(b)
+		case b >= 0x40 && b <= 0x7e:
+			p.state = stateGround
+		}
+
+	case stateOSC:
+		switch b {
+		case 0x07:
+			p.state = stateGround
+			h.OSC(p.osc)
+		case 0x1b:
+			p.state = stateOSCEscape
+		default:
+			if len(p.osc) < maxOSC {
+				p.osc = append(p.osc, b)
+			}
+		}
+
+	case stateOSCEscape:
+		// ESC \ is the string terminator; any other ESC sequence also ends the OSC.
+		h.OSC(p.osc)
+		p.state = stateEscape
+		p.intermediates = p.intermediates[:0]
+		if b != '\\' {
+			p.step(b, h)
+		} else {
+			p.state = stateGround
+		}
+
+	case stateString:
+		switch b {
+		case 0x07:
+			p.state = stateGround
+		case 0x1b:
+			p.state = stateStringEscape
+		}
+
+	case stateStringEscape:
+		if b == '\\' {
+			p.state = stateGround
+		} else {
+			p.state = stateString
+		}
+	}
+}
+
+func (p *Parser) ground(b byte, h Performer) {
+	if p.utf8Len > 0 {
+		if b&0xc0 == 0x80 {
+			p.utf8Buf[p.utf8Len] = b
+			p.utf8Len++
+			if utf8.FullRune(p.utf8Buf[:p.utf8Len]) {
+				r, _ := utf8.DecodeRune(p.utf8Buf[:p.utf8Len])
+				p.utf8Len = 0
+				h.Print(r)
+			}
+			return
+		}
+		// Truncated sequence: emit a replacement and reprocess this byte.
+		p.utf8Len = 0
+		h.Print(utf8.RuneError)
+	}
+
+	switch {
+	case b < 0x20 || b == 0x7f:
+		if b != 0x7f {
+			h.Execute(b)
+		}
+	case b < 0x80:
+		h.Print(rune(b))
+	case b >= 0xc2 && b <= 0xf4:
+		p.utf8Buf[0] = b
+		p.utf8Len = 1
+	default:
+		h.Print(utf8.RuneError)
+	}
+}
+
+func (p *Parser) csi(b byte, h Performer) {
+	switch {
+	case b < 0x20:
+		h.Execute(b)
+
+	case b >= '0' && b <= '9':
+		if p.nParams == 0 {
+			p.nParams = 1
+		}
+		i := p.nParams - 1
+		if n := p.paramLens[i]; n == 0 {
+			p.paramLens[i] = 1
+			p.paramVals[i][0] = int(b - '0')
+		} else if v := &p.paramVals[i][n-1]; *v < 100000 {
+			*v = *v*10 + int(b-'0')
+		}
+
+	case b == ';':
+		if p.nParams == 0 {
+			p.nParams = 1
+			p.paramLens[0] = 0
+		}
+		if p.nParams < maxParams {
+			p.paramLens[p.nParams] = 0
+			p.nParams++
+		}
+
+	case b == ':':
+		if p.nParams == 0 {
+			p.nParams = 1
+			p.paramLens[0] = 0
+		}
+		i := p.nParams - 1
+		if p.paramLens[i] == 0 {
+			p.paramVals[i][0] = 0
+			p.paramLens[i] = 1
+		}
+		if p.paramLens[i] < maxSubParams {
+			p.paramVals[i][p.paramLens[i]] = 0
+			p.paramLens[i]++
+		}
+
+	case b >= '<' && b <= '?':
+		if p.nParams == 0 && p.private == 0 {
+			p.private = b
+		} else {
+			p.state = stateCSIIgnore
+		}
+
+	case b >= 0x20 && b <= 0x2f:
+		p.intermediates = append(p.intermediates, b)
+
+	case b >= 0x40 && b <= 0x7e:
+		p.state = stateGround
+		for i := 0; i < p.nParams; i++ {
+			n := p.paramLens[i]
+			if n == 0 {
+				p.paramVals[i][0] = 0
+				n = 1
+			}
+			p.params[i] = p.paramVals[i][:n]
+		}
+		h.CSI(p.private, p.params[:p.nParams], p.intermediates, b)
+
+	default:
+		p.state = stateCSIIgnore
+	}
+}
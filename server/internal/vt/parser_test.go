@@ -0,0 +1,134 @@
+package vt
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// recorder is a Performer that logs the actions it receives.
+type recorder struct {
+	events []string
+}
+
+func (r *recorder) Print(c rune)   { r.events = append(r.events, fmt.Sprintf("print %q", c)) }
+func (r *recorder) Execute(b byte) { r.events = append(r.events, fmt.Sprintf("exec %#x", b)) }
+func (r *recorder) OSC(data []byte) {
+	r.events = append(r.events, fmt.Sprintf("osc %q", data))
+}
+
+func (r *recorder) CSI(private byte, params [][]int, intermediates []byte, final byte) {
+	var p string
+	if private != 0 {
+		p = string(private)
+	}
+	r.events = append(r.events, fmt.Sprintf("csi %s%v %q %c", p, params, intermediates, final))
+}
+
+func (r *recorder) ESC(intermediates []byte, final byte) {
+	r.events = append(r.events, fmt.Sprintf("esc %q %c", intermediates, final))
+}
+
+func TestParser(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []string
+	}{
+		{
+			name:   "text and controls",
+			chunks: []string{"a\r\n"},
+			want:   []string{`print 'a'`, "exec 0xd", "exec 0xa"},
+		},
+		{
+			name:   "utf-8 split across chunks",
+			chunks: []string{"\xe2\x9c", "\x94"},
+			want:   []string{`print '✔'`},
+		},
+		{
+			name:   "truncated utf-8",
+			chunks: []string{"\xe2a"},
+			want:   []string{`print '�'`, `print 'a'`},
+		},
+		{
+			name:   "csi with params",
+			chunks: []string{"\x1b[1;31m"},
+			want:   []string{`csi [[1] [31]] "" m`},
+		},
+		{
+			name:   "csi with omitted and sub params",
+			chunks: []string{"\x1b[;38:2::1:2:3m"},
+			want:   []string{`csi [[0] [38 2 0 1 2 3]] "" m`}, // omitted values are 0
+		},
+		{
+			name:   "private csi split across chunks",
+			chunks: []string{"\x1b[?", "25", "l"},
+			want:   []string{`csi ?[[25]] "" l`},
+		},
+		{
+			name:   "osc ended by bel",
+			chunks: []string{"\x1b]0;title\x07"},
+			want:   []string{`osc "0;title"`},
+		},
+		{
+			name:   "osc ended by st",
+			chunks: []string{"\x1b]2;x\x1b\\b"},
+			want:   []string{`osc "2;x"`, `print 'b'`},
+		},
+		{
+			name:   "esc with intermediate",
+			chunks: []string{"\x1b(0"},
+			want:   []string{`esc "(" 0`},
+		},
+		{
+			name:   "can aborts a sequence",
+			chunks: []string{"\x1b[12\x18x"},
+			want:   []string{`print 'x'`},
+		},
+		{
+			name:   "dcs is ignored",
+			chunks: []string{"\x1bPq#0;2;0;0;0\x1b\\z"},
+			want:   []string{`print 'z'`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p Parser
+			var r recorder
+			for _, c := range tt.chunks {
+				p.Feed([]byte(c), &r)
+			}
+			if !reflect.DeepEqual(r.events, tt.want) {
+				t.Errorf("events:\n  %s\nwant:\n  %s", strings.Join(r.events, "\n  "), strings.Join(tt.want, "\n  "))
+			}
+			if !p.Ground() {
+				t.Errorf("parser not in ground state")
+			}
+		})
+	}
+}
+
+func TestParserAdvance(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		want   int
+		ground bool
+	}{
+		{"stops after the sequence", "\x1b[0mtext", 4, true},
+		{"stops after a character", "\xe2\x9c\x94rest", 3, true},
+		{"runs out mid-sequence", "\x1b[38;5", 6, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p Parser
+			var r recorder
+			p.Feed([]byte{tt.input[0]}, &r)
+			n := p.Advance([]byte(tt.input[1:]), &r) + 1
+			if n != tt.want || p.Ground() != tt.ground {
+				t.Errorf("Advance consumed %d (ground %v), want %d (ground %v)", n, p.Ground(), tt.want, tt.ground)
+			}
+		})
+	}
+}
@@ -0,0 +1,820 @@
+// Package vt implements a headless terminal emulator. It tracks the screen grid,
+// cursor and modes of a session so a late-joining viewer can be repainted from
+// the current state instead of replaying the raw output stream.
+package vt
+
+import (
+	"sync"
+	"unsafe"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// Default dimensions used until the first resize.
+const (
+	DefaultCols = 80
+	DefaultRows = 24
+
+	// DefaultHistoryLines is the number of lines scrolled off the top of the main
+	// screen that are kept for repaints.
+	DefaultHistoryLines = 1000
+
+	maxCombining = 16
+)
+
+// Cell is one character position on the screen.
+type Cell struct {
+	Ch   rune
+	Comb string // combining marks following Ch
+	Attr Attr
+	// Width is 1 for normal cells, 2 for the first half of a wide character and 0
+	// for the cell covered by its second half.
+	Width uint8
+}
+
+func blankCell(a Attr) Cell {
+	return Cell{Ch: ' ', Attr: Attr{BG: a.BG}, Width: 1}
+}
+
+func (c Cell) isBlank() bool {
+	return c.Width == 1 && c.Ch == ' ' && c.Comb == "" && c.Attr.IsDefault()
+}
+
+type cursor struct {
+	x, y     int
+	attr     Attr
+	wrap     bool // pending wrap: the last column was written
+	origin   bool
+	graphics bool // DEC special graphics charset selected into G0
+}
+
+// Screen is a terminal emulator instance. It is safe for concurrent use.
+type Screen struct {
+	mu     sync.Mutex
+	parser Parser
+	t      terminal
+}
+
+// New returns a screen of the given size that keeps up to historyLines lines of
+// scrollback.
+func New(cols, rows, historyLines int) *Screen {
+	if cols <= 0 {
+		cols = DefaultCols
+	}
+	if rows <= 0 {
+		rows = DefaultRows
+	}
+	s := &Screen{}
+	s.t.historyMax = historyLines
+	s.t.init(cols, rows)
+	return s
+}
+
+// Write feeds terminal output into the emulator. It never fails.
+func (s *Screen) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	s.parser.Feed(p, &s.t)
+	s.mu.Unlock()
+	return len(p), nil
+}
+
+// Resize changes the screen dimensions. Content is truncated or padded, not
+// reflowed; lines pushed off a shrinking main screen move into the history.
+func (s *Screen) Resize(cols, rows int) {
+	if cols <= 0 || rows <= 0 {
+		return
+	}
+	s.mu.Lock()
+	s.t.resize(cols, rows)
+	s.mu.Unlock()
+}
+
+// Reset clears the screen, history and modes.
+func (s *Screen) Reset() {
+	s.mu.Lock()
+	s.parser = Parser{}
+	s.t.init(s.t.cols, s.t.rows)
+	s.t.history = nil
+	s.mu.Unlock()
+}
+
+// Size returns the current dimensions.
+func (s *Screen) Size() (cols, rows int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.cols, s.t.rows
+}
+
+// MemSize estimates the memory held by the screen grids and history in bytes.
+func (s *Screen) MemSize() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines := 2*s.t.rows + len(s.t.history)
+	return lines * s.t.cols * int(unsafe.Sizeof(Cell{}))
+}
+
+// Title returns the window title last set by the application.
+func (s *Screen) Title() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.title
+}
+
+type terminal struct {
+	cols, rows int
+	main, alt  [][]Cell
+	lines      [][]Cell // main or alt
+	altActive  bool
+
+	history    [][]Cell
+	historyMax int
+
+	cur         cursor
+	saved       cursor
+	altSaved    cursor // cursor saved by mode 1049
+	top, bot    int    // scroll region, inclusive
+	tabs        []bool
+	lastChar    rune
+	title       string
+	cursorStyle int
+
+	autowrap       bool
+	insert         bool
+	cursorHidden   bool
+	appCursor      bool
+	appKeypad      bool
+	bracketedPaste bool
+	mouse          int // 0, 9, 1000, 1002 or 1003
+	mouseSGR       bool
+	focus          bool
+}
+
+func (t *terminal) init(cols, rows int) {
+	t.cols, t.rows = cols, rows
+	t.main = newGrid(cols, rows)
+	t.alt = newGrid(cols, rows)
+	t.lines = t.main
+	t.altActive = false
+	t.cur = cursor{}
+	t.saved = cursor{}
+	t.altSaved = cursor{}
+	t.top, t.bot = 0, rows-1
+	t.resetTabs()
+	t.lastChar = 0
+	t.title = ""
+	t.cursorStyle = 0
+	t.autowrap = true
+	t.insert = false
+	t.cursorHidden = false
+	t.appCursor = false
+	t.appKeypad = false
+	t.bracketedPaste = false
+	t.mouse = 0
+	t.mouseSGR = false
+	t.focus = false
+}
+
+func newGrid(cols, rows int) [][]Cell {
+	g := make([][]Cell, rows)
+	for i := range g {
+		g[i] = newLine(cols, Attr{})
+	}
+	return g
+}
+
+func newLine(cols int, a Attr) []Cell {
+	l := make([]Cell, cols)
+	b := blankCell(a)
+	for i := range l {
+		l[i] = b
+	}
+	return l
+}
+
+func (t *terminal) resetTabs() {
+	t.tabs = make([]bool, t.cols)
+	for i := 8; i < t.cols; i += 8 {
+		t.tabs[i] = true
+	}
+}
+
+func (t *terminal) resize(cols, rows int) {
+	// Keep the cursor on screen by moving lines above it into the history.
+	if rows < t.rows && t.cur.y >= rows {
+		n := t.cur.y - rows + 1
+		for i := 0; i < n; i++ {
+			t.pushHistory(t.main[i])
+		}
+		t.main = t.main[n:]
+		if t.altActive {
+			t.alt = t.alt[n:]
+		}
+		t.cur.y -= n
+		t.saved.y = max(t.saved.y-n, 0)
+	}
+	t.main = resizeGrid(t.main, cols, rows)
+	t.alt = resizeGrid(t.alt, cols, rows)
+	if t.altActive {
+		t.lines = t.alt
+	} else {
+		t.lines = t.main
+	}
+	for i, l := range t.history {
+		t.history[i] = resizeLine(l, cols)
+	}
+	t.cols, t.rows = cols, rows
+	t.top, t.bot = 0, rows-1
+	t.cur.x = min(t.cur.x, cols-1)
+	t.cur.y = min(t.cur.y, rows-1)
+	t.cur.wrap = false
+	t.resetTabs()
+}
+
+func resizeGrid(g [][]Cell, cols, rows int) [][]Cell {
+	if len(g) > rows {
+		g = g[:rows]
+	}
+	for i := range g {
+		g[i] = resizeLine(g[i], cols)
+	}
+	for len(g) < rows {
+		g = append(g, newLine(cols, Attr{}))
+	}
+	return g
+}
+
+func resizeLine(l []Cell, cols int) []Cell {
+	if len(l) == cols {
+		return l
+	}
+	if len(l) > cols {
+		l = l[:cols:cols]
+		// Drop a wide character whose second half was cut off.
+		if cols > 0 && l[cols-1].Width == 2 {
+			l[cols-1] = blankCell(Attr{})
+		}
+		return l
+	}
+	n := make([]Cell, cols)
+	copy(n, l)
+	for i := len(l); i < cols; i++ {
+		n[i] = blankCell(Attr{})
+	}
+	return n
+}
+
+func (t *terminal) pushHistory(l []Cell) {
+	if t.historyMax <= 0 {
+		return
+	}
+	t.history = append(t.history, l)
+	if over := len(t.history) - t.historyMax; over > 0 {
+		// Drop in batches so trimming stays amortized O(1).
+		if over >= t.historyMax/4+1 {
+			t.history = append(t.history[:0:0], t.history[over:]...)
+		}
+	}
+}
+
+// visibleHistory returns the retained history, honouring historyMax.
+func (t *terminal) visibleHistory() [][]Cell {
+	if over := len(t.history) - t.historyMax; over > 0 {
+		return t.history[over:]
+	}
+	return t.history
+}
+
+// --- Performer ---
+
+func (t *terminal) Print(r rune) {
+	if t.cur.graphics && r >= 0x5f && r <= 0x7e {
+		r = decGraphics[r-0x5f]
+	}
+	w := runewidth.RuneWidth(r)
+	if w == 0 {
+		t.combine(r)
+		return
+	}
+	if w > 2 {
+		w = 2
+	}
+	if t.cur.wrap && t.autowrap {
+		t.cur.x = 0
+		t.index()
+	}
+	t.cur.wrap = false
+	if w == 2 && t.cur.x == t.cols-1 {
+		if t.cols < 2 {
+			return
+		}
+		if t.autowrap {
+			t.lines[t.cur.y][t.cur.x] = blankCell(t.cur.attr)
+			t.cur.x = 0
+			t.index()
+		} else {
+			t.cur.x--
+		}
+	}
+	line := t.lines[t.cur.y]
+	if t.insert {
+		copy(line[t.cur.x+w:], line[t.cur.x:])
+		t.fixWide(line, t.cols-1)
+	}
+	t.clearWide(line, t.cur.x)
+	line[t.cur.x] = Cell{Ch: r, Attr: t.cur.attr, Width: uint8(w)}
+	if w == 2 {
+		t.clearWide(line, t.cur.x+1)
+		line[t.cur.x+1] = Cell{Attr: t.cur.attr}
+	}
+	t.lastChar = r
+	if t.cur.x+w >= t.cols {
+		t.cur.x = t.cols - 1
+		t.cur.wrap = true
+	} else {
+		t.cur.x += w
+	}
+}
+
+func (t *terminal) combine(r rune) {
+	x, y := t.cur.x, t.cur.y
+	if !t.cur.wrap {
+		x--
+	}
+	if x < 0 {
+		return
+	}
+	line := t.lines[y]
+	if line[x].Width == 0 && x > 0 {
+		x--
+	}
+	if len(line[x].Comb) < maxCombining {
+		line[x].Comb += string(r)
+	}
+}
+
+// clearWide blanks the other half of a wide character overlapping column x.
+func (t *terminal) clearWide(line []Cell, x int) {
+	switch line[x].Width {
+	case 0:
+		if x > 0 {
+			line[x-1] = blankCell(line[x-1].Attr)
+		}
+	case 2:
+		if x+1 < len(line) {
+			line[x+1] = blankCell(line[x+1].Attr)
+		}
+	}
+}
+
+// fixWide repairs a wide character split at the right edge at column x.
+func (t *terminal) fixWide(line []Cell, x int) {
+	if line[x].Width == 2 {
+		line[x] = blankCell(line[x].Attr)
+	}
+}
+
+func (t *terminal) Execute(b byte) {
+	switch b {
+	case '\b':
+		if t.cur.x > 0 {
+			t.cur.x--
+		}
+		t.cur.wrap = false
+	case '\t':
+		t.tab(1)
+	case '\n', '\v', '\f':
+		t.index()
+	case '\r':
+		t.cur.x = 0
+		t.cur.wrap = false
+	case 0x0e, 0x0f:
+		// SO/SI: only G0 is tracked.
+	}
+}
+
+func (t *terminal) tab(n int) {
+	for ; n > 0 && t.cur.x < t.cols-1; n-- {
+		t.cur.x++
+		for t.cur.x < t.cols-1 && !t.tabs[t.cur.x] {
+			t.cur.x++
+		}
+	}
+	t.cur.wrap = false
+}
+
+func (t *terminal) backTab(n int) {
+	for ; n > 0 && t.cur.x > 0; n-- {
+		t.cur.x--
+		for t.cur.x > 0 && !t.tabs[t.cur.x] {
+			t.cur.x--
+		}
+	}
+	t.cur.wrap = false
+}
+
+func (t *terminal) index() {
+	t.cur.wrap = false
+	if t.cur.y == t.bot {
+		t.scrollUp(1)
+	} else if t.cur.y < t.rows-1 {
+		t.cur.y++
+	}
+}
+
+func (t *terminal) reverseIndex() {
+	t.cur.wrap = false
+	if t.cur.y == t.top {
+		t.scrollDown(1)
+	} else if t.cur.y > 0 {
+		t.cur.y--
+	}
+}
+
+// scrollUp scrolls the scroll region up n lines. Lines leaving the top of a
+// full-width main screen region are added to the history.
+func (t *terminal) scrollUp(n int) {
+	t.scrollUpFrom(t.top, n, !t.altActive && t.top == 0)
+}
+
+func (t *terminal) scrollUpFrom(top, n int, save bool) {
+	height := t.bot - top + 1
+	n = min(n, height)
+	region := t.lines[top : t.bot+1]
+	if save {
+		for _, l := range region[:n] {
+			t.pushHistory(l)
+		}
+	}
+	copy(region, region[n:])
+	for i := height - n; i < height; i++ {
+		region[i] = newLine(t.cols, t.cur.attr)
+	}
+}
+
+func (t *terminal) scrollDown(n int) {
+	height := t.bot - t.top + 1
+	n = min(n, height)
+	region := t.lines[t.top : t.bot+1]
+	copy(region[n:], region[:height-n])
+	for i := 0; i < n; i++ {
+		region[i] = newLine(t.cols, t.cur.attr)
+	}
+}
+
+func (t *terminal) moveTo(x, y int) {
+	minY, maxY := 0, t.rows-1
+	if t.cur.origin {
+		y += t.top
+		minY, maxY = t.top, t.bot
+	}
+	t.cur.x = clamp(x, 0, t.cols-1)
+	t.cur.y = clamp(y, minY, maxY)
+	t.cur.wrap = false
+}
+
+// moveRel moves vertically without leaving the scroll region when starting
+// inside it.
+func (t *terminal) moveRel(dx, dy int) {
+	minY, maxY := 0, t.rows-1
+	if t.cur.y >= t.top && t.cur.y <= t.bot {
+		minY, maxY = t.top, t.bot
+	}
+	t.cur.x = clamp(t.cur.x+dx, 0, t.cols-1)
+	t.cur.y = clamp(t.cur.y+dy, minY, maxY)
+	t.cur.wrap = false
+}
+
+func (t *terminal) eraseCells(y, from, to int) {
+	line := t.lines[y]
+	from = clamp(from, 0, t.cols)
+	to = clamp(to, 0, t.cols)
+	if from < to {
+		if from > 0 && line[from].Width == 0 {
+			from--
+		}
+		if to < t.cols && line[to].Width == 0 {
+			to++
+		}
+	}
+	b := blankCell(t.cur.attr)
+	for i := from; i < to; i++ {
+		line[i] = b
+	}
+}
+
+func (t *terminal) CSI(private byte, params [][]int, inter []byte, final byte) {
+	p := func(i, def int) int {
+		if i < len(params) && params[i][0] != 0 {
+			return params[i][0]
+		}
+		return def
+	}
+
+	if len(inter) > 0 {
+		switch {
+		case inter[0] == ' ' && final == 'q':
+			t.cursorStyle = p(0, 0)
+		case inter[0] == '!' && final == 'p':
+			t.softReset()
+		}
+		return
+	}
+
+	if private == '?' {
+		switch final {
+		case 'h', 'l':
+			for _, v := range params {
+				t.setPrivateMode(v[0], final == 'h')
+			}
+		case 'J':
+			t.CSI(0, params, nil, 'J')
+		case 'K':
+			t.CSI(0, params, nil, 'K')
+		}
+		return
+	}
+	if private != 0 {
+		return
+	}
+
+	switch final {
+	case '@':
+		n := min(p(0, 1), t.cols-t.cur.x)
+		line := t.lines[t.cur.y]
+		t.clearWide(line, t.cur.x)
+		copy(line[t.cur.x+n:], line[t.cur.x:])
+		t.fixWide(line, t.cols-1)
+		for i := 0; i < n; i++ {
+			line[t.cur.x+i] = blankCell(t.cur.attr)
+		}
+		t.cur.wrap = false
+	case 'A':
+		t.moveRel(0, -p(0, 1))
+	case 'B', 'e':
+		t.moveRel(0, p(0, 1))
+	case 'C', 'a':
+		t.moveRel(p(0, 1), 0)
+	case 'D':
+		t.moveRel(-p(0, 1), 0)
+	case 'E':
+		t.moveRel(0, p(0, 1))
+		t.cur.x = 0
+	case 'F':
+		t.moveRel(0, -p(0, 1))
+		t.cur.x = 0
+	case 'G', '`':
+		t.cur.x = clamp(p(0, 1)-1, 0, t.cols-1)
+		t.cur.wrap = false
+	case 'H', 'f':
+		t.moveTo(p(1, 1)-1, p(0, 1)-1)
+	case 'I':
+		t.tab(p(0, 1))
+	case 'Z':
+		t.backTab(p(0, 1))
+	case 'd':
+		t.moveTo(t.cur.x, p(0, 1)-1)
+	case 'J':
+		switch p(0, 0) {
+		case 0:
+			t.eraseCells(t.cur.y, t.cur.x, t.cols)
+			for y := t.cur.y + 1; y < t.rows; y++ {
+				t.eraseCells(y, 0, t.cols)
+			}
+		case 1:
+			for y := 0; y < t.cur.y; y++ {
+				t.eraseCells(y, 0, t.cols)
+			}
+			t.eraseCells(t.cur.y, 0, t.cur.x+1)
+		case 2:
+			for y := 0; y < t.rows; y++ {
+				t.eraseCells(y, 0, t.cols)
+			}
+		case 3:
+			t.history = nil
+		}
+		t.cur.wrap = false
+	case 'K':
+		switch p(0, 0) {
+		case 0:
+			t.eraseCells(t.cur.y, t.cur.x, t.cols)
+		case 1:
+			t.eraseCells(t.cur.y, 0, t.cur.x+1)
+		case 2:
+			t.eraseCells(t.cur.y, 0, t.cols)
+		}
+		t.cur.wrap = false
+	case 'L', 'M':
+		if t.cur.y < t.top || t.cur.y > t.bot {
+			return
+		}
+		if final == 'L' {
+			top := t.top
+			t.top = t.cur.y
+			t.scrollDown(p(0, 1))
+			t.top = top
+		} else {
+			// Deleted lines never enter the history.
+			t.scrollUpFrom(t.cur.y, p(0, 1), false)
+		}
+		t.cur.x = 0
+		t.cur.wrap = false
+	case 'P':
+		n := min(p(0, 1), t.cols-t.cur.x)
+		line := t.lines[t.cur.y]
+		t.clearWide(line, t.cur.x)
+		if t.cur.x+n < t.cols {
+			t.clearWide(line, t.cur.x+n)
+		}
+		copy(line[t.cur.x:], line[t.cur.x+n:])
+		for i := t.cols - n; i < t.cols; i++ {
+			line[i] = blankCell(t.cur.attr)
+		}
+		t.cur.wrap = false
+	case 'X':
+		t.eraseCells(t.cur.y, t.cur.x, t.cur.x+p(0, 1))
+		t.cur.wrap = false
+	case 'S':
+		t.scrollUp(p(0, 1))
+	case 'T':
+		if len(params) <= 1 {
+			t.scrollDown(p(0, 1))
+		}
+	case 'b':
+		if t.lastChar != 0 {
+			for n := min(p(0, 1), t.cols*t.rows); n > 0; n-- {
+				t.Print(t.lastChar)
+			}
+		}
+	case 'g':
+		switch p(0, 0) {
+		case 0:
+			t.tabs[t.cur.x] = false
+		case 3:
+			for i := range t.tabs {
+				t.tabs[i] = false
+			}
+		}
+	case 'h', 'l':
+		for _, v := range params {
+			if v[0] == 4 {
+				t.insert = final == 'h'
+			}
+		}
+	case 'm':
+		if len(params) == 0 {
+			t.cur.attr = Attr{}
+		} else {
+			t.cur.attr.ApplySGR(params)
+		}
+	case 'r':
+		top, bot := p(0, 1)-1, p(1, t.rows)-1
+		bot = min(bot, t.rows-1)
+		if top < bot {
+			t.top, t.bot = top, bot
+			t.moveTo(0, 0)
+		}
+	case 's':
+		t.saved = t.cur
+	case 'u':
+		t.restoreCursor(t.saved)
+	}
+}
+
+func (t *terminal) restoreCursor(c cursor) {
+	t.cur = c
+	t.cur.x = clamp(t.cur.x, 0, t.cols-1)
+	t.cur.y = clamp(t.cur.y, 0, t.rows-1)
+}
+
+func (t *terminal) setPrivateMode(mode int, on bool) {
+	switch mode {
+	case 1:
+		t.appCursor = on
+	case 6:
+		t.cur.origin = on
+		t.moveTo(0, 0)
+	case 7:
+		t.autowrap = on
+	case 25:
+		t.cursorHidden = !on
+	case 9, 1000, 1002, 1003:
+		if on {
+			t.mouse = mode
+		} else if t.mouse == mode {
+			t.mouse = 0
+		}
+	case 1004:
+		t.focus = on
+	case 1006:
+		t.mouseSGR = on
+	case 2004:
+		t.bracketedPaste = on
+	case 1048:
+		if on {
+			t.altSaved = t.cur
+		} else {
+			t.restoreCursor(t.altSaved)
+		}
+	case 47, 1047, 1049:
+		if on == t.altActive {
+			return
+		}
+		if mode == 1049 && on {
+			t.altSaved = t.cur
+		}
+		if on {
+			t.alt = newGrid(t.cols, t.rows)
+			t.lines = t.alt
+		} else {
+			t.lines = t.main
+		}
+		t.altActive = on
+		if mode == 1049 && !on {
+			t.restoreCursor(t.altSaved)
+		}
+	}
+}
+
+func (t *terminal) softReset() {
+	t.cur.attr = Attr{}
+	t.cur.origin = false
+	t.cur.graphics = false
+	t.cur.wrap = false
+	t.top, t.bot = 0, t.rows-1
+	t.autowrap = true
+	t.insert = false
+	t.cursorHidden = false
+	t.appCursor = false
+	t.appKeypad = false
+	t.saved = cursor{}
+}
+
+func (t *terminal) ESC(inter []byte, final byte) {
+	if len(inter) > 0 {
+		switch {
+		case inter[0] == '(':
+			t.cur.graphics = final == '0'
+		case inter[0] == '#' && final == '8':
+			e := Cell{Ch: 'E', Width: 1}
+			for _, l := range t.lines {
+				for i := range l {
+					l[i] = e
+				}
+			}
+		}
+		return
+	}
+	switch final {
+	case '7':
+		t.saved = t.cur
+	case '8':
+		t.restoreCursor(t.saved)
+	case 'D':
+		t.index()
+	case 'E':
+		t.index()
+		t.cur.x = 0
+	case 'M':
+		t.reverseIndex()
+	case 'H':
+		t.tabs[t.cur.x] = true
+	case 'c':
+		history := t.history
+		t.init(t.cols, t.rows)
+		t.history = history
+	case '=':
+		t.appKeypad = true
+	case '>':
+		t.appKeypad = false
+	}
+}
+
+func (t *terminal) OSC(data []byte) {
+	i := 0
+	for i < len(data) && data[i] != ';' {
+		i++
+	}
+	if i == len(data) {
+		return
+	}
+	switch string(data[:i]) {
+	case "0", "2":
+		t.title = string(data[i+1:])
+	}
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// decGraphics maps 0x5f-0x7e to the DEC special graphics (line drawing) set.
+var decGraphics = [...]rune{
+	' ', '◆', '▒', '␉', '␌', '␍', '␊', '°', '±', '␤', '␋', '┘', '┐', '┌', '└', '┼',
+	'⎺', '⎻', '─', '⎼', '⎽', '├', '┤', '┴', '┬', '│', '≤', '≥', 'π', '≠', '£', '·',
+}
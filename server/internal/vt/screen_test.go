@@ -0,0 +1,183 @@
+package vt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// text returns the lines as strings with trailing blanks removed.
+func text(lines [][]Cell) []string {
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		var b strings.Builder
+		for _, c := range l {
+			if c.Width == 0 {
+				continue
+			}
+			b.WriteRune(c.Ch)
+			b.WriteString(c.Comb)
+		}
+		out[i] = strings.TrimRight(b.String(), " ")
+	}
+	return out
+}
+
+func TestScreen(t *testing.T) {
+	tests := []struct {
+		name       string
+		cols, rows int
+		input      string
+		lines      []string
+		history    []string
+		x, y       int
+	}{
+		{
+			name:  "text and newlines",
+			cols:  10,
+			rows:  3,
+			input: "ab\r\ncd",
+			lines: []string{"ab", "cd", ""},
+			x:     2,
+			y:     1,
+		},
+		{
+			name:  "autowrap",
+			cols:  4,
+			rows:  3,
+			input: "abcdef",
+			lines: []string{"abcd", "ef", ""},
+			x:     2,
+			y:     1,
+		},
+		{
+			name:    "scrolling into history",
+			cols:    5,
+			rows:    2,
+			input:   "1\r\n2\r\n3\r\n4",
+			lines:   []string{"3", "4"},
+			history: []string{"1", "2"},
+			x:       1,
+			y:       1,
+		},
+		{
+			name:  "cursor movement and erase",
+			cols:  10,
+			rows:  3,
+			input: "hello\x1b[1;3H\x1b[K\x1b[3;2HX",
+			lines: []string{"he", "", " X"},
+			x:     2,
+			y:     2,
+		},
+		{
+			name:  "wide characters",
+			cols:  6,
+			rows:  2,
+			input: "a世b",
+			lines: []string{"a世b", ""},
+			x:     4,
+			y:     0,
+		},
+		{
+			name:  "combining marks",
+			cols:  6,
+			rows:  2,
+			input: "éx",
+			lines: []string{"éx", ""},
+			x:     2,
+			y:     0,
+		},
+		{
+			name:  "scroll region",
+			cols:  5,
+			rows:  4,
+			input: "top\x1b[2;3r\x1b[2;1Ha\r\nb\r\nc\x1b[r",
+			lines: []string{"top", "b", "c", ""},
+			x:     0,
+			y:     0,
+		},
+		{
+			name:  "alternate screen keeps the main screen",
+			cols:  5,
+			rows:  2,
+			input: "main\x1b[?1049hfull\x1b[?1049l",
+			lines: []string{"main", ""},
+			x:     4,
+			y:     0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(tt.cols, tt.rows, 100)
+			s.Write([]byte(tt.input))
+
+			if got := text(s.t.lines); !reflect.DeepEqual(got, tt.lines) {
+				t.Errorf("lines = %q, want %q", got, tt.lines)
+			}
+			if got := text(s.t.history); len(got)+len(tt.history) > 0 && !reflect.DeepEqual(got, tt.history) {
+				t.Errorf("history = %q, want %q", got, tt.history)
+			}
+			if s.t.cur.x != tt.x || s.t.cur.y != tt.y {
+				t.Errorf("cursor = %d,%d, want %d,%d", s.t.cur.x, s.t.cur.y, tt.x, tt.y)
+			}
+		})
+	}
+}
+
+func TestScreenSnapshot(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"plain text", "hello\r\nworld"},
+		{"attributes", "\x1b[1;31mred\x1b[0m plain \x1b[44mblue bg"},
+		{"history", strings.Repeat("line\r\n", 30) + "last"},
+		{"alternate screen", "shell$ \x1b[?1049h\x1b[2J\x1b[Hfull screen\x1b[5;3Hx"},
+		{"modes and title", "\x1b]2;my title\x07\x1b[?25l\x1b[?2004h\x1b[?1h\x1b[3;8r\x1b[5 q"},
+		{"origin mode", "\x1b[2;6r\x1b[?6h\x1b[2;3Hin region"},
+		{"pending attributes", "text\x1b[4;32m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(20, 8, 100)
+			s.Write([]byte(tt.input))
+
+			r := New(20, 8, 100)
+			r.Write(s.Snapshot(100))
+
+			if got, want := text(r.t.history), text(s.t.history); !reflect.DeepEqual(got, want) {
+				t.Errorf("history = %q, want %q", got, want)
+			}
+			if !reflect.DeepEqual(r.t.main, s.t.main) {
+				t.Errorf("main screen = %q, want %q", text(r.t.main), text(s.t.main))
+			}
+			if r.t.altActive != s.t.altActive || (s.t.altActive && !reflect.DeepEqual(r.t.alt, s.t.alt)) {
+				t.Errorf("alternate screen = %q, want %q", text(r.t.alt), text(s.t.alt))
+			}
+			if r.t.cur != s.t.cur {
+				t.Errorf("cursor = %+v, want %+v", r.t.cur, s.t.cur)
+			}
+			if r.t.title != s.t.title || r.t.top != s.t.top || r.t.bot != s.t.bot ||
+				r.t.cursorHidden != s.t.cursorHidden || r.t.bracketedPaste != s.t.bracketedPaste ||
+				r.t.appCursor != s.t.appCursor || r.t.cursorStyle != s.t.cursorStyle {
+				t.Errorf("modes differ after replaying the snapshot")
+			}
+		})
+	}
+}
+
+func TestScreenResize(t *testing.T) {
+	s := New(10, 4, 100)
+	s.Write([]byte("1\r\n2\r\n3\r\n4"))
+	s.Resize(5, 2)
+
+	if cols, rows := s.Size(); cols != 5 || rows != 2 {
+		t.Fatalf("Size() = %d,%d, want 5,2", cols, rows)
+	}
+	if got, want := text(s.t.lines), []string{"3", "4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("lines = %q, want %q", got, want)
+	}
+	if got, want := text(s.t.history), []string{"1", "2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("history = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,126 @@
+package vt
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Snapshot renders the current state as a byte stream that, written to a freshly
+// reset terminal of the same size, reproduces the screen: up to historyLines
+// lines of history, the main screen, the alternate screen if active, scroll
+// region, modes, title, cursor position and current attributes.
+func (s *Screen) Snapshot(historyLines int) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t.snapshot(historyLines)
+}
+
+func (t *terminal) snapshot(historyLines int) []byte {
+	var b strings.Builder
+	b.WriteString("\x1bc")
+
+	history := t.visibleHistory()
+	if historyLines < len(history) {
+		history = history[len(history)-max(historyLines, 0):]
+	}
+	for _, l := range history {
+		writeLine(&b, l)
+		b.WriteString("\r\n")
+	}
+	for i, l := range t.main {
+		writeLine(&b, l)
+		if i < len(t.main)-1 {
+			b.WriteString("\r\n")
+		}
+	}
+
+	if t.altActive {
+		// Mode 1049 saves the main screen cursor before switching.
+		writeCUP(&b, t.altSaved.x, t.altSaved.y)
+		b.WriteString("\x1b[?1049h")
+		for y, l := range t.alt {
+			writeCUP(&b, 0, y)
+			writeLine(&b, l)
+		}
+	}
+
+	if t.top != 0 || t.bot != t.rows-1 {
+		b.WriteString("\x1b[" + strconv.Itoa(t.top+1) + ";" + strconv.Itoa(t.bot+1) + "r")
+	}
+	if !t.autowrap {
+		b.WriteString("\x1b[?7l")
+	}
+	if t.insert {
+		b.WriteString("\x1b[4h")
+	}
+	if t.cursorHidden {
+		b.WriteString("\x1b[?25l")
+	}
+	if t.appCursor {
+		b.WriteString("\x1b[?1h")
+	}
+	if t.appKeypad {
+		b.WriteString("\x1b=")
+	}
+	if t.bracketedPaste {
+		b.WriteString("\x1b[?2004h")
+	}
+	if t.mouse != 0 {
+		b.WriteString("\x1b[?" + strconv.Itoa(t.mouse) + "h")
+	}
+	if t.mouseSGR {
+		b.WriteString("\x1b[?1006h")
+	}
+	if t.focus {
+		b.WriteString("\x1b[?1004h")
+	}
+	if t.cursorStyle != 0 {
+		b.WriteString("\x1b[" + strconv.Itoa(t.cursorStyle) + " q")
+	}
+	if t.title != "" {
+		b.WriteString("\x1b]2;" + t.title + "\x07")
+	}
+	if t.cur.graphics {
+		b.WriteString("\x1b(0")
+	}
+
+	writeCUP(&b, t.cur.x, t.cur.y)
+	if t.cur.origin {
+		// Origin mode homes the cursor, so restore the position relative to the
+		// scroll region afterwards.
+		b.WriteString("\x1b[?6h")
+		writeCUP(&b, t.cur.x, t.cur.y-t.top)
+	}
+	if !t.cur.attr.IsDefault() {
+		b.WriteString(t.cur.attr.SGR())
+	}
+	return []byte(b.String())
+}
+
+// writeLine renders one line, omitting trailing blank cells. Attributes are reset
+// at the end so later line feeds do not paint with the line's background.
+func writeLine(b *strings.Builder, l []Cell) {
+	end := len(l)
+	for end > 0 && l[end-1].isBlank() {
+		end--
+	}
+	var cur Attr
+	for _, c := range l[:end] {
+		if c.Width == 0 {
+			continue
+		}
+		if c.Attr != cur {
+			b.WriteString(c.Attr.SGR())
+			cur = c.Attr
+		}
+		b.WriteRune(c.Ch)
+		b.WriteString(c.Comb)
+	}
+	if !cur.IsDefault() {
+		b.WriteString("\x1b[m")
+	}
+}
+
+func writeCUP(b *strings.Builder, x, y int) {
+	b.WriteString("\x1b[" + strconv.Itoa(y+1) + ";" + strconv.Itoa(x+1) + "H")
+}
@@ -0,0 +1,133 @@
+package worker
+
+import (
+	"bytes"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/moltty/server/internal/ansi"
+	"github.com/moltty/server/internal/session"
+)
+
+// DefaultIdleAfter is how long output must be quiet before a busy session is re-evaluated.
+const DefaultIdleAfter = 2 * time.Second
+
+// activityTailSize is how much recent ANSI-stripped output is kept for prompt matching.
+const activityTailSize = 4096
+
+// DefaultPromptPatterns match the prompts Claude shows when it is waiting on the user.
+var DefaultPromptPatterns = []string{
+	`Do you want to (proceed|make this edit|create|run)`,
+	`❯\s*1\.\s*Yes`,
+	`Esc to cancel`,
+	`\? for shortcuts`,
+	`\((y/n|Y/n|y/N)\)`,
+	`\[(y/n|Y/n|y/N)\]`,
+}
+
+// ActivityDetector derives a session's activity state from its output stream.
+// Output marks the session busy; once output has been quiet for idleAfter the
+// session is awaiting-input if the recent output ends on a known prompt, or idle otherwise.
+type ActivityDetector struct {
+	mu         sync.Mutex
+	idleAfter  time.Duration
+	patterns   []*regexp.Regexp
+	stripper   ansi.Stripper
+	tail       []byte
+	lastOutput time.Time
+	busySince  time.Time
+	state      session.ActivityState
+}
+
+func NewActivityDetector(idleAfter time.Duration, patterns []*regexp.Regexp) *ActivityDetector {
+	return &ActivityDetector{
+		idleAfter: idleAfter,
+		patterns:  patterns,
+		tail:      make([]byte, 0, activityTailSize*2),
+	}
+}
+
+// CompilePromptPatterns compiles prompt regexes, falling back to DefaultPromptPatterns
+// when none are given. Each pattern is anchored so that it only matches on the last
+// line of output.
+func CompilePromptPatterns(exprs []string) ([]*regexp.Regexp, error) {
+	if len(exprs) == 0 {
+		exprs = DefaultPromptPatterns
+	}
+	patterns := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		re, err := regexp.Compile(`(?:` + expr + `)[^\n]*$`)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// Observe records an output chunk. It returns the new state and true if the state changed.
+func (d *ActivityDetector) Observe(data []byte, now time.Time) (session.ActivityState, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.tail = d.stripper.Strip(d.tail, data)
+	if len(d.tail) > activityTailSize {
+		d.tail = append(d.tail[:0], d.tail[len(d.tail)-activityTailSize:]...)
+	}
+	d.lastOutput = now
+
+	if d.state != session.ActivityBusy {
+		d.busySince = now
+	}
+	return d.setState(session.ActivityBusy)
+}
+
+// Tick re-evaluates the state after a period without output. It returns the new state
+// and true if the state changed.
+func (d *ActivityDetector) Tick(now time.Time) (session.ActivityState, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.state != session.ActivityBusy || now.Sub(d.lastOutput) < d.idleAfter {
+		return d.state, false
+	}
+
+	tail := bytes.TrimRight(d.tail, " \t\r\n")
+	for _, re := range d.patterns {
+		if re.Match(tail) {
+			return d.setState(session.ActivityAwaitingInput)
+		}
+	}
+	return d.setState(session.ActivityIdle)
+}
+
+// Input forgets the output seen so far, so that a prompt the user has just answered
+// isn't matched again.
+func (d *ActivityDetector) Input() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.tail = d.tail[:0]
+}
+
+// State returns the current activity state.
+func (d *ActivityDetector) State() session.ActivityState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state
+}
+
+// BusyFor returns how long the most recent busy period lasted, up to its last output.
+func (d *ActivityDetector) BusyFor() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lastOutput.Sub(d.busySince)
+}
+
+func (d *ActivityDetector) setState(state session.ActivityState) (session.ActivityState, bool) {
+	if d.state == state {
+		return state, false
+	}
+	d.state = state
+	return state, true
+}
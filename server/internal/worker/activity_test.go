@@ -0,0 +1,105 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/moltty/server/internal/session"
+)
+
+func TestActivityDetectorTick(t *testing.T) {
+	patterns, err := CompilePromptPatterns(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const idleAfter = 2 * time.Second
+
+	tests := []struct {
+		name        string
+		output      []string
+		input       bool // send input after the output
+		quiet       time.Duration
+		want        session.ActivityState
+		wantChanged bool
+	}{
+		{
+			name:   "still busy",
+			output: []string{"compiling..."},
+			quiet:  time.Second,
+			want:   session.ActivityBusy,
+		},
+		{
+			name:        "idle",
+			output:      []string{"done\r\n$ "},
+			quiet:       idleAfter,
+			want:        session.ActivityIdle,
+			wantChanged: true,
+		},
+		{
+			name:        "prompt at the end",
+			output:      []string{"Edit file?\r\n", "Do you want to proceed?\r\n\x1b[1m❯ 1. Yes\x1b[0m\r\n  2. No\r\n\r\n Esc to cancel \r\n"},
+			quiet:       idleAfter,
+			want:        session.ActivityAwaitingInput,
+			wantChanged: true,
+		},
+		{
+			name:        "prompt split by escapes",
+			output:      []string{"Overwrite? \x1b[1m(", "y/n\x1b[0m)  "},
+			quiet:       idleAfter,
+			want:        session.ActivityAwaitingInput,
+			wantChanged: true,
+		},
+		{
+			name:        "prompt followed by more output",
+			output:      []string{"? for shortcuts\r\n", "Reading files\r\nWrote 3 files\r\n"},
+			quiet:       idleAfter,
+			want:        session.ActivityIdle,
+			wantChanged: true,
+		},
+		{
+			name:        "prompt answered",
+			output:      []string{"Continue? [y/N] "},
+			input:       true,
+			quiet:       idleAfter,
+			want:        session.ActivityIdle,
+			wantChanged: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewActivityDetector(idleAfter, patterns)
+			start := time.Now()
+			for _, out := range tt.output {
+				if state, _ := d.Observe([]byte(out), start); state != session.ActivityBusy {
+					t.Fatalf("Observe() = %s, want busy", state)
+				}
+			}
+			if tt.input {
+				d.Input()
+			}
+
+			state, changed := d.Tick(start.Add(tt.quiet))
+			if state != tt.want || changed != tt.wantChanged {
+				t.Errorf("Tick() = %s, %v, want %s, %v", state, changed, tt.want, tt.wantChanged)
+			}
+			if state, changed := d.Tick(start.Add(tt.quiet)); state != tt.want || changed {
+				t.Errorf("second Tick() = %s, %v, want %s, false", state, changed, tt.want)
+			}
+		})
+	}
+}
+
+func TestActivityDetectorBusyFor(t *testing.T) {
+	d := NewActivityDetector(time.Second, nil)
+	start := time.Now()
+	d.Observe([]byte("a"), start)
+	d.Observe([]byte("b"), start.Add(3*time.Second))
+	d.Tick(start.Add(5 * time.Second))
+
+	if got := d.BusyFor(); got != 3*time.Second {
+		t.Errorf("BusyFor() = %v, want 3s", got)
+	}
+	if got := d.State(); got != session.ActivityIdle {
+		t.Errorf("State() = %s, want idle", got)
+	}
+}
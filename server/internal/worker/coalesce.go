@@ -0,0 +1,102 @@
+package worker
+
+import (
+	"log"
+	"time"
+
+	"github.com/moltty/server/internal/metrics"
+)
+
+// Defaults for output coalescing.
+const (
+	DefaultCoalesceDelay     = 5 * time.Millisecond
+	DefaultCoalesceBytes     = 32 * 1024
+	DefaultInteractiveWindow = 150 * time.Millisecond
+)
+
+var (
+	outputChunks  = metrics.NewCounter("moltty_output_chunks_total", "Output chunks received from workers.")
+	outputFlushes = metrics.NewCounter("moltty_output_flushes_total", "Output batches fanned out to viewers.")
+	viewerFrames  = metrics.NewCounter("moltty_viewer_frames_total", "Binary frames written to viewer connections, one write call each.")
+	viewerBytes   = metrics.NewCounter("moltty_viewer_bytes_total", "Output bytes written to viewer connections.")
+)
+
+// SetOutputCoalescing batches output to viewers: chunks are held for up to delay,
+// or until maxBytes are pending, and sent as one frame. While a viewer has typed
+// within the interactive window output is sent immediately so echo stays snappy.
+// A zero delay disables coalescing.
+func (h *Hub) SetOutputCoalescing(delay time.Duration, maxBytes int, interactive time.Duration) {
+	h.coalesceDelay = delay
+	if maxBytes > 0 {
+		h.coalesceBytes = maxBytes
+	}
+	if interactive >= 0 {
+		h.interactiveWindow = interactive
+	}
+}
+
+// queueOutput hands a chunk of output to the relay's viewers, coalescing it with
+// neighbouring chunks when possible. Callers must hold relay.mu.
+func (h *Hub) queueOutput(relay *SessionRelay, data []byte) {
+	outputChunks.Inc()
+	if len(relay.Viewers) == 0 {
+		return
+	}
+
+	if h.coalesceDelay <= 0 || time.Since(relay.lastInput) < h.interactiveWindow {
+		if len(relay.pending) == 0 {
+			relay.writeViewers(data)
+			return
+		}
+		relay.pending = append(relay.pending, data...)
+		relay.flushLocked()
+		return
+	}
+
+	relay.pending = append(relay.pending, data...)
+	if len(relay.pending) >= h.coalesceBytes {
+		relay.flushLocked()
+		return
+	}
+	if relay.flushTimer == nil {
+		var timer *time.Timer
+		timer = time.AfterFunc(h.coalesceDelay, func() {
+			relay.mu.Lock()
+			defer relay.mu.Unlock()
+			// A flush may have stopped this timer after it fired but before it got
+			// the lock, and a newer timer may since hold the pending output.
+			if relay.flushTimer != timer {
+				return
+			}
+			relay.flushTimer = nil
+			relay.flushLocked()
+		})
+		relay.flushTimer = timer
+	}
+}
+
+// flushLocked sends pending output to the viewers. Callers must hold r.mu.
+func (r *SessionRelay) flushLocked() {
+	if r.flushTimer != nil {
+		r.flushTimer.Stop()
+		r.flushTimer = nil
+	}
+	if len(r.pending) == 0 {
+		return
+	}
+	r.writeViewers(r.pending)
+	r.pending = r.pending[:0]
+}
+
+// writeViewers fans data out to every viewer as one binary frame. Callers must
+// hold r.mu.
+func (r *SessionRelay) writeViewers(data []byte) {
+	outputFlushes.Inc()
+	for vc := range r.Viewers {
+		if err := vc.writeBinary(data); err != nil {
+			log.Printf("hub: failed to write to viewer: %v", err)
+		}
+		viewerFrames.Inc()
+		viewerBytes.Add(int64(len(data)))
+	}
+}
@@ -0,0 +1,216 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
+)
+
+// fakeViewer records the binary frames, control messages and close frames
+// written to a viewer.
+type fakeViewer struct {
+	mu       sync.Mutex
+	frames   []string
+	controls []ViewerMessage
+	closes   []string // close frame payloads
+}
+
+func (f *fakeViewer) WriteMessage(messageType int, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if messageType == websocket.TextMessage {
+		var msg ViewerMessage
+		json.Unmarshal(data, &msg)
+		f.controls = append(f.controls, msg)
+		return nil
+	}
+	f.frames = append(f.frames, string(data))
+	return nil
+}
+
+func (f *fakeViewer) NextWriter(messageType int) (io.WriteCloser, error) {
+	return &frameWriter{f: f, messageType: messageType}, nil
+}
+
+func (f *fakeViewer) WriteControl(_ int, data []byte, _ time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closes = append(f.closes, string(data))
+	return nil
+}
+
+func (f *fakeViewer) SetReadDeadline(time.Time) error { return nil }
+
+func (f *fakeViewer) written() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.frames...)
+}
+
+// messages returns and forgets the control messages written so far.
+func (f *fakeViewer) messages() []ViewerMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	msgs := f.controls
+	f.controls = nil
+	return msgs
+}
+
+// frameWriter buffers a streamed message until it is closed.
+type frameWriter struct {
+	f           *fakeViewer
+	messageType int
+	buf         bytes.Buffer
+}
+
+func (w *frameWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+
+func (w *frameWriter) Close() error { return w.f.WriteMessage(w.messageType, w.buf.Bytes()) }
+
+// addFakeViewer attaches a viewer backed by a fakeViewer to relay.
+func addFakeViewer(relay *SessionRelay) *fakeViewer {
+	fv := &fakeViewer{}
+	relay.Viewers[&ViewerConn{ID: uuid.New(), out: fv, writeMu: &sync.Mutex{}}] = true
+	return fv
+}
+
+func TestQueueOutputCoalescing(t *testing.T) {
+	tests := []struct {
+		name        string
+		delay       time.Duration
+		maxBytes    int
+		typing      bool // a viewer typed just before the output
+		viewers     int
+		chunks      []string
+		immediately []string // frames written before the delay
+		eventually  []string // all frames once the delay has passed
+	}{
+		{
+			name:        "coalescing disabled",
+			viewers:     1,
+			chunks:      []string{"a", "b"},
+			immediately: []string{"a", "b"},
+			eventually:  []string{"a", "b"},
+		},
+		{
+			name:       "within the delay",
+			delay:      20 * time.Millisecond,
+			viewers:    2,
+			chunks:     []string{"ab", "c", "de"},
+			eventually: []string{"abcde"},
+		},
+		{
+			name:        "byte limit reached",
+			delay:       20 * time.Millisecond,
+			maxBytes:    4,
+			viewers:     1,
+			chunks:      []string{"ab", "cd", "e"},
+			immediately: []string{"abcd"},
+			eventually:  []string{"abcd", "e"},
+		},
+		{
+			name:        "viewer typing",
+			delay:       time.Hour,
+			typing:      true,
+			viewers:     1,
+			chunks:      []string{"a", "b"},
+			immediately: []string{"a", "b"},
+			eventually:  []string{"a", "b"},
+		},
+		{
+			name:   "no viewers",
+			delay:  20 * time.Millisecond,
+			chunks: []string{"a", "b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub(nil, nil, 1024)
+			h.SetOutputCoalescing(tt.delay, tt.maxBytes, -1)
+			relay := h.newRelay(uuid.New(), uuid.New(), uuid.New())
+			var viewers []*fakeViewer
+			for i := 0; i < tt.viewers; i++ {
+				viewers = append(viewers, addFakeViewer(relay))
+			}
+			if tt.typing {
+				relay.lastInput = time.Now()
+			}
+			chunks, flushes := outputChunks.Value(), outputFlushes.Value()
+			frames, bytes := viewerFrames.Value(), viewerBytes.Value()
+
+			relay.mu.Lock()
+			for _, c := range tt.chunks {
+				h.queueOutput(relay, []byte(c))
+			}
+			relay.mu.Unlock()
+			for _, fv := range viewers {
+				if got := fv.written(); !equalFrames(got, tt.immediately) {
+					t.Errorf("before the delay, written = %q, want %q", got, tt.immediately)
+				}
+			}
+
+			for _, fv := range viewers {
+				waitFor(t, func() bool { return len(fv.written()) == len(tt.eventually) })
+				if got := fv.written(); !equalFrames(got, tt.eventually) {
+					t.Errorf("written = %q, want %q", got, tt.eventually)
+				}
+			}
+
+			var n int
+			for _, f := range tt.eventually {
+				n += len(f)
+			}
+			if got := outputChunks.Value() - chunks; got != int64(len(tt.chunks)) {
+				t.Errorf("chunks counted = %d, want %d", got, len(tt.chunks))
+			}
+			if got := outputFlushes.Value() - flushes; got != int64(len(tt.eventually)) {
+				t.Errorf("flushes counted = %d, want %d", got, len(tt.eventually))
+			}
+			if got := viewerFrames.Value() - frames; got != int64(len(tt.eventually)*tt.viewers) {
+				t.Errorf("frames counted = %d, want %d", got, len(tt.eventually)*tt.viewers)
+			}
+			if got := viewerBytes.Value() - bytes; got != int64(n*tt.viewers) {
+				t.Errorf("bytes counted = %d, want %d", got, n*tt.viewers)
+			}
+		})
+	}
+}
+
+func equalFrames(got, want []string) bool {
+	return len(got) == len(want) && (len(got) == 0 || reflect.DeepEqual(got, want))
+}
+
+func TestStaleFlushTimer(t *testing.T) {
+	h := NewHub(nil, nil, 1024)
+	h.SetOutputCoalescing(time.Millisecond, 0, -1)
+	relay := h.newRelay(uuid.New(), uuid.New(), uuid.New())
+	fv := addFakeViewer(relay)
+
+	relay.mu.Lock()
+	h.queueOutput(relay, []byte("first"))
+	// Let the timer fire while the lock is held, so its callback waits for it.
+	time.Sleep(20 * time.Millisecond)
+	relay.flushLocked()
+	h.coalesceDelay = time.Hour
+	h.queueOutput(relay, []byte("second"))
+	relay.mu.Unlock()
+
+	// The first timer's callback must not flush output queued for the second.
+	time.Sleep(20 * time.Millisecond)
+	relay.mu.Lock()
+	defer relay.mu.Unlock()
+	if got := fv.written(); !equalFrames(got, []string{"first"}) {
+		t.Errorf("written = %q, want [\"first\"]", got)
+	}
+	if relay.flushTimer == nil || string(relay.pending) != "second" {
+		t.Errorf("pending = %q with timer %v, want \"second\" with a timer", relay.pending, relay.flushTimer != nil)
+	}
+	relay.flushLocked()
+}
@@ -0,0 +1,177 @@
+package worker
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/moltty/server/internal/session"
+)
+
+// followReadSize is the largest output chunk delivered to a follower at once.
+const followReadSize = 32 * 1024
+
+// follower tracks one consumer of a session's output stream. Output is read from
+// the relay's scrollback with a cursor, so a slow follower never blocks the relay
+// and only loses output once it falls behind the scrollback window.
+type follower struct {
+	reader *ScrollbackReader
+	wake   chan struct{}            // signalled when output arrives
+	status chan session.OutputEvent // status and exit events
+	stop   chan struct{}
+}
+
+// wakeFollowers tells followers that output arrived. Callers must hold r.mu.
+func (r *SessionRelay) wakeFollowers() {
+	for f := range r.followers {
+		select {
+		case f.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// publishStatus forwards status and exit control messages to followers. Callers
+// must hold r.mu.
+func (r *SessionRelay) publishStatus(msg ViewerMessage) {
+	var ev session.OutputEvent
+	switch msg.Type {
+	case ViewerMsgStatus:
+		ev = session.OutputEvent{Type: session.OutputEventStatus, Status: session.Status(msg.Status)}
+	case ViewerMsgExit:
+		ev = session.OutputEvent{Type: session.OutputEventExit, ExitCode: msg.ExitCode}
+	default:
+		return
+	}
+	_, ev.Offset = r.Scrollback.Offsets()
+	for f := range r.followers {
+		select {
+		case f.status <- ev:
+		default:
+		}
+	}
+}
+
+// OutputOffset returns the current end of a session's output stream, preparing its
+// relay so that input can be sent to the session.
+func (h *Hub) OutputOffset(sessionID uuid.UUID) (int64, error) {
+	relay, err := h.relayFor(sessionID)
+	if err != nil {
+		return 0, err
+	}
+
+	relay.mu.Lock()
+	defer relay.mu.Unlock()
+	if relay.removed {
+		return 0, ErrSessionNotFound
+	}
+	h.hydrate(relay)
+	relay.lastUsed = time.Now()
+	_, end := relay.Scrollback.Offsets()
+	return end, nil
+}
+
+// FollowOutput streams a session's output and status changes, starting at stream
+// offset from (clamped to the retained scrollback) or, if from is negative, at the
+// current end. Offsets restart from zero when the server restarts. The channel is
+// closed when the session is removed; call the returned function to stop.
+func (h *Hub) FollowOutput(sessionID uuid.UUID, from int64) (<-chan session.OutputEvent, func(), error) {
+	relay, err := h.relayFor(sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	relay.mu.Lock()
+	if relay.removed {
+		relay.mu.Unlock()
+		return nil, nil, ErrSessionNotFound
+	}
+	h.hydrate(relay)
+	if _, end := relay.Scrollback.Offsets(); from < 0 || from > end {
+		from = end
+	}
+	f := &follower{
+		reader: relay.Scrollback.NewReaderAt(from),
+		wake:   make(chan struct{}, 1),
+		status: make(chan session.OutputEvent, 8),
+		stop:   make(chan struct{}),
+	}
+	if relay.followers == nil {
+		relay.followers = make(map[*follower]struct{})
+	}
+	relay.followers[f] = struct{}{}
+	relay.lastUsed = time.Now()
+	relay.mu.Unlock()
+
+	events := make(chan session.OutputEvent)
+	go h.runFollower(relay, f, events)
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			close(f.stop)
+			relay.mu.Lock()
+			delete(relay.followers, f)
+			relay.lastUsed = time.Now()
+			relay.mu.Unlock()
+		})
+	}
+	return events, cancel, nil
+}
+
+func (h *Hub) runFollower(relay *SessionRelay, f *follower, events chan<- session.OutputEvent) {
+	defer close(events)
+	send := func(ev session.OutputEvent) bool {
+		select {
+		case events <- ev:
+			return true
+		case <-f.stop:
+			return false
+		}
+	}
+
+	// drain sends all buffered output after the reader's cursor. It reports false
+	// once the follower should stop.
+	drain := func() bool {
+		for {
+			relay.mu.Lock()
+			removed := relay.removed
+			relay.mu.Unlock()
+			if removed {
+				return false
+			}
+
+			buf := make([]byte, followReadSize)
+			n, err := f.reader.Read(buf)
+			if err == io.EOF || n == 0 {
+				return true
+			}
+			// Read skips ahead if the follower fell behind the scrollback window.
+			offset := f.reader.Offset() - int64(n)
+			if !send(session.OutputEvent{Type: session.OutputEventData, Offset: offset, Data: buf[:n]}) {
+				return false
+			}
+		}
+	}
+
+	// Start with whatever is already buffered after the starting offset.
+	if !drain() {
+		return
+	}
+	for {
+		select {
+		case <-f.stop:
+			return
+		case ev := <-f.status:
+			// Deliver output produced before the status change first.
+			if !drain() || !send(ev) {
+				return
+			}
+		case <-f.wake:
+			if !drain() {
+				return
+			}
+		}
+	}
+}
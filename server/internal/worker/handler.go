@@ -2,6 +2,7 @@ package worker
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 
@@ -9,18 +10,48 @@ import (
 	"github.com/gofiber/fiber/v2"
 	jwtlib "github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/moltty/server/internal/quota"
 )
 
 type Handler struct {
 	hub       *Hub
 	repo      *Repository
 	jwtSecret string
+	quotas    *quota.Repository
 }
 
 func NewHandler(hub *Hub, repo *Repository, jwtSecret string) *Handler {
 	return &Handler{hub: hub, repo: repo, jwtSecret: jwtSecret}
 }
 
+// SetQuotas enables the per-user limit on registered workers.
+func (h *Handler) SetQuotas(q *quota.Repository) {
+	h.quotas = q
+}
+
+// checkWorkerQuota returns a *quota.ExceededError if the user may not register
+// workerID. Workers that are already registered may always reconnect.
+func (h *Handler) checkWorkerQuota(userID uuid.UUID, workerIDStr string) error {
+	if h.quotas == nil {
+		return nil
+	}
+	if workerID, err := uuid.Parse(workerIDStr); err == nil {
+		if w, err := h.repo.FindByID(workerID); err == nil && w.UserID == userID {
+			return nil
+		}
+	}
+
+	limits, err := h.quotas.Limits(userID)
+	if err != nil || limits.MaxWorkers <= 0 {
+		return err
+	}
+	var usage quota.Usage
+	if usage.Workers, err = h.repo.CountByUserID(userID); err != nil {
+		return err
+	}
+	return limits.AllowWorker(usage)
+}
+
 // UpgradeMiddleware validates JWT from query param before WebSocket upgrade.
 func (h *Handler) UpgradeMiddleware() fiber.Handler {
 	return func(c *fiber.Ctx) error {
@@ -43,6 +74,15 @@ func (h *Handler) UpgradeMiddleware() fiber.Handler {
 		c.Locals("userID", claims["sub"].(string))
 		c.Locals("workerID", c.Query("workerId"))
 
+		userID, _ := uuid.Parse(claims["sub"].(string))
+		if err := h.checkWorkerQuota(userID, c.Query("workerId")); err != nil {
+			var exceeded *quota.ExceededError
+			if errors.As(err, &exceeded) {
+				return c.Status(exceeded.StatusCode()).JSON(fiber.Map{"error": exceeded.Error()})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to check worker quota"})
+		}
+
 		if websocket.IsWebSocketUpgrade(c) {
 			return c.Next()
 		}
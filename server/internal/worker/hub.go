@@ -2,73 +2,379 @@ package worker
 
 import (
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"regexp"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/gofiber/contrib/websocket"
 	"github.com/google/uuid"
+	"github.com/moltty/server/internal/events"
+	"github.com/moltty/server/internal/metrics"
+	"github.com/moltty/server/internal/notify"
+	"github.com/moltty/server/internal/quota"
+	"github.com/moltty/server/internal/recording"
 	"github.com/moltty/server/internal/session"
+	"github.com/moltty/server/internal/usage"
+	"github.com/moltty/server/internal/vt"
+	"gorm.io/gorm"
 )
 
-// WorkerConn represents a live WebSocket connection from a worker.
+// WorkerConn represents a live WebSocket connection from a worker. Messages are
+// written by a dedicated goroutine from priority queues; use Send.
 type WorkerConn struct {
 	WorkerID   uuid.UUID
 	UserID     uuid.UUID
 	Conn       *websocket.Conn
-	WriteMu    sync.Mutex
 	SessionIDs map[uuid.UUID]bool
+
+	out       messageWriter // Conn, or a fake in tests
+	queues    [numPriorities]chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	depth     *metrics.Gauge // this worker's moltty_worker_queue_depth series
+	queueMu   sync.Mutex
+	queued    int64 // messages waiting across all priorities
+	drained   bool  // the writer has stopped and discarded the queues
 }
 
-// SessionRelay holds per-session state: scrollback buffer, worker association, and viewer fan-out.
+// SessionRelay holds per-session state: scrollback buffer, terminal emulator, worker
+// association, and viewer fan-out.
 type SessionRelay struct {
 	SessionID  uuid.UUID
+	UserID     uuid.UUID
 	WorkerID   uuid.UUID
 	Viewers    map[*ViewerConn]bool
 	Scrollback *ScrollbackBuffer
+	Screen     *vt.Screen
+	Activity   *ActivityDetector
+	hydrated   bool      // scrollback loaded from the persistent store
+	spilled    bool      // scrollback and screen evicted to the spill area
+	lastUsed   time.Time // last output or viewer change, for LRU eviction
+	stoppedAt  time.Time // when the session stopped; zero while it may still produce output
+	removed    bool      // dropped from the hub; buffers are released
+	status     session.Status
+	lastInput  time.Time // last keystrokes from a viewer
+	lastOutput time.Time // last output from the worker
+	warnedFor  time.Time // timeout deadline viewers were last warned about
+	killing    bool      // a timeout kill is in progress
+	stats      relayStats
+	pending    []byte      // output waiting to be coalesced into one frame
+	flushTimer *time.Timer // flushes pending output
+	followers  map[*follower]struct{}
+	persist    *storeWriter // started on the first output when a store is configured
 	mu         sync.Mutex
 }
 
-// ViewerConn represents a viewer WebSocket connection.
+// memSize returns the memory held by the relay's scrollback and screen. Callers
+// must hold r.mu.
+func (r *SessionRelay) memSize() int64 {
+	if r.spilled || r.removed {
+		return 0
+	}
+	return int64(r.Scrollback.MemSize() + r.Screen.MemSize())
+}
+
+// ViewerConn represents a viewer attached to one session. On a multiplexed
+// connection several ViewerConns share the socket and its write lock, and every
+// frame is tagged with the viewer's channel.
 type ViewerConn struct {
-	Conn    *websocket.Conn
-	WriteMu sync.Mutex
+	ID          uuid.UUID
+	UserID      uuid.UUID
+	Device      string
+	ConnectedAt time.Time
+	Conn        *websocket.Conn
+	Channel     uint32       // 0 on a dedicated connection
+	out         viewerWriter // Conn, or a fake in tests
+	writeMu     *sync.Mutex
+	onClose     func()
 }
 
+// viewerWriter is the part of a websocket connection used to write to a viewer.
+type viewerWriter interface {
+	WriteMessage(messageType int, data []byte) error
+	NextWriter(messageType int) (io.WriteCloser, error)
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetReadDeadline(t time.Time) error
+}
+
+// NewViewerConn returns a viewer that has conn to itself.
+func NewViewerConn(userID uuid.UUID, device string, conn *websocket.Conn) *ViewerConn {
+	return NewChannelViewer(userID, device, conn, &sync.Mutex{}, 0)
+}
+
+// NewChannelViewer returns a viewer on a multiplexed connection. writeMu must be
+// shared by all viewers on conn; channel must be non-zero.
+func NewChannelViewer(userID uuid.UUID, device string, conn *websocket.Conn, writeMu *sync.Mutex, channel uint32) *ViewerConn {
+	return &ViewerConn{
+		ID:          uuid.New(),
+		UserID:      userID,
+		Device:      device,
+		ConnectedAt: time.Now(),
+		Conn:        conn,
+		Channel:     channel,
+		out:         conn,
+		writeMu:     writeMu,
+	}
+}
+
+// SetOnClose registers fn to be called when the hub closes a multiplexed viewer's
+// channel. It must be set before the viewer is attached.
+func (vc *ViewerConn) SetOnClose(fn func()) {
+	vc.onClose = fn
+}
+
+// Info returns the presence record for this viewer.
+func (vc *ViewerConn) Info() session.ViewerInfo {
+	return session.ViewerInfo{
+		ID:          vc.ID,
+		UserID:      vc.UserID,
+		Device:      vc.Device,
+		ConnectedAt: vc.ConnectedAt,
+	}
+}
+
+// SendError tells the viewer that a request or its input failed.
+func (vc *ViewerConn) SendError(err error) {
+	vc.writeJSON(ViewerMessage{Type: ViewerMsgError, Error: err.Error()})
+}
+
+// writeJSON sends a control message to the viewer as a text frame.
+func (vc *ViewerConn) writeJSON(msg ViewerMessage) {
+	msg.Channel = vc.Channel
+	data, _ := json.Marshal(msg)
+	vc.writeMu.Lock()
+	defer vc.writeMu.Unlock()
+	if err := vc.out.WriteMessage(websocket.TextMessage, data); err != nil {
+		log.Printf("hub: failed to write control message to viewer: %v", err)
+	}
+}
+
+// writeBinary sends terminal output as a binary frame, prefixed with the channel
+// header on a multiplexed connection.
+func (vc *ViewerConn) writeBinary(data []byte) error {
+	vc.writeMu.Lock()
+	defer vc.writeMu.Unlock()
+	if vc.Channel == 0 {
+		return vc.out.WriteMessage(websocket.BinaryMessage, data)
+	}
+	w, err := vc.out.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	var header [ChannelHeaderSize]byte
+	binary.BigEndian.PutUint32(header[:], vc.Channel)
+	w.Write(header[:])
+	w.Write(data)
+	return w.Close()
+}
+
+// writeView streams a scrollback view to the viewer as a single binary message.
+func (vc *ViewerConn) writeView(view *ScrollbackView) error {
+	vc.writeMu.Lock()
+	defer vc.writeMu.Unlock()
+	w, err := vc.out.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	if vc.Channel != 0 {
+		var header [ChannelHeaderSize]byte
+		binary.BigEndian.PutUint32(header[:], vc.Channel)
+		w.Write(header[:])
+	}
+	if _, err := view.WriteTo(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// close ends the viewer with a close frame. The viewer's read loop then fails
+// and unregisters it. On a multiplexed connection only the channel is closed,
+// with a "closed" control message.
+func (vc *ViewerConn) close(code int, reason string) {
+	if vc.Channel != 0 {
+		vc.writeJSON(ViewerMessage{Type: ViewerMsgClosed, Code: code, Reason: reason})
+		if vc.onClose != nil {
+			vc.onClose()
+		}
+		return
+	}
+	vc.writeMu.Lock()
+	defer vc.writeMu.Unlock()
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := vc.out.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+		log.Printf("hub: failed to close viewer: %v", err)
+	}
+	// Don't wait forever for the client to answer the close frame.
+	vc.out.SetReadDeadline(time.Now().Add(5 * time.Second))
+}
+
+// Errors returned by the hub's session operations.
+var (
+	ErrSessionNotFound  = errors.New("session not found")
+	ErrNotWorkerSession = errors.New("not a worker session")
+	ErrWorkerOffline    = errors.New("worker offline")
+)
+
 // Hub is the core in-memory relay for worker and viewer connections.
 type Hub struct {
-	workers     map[uuid.UUID]*WorkerConn
-	sessions    map[uuid.UUID]*SessionRelay
-	mu          sync.RWMutex
-	workerRepo  *Repository
-	sessionRepo *session.Repository
+	workers        map[uuid.UUID]*WorkerConn
+	sessions       map[uuid.UUID]*SessionRelay
+	mu             sync.RWMutex
+	workerRepo     *Repository
+	sessionRepo    *session.Repository
 	scrollbackSize int
+
+	idleAfter      time.Duration
+	promptPatterns []*regexp.Regexp
+
+	notifier      *notify.Notifier
+	idleNotifyMin time.Duration
+
+	store         ScrollbackStore
+	storeMaxBytes int64
+	storeMaxAge   time.Duration
+
+	recorder  *recording.Recorder
+	events    *events.Broker
+	usageRepo *usage.Repository
+
+	quotas      *quota.Repository
+	storageUsed map[uuid.UUID]storageUsage
+	storageMu   sync.Mutex
+
+	snapshotReplay bool
+	historyLines   int
+
+	memBudget int64
+	spill     *spillArea
+
+	coalesceDelay     time.Duration
+	coalesceBytes     int
+	interactiveWindow time.Duration
+
+	startedAt      time.Time
+	timeouts       session.TimeoutPolicy
+	timeoutWarning time.Duration
+	killGrace      time.Duration
 }
 
 func NewHub(workerRepo *Repository, sessionRepo *session.Repository, scrollbackSize int) *Hub {
 	if scrollbackSize <= 0 {
 		scrollbackSize = DefaultScrollbackSize
 	}
+	promptPatterns, _ := CompilePromptPatterns(DefaultPromptPatterns)
 	return &Hub{
 		workers:        make(map[uuid.UUID]*WorkerConn),
 		sessions:       make(map[uuid.UUID]*SessionRelay),
 		workerRepo:     workerRepo,
 		sessionRepo:    sessionRepo,
 		scrollbackSize: scrollbackSize,
+		idleAfter:      DefaultIdleAfter,
+		promptPatterns: promptPatterns,
+		storageUsed:    make(map[uuid.UUID]storageUsage),
+		snapshotReplay: true,
+		historyLines:   vt.DefaultHistoryLines,
+
+		coalesceDelay:     DefaultCoalesceDelay,
+		coalesceBytes:     DefaultCoalesceBytes,
+		interactiveWindow: DefaultInteractiveWindow,
+
+		startedAt:      time.Now(),
+		timeoutWarning: DefaultTimeoutWarning,
+		killGrace:      DefaultKillGrace,
 	}
 }
 
-// RegisterWorker registers a worker WebSocket connection and auto-resumes offline sessions.
-func (h *Hub) RegisterWorker(workerID, userID uuid.UUID, conn *websocket.Conn) {
-	wc := &WorkerConn{
-		WorkerID:   workerID,
+// SetActivityDetection configures how long output must be quiet before a session counts
+// as idle, and which prompts mark it as awaiting input.
+func (h *Hub) SetActivityDetection(idleAfter time.Duration, patterns []*regexp.Regexp) {
+	if idleAfter > 0 {
+		h.idleAfter = idleAfter
+	}
+	h.promptPatterns = patterns
+}
+
+// SetNotifier enables user notifications. Sessions that go idle only trigger a
+// notification if they were busy for at least idleNotifyMin beforehand.
+func (h *Hub) SetNotifier(n *notify.Notifier, idleNotifyMin time.Duration) {
+	h.notifier = n
+	h.idleNotifyMin = idleNotifyMin
+}
+
+// SetScrollbackStore persists session output to store. maxBytes and maxAge are the
+// default retention limits for sessions that don't set their own.
+func (h *Hub) SetScrollbackStore(store ScrollbackStore, maxBytes int64, maxAge time.Duration) {
+	h.store = store
+	h.storeMaxBytes = maxBytes
+	h.storeMaxAge = maxAge
+}
+
+// SetRecorder enables asciicast recording of session output for users who opted in.
+func (h *Hub) SetRecorder(r *recording.Recorder) {
+	h.recorder = r
+}
+
+// SetEventBroker publishes session and worker changes to users' event streams.
+func (h *Hub) SetEventBroker(b *events.Broker) {
+	h.events = b
+}
+
+// SetViewerReplay controls what a newly attached viewer receives. With snapshot set,
+// viewers get a repaint of the emulated screen preceded by up to historyLines lines
+// of history; otherwise the raw scrollback buffer is replayed.
+func (h *Hub) SetViewerReplay(snapshot bool, historyLines int) {
+	h.snapshotReplay = snapshot
+	if historyLines >= 0 {
+		h.historyLines = historyLines
+	}
+}
+
+// SetMemoryBudget caps the memory held by relay scrollback and screens. When the
+// total exceeds budget, relays without viewers are spilled to dir, least recently
+// used first, and loaded back when a viewer attaches or output arrives.
+func (h *Hub) SetMemoryBudget(budget int64, dir string) error {
+	spill, err := newSpillArea(dir)
+	if err != nil {
+		return err
+	}
+	h.memBudget = budget
+	h.spill = spill
+	return nil
+}
+
+// notify forwards an event to the notifier, if one is configured.
+func (h *Hub) notify(ev notify.Event) {
+	if h.notifier != nil {
+		h.notifier.Notify(ev)
+	}
+}
+
+// newRelay builds an empty relay for a session. Callers must hold h.mu.
+func (h *Hub) newRelay(sessionID, userID, workerID uuid.UUID) *SessionRelay {
+	return &SessionRelay{
+		SessionID:  sessionID,
 		UserID:     userID,
-		Conn:       conn,
-		SessionIDs: make(map[uuid.UUID]bool),
+		WorkerID:   workerID,
+		Viewers:    make(map[*ViewerConn]bool),
+		Scrollback: NewScrollbackBuffer(h.scrollbackSize),
+		Screen:     vt.New(vt.DefaultCols, vt.DefaultRows, h.historyLines),
+		Activity:   NewActivityDetector(h.idleAfter, h.promptPatterns),
+		lastUsed:   time.Now(),
 	}
+}
 
+// RegisterWorker registers a worker WebSocket connection and auto-resumes offline sessions.
+func (h *Hub) RegisterWorker(workerID, userID uuid.UUID, conn *websocket.Conn) {
 	h.mu.Lock()
+	wc := newWorkerConn(workerID, userID, conn)
 	h.workers[workerID] = wc
 	h.mu.Unlock()
 
@@ -89,6 +395,12 @@ func (h *Hub) RegisterWorker(workerID, userID uuid.UUID, conn *websocket.Conn) {
 		w.LastSeenAt = time.Now()
 		h.workerRepo.Update(w)
 	}
+	h.events.Publish(events.Event{
+		Type:     events.WorkerOnline,
+		UserID:   userID,
+		WorkerID: workerID.String(),
+		Data:     map[string]any{"name": w.Name},
+	})
 
 	// Auto-resume offline sessions
 	resumable, err := h.sessionRepo.FindResumable(workerID)
@@ -97,17 +409,51 @@ func (h *Hub) RegisterWorker(workerID, userID uuid.UUID, conn *websocket.Conn) {
 		return
 	}
 
-	for _, sess := range resumable {
-		cmd := "claude --continue"
-		workDir := sess.WorkDir
-		if workDir == "" {
-			workDir = "~"
+	running := make(map[uuid.UUID]int)
+	users := make(map[uuid.UUID]*session.UserTimeouts)
+	for i := range resumable {
+		sess := &resumable[i]
+		h.broadcast(sess.ID, ViewerMessage{Type: ViewerMsgWorker, Event: "online", WorkerID: workerID.String()})
+		if h.lifetimeExpired(sess, users) {
+			// Resuming would only have the timeout loop stop it again.
+			h.stopExpired(sess)
+			continue
+		}
+		if err := h.resumeAllowed(sess.UserID, running); err != nil {
+			log.Printf("hub: not resuming session %s: %v", sess.ID, err)
+			continue
 		}
 		log.Printf("hub: auto-resuming session %s on worker %s", sess.ID, workerID)
-		h.SpawnSession(sess.ID, workerID, cmd, workDir)
+		h.resumeSession(sess.ID, workerID, sess.WorkDir)
 	}
 }
 
+// resumeSession restarts an offline session on its worker with "claude --continue"
+// and tells attached viewers that a resume is under way. Callers must check the
+// owner's quota with resumeAllowed first.
+func (h *Hub) resumeSession(sessionID, workerID uuid.UUID, workDir string) {
+	if workDir == "" {
+		workDir = "~"
+	}
+	h.broadcast(sessionID, ViewerMessage{Type: ViewerMsgResume, WorkerID: workerID.String()})
+	if err := h.sessionRepo.IncrementResumes(sessionID); err != nil {
+		log.Printf("hub: failed to count resume of session %s: %v", sessionID, err)
+	}
+
+	h.mu.RLock()
+	wc, ok := h.workers[workerID]
+	h.mu.RUnlock()
+	if ok {
+		h.events.Publish(events.Event{
+			Type:      events.SessionResumed,
+			UserID:    wc.UserID,
+			SessionID: sessionID.String(),
+			WorkerID:  workerID.String(),
+		})
+	}
+	h.SpawnSession(sessionID, workerID, "claude --continue", workDir)
+}
+
 // UnregisterWorker marks all sessions as offline and updates DB.
 func (h *Hub) UnregisterWorker(workerID uuid.UUID) {
 	h.mu.Lock()
@@ -117,8 +463,11 @@ func (h *Hub) UnregisterWorker(workerID uuid.UUID) {
 		return
 	}
 	delete(h.workers, workerID)
+	wc.close()
+	workerQueueDepth.Delete(workerID.String())
 
 	// Mark associated sessions as offline
+	var offline []*SessionRelay
 	for sessID := range wc.SessionIDs {
 		if relay, exists := h.sessions[sessID]; exists {
 			sess, err := h.sessionRepo.FindByID(sessID)
@@ -126,19 +475,54 @@ func (h *Hub) UnregisterWorker(workerID uuid.UUID) {
 				sess.Status = session.StatusOffline
 				h.sessionRepo.Update(sess)
 			}
-			// Notify viewers
+			if h.recorder != nil {
+				h.recorder.Stop(sessID)
+			}
+
 			relay.mu.Lock()
 			relay.WorkerID = uuid.Nil
+			relay.setStatus(session.StatusOffline, time.Now())
 			relay.mu.Unlock()
+			offline = append(offline, relay)
 		}
 	}
 	h.mu.Unlock()
 
+	now := time.Now()
+	for _, relay := range offline {
+		h.flushRelayStats(relay, now)
+	}
+	if h.usageRepo != nil {
+		if err := h.usageRepo.EndWorker(workerID, now, usage.EndOffline); err != nil {
+			log.Printf("hub: failed to end usage intervals for worker %s: %v", workerID, err)
+		}
+	}
+
+	// Notify viewers
+	for _, relay := range offline {
+		relay.broadcast(ViewerMessage{Type: ViewerMsgWorker, Event: "offline", WorkerID: workerID.String()})
+		relay.broadcast(ViewerMessage{Type: ViewerMsgStatus, Status: string(session.StatusOffline)})
+		h.publishStatus(relay.UserID, relay.SessionID, session.StatusOffline, nil)
+	}
+	h.events.Publish(events.Event{
+		Type:     events.WorkerOffline,
+		UserID:   wc.UserID,
+		WorkerID: workerID.String(),
+	})
+
 	// Update worker status in DB
 	w, err := h.workerRepo.FindByID(workerID)
 	if err == nil {
 		w.Status = StatusOffline
 		h.workerRepo.Update(w)
+
+		h.notify(notify.Event{
+			Kind:     notify.KindWorkerOffline,
+			UserID:   wc.UserID,
+			WorkerID: workerID,
+			Title:    fmt.Sprintf("Worker %s went offline", w.Name),
+			Body:     fmt.Sprintf("Worker %s disconnected. %d session(s) were marked offline and will resume when it reconnects.", w.Name, len(wc.SessionIDs)),
+		})
 	}
 
 	log.Printf("hub: worker %s unregistered", workerID)
@@ -162,14 +546,36 @@ func (h *Hub) HandleWorkerMessage(workerID uuid.UUID, msg WorkerMessage) {
 		h.mu.Lock()
 		if wc, ok := h.workers[workerID]; ok {
 			wc.SessionIDs[sessID] = true
+			h.publishWorkerSessions(wc)
 		}
+		relay := h.sessions[sessID]
 		h.mu.Unlock()
 
+		if relay != nil {
+			relay.mu.Lock()
+			relay.stoppedAt = time.Time{}
+			relay.setStatus(session.StatusRunning, time.Now())
+			relay.killing = false
+			relay.mu.Unlock()
+			relay.broadcast(ViewerMessage{Type: ViewerMsgStatus, Status: string(session.StatusRunning)})
+		}
+
 		// Update session status
 		sess, err := h.sessionRepo.FindByID(sessID)
 		if err == nil {
+			now := time.Now()
 			sess.Status = session.StatusRunning
+			sess.StartedAt = &now
+			if sess.FirstStartedAt == nil {
+				sess.FirstStartedAt = &now
+			}
 			h.sessionRepo.Update(sess)
+			h.startUsage(sessID, sess.UserID, workerID, now)
+			h.publishStatus(sess.UserID, sessID, session.StatusRunning, nil)
+
+			if h.recorder != nil {
+				h.recorder.Start(sessID, sess.UserID, sess.Name)
+			}
 		}
 		log.Printf("hub: session %s started on worker %s", sessID, workerID)
 
@@ -182,15 +588,43 @@ func (h *Hub) HandleWorkerMessage(workerID uuid.UUID, msg WorkerMessage) {
 		h.mu.Lock()
 		if wc, ok := h.workers[workerID]; ok {
 			delete(wc.SessionIDs, sessID)
+			h.publishWorkerSessions(wc)
 		}
+		relay := h.sessions[sessID]
 		h.mu.Unlock()
 
+		if relay != nil {
+			relay.mu.Lock()
+			relay.stoppedAt = time.Now()
+			relay.setStatus(session.StatusStopped, relay.stoppedAt)
+			relay.mu.Unlock()
+			relay.broadcast(ViewerMessage{Type: ViewerMsgExit, ExitCode: &exitCode})
+			relay.broadcast(ViewerMessage{Type: ViewerMsgStatus, Status: string(session.StatusStopped)})
+		}
+
+		if h.recorder != nil {
+			h.recorder.Stop(sessID)
+		}
+		h.endUsage(sessID, relay, time.Now(), usage.EndExited)
+
 		// Update session status
 		sess, err := h.sessionRepo.FindByID(sessID)
 		if err == nil {
 			sess.Status = session.StatusStopped
 			sess.ExitCode = msg.ExitCode
 			h.sessionRepo.Update(sess)
+			h.publishStatus(sess.UserID, sessID, session.StatusStopped, &exitCode)
+
+			if exitCode != 0 {
+				h.notify(notify.Event{
+					Kind:      notify.KindSessionExited,
+					UserID:    sess.UserID,
+					SessionID: sessID,
+					WorkerID:  workerID,
+					Title:     fmt.Sprintf("%s exited with code %d", sess.Name, exitCode),
+					Body:      fmt.Sprintf("Session %q in %s exited with code %d.", sess.Name, sess.WorkDir, exitCode),
+				})
+			}
 		}
 		log.Printf("hub: session %s exited with code %d", sessID, exitCode)
 
@@ -207,29 +641,52 @@ func (h *Hub) HandleWorkerMessage(workerID uuid.UUID, msg WorkerMessage) {
 
 		if !exists {
 			// Create relay on first output
-			relay = &SessionRelay{
-				SessionID:  sessID,
-				WorkerID:   workerID,
-				Viewers:    make(map[*ViewerConn]bool),
-				Scrollback: NewScrollbackBuffer(h.scrollbackSize),
-			}
 			h.mu.Lock()
+			var userID uuid.UUID
+			if wc, ok := h.workers[workerID]; ok {
+				userID = wc.UserID
+			}
+			relay = h.newRelay(sessID, userID, workerID)
+			// Output means the process is running, even if the hub missed its start.
+			relay.setStatus(session.StatusRunning, time.Now())
 			h.sessions[sessID] = relay
 			h.mu.Unlock()
 		}
 
-		// Append to scrollback
-		relay.Scrollback.Write(data)
+		if state, changed := relay.Activity.Observe(data, time.Now()); changed {
+			h.setActivity(relay, state)
+		}
 
-		// Fan out to viewers
 		relay.mu.Lock()
-		for vc := range relay.Viewers {
-			vc.WriteMu.Lock()
-			if err := vc.Conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
-				log.Printf("hub: failed to write to viewer: %v", err)
+		if relay.removed {
+			relay.mu.Unlock()
+			return
+		}
+		h.unspill(relay)
+		relay.lastUsed = time.Now()
+		relay.lastOutput = relay.lastUsed
+		relay.stats.outputAt = relay.lastUsed
+		relay.stats.bytesOut += int64(len(data))
+
+		// Queue for the store, then append to scrollback. Queueing under relay.mu
+		// keeps the store in the same order as the scrollback; only the queueing,
+		// not the write, happens under the lock.
+		if h.store != nil {
+			if relay.persist == nil {
+				relay.persist = newStoreWriter(sessID, h.store)
 			}
-			vc.WriteMu.Unlock()
+			relay.persist.write(data)
+		}
+		relay.Scrollback.Write(data)
+		relay.Screen.Write(data)
+		relay.wakeFollowers()
+
+		if h.recorder != nil {
+			h.recorder.Output(sessID, relay.UserID, data)
 		}
+
+		// Fan out to viewers
+		h.queueOutput(relay, data)
 		relay.mu.Unlock()
 
 	}
@@ -249,12 +706,7 @@ func (h *Hub) SpawnSession(sessionID, workerID uuid.UUID, command, workDir strin
 	// Ensure relay exists
 	h.mu.Lock()
 	if _, exists := h.sessions[sessionID]; !exists {
-		h.sessions[sessionID] = &SessionRelay{
-			SessionID:  sessionID,
-			WorkerID:   workerID,
-			Viewers:    make(map[*ViewerConn]bool),
-			Scrollback: NewScrollbackBuffer(h.scrollbackSize),
-		}
+		h.sessions[sessionID] = h.newRelay(sessionID, wc.UserID, workerID)
 	} else {
 		h.sessions[sessionID].WorkerID = workerID
 	}
@@ -267,30 +719,35 @@ func (h *Hub) SpawnSession(sessionID, workerID uuid.UUID, command, workDir strin
 		WorkDir:   workDir,
 	}
 
-	data, _ := json.Marshal(msg)
-	wc.WriteMu.Lock()
-	defer wc.WriteMu.Unlock()
-	if err := wc.Conn.WriteMessage(websocket.TextMessage, data); err != nil {
+	if err := wc.Send(PriorityNormal, msg); err != nil {
 		log.Printf("hub: failed to send spawn to worker %s: %v", workerID, err)
 	}
 }
 
-// SendInput sends keystroke data to a session's worker.
-func (h *Hub) SendInput(sessionID uuid.UUID, data string) {
-	h.mu.RLock()
-	relay, exists := h.sessions[sessionID]
-	h.mu.RUnlock()
+// SendInput sends keystroke data to a session's worker. It fails with
+// ErrWorkerBusy if the worker's input queue stayed full and the input was dropped.
+func (h *Hub) SendInput(sessionID uuid.UUID, data string) error {
+	return h.sendInput(sessionID, data, true)
+}
 
-	if !exists {
-		return
+// sendInput writes base64 input to a session's worker. Input from users counts as
+// activity and towards the session's stats; input the server sends itself does not.
+func (h *Hub) sendInput(sessionID uuid.UUID, data string, fromUser bool) error {
+	relay, wc, err := h.relayWorker(sessionID)
+	if err != nil {
+		return err
 	}
 
-	h.mu.RLock()
-	wc, ok := h.workers[relay.WorkerID]
-	h.mu.RUnlock()
-
-	if !ok {
-		return
+	relay.mu.Lock()
+	if fromUser {
+		relay.lastInput = time.Now()
+		relay.stats.inputAt = relay.lastInput
+		relay.stats.bytesIn += decodedLen(data)
+	}
+	relay.flushLocked()
+	relay.mu.Unlock()
+	if fromUser {
+		relay.Activity.Input()
 	}
 
 	msg := ServerMessage{
@@ -299,28 +756,39 @@ func (h *Hub) SendInput(sessionID uuid.UUID, data string) {
 		Data:      data,
 	}
 
-	msgData, _ := json.Marshal(msg)
-	wc.WriteMu.Lock()
-	defer wc.WriteMu.Unlock()
-	wc.Conn.WriteMessage(websocket.TextMessage, msgData)
+	return wc.Send(PriorityHigh, msg)
 }
 
-// SendResize sends a resize command to a session's worker.
-func (h *Hub) SendResize(sessionID uuid.UUID, cols, rows int) {
-	h.mu.RLock()
-	relay, exists := h.sessions[sessionID]
-	h.mu.RUnlock()
+// Terminal size limits. Sizes come from viewers and the hub allocates a cols×rows
+// screen, so they must be bounded.
+const (
+	MaxTerminalCols = 1000
+	MaxTerminalRows = 500
+)
 
-	if !exists {
-		return
+// SendResize sends a resize command to a session's worker. Non-positive sizes are
+// ignored and oversized ones clamped.
+func (h *Hub) SendResize(sessionID uuid.UUID, cols, rows int) error {
+	if cols <= 0 || rows <= 0 {
+		return nil
 	}
+	cols = min(cols, MaxTerminalCols)
+	rows = min(rows, MaxTerminalRows)
 
-	h.mu.RLock()
-	wc, ok := h.workers[relay.WorkerID]
-	h.mu.RUnlock()
+	relay, wc, err := h.relayWorker(sessionID)
+	if err != nil {
+		return err
+	}
 
-	if !ok {
-		return
+	relay.mu.Lock()
+	if !relay.removed {
+		h.unspill(relay)
+		relay.Screen.Resize(cols, rows)
+	}
+	relay.mu.Unlock()
+
+	if h.recorder != nil {
+		h.recorder.Resize(sessionID, cols, rows)
 	}
 
 	msg := ServerMessage{
@@ -330,72 +798,197 @@ func (h *Hub) SendResize(sessionID uuid.UUID, cols, rows int) {
 		Rows:      rows,
 	}
 
-	data, _ := json.Marshal(msg)
-	wc.WriteMu.Lock()
-	defer wc.WriteMu.Unlock()
-	wc.Conn.WriteMessage(websocket.TextMessage, data)
+	return wc.Send(PriorityHigh, msg)
 }
 
-// KillSession sends a kill command to a session's worker.
-func (h *Hub) KillSession(sessionID uuid.UUID) {
-	h.mu.RLock()
-	relay, exists := h.sessions[sessionID]
-	h.mu.RUnlock()
+// KillSession sends a kill command to a session's worker. Sessions that aren't
+// loaded or whose worker is offline have no process to kill and are ignored; an
+// error means the kill could not be delivered.
+func (h *Hub) KillSession(sessionID uuid.UUID) error {
+	_, wc, err := h.relayWorker(sessionID)
+	if errors.Is(err, ErrSessionNotFound) || errors.Is(err, ErrWorkerOffline) {
+		return nil
+	}
 
-	if !exists {
-		return
+	msg := ServerMessage{
+		Type:      "kill",
+		SessionID: sessionID.String(),
 	}
 
+	return wc.Send(PriorityHigh, msg)
+}
+
+// relayWorker returns a session's relay and the connection of its worker.
+func (h *Hub) relayWorker(sessionID uuid.UUID) (*SessionRelay, *WorkerConn, error) {
 	h.mu.RLock()
+	defer h.mu.RUnlock()
+	relay, ok := h.sessions[sessionID]
+	if !ok {
+		return nil, nil, ErrSessionNotFound
+	}
 	wc, ok := h.workers[relay.WorkerID]
-	h.mu.RUnlock()
-
 	if !ok {
-		return
+		return nil, nil, ErrWorkerOffline
 	}
+	return relay, wc, nil
+}
 
-	msg := ServerMessage{
-		Type:      "kill",
-		SessionID: sessionID.String(),
+// RegisterViewer registers a viewer connection for a session.
+// Sends a repaint of the current screen (or the raw scrollback) to the viewer
+// immediately, then announces the viewer to everyone attached to the session.
+// Only existing worker sessions can be viewed.
+func (h *Hub) RegisterViewer(sessionID, userID uuid.UUID, device string, conn *websocket.Conn) (*ViewerConn, error) {
+	vc := NewViewerConn(userID, device, conn)
+	if err := h.AttachViewer(sessionID, vc); err != nil {
+		return nil, err
 	}
+	return vc, nil
+}
 
-	data, _ := json.Marshal(msg)
-	wc.WriteMu.Lock()
-	defer wc.WriteMu.Unlock()
-	wc.Conn.WriteMessage(websocket.TextMessage, data)
+// AttachViewer registers vc as a viewer of a session, as RegisterViewer does.
+func (h *Hub) AttachViewer(sessionID uuid.UUID, vc *ViewerConn) error {
+	relay, err := h.relayFor(sessionID)
+	if err != nil {
+		return err
+	}
+
+	relay.mu.Lock()
+	if relay.removed {
+		relay.mu.Unlock()
+		return ErrSessionNotFound
+	}
+	h.hydrate(relay)
+	// Existing viewers get pending output first; the new viewer's repaint
+	// already includes it.
+	relay.flushLocked()
+
+	// Send the current terminal state
+	if h.snapshotReplay {
+		if err := vc.writeBinary(relay.Screen.Snapshot(h.historyLines)); err != nil {
+			log.Printf("hub: failed to send screen to viewer: %v", err)
+		}
+	} else if view := relay.Scrollback.View(); view.Len() > 0 {
+		if err := vc.writeView(view); err != nil {
+			log.Printf("hub: failed to send scrollback to viewer: %v", err)
+		}
+		view.Release()
+	}
+
+	relay.Viewers[vc] = true
+	relay.lastUsed = time.Now()
+	status := relay.status
+	relay.mu.Unlock()
+
+	if status != "" {
+		vc.writeJSON(ViewerMessage{Type: ViewerMsgStatus, Status: string(status)})
+	}
+
+	info := vc.Info()
+	relay.broadcastPresence("join", info)
+
+	return nil
 }
 
-// RegisterViewer registers a viewer connection for a session.
-// Sends existing scrollback data to the viewer immediately.
-func (h *Hub) RegisterViewer(sessionID uuid.UUID, conn *websocket.Conn) *ViewerConn {
-	vc := &ViewerConn{Conn: conn}
+// relayFor returns a session's relay, creating it if the session exists and runs
+// on a worker.
+func (h *Hub) relayFor(sessionID uuid.UUID) (*SessionRelay, error) {
+	h.mu.RLock()
+	relay, exists := h.sessions[sessionID]
+	h.mu.RUnlock()
+	if exists {
+		return relay, nil
+	}
+
+	sess, err := h.sessionRepo.FindByID(sessionID)
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+	if sess.SessionType != session.SessionTypeWorker {
+		return nil, ErrNotWorkerSession
+	}
 
 	h.mu.Lock()
-	relay, exists := h.sessions[sessionID]
-	if !exists {
-		relay = &SessionRelay{
-			SessionID:  sessionID,
-			WorkerID:   uuid.Nil,
-			Viewers:    make(map[*ViewerConn]bool),
-			Scrollback: NewScrollbackBuffer(h.scrollbackSize),
+	defer h.mu.Unlock()
+	if relay, exists = h.sessions[sessionID]; !exists {
+		var workerID uuid.UUID
+		if sess.WorkerID != nil {
+			workerID = *sess.WorkerID
 		}
+		relay = h.newRelay(sessionID, sess.UserID, workerID)
+		if sess.Status == session.StatusStopped || sess.Status == session.StatusError {
+			relay.stoppedAt = time.Now()
+		}
+		relay.setStatus(sess.Status, time.Now())
 		h.sessions[sessionID] = relay
 	}
-	h.mu.Unlock()
+	return relay, nil
+}
+
+// History returns a session's terminal output for searching: the persistent history
+// when a store is configured, otherwise the in-memory scrollback, and the stream
+// offset it starts at.
+func (h *Hub) History(sessionID uuid.UUID) ([]byte, int64, error) {
+	h.mu.RLock()
+	relay, exists := h.sessions[sessionID]
+	h.mu.RUnlock()
 
-	// Send existing scrollback
-	scrollback := relay.Scrollback.Bytes()
-	if len(scrollback) > 0 {
-		vc.WriteMu.Lock()
-		conn.WriteMessage(websocket.BinaryMessage, scrollback)
-		vc.WriteMu.Unlock()
+	if h.store != nil {
+		if exists {
+			relay.mu.Lock()
+			persist := relay.persist
+			relay.mu.Unlock()
+			if persist != nil {
+				persist.flush()
+			}
+		}
+		return h.store.Load(sessionID, MaxHistorySize)
+	}
+
+	if !exists {
+		return nil, 0, nil
 	}
 
 	relay.mu.Lock()
-	relay.Viewers[vc] = true
-	relay.mu.Unlock()
+	defer relay.mu.Unlock()
+	if relay.removed {
+		return nil, 0, nil
+	}
+	h.unspill(relay)
+	start, _ := relay.Scrollback.Offsets()
+	return relay.Scrollback.Bytes(), start, nil
+}
+
+// hydrate loads a relay's scrollback from the persistent store the first time it is
+// needed, or from the spill area if it was evicted. Callers must hold relay.mu.
+func (h *Hub) hydrate(relay *SessionRelay) {
+	h.unspill(relay)
+	if relay.hydrated || h.store == nil {
+		return
+	}
+	relay.hydrated = true
 
-	return vc
+	// Output received before the first hydrate may still be on its way to the store.
+	if relay.persist != nil {
+		relay.persist.flush()
+	}
+	data, start, err := h.store.Load(relay.SessionID, h.scrollbackSize)
+	if err != nil {
+		log.Printf("hub: failed to load scrollback for session %s: %v", relay.SessionID, err)
+		return
+	}
+	storedEnd := start + int64(len(data))
+	if len(data) == h.scrollbackSize {
+		// The history was cut to fit; drop the partial first line.
+		data = SafeStart(data)
+	}
+	if len(data) > 0 {
+		// The stored history ends at the stream's end. Never move backwards past
+		// output already relayed.
+		_, end := relay.Scrollback.Offsets()
+		relay.Scrollback.ReplaceAt(data, max(end, storedEnd))
+		relay.Screen.Reset()
+		relay.Screen.Write(data)
+	}
 }
 
 // UnregisterViewer removes a viewer connection from a session.
@@ -410,7 +1003,71 @@ func (h *Hub) UnregisterViewer(sessionID uuid.UUID, vc *ViewerConn) {
 
 	relay.mu.Lock()
 	delete(relay.Viewers, vc)
+	relay.lastUsed = time.Now()
 	relay.mu.Unlock()
+
+	relay.broadcastPresence("leave", vc.Info())
+}
+
+// Viewers returns the viewers currently attached to a session, oldest first.
+func (h *Hub) Viewers(sessionID uuid.UUID) []session.ViewerInfo {
+	h.mu.RLock()
+	relay, exists := h.sessions[sessionID]
+	h.mu.RUnlock()
+
+	if !exists {
+		return []session.ViewerInfo{}
+	}
+	return relay.viewerInfos()
+}
+
+// viewerInfos returns presence records for the relay's viewers, oldest first.
+func (r *SessionRelay) viewerInfos() []session.ViewerInfo {
+	r.mu.Lock()
+	infos := make([]session.ViewerInfo, 0, len(r.Viewers))
+	for vc := range r.Viewers {
+		infos = append(infos, vc.Info())
+	}
+	r.mu.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ConnectedAt.Before(infos[j].ConnectedAt)
+	})
+	return infos
+}
+
+// broadcastPresence tells every attached viewer that a viewer joined or left,
+// along with the full current viewer list.
+func (r *SessionRelay) broadcastPresence(event string, viewer session.ViewerInfo) {
+	r.broadcast(ViewerMessage{
+		Type:    ViewerMsgPresence,
+		Event:   event,
+		Viewer:  &viewer,
+		Viewers: r.viewerInfos(),
+	})
+}
+
+// broadcast sends a control message to every viewer attached to the relay.
+func (r *SessionRelay) broadcast(msg ViewerMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Keep control messages ordered after the output that preceded them.
+	r.flushLocked()
+	for vc := range r.Viewers {
+		vc.writeJSON(msg)
+	}
+	r.publishStatus(msg)
+}
+
+// broadcast sends a control message to the viewers of a session, if it has a relay.
+func (h *Hub) broadcast(sessionID uuid.UUID, msg ViewerMessage) {
+	h.mu.RLock()
+	relay, exists := h.sessions[sessionID]
+	h.mu.RUnlock()
+
+	if exists {
+		relay.broadcast(msg)
+	}
 }
 
 // StartPingLoop periodically pings all connected workers.
@@ -420,14 +1077,156 @@ func (h *Hub) StartPingLoop(interval time.Duration) {
 		defer ticker.Stop()
 		for range ticker.C {
 			h.mu.RLock()
+			workers := make([]*WorkerConn, 0, len(h.workers))
 			for _, wc := range h.workers {
-				msg := ServerMessage{Type: "ping"}
-				data, _ := json.Marshal(msg)
-				wc.WriteMu.Lock()
-				wc.Conn.WriteMessage(websocket.TextMessage, data)
-				wc.WriteMu.Unlock()
+				workers = append(workers, wc)
+			}
+			h.mu.RUnlock()
+
+			for _, wc := range workers {
+				wc.Send(PriorityNormal, ServerMessage{Type: "ping"})
+			}
+		}
+	}()
+}
+
+// StartActivityLoop periodically re-evaluates the activity state of every relay so
+// sessions that stop producing output move from busy to idle or awaiting-input.
+func (h *Hub) StartActivityLoop(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			h.mu.RLock()
+			relays := make([]*SessionRelay, 0, len(h.sessions))
+			for _, relay := range h.sessions {
+				relays = append(relays, relay)
 			}
 			h.mu.RUnlock()
+
+			for _, relay := range relays {
+				if state, changed := relay.Activity.Tick(now); changed {
+					h.setActivity(relay, state)
+				}
+			}
+		}
+	}()
+}
+
+// setActivity persists a relay's new activity state and publishes it to subscribers.
+func (h *Hub) setActivity(relay *SessionRelay, state session.ActivityState) {
+	now := time.Now()
+	if err := h.sessionRepo.UpdateActivity(relay.SessionID, state, now); err != nil {
+		log.Printf("hub: failed to store activity for session %s: %v", relay.SessionID, err)
+	}
+
+	if state != session.ActivityBusy && h.notifier != nil && relay.Activity.BusyFor() >= h.idleNotifyMin {
+		h.notifyIdle(relay, state)
+	}
+
+	h.events.Publish(events.Event{
+		Type:      events.SessionActivity,
+		UserID:    relay.UserID,
+		SessionID: relay.SessionID.String(),
+		At:        now,
+		Data:      map[string]any{"state": state},
+	})
+}
+
+// publishStatus publishes a session status change to the owner's event stream.
+func (h *Hub) publishStatus(userID, sessionID uuid.UUID, status session.Status, exitCode *int) {
+	data := map[string]any{"status": status}
+	if exitCode != nil {
+		data["exitCode"] = *exitCode
+	}
+	h.events.Publish(events.Event{
+		Type:      events.SessionStatus,
+		UserID:    userID,
+		SessionID: sessionID.String(),
+		Data:      data,
+	})
+}
+
+// publishWorkerSessions publishes a worker's running session count. Callers must
+// hold h.mu.
+func (h *Hub) publishWorkerSessions(wc *WorkerConn) {
+	h.events.Publish(events.Event{
+		Type:     events.WorkerUpdated,
+		UserID:   wc.UserID,
+		WorkerID: wc.WorkerID.String(),
+		Data:     map[string]any{"activeSessions": len(wc.SessionIDs)},
+	})
+}
+
+// notifyIdle tells the user a session stopped producing output after a stretch of work.
+func (h *Hub) notifyIdle(relay *SessionRelay, state session.ActivityState) {
+	sess, err := h.sessionRepo.FindByID(relay.SessionID)
+	if err != nil {
+		return
+	}
+
+	title := fmt.Sprintf("%s is idle", sess.Name)
+	body := fmt.Sprintf("Session %q finished its work and is idle.", sess.Name)
+	if state == session.ActivityAwaitingInput {
+		title = fmt.Sprintf("%s is waiting for input", sess.Name)
+		body = fmt.Sprintf("Session %q is waiting for you to respond.", sess.Name)
+	}
+
+	h.notify(notify.Event{
+		Kind:      notify.KindSessionIdle,
+		UserID:    sess.UserID,
+		SessionID: sess.ID,
+		WorkerID:  relay.WorkerID,
+		Title:     title,
+		Body:      body,
+	})
+}
+
+// StartScrollbackRetention periodically applies each session's size and age limits to
+// the persistent store, and drops history for sessions that no longer exist.
+func (h *Hub) StartScrollbackRetention(interval time.Duration) {
+	if h.store == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			h.applyScrollbackRetention()
 		}
 	}()
 }
+
+func (h *Hub) applyScrollbackRetention() {
+	ids, err := h.store.Sessions()
+	if err != nil {
+		log.Printf("hub: failed to list stored scrollback: %v", err)
+		return
+	}
+
+	for _, id := range ids {
+		// Soft-deleted sessions keep their scrollback until they are purged.
+		sess, err := h.sessionRepo.FindByIDUnscoped(id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := h.store.Delete(id); err != nil {
+				log.Printf("hub: failed to delete scrollback for session %s: %v", id, err)
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("hub: failed to look up session %s: %v", id, err)
+			continue
+		}
+
+		maxBytes, maxAge := h.storeMaxBytes, h.storeMaxAge
+		if sess.ScrollbackMaxBytes != nil {
+			maxBytes = *sess.ScrollbackMaxBytes
+		}
+		if sess.ScrollbackRetentionDays != nil {
+			maxAge = time.Duration(*sess.ScrollbackRetentionDays) * 24 * time.Hour
+		}
+		if err := h.store.Trim(id, maxBytes, maxAge); err != nil {
+			log.Printf("hub: failed to trim scrollback for session %s: %v", id, err)
+		}
+	}
+}
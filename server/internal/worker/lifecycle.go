@@ -0,0 +1,119 @@
+package worker
+
+import (
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultRelayGrace is how long a stopped session's relay stays in memory without
+// viewers before it is discarded.
+const DefaultRelayGrace = 10 * time.Minute
+
+// RemoveSession tears down a deleted session: attached viewers are closed with a
+// close frame and the relay is discarded. Persisted scrollback and recordings are
+// kept until PurgeSessionData.
+func (h *Hub) RemoveSession(sessionID uuid.UUID) {
+	h.mu.Lock()
+	relay, exists := h.sessions[sessionID]
+	delete(h.sessions, sessionID)
+	for _, wc := range h.workers {
+		delete(wc.SessionIDs, sessionID)
+	}
+	h.mu.Unlock()
+
+	if !exists {
+		return
+	}
+
+	relay.mu.Lock()
+	viewers := make([]*ViewerConn, 0, len(relay.Viewers))
+	for vc := range relay.Viewers {
+		viewers = append(viewers, vc)
+	}
+	relay.Viewers = make(map[*ViewerConn]bool)
+	h.releaseRelay(relay)
+	relay.mu.Unlock()
+
+	for _, vc := range viewers {
+		vc.close(CloseSessionNotFound, "session deleted")
+	}
+	log.Printf("hub: removed session %s, closed %d viewers", sessionID, len(viewers))
+}
+
+// PurgeSessionData deletes a session's persisted scrollback and recording.
+func (h *Hub) PurgeSessionData(sessionID uuid.UUID) {
+	if h.store != nil {
+		if err := h.store.Delete(sessionID); err != nil {
+			log.Printf("hub: failed to delete scrollback for session %s: %v", sessionID, err)
+		}
+	}
+	if h.recorder != nil {
+		if err := h.recorder.Delete(sessionID); err != nil {
+			log.Printf("hub: failed to delete recording for session %s: %v", sessionID, err)
+		}
+	}
+}
+
+// releaseRelay frees a relay's buffers once it has been removed from the hub.
+// Callers must hold relay.mu.
+func (h *Hub) releaseRelay(relay *SessionRelay) {
+	if relay.spilled {
+		h.spill.remove(relay.SessionID)
+		relay.spilled = false
+	}
+	if relay.flushTimer != nil {
+		relay.flushTimer.Stop()
+		relay.flushTimer = nil
+	}
+	relay.pending = nil
+	if relay.persist != nil {
+		relay.persist.stop()
+	}
+	relay.Scrollback.Replace(nil)
+	relay.Screen = nil
+	relay.removed = true
+	relay.wakeFollowers()
+}
+
+// StartRelayGC periodically discards the relays of stopped sessions that have had
+// no viewers for the grace period. Their output stays available from the
+// persistent store, if one is configured.
+func (h *Hub) StartRelayGC(interval, grace time.Duration) {
+	if grace <= 0 {
+		grace = DefaultRelayGrace
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			h.collectRelays(grace)
+		}
+	}()
+}
+
+func (h *Hub) collectRelays(grace time.Duration) {
+	now := time.Now()
+	removed := 0
+
+	h.mu.Lock()
+	for id, relay := range h.sessions {
+		relay.mu.Lock()
+		idle := relay.lastUsed
+		if relay.stoppedAt.After(idle) {
+			idle = relay.stoppedAt
+		}
+		if !relay.stoppedAt.IsZero() && len(relay.Viewers) == 0 && len(relay.followers) == 0 && now.Sub(idle) >= grace {
+			delete(h.sessions, id)
+			h.releaseRelay(relay)
+			removed++
+		}
+		relay.mu.Unlock()
+	}
+	h.mu.Unlock()
+
+	if removed > 0 {
+		log.Printf("hub: collected %d relays of stopped sessions", removed)
+	}
+}
@@ -0,0 +1,74 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
+)
+
+func TestRemoveSessionClosesViewers(t *testing.T) {
+	h := NewHub(nil, nil, 1024)
+	relay := addRelay(h, "output\r\n")
+	wc := startWorkerConn(relay.WorkerID, relay.UserID, &fakeWorker{})
+	defer wc.close()
+	wc.SessionIDs[relay.SessionID] = true
+	h.workers[wc.WorkerID] = wc
+
+	dedicated, dedicatedOut := newFakeViewerConn("dedicated", time.Now())
+	channel, channelOut := newFakeViewerConn("mux", time.Now())
+	channel.Channel = 3
+	channelClosed := false
+	channel.SetOnClose(func() { channelClosed = true })
+	relay.Viewers[dedicated] = true
+	relay.Viewers[channel] = true
+	events, cancel, err := h.FollowOutput(relay.SessionID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	h.RemoveSession(relay.SessionID)
+
+	want := string(websocket.FormatCloseMessage(CloseSessionNotFound, "session deleted"))
+	if len(dedicatedOut.closes) != 1 || dedicatedOut.closes[0] != want {
+		t.Errorf("dedicated viewer got close frames %q, want %q", dedicatedOut.closes, want)
+	}
+	msgs := channelOut.messages()
+	if len(msgs) != 1 || msgs[0].Type != ViewerMsgClosed || msgs[0].Channel != 3 ||
+		msgs[0].Code != CloseSessionNotFound || msgs[0].Reason != "session deleted" {
+		t.Errorf("channel viewer got %+v, want a closed message on channel 3", msgs)
+	}
+	if !channelClosed {
+		t.Error("the channel's onClose was not called")
+	}
+	if len(channelOut.closes) != 0 {
+		t.Error("a channel viewer's shared connection got a close frame")
+	}
+	if wc.SessionIDs[relay.SessionID] {
+		t.Error("the worker still lists the session")
+	}
+
+	// The follower sees the buffered output, then its stream ends.
+	deadline := time.After(time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-events:
+		case <-deadline:
+			t.Fatal("follower stream not closed")
+		}
+	}
+	if got := h.Viewers(relay.SessionID); len(got) != 0 {
+		t.Errorf("Viewers() = %+v after removal", got)
+	}
+}
+
+func TestRemoveSessionUnknown(t *testing.T) {
+	h := NewHub(nil, nil, 1024)
+	relay := addRelay(h, "")
+	h.RemoveSession(uuid.New())
+	if _, ok := h.sessions[relay.SessionID]; !ok || relay.removed {
+		t.Error("removing an unknown session touched another relay")
+	}
+}
@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/moltty/server/internal/session"
+)
+
+// newFakeViewerConn returns a viewer backed by a fakeViewer.
+func newFakeViewerConn(device string, connectedAt time.Time) (*ViewerConn, *fakeViewer) {
+	fv := &fakeViewer{}
+	return &ViewerConn{
+		ID:          uuid.New(),
+		UserID:      uuid.New(),
+		Device:      device,
+		ConnectedAt: connectedAt,
+		out:         fv,
+		writeMu:     &sync.Mutex{},
+	}, fv
+}
+
+// presenceOf summarises a presence message as its event, the viewer's device and
+// the devices of everyone attached.
+func presenceOf(msg ViewerMessage) []string {
+	if msg.Type != ViewerMsgPresence || msg.Viewer == nil {
+		return []string{msg.Type}
+	}
+	out := []string{msg.Event, msg.Viewer.Device}
+	for _, v := range msg.Viewers {
+		out = append(out, v.Device)
+	}
+	return out
+}
+
+func TestViewerPresence(t *testing.T) {
+	h := NewHub(nil, nil, 1024)
+	relay := addRelay(h, "")
+	relay.setStatus(session.StatusRunning, time.Now())
+	now := time.Now()
+	laptop, laptopOut := newFakeViewerConn("laptop", now.Add(-time.Minute))
+	phone, phoneOut := newFakeViewerConn("phone", now)
+
+	if err := h.AttachViewer(relay.SessionID, laptop); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.AttachViewer(relay.SessionID, phone); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := laptopOut.messages()
+	if len(msgs) != 3 || msgs[0].Type != ViewerMsgStatus || msgs[0].Status != string(session.StatusRunning) {
+		t.Fatalf("laptop got %+v, want status then two joins", msgs)
+	}
+	if got, want := presenceOf(msgs[1]), []string{"join", "laptop", "laptop"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("laptop's first presence = %q, want %q", got, want)
+	}
+	if got, want := presenceOf(msgs[2]), []string{"join", "phone", "laptop", "phone"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("laptop's second presence = %q, want %q", got, want)
+	}
+	if msgs := phoneOut.messages(); len(msgs) != 2 || msgs[0].Type != ViewerMsgStatus ||
+		!reflect.DeepEqual(presenceOf(msgs[1]), []string{"join", "phone", "laptop", "phone"}) {
+		t.Errorf("phone got %+v, want status then its own join", msgs)
+	}
+
+	if got := h.Viewers(relay.SessionID); len(got) != 2 || got[0].ID != laptop.ID || got[1].ID != phone.ID {
+		t.Errorf("Viewers() = %+v, want laptop then phone", got)
+	}
+
+	h.UnregisterViewer(relay.SessionID, phone)
+	if msgs := laptopOut.messages(); len(msgs) != 1 || !reflect.DeepEqual(presenceOf(msgs[0]), []string{"leave", "phone", "laptop"}) {
+		t.Errorf("after the phone left, laptop got %+v", msgs)
+	}
+	if msgs := phoneOut.messages(); len(msgs) != 0 {
+		t.Errorf("the phone got %+v after leaving", msgs)
+	}
+	if got := h.Viewers(uuid.New()); got == nil || len(got) != 0 {
+		t.Errorf("Viewers() of an unknown session = %#v, want an empty list", got)
+	}
+}
@@ -1,5 +1,7 @@
 package worker
 
+import "github.com/moltty/server/internal/session"
+
 // WorkerMessage is sent from the worker to the server.
 type WorkerMessage struct {
 	Type      string `json:"type"`      // session-started, session-exited, output, pong
@@ -18,3 +20,47 @@ type ServerMessage struct {
 	Cols      int    `json:"cols"`      // terminal columns (for "resize")
 	Rows      int    `json:"rows"`      // terminal rows (for "resize")
 }
+
+// Viewer control message types.
+const (
+	ViewerMsgPresence = "presence" // a viewer joined or left
+	ViewerMsgStatus   = "status"   // the session's status changed
+	ViewerMsgExit     = "exit"     // the session's process exited
+	ViewerMsgWorker   = "worker"   // the session's worker went offline or came back
+	ViewerMsgResume   = "resume"   // the session is being resumed on its worker
+	ViewerMsgTimeout  = "timeout"  // the session is about to time out, or just did
+	ViewerMsgError    = "error"    // a request or input failed
+
+	// Multiplexed connections only.
+	ViewerMsgSubscribed = "subscribed" // a subscribe request succeeded
+	ViewerMsgClosed     = "closed"     // the channel was closed by the server
+)
+
+// ChannelHeaderSize is the length of the big-endian channel ID that prefixes every
+// binary frame, in both directions, on a multiplexed viewer connection.
+const ChannelHeaderSize = 4
+
+// ViewerMessage is sent from the server to a viewer as a JSON text frame.
+// PTY output is always sent as binary frames.
+type ViewerMessage struct {
+	Type      string               `json:"type"`                // presence, status, exit, worker, resume, timeout, subscribed, closed, error
+	Channel   uint32               `json:"channel,omitempty"`   // channel the message belongs to (multiplexed connections)
+	SessionID string               `json:"sessionId,omitempty"` // session bound to the channel (for "subscribed")
+	Event     string               `json:"event,omitempty"`     // join, leave (for "presence"); online, offline (for "worker"); warning, expired (for "timeout")
+	Status    string               `json:"status,omitempty"`    // session status (for "status")
+	ExitCode  *int                 `json:"exitCode,omitempty"`  // process exit code (for "exit")
+	WorkerID  string               `json:"workerId,omitempty"`  // worker (for "worker" and "resume")
+	Viewer    *session.ViewerInfo  `json:"viewer,omitempty"`    // viewer that joined or left (for "presence")
+	Viewers   []session.ViewerInfo `json:"viewers,omitempty"`   // everyone currently attached (for "presence")
+	Code      int                  `json:"code,omitempty"`      // close code (for "closed")
+	Reason    string               `json:"reason,omitempty"`    // close reason (for "closed"); idle, lifetime (for "timeout")
+	Remaining int64                `json:"remaining,omitempty"` // seconds until the session is stopped (for "timeout")
+	Error     string               `json:"error,omitempty"`     // failure description (for "error")
+}
+
+// Close codes sent to viewers when the server ends the connection. Clients should
+// not reconnect after receiving one of these.
+const (
+	CloseNotWorkerSession = 4400 // the session doesn't run on a worker
+	CloseSessionNotFound  = 4404 // the session doesn't exist or was deleted
+)
@@ -0,0 +1,99 @@
+package worker
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/moltty/server/internal/session"
+)
+
+func TestViewerMessageJSON(t *testing.T) {
+	zero := 0
+	tests := []struct {
+		msg  ViewerMessage
+		want string
+	}{
+		{ViewerMessage{Type: ViewerMsgStatus, Status: string(session.StatusOffline)}, `{"type":"status","status":"offline"}`},
+		{ViewerMessage{Type: ViewerMsgExit, ExitCode: &zero}, `{"type":"exit","exitCode":0}`},
+		{ViewerMessage{Type: ViewerMsgWorker, Event: "offline", WorkerID: "w1"}, `{"type":"worker","event":"offline","workerId":"w1"}`},
+		{ViewerMessage{Type: ViewerMsgResume, WorkerID: "w1"}, `{"type":"resume","workerId":"w1"}`},
+	}
+	for _, tt := range tests {
+		data, err := json.Marshal(tt.msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("Marshal(%+v) = %s, want %s", tt.msg, data, tt.want)
+		}
+	}
+}
+
+func TestBroadcastAfterPendingOutput(t *testing.T) {
+	h := NewHub(nil, nil, 1024)
+	h.SetOutputCoalescing(time.Hour, 0, -1)
+	relay := addRelay(h, "")
+	fv := addFakeViewer(relay)
+
+	relay.mu.Lock()
+	h.queueOutput(relay, []byte("last words"))
+	relay.mu.Unlock()
+	if got := fv.written(); len(got) != 0 {
+		t.Fatalf("output sent before the coalescing delay: %q", got)
+	}
+
+	exitCode := 1
+	h.broadcast(relay.SessionID, ViewerMessage{Type: ViewerMsgExit, ExitCode: &exitCode})
+	if got := fv.written(); len(got) != 1 || got[0] != "last words" {
+		t.Errorf("frames when the exit was sent = %q, want the pending output", got)
+	}
+	if msgs := fv.messages(); len(msgs) != 1 || msgs[0].Type != ViewerMsgExit || *msgs[0].ExitCode != 1 {
+		t.Errorf("control messages = %+v, want one exit with code 1", msgs)
+	}
+
+	// Sessions without a relay are ignored.
+	h.broadcast(uuid.New(), ViewerMessage{Type: ViewerMsgStatus, Status: string(session.StatusStopped)})
+}
+
+func TestBroadcastReachesFollowers(t *testing.T) {
+	h := NewHub(nil, nil, 1024)
+	relay := addRelay(h, "")
+	events, cancel, err := h.FollowOutput(relay.SessionID, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	relay.mu.Lock()
+	relay.Scrollback.Write([]byte("bye\r\n"))
+	relay.wakeFollowers()
+	relay.mu.Unlock()
+	exitCode := 2
+	relay.broadcast(ViewerMessage{Type: ViewerMsgExit, ExitCode: &exitCode})
+	relay.broadcast(ViewerMessage{Type: ViewerMsgStatus, Status: string(session.StatusStopped)})
+	relay.broadcast(ViewerMessage{Type: ViewerMsgWorker, Event: "offline"})
+
+	want := []session.OutputEvent{
+		{Type: session.OutputEventData, Offset: 0, Data: []byte("bye\r\n")},
+		{Type: session.OutputEventExit, Offset: 5, ExitCode: &exitCode},
+		{Type: session.OutputEventStatus, Offset: 5, Status: session.StatusStopped},
+	}
+	for i, w := range want {
+		select {
+		case got := <-events:
+			if got.Type != w.Type || got.Offset != w.Offset || string(got.Data) != string(w.Data) ||
+				got.Status != w.Status || (w.ExitCode != nil && (got.ExitCode == nil || *got.ExitCode != *w.ExitCode)) {
+				t.Errorf("event %d = %+v, want %+v", i, got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for event %d", i)
+		}
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("unexpected event %+v; worker messages aren't forwarded to followers", ev)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
@@ -0,0 +1,129 @@
+package worker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/moltty/server/internal/quota"
+)
+
+// storageCacheTTL is how long a user's measured storage is reused before the hub
+// measures it again.
+const storageCacheTTL = time.Minute
+
+type storageUsage struct {
+	bytes      int64
+	measuredAt time.Time
+}
+
+// SetQuotas enables the per-user session limits for sessions the hub resumes on
+// its own when a worker reconnects, the only way sessions are resumed.
+func (h *Hub) SetQuotas(q *quota.Repository) {
+	h.quotas = q
+}
+
+// QuotaUsage reports how many sessions a user is running, how many workers they
+// registered and how much scrollback and recording storage their sessions take.
+func (h *Hub) QuotaUsage(userID uuid.UUID) (quota.Usage, error) {
+	var u quota.Usage
+	var err error
+	if u.RunningSessions, err = h.sessionRepo.CountRunning(userID); err != nil {
+		return u, err
+	}
+	if u.Workers, err = h.workerRepo.CountByUserID(userID); err != nil {
+		return u, err
+	}
+	u.StorageBytes, err = h.StorageUsed(userID)
+	return u, err
+}
+
+// StorageUsed returns the bytes of persisted scrollback and recordings held by a
+// user's sessions. The total is measured at most once per storageCacheTTL.
+//
+// Storage is only enforced when a session is created or resumed: sessions that are
+// already running keep writing output past the limit until retention trims it.
+func (h *Hub) StorageUsed(userID uuid.UUID) (int64, error) {
+	if h.store == nil && h.recorder == nil {
+		return 0, nil
+	}
+	h.storageMu.Lock()
+	cached, ok := h.storageUsed[userID]
+	h.storageMu.Unlock()
+	if ok && time.Since(cached.measuredAt) < storageCacheTTL {
+		return cached.bytes, nil
+	}
+
+	total, err := h.measureStorage(userID)
+	if err != nil {
+		return total, err
+	}
+	h.storageMu.Lock()
+	for id, u := range h.storageUsed {
+		if time.Since(u.measuredAt) >= storageCacheTTL {
+			delete(h.storageUsed, id)
+		}
+	}
+	h.storageUsed[userID] = storageUsage{bytes: total, measuredAt: time.Now()}
+	h.storageMu.Unlock()
+	return total, nil
+}
+
+// measureStorage adds up the stored scrollback and recordings of a user's sessions.
+func (h *Hub) measureStorage(userID uuid.UUID) (int64, error) {
+	sessions, err := h.sessionRepo.FindByUserID(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, sess := range sessions {
+		if h.store != nil {
+			n, err := h.store.Size(sess.ID)
+			if err != nil {
+				return total, err
+			}
+			total += n
+		}
+		if h.recorder != nil {
+			total += h.recorder.Size(sess.ID)
+		}
+	}
+	return total, nil
+}
+
+// resumeAllowed checks a user's quota before the hub resumes one of their sessions,
+// with the same limits as creating a session. running counts the user's running
+// sessions, including the ones resumed so far; it is filled in on the user's
+// first call.
+func (h *Hub) resumeAllowed(userID uuid.UUID, running map[uuid.UUID]int) error {
+	if h.quotas == nil {
+		return nil
+	}
+	limits, err := h.quotas.Limits(userID)
+	if err != nil {
+		return fmt.Errorf("load quota: %w", err)
+	}
+
+	var u quota.Usage
+	if limits.MaxRunningSessions > 0 {
+		n, ok := running[userID]
+		if !ok {
+			if n, err = h.sessionRepo.CountRunning(userID); err != nil {
+				return fmt.Errorf("count running sessions: %w", err)
+			}
+		}
+		running[userID] = n
+		u.RunningSessions = n
+	}
+	if limits.MaxStorageBytes > 0 {
+		if u.StorageBytes, err = h.StorageUsed(userID); err != nil {
+			return fmt.Errorf("measure storage: %w", err)
+		}
+	}
+	if err := limits.AllowSession(u); err != nil {
+		return err
+	}
+	running[userID]++
+	return nil
+}
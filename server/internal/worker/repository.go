@@ -38,6 +38,13 @@ func (r *Repository) FindOnlineByUserID(userID uuid.UUID) ([]Worker, error) {
 	return workers, err
 }
 
+// CountByUserID returns how many workers a user has registered.
+func (r *Repository) CountByUserID(userID uuid.UUID) (int, error) {
+	var n int64
+	err := r.db.Model(&Worker{}).Where("user_id = ?", userID).Count(&n).Error
+	return int(n), err
+}
+
 // SelectWorker picks the least-loaded online worker for a user.
 func (r *Repository) SelectWorker(userID uuid.UUID) (*Worker, error) {
 	var w Worker
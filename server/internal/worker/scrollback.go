@@ -1,44 +1,453 @@
 package worker
 
-import "sync"
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/moltty/server/internal/vt"
+)
 
 const DefaultScrollbackSize = 1024 * 1024 // 1MB
 
-// ScrollbackBuffer is a bounded, thread-safe ring buffer that stores recent terminal output.
+// scrollbackChunkSize is the size of the fixed chunks output is stored in.
+const scrollbackChunkSize = 16 * 1024
+
+// minMarkInterval is the smallest distance between eviction points; marks are
+// spaced at roughly 1/16 of the buffer so eviction never drops more than a small
+// fraction.
+const minMarkInterval = 4 * 1024
+
+// scrollbackChunk is a fixed-size block of output. Bytes below n are never
+// modified while the chunk is referenced, so views can share them without copying.
+// Chunks are reference counted and recycled once the buffer and all views have
+// released them.
+type scrollbackChunk struct {
+	data [scrollbackChunkSize]byte
+	n    int // guarded by the owning buffer's mu
+	refs atomic.Int32
+}
+
+var chunkPool = sync.Pool{New: func() any { return new(scrollbackChunk) }}
+
+func newScrollbackChunk() *scrollbackChunk {
+	c := chunkPool.Get().(*scrollbackChunk)
+	c.n = 0
+	c.refs.Store(1)
+	return c
+}
+
+func (c *scrollbackChunk) retain() { c.refs.Add(1) }
+
+func (c *scrollbackChunk) release() {
+	if c.refs.Add(-1) == 0 {
+		chunkPool.Put(c)
+	}
+}
+
+// scrollbackMark is a safe eviction point: outside any escape sequence or
+// multi-byte character, normally at the start of a line.
+type scrollbackMark struct {
+	off  int64
+	attr vt.Attr // SGR attributes in effect at off
+}
+
+// ScrollbackBuffer is a bounded, thread-safe ring of fixed-size chunks that stores
+// recent terminal output. Appends copy into preallocated chunks, and views and
+// readers share chunks instead of copying the buffer.
+//
+// Offsets are absolute positions in the session's output stream. The oldest data is
+// only evicted at safe marks, so the retained bytes never begin inside a UTF-8
+// character or escape sequence, and replay starts with the text attributes that
+// were active at the cut.
 type ScrollbackBuffer struct {
-	mu      sync.Mutex
-	buf     []byte
-	maxSize int
+	mu        sync.Mutex
+	chunks    []*scrollbackChunk
+	base      int64 // offset of chunks[0].data[0]
+	start     int64 // offset of the oldest retained byte
+	end       int64 // offset just past the newest byte
+	startAttr vt.Attr
+
+	marks        []scrollbackMark
+	sinceMark    int
+	maxSize      int
+	markInterval int
+
+	parser vt.Parser
+	sgr    sgrTracker
 }
 
 func NewScrollbackBuffer(maxSize int) *ScrollbackBuffer {
 	if maxSize <= 0 {
 		maxSize = DefaultScrollbackSize
 	}
+	interval := max(maxSize/16, minMarkInterval)
 	return &ScrollbackBuffer{
-		buf:     make([]byte, 0, maxSize),
-		maxSize: maxSize,
+		chunks:       make([]*scrollbackChunk, 0, maxSize/scrollbackChunkSize+3),
+		marks:        make([]scrollbackMark, 0, maxSize/interval+3),
+		maxSize:      maxSize,
+		markInterval: interval,
 	}
 }
 
-// Write appends data to the buffer, truncating from the front if over max size.
+// Write appends data to the buffer, evicting the oldest data if over max size.
 func (sb *ScrollbackBuffer) Write(p []byte) {
 	sb.mu.Lock()
 	defer sb.mu.Unlock()
+	sb.write(p)
+}
 
-	sb.buf = append(sb.buf, p...)
-	if len(sb.buf) > sb.maxSize {
-		// Keep only the last maxSize bytes
-		sb.buf = sb.buf[len(sb.buf)-sb.maxSize:]
+func (sb *ScrollbackBuffer) write(p []byte) {
+	sb.scan(p)
+
+	for len(p) > 0 {
+		tail := sb.tail()
+		n := copy(tail.data[tail.n:], p)
+		tail.n += n
+		p = p[n:]
+		sb.end += int64(n)
 	}
+
+	sb.evict()
 }
 
-// Bytes returns a snapshot of the current buffer contents.
-func (sb *ScrollbackBuffer) Bytes() []byte {
+// scan tracks SGR state through p and records eviction marks. Only escape
+// sequences go through the parser; text between them is skipped in bulk.
+func (sb *ScrollbackBuffer) scan(p []byte) {
+	for i := 0; i < len(p); {
+		if !sb.parser.Ground() {
+			n := sb.parser.Advance(p[i:], &sb.sgr)
+			i += n
+			sb.sinceMark += n
+			if sb.parser.Ground() && sb.sinceMark >= 2*sb.markInterval {
+				sb.mark(i)
+			}
+			continue
+		}
+		j := bytes.IndexByte(p[i:], 0x1b)
+		if j < 0 {
+			j = len(p) - i
+		}
+		sb.scanText(p, i, i+j)
+		i += j
+		if i < len(p) {
+			sb.parser.Feed(p[i:i+1], &sb.sgr)
+			i++
+			sb.sinceMark++
+		}
+	}
+}
+
+// scanText records marks within the escape-free text p[from:to]. Marks prefer
+// line starts; when output runs long without a newline (e.g. full-screen apps)
+// any character boundary is used.
+func (sb *ScrollbackBuffer) scanText(p []byte, from, to int) {
+	for i := from; i < to; {
+		if sb.sinceMark < sb.markInterval {
+			n := min(sb.markInterval-sb.sinceMark, to-i)
+			i += n
+			sb.sinceMark += n
+			continue
+		}
+		limit := min(max(2*sb.markInterval-sb.sinceMark, 0), to-i)
+		if k := bytes.IndexByte(p[i:i+limit], '\n'); k >= 0 {
+			i += k + 1
+			sb.sinceMark += k + 1
+			sb.mark(i)
+			continue
+		}
+		i += limit
+		sb.sinceMark += limit
+		if sb.sinceMark < 2*sb.markInterval {
+			continue
+		}
+		for i < to && !utf8.RuneStart(p[i]) {
+			i++
+			sb.sinceMark++
+		}
+		if i == to {
+			return
+		}
+		sb.mark(i)
+	}
+}
+
+// mark records an eviction point at index i of the data being written.
+func (sb *ScrollbackBuffer) mark(i int) {
+	sb.marks = append(sb.marks, scrollbackMark{off: sb.end + int64(i), attr: sb.sgr.attr})
+	sb.sinceMark = 0
+}
+
+// tail returns the chunk to append to, starting a new one when the last is full.
+func (sb *ScrollbackBuffer) tail() *scrollbackChunk {
+	if n := len(sb.chunks); n > 0 && sb.chunks[n-1].n < scrollbackChunkSize {
+		return sb.chunks[n-1]
+	}
+	c := newScrollbackChunk()
+	sb.chunks = append(sb.chunks, c)
+	return c
+}
+
+func (sb *ScrollbackBuffer) evict() {
+	if sb.end-sb.start <= int64(sb.maxSize) {
+		return
+	}
+
+	// Advance to the oldest mark that brings the size within bounds.
+	i := 0
+	for i < len(sb.marks) && sb.end-sb.marks[i].off > int64(sb.maxSize) {
+		i++
+	}
+	if i < len(sb.marks) && sb.marks[i].off > sb.start {
+		sb.start = sb.marks[i].off
+		sb.startAttr = sb.marks[i].attr
+		i++
+	} else if sb.end-sb.start > 2*int64(sb.maxSize) {
+		// No safe point for a long time (e.g. an unterminated string sequence);
+		// cut anyway rather than grow without bound.
+		sb.start = sb.end - int64(sb.maxSize)
+		sb.startAttr = sb.sgr.attr
+	}
+	n := copy(sb.marks, sb.marks[i:])
+	sb.marks = sb.marks[:n]
+
+	// Release chunks that lie entirely before the start.
+	drop := 0
+	for drop < len(sb.chunks)-1 && sb.base+scrollbackChunkSize <= sb.start {
+		sb.chunks[drop].release()
+		sb.base += scrollbackChunkSize
+		drop++
+	}
+	if drop > 0 {
+		n := copy(sb.chunks, sb.chunks[drop:])
+		clear(sb.chunks[n:])
+		sb.chunks = sb.chunks[:n]
+	}
+}
+
+// Len returns the number of bytes retained.
+func (sb *ScrollbackBuffer) Len() int {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return int(sb.end - sb.start)
+}
+
+// MemSize returns the memory held by the buffer's chunks in bytes.
+func (sb *ScrollbackBuffer) MemSize() int {
 	sb.mu.Lock()
 	defer sb.mu.Unlock()
+	return len(sb.chunks) * scrollbackChunkSize
+}
+
+// Offsets returns the stream offsets of the oldest retained byte and of the end of
+// the buffer.
+func (sb *ScrollbackBuffer) Offsets() (start, end int64) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.start, sb.end
+}
+
+// Bytes returns a copy of the current buffer contents, prefixed with the SGR
+// sequence needed to restore the attributes in effect at its start.
+func (sb *ScrollbackBuffer) Bytes() []byte {
+	v := sb.View()
+	defer v.Release()
 
-	out := make([]byte, len(sb.buf))
-	copy(out, sb.buf)
+	out := make([]byte, 0, v.Len())
+	for _, part := range v.parts {
+		out = append(out, part...)
+	}
 	return out
 }
+
+// View returns a read-only view of the current contents that shares the buffer's
+// chunks. Callers must Release it when done.
+func (sb *ScrollbackBuffer) View() *ScrollbackView {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	v := &ScrollbackView{}
+	if sb.end == sb.start {
+		return v
+	}
+	if !sb.startAttr.IsDefault() {
+		v.parts = append(v.parts, []byte(sb.startAttr.SGR()))
+	}
+	sb.appendRange(v, sb.start, sb.end)
+	return v
+}
+
+// appendRange adds the bytes in [from, to) to v. Callers must hold sb.mu.
+func (sb *ScrollbackBuffer) appendRange(v *ScrollbackView, from, to int64) {
+	for off := from; off < to; {
+		rel := off - sb.base
+		c := sb.chunks[rel/scrollbackChunkSize]
+		lo := int(rel % scrollbackChunkSize)
+		hi := min(c.n, lo+int(to-off))
+		c.retain()
+		v.chunks = append(v.chunks, c)
+		v.parts = append(v.parts, c.data[lo:hi:hi])
+		off += int64(hi - lo)
+	}
+}
+
+// Replace discards the buffer contents and loads data, keeping at most maxSize
+// bytes starting at a line boundary. Stream offsets restart from zero.
+func (sb *ScrollbackBuffer) Replace(data []byte) {
+	sb.ReplaceAt(data, int64(len(data)))
+}
+
+// ReplaceAt is like Replace, but places data so that it ends at stream offset end.
+// Use it when reloading output that was saved elsewhere, so that readers' offsets
+// stay valid.
+func (sb *ScrollbackBuffer) ReplaceAt(data []byte, end int64) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	if len(data) > sb.maxSize {
+		data = SafeStart(data[len(data)-sb.maxSize:])
+	}
+	for _, c := range sb.chunks {
+		c.release()
+	}
+	clear(sb.chunks)
+	sb.chunks = sb.chunks[:0]
+	sb.marks = sb.marks[:0]
+	start := max(end-int64(len(data)), 0)
+	sb.base, sb.start, sb.end = start, start, start
+	sb.startAttr = vt.Attr{}
+	sb.sinceMark = 0
+	sb.parser = vt.Parser{}
+	sb.sgr = sgrTracker{}
+	sb.write(data)
+}
+
+// NewReader returns a reader positioned at the oldest retained byte.
+func (sb *ScrollbackBuffer) NewReader() *ScrollbackReader {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return &ScrollbackReader{sb: sb, off: sb.start}
+}
+
+// NewReaderAt returns a reader positioned at stream offset off.
+func (sb *ScrollbackBuffer) NewReaderAt(off int64) *ScrollbackReader {
+	return &ScrollbackReader{sb: sb, off: off}
+}
+
+// ScrollbackView is a read-only view of a buffer's contents. Its parts reference
+// the buffer's chunks and stay valid until Release.
+type ScrollbackView struct {
+	parts  [][]byte
+	chunks []*scrollbackChunk
+}
+
+// Len returns the total number of bytes in the view.
+func (v *ScrollbackView) Len() int {
+	n := 0
+	for _, p := range v.parts {
+		n += len(p)
+	}
+	return n
+}
+
+// WriteTo writes the view's contents to w without copying them first.
+func (v *ScrollbackView) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for _, p := range v.parts {
+		n, err := w.Write(p)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Release returns the view's chunks to the buffer. The view must not be used
+// afterwards.
+func (v *ScrollbackView) Release() {
+	for _, c := range v.chunks {
+		c.release()
+	}
+	v.chunks = nil
+	v.parts = nil
+}
+
+// ScrollbackReader reads a buffer's output stream from a cursor. If the cursor
+// falls behind the oldest retained byte, reading resumes at the oldest byte.
+type ScrollbackReader struct {
+	sb  *ScrollbackBuffer
+	off int64
+}
+
+// Offset returns the stream offset of the next byte to be read.
+func (r *ScrollbackReader) Offset() int64 {
+	return r.off
+}
+
+// Read copies buffered output into p. It returns io.EOF once it has caught up with
+// the end of the buffer; later writes make more data available.
+func (r *ScrollbackReader) Read(p []byte) (int, error) {
+	sb := r.sb
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	if r.off < sb.start || r.off > sb.end {
+		r.off = sb.start
+	}
+	n := 0
+	for n < len(p) && r.off < sb.end {
+		rel := r.off - sb.base
+		c := sb.chunks[rel/scrollbackChunkSize]
+		lo := int(rel % scrollbackChunkSize)
+		m := copy(p[n:], c.data[lo:c.n])
+		n += m
+		r.off += int64(m)
+	}
+	if n == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// maxSafeStartScan bounds how far SafeStart looks for a line boundary.
+const maxSafeStartScan = 64 * 1024
+
+// SafeStart trims data that may have been cut at an arbitrary offset so that it
+// starts after the first newline, where no escape sequence or multi-byte character
+// can be in progress. Data without a nearby newline is returned unchanged.
+func SafeStart(data []byte) []byte {
+	scan := data[:min(len(data), maxSafeStartScan)]
+	if i := bytes.IndexByte(scan, '\n'); i >= 0 {
+		return data[i+1:]
+	}
+	return data
+}
+
+// sgrTracker follows the SGR attributes set by a stream of output.
+type sgrTracker struct {
+	attr vt.Attr
+}
+
+func (t *sgrTracker) Print(rune)      {}
+func (t *sgrTracker) Execute(byte)    {}
+func (t *sgrTracker) OSC(data []byte) {}
+
+func (t *sgrTracker) CSI(private byte, params [][]int, intermediates []byte, final byte) {
+	if final != 'm' || private != 0 || len(intermediates) != 0 {
+		return
+	}
+	if len(params) == 0 {
+		t.attr = vt.Attr{}
+		return
+	}
+	t.attr.ApplySGR(params)
+}
+
+func (t *sgrTracker) ESC(intermediates []byte, final byte) {
+	if final == 'c' && len(intermediates) == 0 {
+		t.attr = vt.Attr{}
+	}
+}
@@ -0,0 +1,402 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultSegmentSize is the size at which the disk store starts a new segment file.
+const DefaultSegmentSize = 256 * 1024
+
+// diskWriterIdle is how long a session's tail segment stays open without writes.
+const diskWriterIdle = time.Minute
+
+// trimmedFile holds the number of bytes trimmed from a session's history, in
+// decimal, so stream offsets survive trimming.
+const trimmedFile = "trimmed"
+
+// DiskStore is a ScrollbackStore that keeps each session's history in a directory of
+// append-only segment files (<dir>/<session-id>/<seq>.seg). Segments are never
+// rewritten; trimming deletes whole segments, oldest first.
+type DiskStore struct {
+	dir         string
+	segmentSize int64
+
+	// locks serialize the operations on each session's files. Sessions share a
+	// lock by the first byte of their ID.
+	locks [64]sync.Mutex
+
+	mu      sync.Mutex // guards writers
+	writers map[uuid.UUID]*segmentWriter
+}
+
+// segmentWriter is the open tail segment of a session.
+type segmentWriter struct {
+	file      *os.File
+	seq       int
+	size      int64
+	lastWrite time.Time
+}
+
+type segmentInfo struct {
+	seq     int
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func NewDiskStore(dir string, segmentSize int64) (*DiskStore, error) {
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("create scrollback dir: %w", err)
+	}
+	s := &DiskStore{
+		dir:         dir,
+		segmentSize: segmentSize,
+		writers:     make(map[uuid.UUID]*segmentWriter),
+	}
+	go func() {
+		ticker := time.NewTicker(diskWriterIdle)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.closeIdle(time.Now().Add(-diskWriterIdle))
+		}
+	}()
+	return s, nil
+}
+
+// closeIdle closes the tail segments that haven't been written since before. They
+// are reopened on the next Append.
+func (s *DiskStore) closeIdle(before time.Time) {
+	s.mu.Lock()
+	var idle []uuid.UUID
+	for sessionID, w := range s.writers {
+		if w.lastWrite.Before(before) {
+			idle = append(idle, sessionID)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, sessionID := range idle {
+		lock := s.lock(sessionID)
+		lock.Lock()
+		if w, ok := s.getWriter(sessionID); ok && w.lastWrite.Before(before) {
+			s.closeWriter(sessionID)
+		}
+		lock.Unlock()
+	}
+}
+
+// lock returns the lock that serializes operations on a session's files.
+func (s *DiskStore) lock(sessionID uuid.UUID) *sync.Mutex {
+	return &s.locks[int(sessionID[0])%len(s.locks)]
+}
+
+func (s *DiskStore) getWriter(sessionID uuid.UUID) (*segmentWriter, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.writers[sessionID]
+	return w, ok
+}
+
+// closeWriter closes a session's tail segment, if open. Callers must hold the
+// session's lock.
+func (s *DiskStore) closeWriter(sessionID uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w, ok := s.writers[sessionID]; ok {
+		w.file.Close()
+		delete(s.writers, sessionID)
+	}
+}
+
+func (s *DiskStore) sessionDir(sessionID uuid.UUID) string {
+	return filepath.Join(s.dir, sessionID.String())
+}
+
+func (s *DiskStore) Append(sessionID uuid.UUID, data []byte) error {
+	lock := s.lock(sessionID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	w, err := s.writer(sessionID)
+	if err != nil {
+		return err
+	}
+
+	if w.size > 0 && w.size+int64(len(data)) > s.segmentSize {
+		s.closeWriter(sessionID)
+		if w, err = s.openSegment(sessionID, w.seq+1); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.file.Write(data)
+	w.size += int64(n)
+	w.lastWrite = time.Now()
+	return err
+}
+
+// writer returns the open tail segment for a session, opening or creating it if needed.
+// Callers must hold the session's lock.
+func (s *DiskStore) writer(sessionID uuid.UUID) (*segmentWriter, error) {
+	if w, ok := s.getWriter(sessionID); ok {
+		return w, nil
+	}
+
+	segments, err := s.segments(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	seq := 0
+	if len(segments) > 0 {
+		seq = segments[len(segments)-1].seq
+	}
+	return s.openSegment(sessionID, seq)
+}
+
+// openSegment opens segment seq for appending. Callers must hold the session's lock.
+func (s *DiskStore) openSegment(sessionID uuid.UUID, seq int) (*segmentWriter, error) {
+	dir := s.sessionDir(sessionID)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, segmentName(seq)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	w := &segmentWriter{file: f, seq: seq, size: info.Size(), lastWrite: time.Now()}
+	s.mu.Lock()
+	s.writers[sessionID] = w
+	s.mu.Unlock()
+	return w, nil
+}
+
+// segments lists a session's segment files in order.
+func (s *DiskStore) segments(sessionID uuid.UUID) ([]segmentInfo, error) {
+	dir := s.sessionDir(sessionID)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []segmentInfo
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, ".seg") {
+			continue
+		}
+		seq, err := strconv.Atoi(strings.TrimSuffix(name, ".seg"))
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segmentInfo{
+			seq:     seq,
+			path:    filepath.Join(dir, name),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].seq < segments[j].seq })
+	return segments, nil
+}
+
+func (s *DiskStore) Load(sessionID uuid.UUID, limit int) ([]byte, int64, error) {
+	lock := s.lock(sessionID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	segments, err := s.segments(sessionID)
+	if err != nil {
+		return nil, 0, err
+	}
+	trimmed, err := s.trimmed(sessionID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Walk back from the newest segment until we have enough bytes.
+	var total int64
+	start := len(segments)
+	for start > 0 && total < int64(limit) {
+		start--
+		total += segments[start].size
+	}
+
+	out := make([]byte, 0, min(total, int64(limit)))
+	skip := total - int64(limit)
+	for _, seg := range segments[start:] {
+		data, err := readSegment(seg, max(skip, 0))
+		if err != nil {
+			return nil, 0, err
+		}
+		skip -= seg.size
+		out = append(out, data...)
+	}
+
+	var stored int64
+	for _, seg := range segments {
+		stored += seg.size
+	}
+	return out, trimmed + stored - int64(len(out)), nil
+}
+
+// readSegment reads a segment file from offset to its end.
+func readSegment(seg segmentInfo, offset int64) ([]byte, error) {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if offset > 0 {
+		if _, err := f.Seek(min(offset, seg.size), io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return io.ReadAll(f)
+}
+
+// trimmed returns the number of bytes trimmed from a session's history. Callers
+// must hold the session's lock.
+func (s *DiskStore) trimmed(sessionID uuid.UUID) (int64, error) {
+	data, err := os.ReadFile(filepath.Join(s.sessionDir(sessionID), trimmedFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// setTrimmed records the number of bytes trimmed from a session's history.
+// Callers must hold the session's lock.
+func (s *DiskStore) setTrimmed(sessionID uuid.UUID, n int64) error {
+	path := filepath.Join(s.sessionDir(sessionID), trimmedFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(n, 10)), 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *DiskStore) Trim(sessionID uuid.UUID, maxBytes int64, maxAge time.Duration) error {
+	lock := s.lock(sessionID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	segments, err := s.segments(sessionID)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, seg := range segments {
+		total += seg.size
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	drop := 0
+	var dropped int64
+	for _, seg := range segments {
+		overSize := maxBytes > 0 && total > maxBytes
+		tooOld := maxAge > 0 && seg.modTime.Before(cutoff)
+		if !overSize && !tooOld {
+			break
+		}
+		total -= seg.size
+		dropped += seg.size
+		drop++
+	}
+	if drop == 0 {
+		return nil
+	}
+
+	// Count the bytes first: if removing the segments fails part way, offsets
+	// jump ahead rather than going back.
+	trimmed, err := s.trimmed(sessionID)
+	if err != nil {
+		return err
+	}
+	if err := s.setTrimmed(sessionID, trimmed+dropped); err != nil {
+		return err
+	}
+	for _, seg := range segments[:drop] {
+		if w, ok := s.getWriter(sessionID); ok && w.seq == seg.seq {
+			s.closeWriter(sessionID)
+		}
+		if err := os.Remove(seg.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *DiskStore) Size(sessionID uuid.UUID) (int64, error) {
+	lock := s.lock(sessionID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	segments, err := s.segments(sessionID)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, seg := range segments {
+		total += seg.size
+	}
+	return total, nil
+}
+
+func (s *DiskStore) Sessions() ([]uuid.UUID, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uuid.UUID
+	for _, e := range entries {
+		if id, err := uuid.Parse(e.Name()); err == nil && e.IsDir() {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (s *DiskStore) Delete(sessionID uuid.UUID) error {
+	lock := s.lock(sessionID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	s.closeWriter(sessionID)
+	return os.RemoveAll(s.sessionDir(sessionID))
+}
+
+func segmentName(seq int) string {
+	return fmt.Sprintf("%016d.seg", seq)
+}
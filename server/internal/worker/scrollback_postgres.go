@@ -0,0 +1,212 @@
+package worker
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// postgresFlushSize is how much output is buffered per session before it is written.
+const postgresFlushSize = 32 * 1024
+
+// ScrollbackChunk is a row of persisted session output.
+type ScrollbackChunk struct {
+	ID        int64     `gorm:"primaryKey;autoIncrement"`
+	SessionID uuid.UUID `gorm:"type:uuid;index:idx_scrollback_session_id;not null"`
+	Data      []byte    `gorm:"type:bytea;not null"`
+	Size      int       `gorm:"not null"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// ScrollbackTrim counts the bytes trimmed from a session's persisted output, so
+// stream offsets survive trimming.
+type ScrollbackTrim struct {
+	SessionID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Trimmed   int64     `gorm:"not null;default:0"`
+}
+
+// PostgresStore is a ScrollbackStore backed by the scrollback_chunks table. Output is
+// buffered per session and written in batches, either when postgresFlushSize is
+// reached or on the periodic flush.
+type PostgresStore struct {
+	db *gorm.DB
+
+	mu      sync.Mutex
+	pending map[uuid.UUID][]byte
+
+	// flushLocks serialize the flushes of each session, so that its chunks are
+	// inserted in the order they were taken from pending. Sessions share a lock by
+	// the first byte of their ID.
+	flushLocks [64]sync.Mutex
+}
+
+func NewPostgresStore(db *gorm.DB, flushInterval time.Duration) *PostgresStore {
+	s := &PostgresStore{
+		db:      db,
+		pending: make(map[uuid.UUID][]byte),
+	}
+	go func() {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.flushAll()
+		}
+	}()
+	return s
+}
+
+func (s *PostgresStore) Append(sessionID uuid.UUID, data []byte) error {
+	s.mu.Lock()
+	buf := append(s.pending[sessionID], data...)
+	s.pending[sessionID] = buf
+	s.mu.Unlock()
+
+	if len(buf) < postgresFlushSize {
+		return nil
+	}
+	return s.flush(sessionID)
+}
+
+func (s *PostgresStore) insert(sessionID uuid.UUID, data []byte) error {
+	return s.db.Create(&ScrollbackChunk{SessionID: sessionID, Data: data, Size: len(data)}).Error
+}
+
+func (s *PostgresStore) flushAll() {
+	s.mu.Lock()
+	sessions := make([]uuid.UUID, 0, len(s.pending))
+	for sessionID := range s.pending {
+		sessions = append(sessions, sessionID)
+	}
+	s.mu.Unlock()
+
+	for _, sessionID := range sessions {
+		if err := s.flush(sessionID); err != nil {
+			log.Printf("scrollback: failed to flush session %s: %v", sessionID, err)
+		}
+	}
+}
+
+// flushLock returns the lock that serializes writes of a session's chunks.
+func (s *PostgresStore) flushLock(sessionID uuid.UUID) *sync.Mutex {
+	return &s.flushLocks[int(sessionID[0])%len(s.flushLocks)]
+}
+
+// flush writes any buffered output for one session.
+func (s *PostgresStore) flush(sessionID uuid.UUID) error {
+	lock := s.flushLock(sessionID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	s.mu.Lock()
+	data, ok := s.pending[sessionID]
+	delete(s.pending, sessionID)
+	s.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return s.insert(sessionID, data)
+}
+
+func (s *PostgresStore) Load(sessionID uuid.UUID, limit int) ([]byte, int64, error) {
+	if err := s.flush(sessionID); err != nil {
+		return nil, 0, err
+	}
+
+	// Newest chunks until the running total covers the limit, with the stream
+	// offset at the end of the history, all from one snapshot.
+	var chunks []struct {
+		Data []byte
+		End  int64
+	}
+	err := s.db.Raw(`
+		SELECT data, end_offset AS "end" FROM (
+			SELECT id, data, SUM(size) OVER (ORDER BY id DESC) - size AS newer,
+				SUM(size) OVER () + COALESCE((SELECT trimmed FROM scrollback_trims WHERE session_id = ?), 0) AS end_offset
+			FROM scrollback_chunks WHERE session_id = ?
+		) t WHERE newer < ? ORDER BY id ASC`, sessionID, sessionID, limit).Scan(&chunks).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(chunks) == 0 {
+		var trim ScrollbackTrim
+		err := s.db.Where("session_id = ?", sessionID).Limit(1).Find(&trim).Error
+		return nil, trim.Trimmed, err
+	}
+
+	var out []byte
+	for _, c := range chunks {
+		out = append(out, c.Data...)
+	}
+	if len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out, chunks[0].End - int64(len(out)), nil
+}
+
+// trimQuery deletes a session's chunks selected by the inner query and adds their
+// size to the session's trimmed count, in one statement.
+const trimQuery = `
+	WITH dropped AS (
+		DELETE FROM scrollback_chunks WHERE id IN (%s) RETURNING size
+	)
+	INSERT INTO scrollback_trims (session_id, trimmed)
+	SELECT ?, SUM(size) FROM dropped HAVING COUNT(*) > 0
+	ON CONFLICT (session_id) DO UPDATE SET trimmed = scrollback_trims.trimmed + EXCLUDED.trimmed`
+
+func (s *PostgresStore) Trim(sessionID uuid.UUID, maxBytes int64, maxAge time.Duration) error {
+	if maxAge > 0 {
+		err := s.db.Exec(fmt.Sprintf(trimQuery,
+			`SELECT id FROM scrollback_chunks WHERE session_id = ? AND created_at < ?`),
+			sessionID, time.Now().Add(-maxAge), sessionID).Error
+		if err != nil {
+			return err
+		}
+	}
+	if maxBytes > 0 {
+		return s.db.Exec(fmt.Sprintf(trimQuery, `
+			SELECT id FROM (
+				SELECT id, SUM(size) OVER (ORDER BY id DESC) AS total
+				FROM scrollback_chunks WHERE session_id = ?
+			) t WHERE total > ?`), sessionID, maxBytes, sessionID).Error
+	}
+	return nil
+}
+
+func (s *PostgresStore) Size(sessionID uuid.UUID) (int64, error) {
+	var total int64
+	err := s.db.Model(&ScrollbackChunk{}).Where("session_id = ?", sessionID).
+		Select("COALESCE(SUM(size), 0)").Scan(&total).Error
+
+	s.mu.Lock()
+	total += int64(len(s.pending[sessionID]))
+	s.mu.Unlock()
+	return total, err
+}
+
+func (s *PostgresStore) Sessions() ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := s.db.Model(&ScrollbackChunk{}).Distinct("session_id").Pluck("session_id", &ids).Error
+	return ids, err
+}
+
+func (s *PostgresStore) Delete(sessionID uuid.UUID) error {
+	lock := s.flushLock(sessionID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	s.mu.Lock()
+	delete(s.pending, sessionID)
+	s.mu.Unlock()
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("session_id = ?", sessionID).Delete(&ScrollbackChunk{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("session_id = ?", sessionID).Delete(&ScrollbackTrim{}).Error
+	})
+}
@@ -0,0 +1,243 @@
+package worker
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxHistorySize caps how much persistent history is loaded for a single search.
+const MaxHistorySize = 16 * 1024 * 1024
+
+// ScrollbackStore persists session output beyond the in-memory ScrollbackBuffer so
+// history survives server restarts. Stream offsets count every byte ever appended
+// to a session, including bytes trimmed since, so they never move backwards.
+type ScrollbackStore interface {
+	// Append adds output to the end of a session's history.
+	Append(sessionID uuid.UUID, data []byte) error
+	// Load returns up to the last limit bytes of a session's history and the stream
+	// offset they start at.
+	Load(sessionID uuid.UUID, limit int) ([]byte, int64, error)
+	// Trim drops the oldest history beyond maxBytes, and history older than maxAge.
+	// Zero values disable the corresponding limit.
+	Trim(sessionID uuid.UUID, maxBytes int64, maxAge time.Duration) error
+	// Size returns the number of bytes stored for a session.
+	Size(sessionID uuid.UUID) (int64, error)
+	// Sessions lists every session with stored history.
+	Sessions() ([]uuid.UUID, error)
+	// Delete removes a session's history.
+	Delete(sessionID uuid.UUID) error
+}
+
+// MemoryStore is a ScrollbackStore that keeps history in process memory. It does not
+// survive restarts and is mainly useful for development.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[uuid.UUID]*memorySession
+}
+
+type memorySession struct {
+	chunks  []memoryChunk
+	trimmed int64 // bytes dropped from the front
+}
+
+type memoryChunk struct {
+	data []byte
+	at   time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[uuid.UUID]*memorySession)}
+}
+
+func (s *MemoryStore) Append(sessionID uuid.UUID, data []byte) error {
+	chunk := memoryChunk{data: append([]byte(nil), data...), at: time.Now()}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ms, ok := s.sessions[sessionID]
+	if !ok {
+		ms = &memorySession{}
+		s.sessions[sessionID] = ms
+	}
+	ms.chunks = append(ms.chunks, chunk)
+	return nil
+}
+
+func (s *MemoryStore) Load(sessionID uuid.UUID, limit int) ([]byte, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ms, ok := s.sessions[sessionID]
+	if !ok {
+		return nil, 0, nil
+	}
+	var total int
+	start := len(ms.chunks)
+	for start > 0 && total < limit {
+		start--
+		total += len(ms.chunks[start].data)
+	}
+
+	out := make([]byte, 0, total)
+	for _, c := range ms.chunks[start:] {
+		out = append(out, c.data...)
+	}
+	if len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out, ms.trimmed + ms.size() - int64(len(out)), nil
+}
+
+func (s *MemoryStore) Trim(sessionID uuid.UUID, maxBytes int64, maxAge time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ms, ok := s.sessions[sessionID]
+	if !ok {
+		return nil
+	}
+	total := ms.size()
+	cutoff := time.Now().Add(-maxAge)
+	drop := 0
+	for drop < len(ms.chunks) {
+		c := ms.chunks[drop]
+		overSize := maxBytes > 0 && total > maxBytes
+		tooOld := maxAge > 0 && c.at.Before(cutoff)
+		if !overSize && !tooOld {
+			break
+		}
+		total -= int64(len(c.data))
+		ms.trimmed += int64(len(c.data))
+		drop++
+	}
+	if drop > 0 {
+		ms.chunks = append([]memoryChunk(nil), ms.chunks[drop:]...)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Size(sessionID uuid.UUID) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ms, ok := s.sessions[sessionID]; ok {
+		return ms.size(), nil
+	}
+	return 0, nil
+}
+
+func (s *MemoryStore) Sessions() ([]uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]uuid.UUID, 0, len(s.sessions))
+	for id := range s.sessions {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (s *MemoryStore) Delete(sessionID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sessionID)
+	return nil
+}
+
+// size returns the bytes held for the session. Callers must hold the store's lock.
+func (ms *memorySession) size() int64 {
+	var total int64
+	for _, c := range ms.chunks {
+		total += int64(len(c.data))
+	}
+	return total
+}
+
+// storeQueueSize is how many output chunks of a session can wait to be persisted
+// before its output backs up.
+const storeQueueSize = 1024
+
+// storeWriter persists one session's output in order on its own goroutine, so a
+// slow store holds up neither the relay nor its viewers.
+type storeWriter struct {
+	sessionID uuid.UUID
+	store     ScrollbackStore
+	queue     chan storeOp
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+type storeOp struct {
+	data    []byte
+	flushed chan struct{} // closed once everything queued before it is stored
+}
+
+func newStoreWriter(sessionID uuid.UUID, store ScrollbackStore) *storeWriter {
+	w := &storeWriter{
+		sessionID: sessionID,
+		store:     store,
+		queue:     make(chan storeOp, storeQueueSize),
+		done:      make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *storeWriter) run() {
+	for {
+		select {
+		case op := <-w.queue:
+			w.do(op)
+		case <-w.done:
+			// Store what was queued before the writer stopped.
+			for {
+				select {
+				case op := <-w.queue:
+					w.do(op)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *storeWriter) do(op storeOp) {
+	if op.flushed != nil {
+		close(op.flushed)
+		return
+	}
+	if err := w.store.Append(w.sessionID, op.data); err != nil {
+		log.Printf("hub: failed to persist output for session %s: %v", w.sessionID, err)
+	}
+}
+
+// write queues output to be persisted. It only waits while the queue is full.
+func (w *storeWriter) write(data []byte) {
+	select {
+	case w.queue <- storeOp{data: data}:
+	case <-w.done:
+	}
+}
+
+// flush waits until everything queued so far has been persisted.
+func (w *storeWriter) flush() {
+	flushed := make(chan struct{})
+	select {
+	case w.queue <- storeOp{flushed: flushed}:
+	case <-w.done:
+		return
+	}
+	select {
+	case <-flushed:
+	case <-w.done:
+	}
+}
+
+// stop ends the writer once the queued output is persisted.
+func (w *storeWriter) stop() {
+	w.stopOnce.Do(func() { close(w.done) })
+}
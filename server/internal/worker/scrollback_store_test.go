@@ -0,0 +1,239 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// newTestDiskStore returns a disk store with 10-byte segments holding
+// "aaaaaaaaaa", "bbbbb" and "ccccccc", one per segment.
+func newTestDiskStore(t *testing.T) (*DiskStore, uuid.UUID) {
+	t.Helper()
+	s, err := NewDiskStore(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := uuid.New()
+	for _, data := range []string{"aaaaaaaaaa", "bbbbb", "ccccccc"} {
+		if err := s.Append(id, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s, id
+}
+
+func TestDiskStoreSegments(t *testing.T) {
+	s, id := newTestDiskStore(t)
+
+	segments, err := s.segments(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sizes []int64
+	for _, seg := range segments {
+		sizes = append(sizes, seg.size)
+	}
+	if len(sizes) != 3 || sizes[0] != 10 || sizes[1] != 5 || sizes[2] != 7 {
+		t.Errorf("segment sizes = %v, want [10 5 7]", sizes)
+	}
+
+	// Appending to a closed tail segment reopens it.
+	s.closeIdle(time.Now().Add(time.Second))
+	if err := s.Append(id, []byte("dd")); err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := s.Size(id); size != 24 {
+		t.Errorf("Size() = %d, want 24", size)
+	}
+	if segments, _ := s.segments(id); len(segments) != 3 {
+		t.Errorf("got %d segments after reopening, want 3", len(segments))
+	}
+}
+
+func TestDiskStoreLoad(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int
+		want      string
+		wantStart int64
+	}{
+		{name: "everything", limit: 100, want: "aaaaaaaaaabbbbbccccccc", wantStart: 0},
+		{name: "within the last segment", limit: 4, want: "cccc", wantStart: 18},
+		{name: "spanning two segments", limit: 9, want: "bbccccccc", wantStart: 13},
+		{name: "spanning three segments", limit: 15, want: "aaabbbbbccccccc", wantStart: 7},
+	}
+	s, id := newTestDiskStore(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, start, err := s.Load(id, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want || start != tt.wantStart {
+				t.Errorf("Load(%d) = %q at %d, want %q at %d", tt.limit, data, start, tt.want, tt.wantStart)
+			}
+		})
+	}
+}
+
+func TestDiskStoreTrim(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxBytes  int64
+		maxAge    time.Duration
+		old       int // segments to backdate by two hours
+		want      string
+		wantStart int64
+	}{
+		{name: "within limits", maxBytes: 100, want: "aaaaaaaaaabbbbbccccccc", wantStart: 0},
+		{name: "by size", maxBytes: 12, want: "bbbbbccccccc", wantStart: 10},
+		{name: "by size including the tail", maxBytes: 5, want: "", wantStart: 22},
+		{name: "by age", maxAge: time.Hour, old: 2, want: "ccccccc", wantStart: 15},
+		{name: "nothing old enough", maxAge: time.Hour, want: "aaaaaaaaaabbbbbccccccc", wantStart: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, id := newTestDiskStore(t)
+			segments, _ := s.segments(id)
+			past := time.Now().Add(-2 * time.Hour)
+			for _, seg := range segments[:tt.old] {
+				if err := os.Chtimes(seg.path, past, past); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := s.Trim(id, tt.maxBytes, tt.maxAge); err != nil {
+				t.Fatal(err)
+			}
+			data, start, err := s.Load(id, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want || start != tt.wantStart {
+				t.Errorf("after Trim, Load() = %q at %d, want %q at %d", data, start, tt.want, tt.wantStart)
+			}
+			if size, _ := s.Size(id); size != int64(len(tt.want)) {
+				t.Errorf("Size() = %d, want %d", size, len(tt.want))
+			}
+
+			// Offsets carry on from the trimmed history, also after a restart.
+			if err := s.Append(id, []byte("dd")); err != nil {
+				t.Fatal(err)
+			}
+			reopened, err := NewDiskStore(s.dir, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, start, err = reopened.Load(id, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "dd" || start != 22 {
+				t.Errorf("after reopening, Load(2) = %q at %d, want \"dd\" at 22", data, start)
+			}
+		})
+	}
+}
+
+func TestDiskStoreDelete(t *testing.T) {
+	s, id := newTestDiskStore(t)
+	if err := s.Trim(id, 12, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(s.dir, id.String())); !os.IsNotExist(err) {
+		t.Errorf("session directory still exists: %v", err)
+	}
+	data, start, err := s.Load(id, 100)
+	if err != nil || len(data) != 0 || start != 0 {
+		t.Errorf("Load() after Delete = %q at %d, %v; want nothing at 0", data, start, err)
+	}
+	ids, err := s.Sessions()
+	if err != nil || len(ids) != 0 {
+		t.Errorf("Sessions() after Delete = %v, %v; want none", ids, err)
+	}
+
+	// A deleted session starts over.
+	if err := s.Append(id, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if data, start, _ := s.Load(id, 100); string(data) != "new" || start != 0 {
+		t.Errorf("Load() = %q at %d, want \"new\" at 0", data, start)
+	}
+}
+
+func TestMemoryStoreTrimKeepsOffsets(t *testing.T) {
+	s := NewMemoryStore()
+	id := uuid.New()
+	for _, data := range []string{"aaaa", "bbb", "cc"} {
+		s.Append(id, []byte(data))
+	}
+
+	tests := []struct {
+		maxBytes  int64
+		want      string
+		wantStart int64
+	}{
+		{maxBytes: 9, want: "aaaabbbcc", wantStart: 0},
+		{maxBytes: 5, want: "bbbcc", wantStart: 4},
+		{maxBytes: 1, want: "", wantStart: 9},
+	}
+	for _, tt := range tests {
+		if err := s.Trim(id, tt.maxBytes, 0); err != nil {
+			t.Fatal(err)
+		}
+		data, start, _ := s.Load(id, 100)
+		if string(data) != tt.want || start != tt.wantStart {
+			t.Errorf("Trim(%d): Load() = %q at %d, want %q at %d", tt.maxBytes, data, start, tt.want, tt.wantStart)
+		}
+	}
+}
+
+// gatedStore is a MemoryStore whose appends wait on gate.
+type gatedStore struct {
+	*MemoryStore
+	gate chan struct{}
+}
+
+func (s *gatedStore) Append(sessionID uuid.UUID, data []byte) error {
+	<-s.gate
+	return s.MemoryStore.Append(sessionID, data)
+}
+
+func TestStoreWriter(t *testing.T) {
+	store := &gatedStore{MemoryStore: NewMemoryStore(), gate: make(chan struct{})}
+	id := uuid.New()
+	w := newStoreWriter(id, store)
+
+	// Writes don't wait for the store.
+	chunks := []string{"one ", "two ", "three"}
+	for _, c := range chunks {
+		w.write([]byte(c))
+	}
+	if size, _ := store.Size(id); size != 0 {
+		t.Fatalf("stored %d bytes before the store accepted anything", size)
+	}
+
+	close(store.gate)
+	w.flush()
+	if data, _, _ := store.Load(id, 100); string(data) != "one two three" {
+		t.Errorf("after flush, stored %q, want \"one two three\"", data)
+	}
+
+	// Output queued before stop is still stored.
+	store.gate = make(chan struct{})
+	w.write([]byte(" four"))
+	w.stop()
+	close(store.gate)
+	waitFor(t, func() bool {
+		data, _, _ := store.Load(id, 100)
+		return string(data) == "one two three four"
+	})
+}
@@ -0,0 +1,274 @@
+package worker
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestScrollbackBufferEviction(t *testing.T) {
+	const maxSize = 16 * 1024
+	tests := []struct {
+		name      string
+		write     []byte
+		chunk     int // bytes per Write
+		wantStart bool
+		prefix    string // expected start of the retained bytes
+		maxLen    int    // defaults to maxSize
+	}{
+		{
+			name:   "under the limit",
+			write:  bytes.Repeat([]byte("line\n"), 100),
+			chunk:  64,
+			prefix: "line\n",
+		},
+		{
+			name:      "cut at a line start",
+			write:     []byte(strings.Repeat("0123456789abcdef0123456789abcdef\n", 4096)),
+			chunk:     1000,
+			wantStart: true,
+			prefix:    "0123456789abcdef",
+		},
+		{
+			name:      "restores attributes at the cut",
+			write:     []byte("\x1b[1m" + strings.Repeat("bold text on a long line\n", 4096)),
+			chunk:     4096,
+			wantStart: true,
+			prefix:    "\x1b[0;1mbold text",
+		},
+		{
+			name:      "no newlines",
+			write:     bytes.Repeat([]byte("✔ü"), 40000),
+			chunk:     333,
+			wantStart: true,
+		},
+		{
+			name:      "unterminated string sequence",
+			write:     append([]byte("\x1b]0;"), bytes.Repeat([]byte("x"), 4*maxSize)...),
+			chunk:     1024,
+			wantStart: true,
+			maxLen:    2 * maxSize, // cut without a safe point only past twice the size
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sb := NewScrollbackBuffer(maxSize)
+			for p := tt.write; len(p) > 0; {
+				n := min(tt.chunk, len(p))
+				sb.Write(p[:n])
+				p = p[n:]
+			}
+
+			start, end := sb.Offsets()
+			if end != int64(len(tt.write)) {
+				t.Errorf("end = %d, want %d", end, len(tt.write))
+			}
+			if got := start > 0; got != tt.wantStart {
+				t.Errorf("start = %d, want evicted %v", start, tt.wantStart)
+			}
+			maxLen := tt.maxLen
+			if maxLen == 0 {
+				maxLen = maxSize
+			}
+			if n := sb.Len(); n > maxLen || int64(n) != end-start {
+				t.Errorf("Len() = %d with offsets [%d, %d), max %d", n, start, end, maxLen)
+			}
+			data := sb.Bytes()
+			if !bytes.HasPrefix(data, []byte(tt.prefix)) {
+				t.Errorf("Bytes() starts with %q, want %q", data[:min(len(data), 32)], tt.prefix)
+			}
+			if !utf8.Valid(data) {
+				t.Errorf("Bytes() is not valid UTF-8")
+			}
+			if !bytes.HasSuffix(tt.write, data[len(data)-sb.Len():]) {
+				t.Errorf("Bytes() is not the tail of the output")
+			}
+		})
+	}
+}
+
+func TestScrollbackBufferReplaceAt(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		end       int64
+		wantStart int64
+		wantEnd   int64
+	}{
+		{"at stream start", "hello", 5, 0, 5},
+		{"later in the stream", "hello", 100, 95, 100},
+		{"end before the data", "hello", 3, 0, 5},
+		{"empty", "", 42, 42, 42},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sb := NewScrollbackBuffer(1024)
+			sb.Write([]byte("previous output\n"))
+			sb.ReplaceAt([]byte(tt.data), tt.end)
+
+			start, end := sb.Offsets()
+			if start != tt.wantStart || end != tt.wantEnd {
+				t.Errorf("Offsets() = %d, %d, want %d, %d", start, end, tt.wantStart, tt.wantEnd)
+			}
+			if got := string(sb.Bytes()); got != tt.data {
+				t.Errorf("Bytes() = %q, want %q", got, tt.data)
+			}
+
+			sb.Write([]byte("more"))
+			if _, end := sb.Offsets(); end != tt.wantEnd+4 {
+				t.Errorf("end after write = %d, want %d", end, tt.wantEnd+4)
+			}
+		})
+	}
+}
+
+func TestScrollbackReader(t *testing.T) {
+	sb := NewScrollbackBuffer(16 * 1024)
+	line := []byte(strings.Repeat("x", 63) + "\n")
+	for range 1024 {
+		sb.Write(line)
+	}
+	start, end := sb.Offsets()
+
+	tests := []struct {
+		name     string
+		from     int64
+		wantFrom int64
+	}{
+		{"from the oldest byte", start, start},
+		{"from the middle", end - 100, end - 100},
+		{"behind the buffer", 0, start},
+		{"past the end", end + 10, start},
+		{"at the end", end, end},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := sb.NewReaderAt(tt.from)
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := int(end - tt.wantFrom); len(got) != want {
+				t.Errorf("read %d bytes, want %d", len(got), want)
+			}
+			if r.Offset() != end {
+				t.Errorf("Offset() = %d, want %d", r.Offset(), end)
+			}
+		})
+	}
+}
+
+// naiveBuffer is the original append-and-reslice scrollback buffer, kept as the
+// baseline for the benchmarks.
+type naiveBuffer struct {
+	mu      sync.Mutex
+	buf     []byte
+	maxSize int
+}
+
+func newNaiveBuffer(maxSize int) *naiveBuffer {
+	return &naiveBuffer{
+		buf:     make([]byte, 0, maxSize),
+		maxSize: maxSize,
+	}
+}
+
+func (sb *naiveBuffer) Write(p []byte) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	sb.buf = append(sb.buf, p...)
+	if len(sb.buf) > sb.maxSize {
+		sb.buf = sb.buf[len(sb.buf)-sb.maxSize:]
+	}
+}
+
+func (sb *naiveBuffer) Bytes() []byte {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	out := make([]byte, len(sb.buf))
+	copy(out, sb.buf)
+	return out
+}
+
+// benchWriteSize is the size of each write in the benchmarks.
+const benchWriteSize = 512
+
+// sampleOutput returns n bytes of colored terminal output with multi-byte
+// characters and line breaks.
+func sampleOutput(n int) []byte {
+	const line = "\x1b[32m✔\x1b[0m compiled \x1b[1mmodule\x1b[22m in 12ms — ok\r\n"
+	out := make([]byte, 0, n+len(line))
+	for len(out) < n {
+		out = append(out, line...)
+	}
+	return out[:n]
+}
+
+func fill(write func([]byte), chunk []byte, size int) {
+	for n := 0; n < 2*size; n += len(chunk) {
+		write(chunk)
+	}
+}
+
+func BenchmarkNaiveWrite(b *testing.B) {
+	sb := newNaiveBuffer(DefaultScrollbackSize)
+	chunk := sampleOutput(benchWriteSize)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(chunk)))
+	for i := 0; i < b.N; i++ {
+		sb.Write(chunk)
+	}
+}
+
+func BenchmarkScrollbackWrite(b *testing.B) {
+	sb := NewScrollbackBuffer(DefaultScrollbackSize)
+	chunk := sampleOutput(benchWriteSize)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(chunk)))
+	for i := 0; i < b.N; i++ {
+		sb.Write(chunk)
+	}
+}
+
+func BenchmarkNaiveAttach(b *testing.B) {
+	sb := newNaiveBuffer(DefaultScrollbackSize)
+	fill(sb.Write, sampleOutput(benchWriteSize), DefaultScrollbackSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		io.Discard.Write(sb.Bytes())
+	}
+}
+
+func BenchmarkScrollbackAttach(b *testing.B) {
+	sb := NewScrollbackBuffer(DefaultScrollbackSize)
+	fill(sb.Write, sampleOutput(benchWriteSize), DefaultScrollbackSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v := sb.View()
+		v.WriteTo(io.Discard)
+		v.Release()
+	}
+}
+
+func BenchmarkScrollbackRead(b *testing.B) {
+	sb := NewScrollbackBuffer(DefaultScrollbackSize)
+	fill(sb.Write, sampleOutput(benchWriteSize), DefaultScrollbackSize)
+	buf := make([]byte, 32*1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := sb.NewReader()
+		for {
+			if _, err := r.Read(buf); err != nil {
+				break
+			}
+		}
+	}
+}
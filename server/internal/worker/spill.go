@@ -0,0 +1,199 @@
+package worker
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/moltty/server/internal/vt"
+)
+
+// spillArea stores the scrollback and screen state of evicted relays on disk, one
+// file per session. Its contents only live as long as the process.
+type spillArea struct {
+	dir string
+}
+
+// newSpillArea prepares dir, discarding files left behind by a previous process.
+func newSpillArea(dir string) (*spillArea, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &spillArea{dir: dir}, nil
+}
+
+func (a *spillArea) path(sessionID uuid.UUID) string {
+	return filepath.Join(a.dir, sessionID.String()+".spill")
+}
+
+// spilledRelay is the state saved for an evicted relay.
+type spilledRelay struct {
+	scrollback []byte
+	end        int64  // stream offset of the end of scrollback
+	screen     []byte // vt snapshot including history
+	cols, rows int
+}
+
+// save writes a relay's state. The file is a header line followed by the raw
+// scrollback and the screen snapshot.
+func (a *spillArea) save(sessionID uuid.UUID, st spilledRelay) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "spill2 %d %d %d %d %d\n", st.cols, st.rows, st.end, len(st.scrollback), len(st.screen))
+	buf.Write(st.scrollback)
+	buf.Write(st.screen)
+
+	tmp := a.path(sessionID) + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.path(sessionID))
+}
+
+// load reads and removes a relay's saved state.
+func (a *spillArea) load(sessionID uuid.UUID) (spilledRelay, error) {
+	var st spilledRelay
+	data, err := os.ReadFile(a.path(sessionID))
+	if err != nil {
+		return st, err
+	}
+	header, body, ok := bytes.Cut(data, []byte("\n"))
+	if !ok {
+		return st, fmt.Errorf("spill file for %s has no header", sessionID)
+	}
+	var scrollbackLen, screenLen int
+	if _, err := fmt.Sscanf(string(header), "spill2 %d %d %d %d %d", &st.cols, &st.rows, &st.end, &scrollbackLen, &screenLen); err != nil {
+		return st, fmt.Errorf("spill file for %s: %w", sessionID, err)
+	}
+	if scrollbackLen+screenLen != len(body) {
+		return st, fmt.Errorf("spill file for %s is truncated", sessionID)
+	}
+	st.scrollback = body[:scrollbackLen]
+	st.screen = body[scrollbackLen:]
+	a.remove(sessionID)
+	return st, nil
+}
+
+func (a *spillArea) remove(sessionID uuid.UUID) {
+	os.Remove(a.path(sessionID))
+}
+
+// StartSpillLoop periodically enforces the memory budget set with SetMemoryBudget.
+func (h *Hub) StartSpillLoop(interval time.Duration) {
+	if h.spill == nil || h.memBudget <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			h.enforceMemoryBudget()
+		}
+	}()
+}
+
+// enforceMemoryBudget spills the least recently used relays without viewers or
+// followers until the total memory held by relays is within budget.
+func (h *Hub) enforceMemoryBudget() {
+	h.mu.RLock()
+	relays := make([]*SessionRelay, 0, len(h.sessions))
+	for _, relay := range h.sessions {
+		relays = append(relays, relay)
+	}
+	h.mu.RUnlock()
+
+	type candidate struct {
+		relay    *SessionRelay
+		size     int64
+		lastUsed time.Time
+	}
+	var total int64
+	var candidates []candidate
+	for _, relay := range relays {
+		relay.mu.Lock()
+		size := relay.memSize()
+		total += size
+		if size > 0 && len(relay.Viewers) == 0 && len(relay.followers) == 0 {
+			candidates = append(candidates, candidate{relay, size, relay.lastUsed})
+		}
+		relay.mu.Unlock()
+	}
+	if total <= h.memBudget {
+		return
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].lastUsed.Before(candidates[j].lastUsed)
+	})
+	spilled := 0
+	for _, c := range candidates {
+		if total <= h.memBudget {
+			break
+		}
+		relay := c.relay
+		relay.mu.Lock()
+		// Skip relays that became active or gained a viewer or follower since they
+		// were measured.
+		idle := len(relay.Viewers) == 0 && len(relay.followers) == 0 && relay.lastUsed.Equal(c.lastUsed)
+		if !relay.removed && idle && h.spillRelay(relay) {
+			total -= c.size
+			spilled++
+		}
+		relay.mu.Unlock()
+	}
+	if spilled > 0 {
+		log.Printf("hub: spilled %d relays to disk, %d bytes still in memory", spilled, total)
+	}
+}
+
+// spillRelay writes a relay's scrollback and screen to the spill area and frees
+// them. Callers must hold relay.mu.
+func (h *Hub) spillRelay(relay *SessionRelay) bool {
+	if relay.spilled {
+		return false
+	}
+	cols, rows := relay.Screen.Size()
+	_, end := relay.Scrollback.Offsets()
+	st := spilledRelay{
+		scrollback: relay.Scrollback.Bytes(),
+		end:        end,
+		screen:     relay.Screen.Snapshot(math.MaxInt),
+		cols:       cols,
+		rows:       rows,
+	}
+	if err := h.spill.save(relay.SessionID, st); err != nil {
+		log.Printf("hub: failed to spill session %s: %v", relay.SessionID, err)
+		return false
+	}
+	relay.Scrollback.ReplaceAt(nil, end)
+	relay.Screen = nil
+	relay.spilled = true
+	return true
+}
+
+// unspill loads an evicted relay back into memory. If the spill file can't be read
+// the relay starts over empty. Callers must hold relay.mu.
+func (h *Hub) unspill(relay *SessionRelay) {
+	if !relay.spilled {
+		return
+	}
+	relay.spilled = false
+
+	st, err := h.spill.load(relay.SessionID)
+	if err != nil {
+		log.Printf("hub: failed to load spilled session %s: %v", relay.SessionID, err)
+		relay.Screen = vt.New(vt.DefaultCols, vt.DefaultRows, h.historyLines)
+		return
+	}
+	relay.Scrollback.ReplaceAt(st.scrollback, st.end)
+	relay.Screen = vt.New(st.cols, st.rows, h.historyLines)
+	relay.Screen.Write(st.screen)
+}
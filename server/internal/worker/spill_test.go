@@ -0,0 +1,198 @@
+package worker
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/moltty/server/internal/session"
+)
+
+// newTestHub returns a hub without repositories, with a spill area in a temp dir.
+func newTestHub(t *testing.T, budget int64) *Hub {
+	t.Helper()
+	h := NewHub(nil, nil, 64*1024)
+	if err := h.SetMemoryBudget(budget, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// addRelay adds a relay that received output to the hub.
+func addRelay(h *Hub, output string) *SessionRelay {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	relay := h.newRelay(uuid.New(), uuid.New(), uuid.New())
+	relay.Scrollback.Write([]byte(output))
+	relay.Screen.Write([]byte(output))
+	h.sessions[relay.SessionID] = relay
+	return relay
+}
+
+func TestSpillRoundTrip(t *testing.T) {
+	h := newTestHub(t, 1)
+	relay := addRelay(h, "line one\r\n\x1b[1mline two\x1b[0m\r\n$ ")
+
+	relay.mu.Lock()
+	defer relay.mu.Unlock()
+	relay.Screen.Resize(40, 10)
+	wantScrollback := relay.Scrollback.Bytes()
+	wantStart, wantEnd := relay.Scrollback.Offsets()
+	wantScreen := relay.Screen.Snapshot(math.MaxInt)
+
+	if !h.spillRelay(relay) {
+		t.Fatal("spillRelay() = false")
+	}
+	if !relay.spilled || relay.memSize() != 0 {
+		t.Fatalf("after spilling, spilled = %v and memSize = %d", relay.spilled, relay.memSize())
+	}
+	if h.spillRelay(relay) {
+		t.Error("spilling a spilled relay succeeded")
+	}
+
+	h.unspill(relay)
+	if relay.spilled {
+		t.Fatal("relay still spilled after unspill")
+	}
+	if got := relay.Scrollback.Bytes(); !bytes.Equal(got, wantScrollback) {
+		t.Errorf("scrollback = %q, want %q", got, wantScrollback)
+	}
+	if start, end := relay.Scrollback.Offsets(); start != wantStart || end != wantEnd {
+		t.Errorf("offsets = %d-%d, want %d-%d", start, end, wantStart, wantEnd)
+	}
+	if cols, rows := relay.Screen.Size(); cols != 40 || rows != 10 {
+		t.Errorf("screen size = %dx%d, want 40x10", cols, rows)
+	}
+	if got := relay.Screen.Snapshot(math.MaxInt); !bytes.Equal(got, wantScreen) {
+		t.Errorf("screen snapshot = %q, want %q", got, wantScreen)
+	}
+	if _, err := os.Stat(h.spill.path(relay.SessionID)); !os.IsNotExist(err) {
+		t.Errorf("spill file left behind: %v", err)
+	}
+}
+
+func TestEnforceMemoryBudget(t *testing.T) {
+	tests := []struct {
+		name      string
+		viewer    bool
+		follower  bool
+		removed   bool
+		wantSpill bool
+	}{
+		{name: "idle", wantSpill: true},
+		{name: "with a viewer", viewer: true},
+		{name: "with a follower", follower: true},
+		{name: "removed", removed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHub(t, 1)
+			relay := addRelay(h, "some output\r\n")
+			relay.mu.Lock()
+			if tt.viewer {
+				relay.Viewers[&ViewerConn{}] = true
+			}
+			if tt.follower {
+				relay.followers = map[*follower]struct{}{{wake: make(chan struct{}, 1)}: {}}
+			}
+			if tt.removed {
+				h.releaseRelay(relay)
+			}
+			relay.mu.Unlock()
+
+			h.enforceMemoryBudget()
+
+			relay.mu.Lock()
+			defer relay.mu.Unlock()
+			if relay.spilled != tt.wantSpill {
+				t.Errorf("spilled = %v, want %v", relay.spilled, tt.wantSpill)
+			}
+		})
+	}
+}
+
+func TestEnforceMemoryBudgetLeastRecentlyUsed(t *testing.T) {
+	h := newTestHub(t, 0)
+	older := addRelay(h, "older output\r\n")
+	newer := addRelay(h, "newer output\r\n")
+	older.lastUsed = time.Now().Add(-time.Hour)
+
+	// Room for one of the two relays.
+	older.mu.Lock()
+	h.memBudget = older.memSize()
+	older.mu.Unlock()
+	h.enforceMemoryBudget()
+
+	if !older.spilled || newer.spilled {
+		t.Errorf("spilled older = %v, newer = %v; want only the older relay", older.spilled, newer.spilled)
+	}
+}
+
+func TestRemoveSession(t *testing.T) {
+	h := newTestHub(t, 1)
+	relay := addRelay(h, "output\r\n")
+	relay.mu.Lock()
+	h.spillRelay(relay)
+	relay.mu.Unlock()
+
+	h.RemoveSession(relay.SessionID)
+
+	if _, exists := h.sessions[relay.SessionID]; exists {
+		t.Error("relay still in the hub")
+	}
+	if !relay.removed || relay.spilled || relay.Screen != nil {
+		t.Errorf("removed = %v, spilled = %v, screen freed = %v", relay.removed, relay.spilled, relay.Screen == nil)
+	}
+	if _, err := os.Stat(h.spill.path(relay.SessionID)); !os.IsNotExist(err) {
+		t.Errorf("spill file left behind: %v", err)
+	}
+	// Removing it again is a no-op.
+	h.RemoveSession(relay.SessionID)
+}
+
+func TestCollectRelays(t *testing.T) {
+	const grace = 10 * time.Minute
+	tests := []struct {
+		name        string
+		stoppedAgo  time.Duration // zero while running
+		usedAgo     time.Duration
+		viewer      bool
+		follower    bool
+		wantCollect bool
+	}{
+		{name: "stopped past the grace period", stoppedAgo: time.Hour, usedAgo: time.Hour, wantCollect: true},
+		{name: "still running", usedAgo: time.Hour},
+		{name: "stopped recently", stoppedAgo: time.Minute, usedAgo: time.Hour},
+		{name: "used recently", stoppedAgo: time.Hour, usedAgo: time.Minute},
+		{name: "with a viewer", stoppedAgo: time.Hour, usedAgo: time.Hour, viewer: true},
+		{name: "with a follower", stoppedAgo: time.Hour, usedAgo: time.Hour, follower: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHub(t, 1)
+			relay := addRelay(h, "output\r\n")
+			now := time.Now()
+			relay.lastUsed = now.Add(-tt.usedAgo)
+			if tt.stoppedAgo > 0 {
+				relay.stoppedAt = now.Add(-tt.stoppedAgo)
+				relay.setStatus(session.StatusStopped, relay.stoppedAt)
+			}
+			if tt.viewer {
+				relay.Viewers[&ViewerConn{}] = true
+			}
+			if tt.follower {
+				relay.followers = map[*follower]struct{}{{wake: make(chan struct{}, 1)}: {}}
+			}
+
+			h.collectRelays(grace)
+
+			_, kept := h.sessions[relay.SessionID]
+			if kept == tt.wantCollect || relay.removed != tt.wantCollect {
+				t.Errorf("kept = %v, removed = %v; want collected = %v", kept, relay.removed, tt.wantCollect)
+			}
+		})
+	}
+}
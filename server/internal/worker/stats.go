@@ -0,0 +1,110 @@
+package worker
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/moltty/server/internal/session"
+)
+
+// relayStats accumulates a session's activity counters between flushes to the
+// database.
+type relayStats struct {
+	bytesIn      int64
+	bytesOut     int64
+	running      time.Duration
+	runningSince time.Time // zero while the session isn't running
+	inputAt      time.Time // zero if no input since the last flush
+	outputAt     time.Time // zero if no output since the last flush
+}
+
+// setStatus records a status change, accounting running time. Callers must hold
+// r.mu.
+func (r *SessionRelay) setStatus(status session.Status, now time.Time) {
+	r.status = status
+	st := &r.stats
+	if status == session.StatusRunning {
+		if st.runningSince.IsZero() {
+			st.runningSince = now
+		}
+		return
+	}
+	if !st.runningSince.IsZero() {
+		st.running += now.Sub(st.runningSince)
+		st.runningSince = time.Time{}
+	}
+}
+
+// takeStats returns the counters gathered since the last call and resets them.
+// Callers must hold r.mu.
+func (r *SessionRelay) takeStats(now time.Time) session.ActivityStats {
+	st := &r.stats
+	if !st.runningSince.IsZero() {
+		st.running += now.Sub(st.runningSince)
+		st.runningSince = now
+	}
+	out := session.ActivityStats{
+		BytesIn:      st.bytesIn,
+		BytesOut:     st.bytesOut,
+		Running:      st.running,
+		LastInputAt:  st.inputAt,
+		LastOutputAt: st.outputAt,
+	}
+	st.bytesIn, st.bytesOut, st.running = 0, 0, 0
+	st.inputAt, st.outputAt = time.Time{}, time.Time{}
+	return out
+}
+
+// StartStatsLoop periodically writes the sessions' activity counters to the
+// database.
+func (h *Hub) StartStatsLoop(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			h.flushStats()
+		}
+	}()
+}
+
+func (h *Hub) flushStats() {
+	h.mu.RLock()
+	relays := make([]*SessionRelay, 0, len(h.sessions))
+	for _, relay := range h.sessions {
+		relays = append(relays, relay)
+	}
+	h.mu.RUnlock()
+
+	now := time.Now()
+	for _, relay := range relays {
+		h.flushRelayStats(relay, now)
+	}
+	h.splitUsage(now)
+}
+
+// flushRelayStats writes a relay's counters to its session and its open usage
+// interval.
+func (h *Hub) flushRelayStats(relay *SessionRelay, now time.Time) {
+	relay.mu.Lock()
+	stats := relay.takeStats(now)
+	running := relay.status == session.StatusRunning
+	workerID := relay.WorkerID
+	relay.mu.Unlock()
+
+	if stats.IsZero() {
+		return
+	}
+	if err := h.sessionRepo.AddActivityStats(relay.SessionID, stats); err != nil {
+		log.Printf("hub: failed to store activity stats for session %s: %v", relay.SessionID, err)
+	}
+	if stats.BytesIn > 0 || stats.BytesOut > 0 {
+		h.addUsageBytes(relay, workerID, running, stats, now)
+	}
+}
+
+// decodedLen returns the length of the data a base64 string decodes to.
+func decodedLen(encoded string) int64 {
+	n := len(encoded) / 4 * 3
+	return int64(n - strings.Count(encoded[max(len(encoded)-2, 0):], "="))
+}
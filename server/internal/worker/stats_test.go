@@ -0,0 +1,80 @@
+package worker
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/moltty/server/internal/session"
+)
+
+func TestRelayStatsRunningTime(t *testing.T) {
+	h := NewHub(nil, nil, 1024)
+	relay := addRelay(h, "")
+	start := time.Now()
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	relay.setStatus(session.StatusRunning, at(0))
+	relay.setStatus(session.StatusRunning, at(time.Minute)) // already running
+	if got := relay.takeStats(at(2 * time.Minute)).Running; got != 2*time.Minute {
+		t.Errorf("first flush: running = %v, want 2m", got)
+	}
+	relay.setStatus(session.StatusOffline, at(3*time.Minute))
+	relay.setStatus(session.StatusStopped, at(5*time.Minute))
+	if got := relay.takeStats(at(6 * time.Minute)).Running; got != time.Minute {
+		t.Errorf("second flush: running = %v, want 1m", got)
+	}
+	if got := relay.takeStats(at(7 * time.Minute)); !got.IsZero() {
+		t.Errorf("flush while stopped = %+v, want nothing", got)
+	}
+
+	// A resumed session counts again from its restart.
+	relay.setStatus(session.StatusRunning, at(10*time.Minute))
+	if got := relay.takeStats(at(10*time.Minute + 30*time.Second)).Running; got != 30*time.Second {
+		t.Errorf("after resuming: running = %v, want 30s", got)
+	}
+}
+
+func TestRelayStatsInputAndOutput(t *testing.T) {
+	h := NewHub(nil, nil, 1024)
+	relay := addRelay(h, "")
+	wc := startWorkerConn(relay.WorkerID, relay.UserID, &fakeWorker{})
+	defer wc.close()
+	h.workers[wc.WorkerID] = wc
+
+	enc := base64.StdEncoding.EncodeToString
+	if err := h.SendInput(relay.SessionID, enc([]byte("ls -la\r"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SendInput(relay.SessionID, enc([]byte("y"))); err != nil {
+		t.Fatal(err)
+	}
+	// Input the server sends itself isn't the user's activity.
+	if err := h.sendInput(relay.SessionID, enc([]byte("\x03")), false); err != nil {
+		t.Fatal(err)
+	}
+	relay.mu.Lock()
+	relay.stats.bytesOut += 42
+	relay.stats.outputAt = time.Now()
+	relay.mu.Unlock()
+
+	stats := relay.takeStats(time.Now())
+	if stats.BytesIn != 8 || stats.BytesOut != 42 {
+		t.Errorf("bytes in, out = %d, %d; want 8, 42", stats.BytesIn, stats.BytesOut)
+	}
+	if stats.LastInputAt.IsZero() || stats.LastOutputAt.IsZero() {
+		t.Errorf("last input at %v, last output at %v; want both set", stats.LastInputAt, stats.LastOutputAt)
+	}
+	if again := relay.takeStats(time.Now()); !again.IsZero() {
+		t.Errorf("second flush = %+v, want nothing", again)
+	}
+}
+
+func TestDecodedLen(t *testing.T) {
+	for _, s := range []string{"", "a", "ab", "abc", "abcd", "\x1b[A", "hello, world"} {
+		encoded := base64.StdEncoding.EncodeToString([]byte(s))
+		if got := decodedLen(encoded); got != int64(len(s)) {
+			t.Errorf("decodedLen(%q) = %d, want %d", encoded, got, len(s))
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package worker
+
+import (
+	"encoding/base64"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/moltty/server/internal/session"
+)
+
+// Defaults for session timeout enforcement.
+const (
+	DefaultTimeoutWarning = 5 * time.Minute
+	DefaultKillGrace      = 15 * time.Second
+)
+
+// SetSessionTimeouts sets the server's default idle timeout and maximum lifetime,
+// how long before a timeout viewers are warned, and how long a session gets to
+// exit after Ctrl-C before it is killed.
+func (h *Hub) SetSessionTimeouts(defaults session.TimeoutPolicy, warnBefore, killGrace time.Duration) {
+	h.timeouts = defaults
+	if warnBefore > 0 {
+		h.timeoutWarning = warnBefore
+	}
+	if killGrace > 0 {
+		h.killGrace = killGrace
+	}
+}
+
+// StartTimeoutLoop periodically stops running sessions that exceeded their idle
+// timeout or maximum lifetime.
+func (h *Hub) StartTimeoutLoop(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			h.enforceTimeouts(now)
+		}
+	}()
+}
+
+func (h *Hub) enforceTimeouts(now time.Time) {
+	sessions, err := h.sessionRepo.FindRunningWorkerSessions()
+	if err != nil {
+		log.Printf("hub: failed to list running sessions: %v", err)
+		return
+	}
+
+	users := make(map[uuid.UUID]*session.UserTimeouts)
+	for i := range sessions {
+		sess := &sessions[i]
+		user, ok := users[sess.UserID]
+		if !ok {
+			if user, err = h.sessionRepo.FindUserTimeouts(sess.UserID); err != nil {
+				log.Printf("hub: failed to load timeouts for user %s: %v", sess.UserID, err)
+			}
+			users[sess.UserID] = user
+		}
+
+		st := h.TimeoutStatus(sess, user)
+		if st.Deadline == nil {
+			continue
+		}
+		// Short limits get their warning halfway, so that an active session isn't
+		// warned over and over.
+		limit := st.MaxLifetime
+		if st.Reason == session.TimeoutIdle {
+			limit = st.IdleTimeout
+		}
+		warnAt := min(h.timeoutWarning, time.Duration(limit)*time.Second/2)
+
+		remaining := st.Deadline.Sub(now)
+		switch {
+		case remaining <= 0:
+			h.expire(sess.ID, st.Reason)
+		case remaining <= warnAt:
+			h.warnTimeout(sess.ID, *st.Deadline, st.Reason, remaining)
+		}
+	}
+}
+
+// TimeoutStatus reports a session's timeout limits and remaining time under the
+// server defaults and the owner's overrides, which may be nil.
+func (h *Hub) TimeoutStatus(sess *session.Session, user *session.UserTimeouts) session.TimeoutStatus {
+	policy := session.ResolveTimeouts(h.timeouts, user, sess)
+	return policy.Status(sess, h.lastActive(sess), time.Now())
+}
+
+// lastActive returns when a session last had input or output. Activity from
+// before the hub started is unknown, so the hub's start time is a lower bound.
+func (h *Hub) lastActive(sess *session.Session) time.Time {
+	last := h.startedAt
+	if sess.StartedAt != nil && sess.StartedAt.After(last) {
+		last = *sess.StartedAt
+	}
+
+	h.mu.RLock()
+	relay, exists := h.sessions[sess.ID]
+	h.mu.RUnlock()
+	if exists {
+		relay.mu.Lock()
+		for _, t := range []time.Time{relay.lastInput, relay.lastOutput} {
+			if t.After(last) {
+				last = t
+			}
+		}
+		relay.mu.Unlock()
+	}
+	return last
+}
+
+// lifetimeExpired reports whether an offline session used up its maximum lifetime.
+// users caches the owners' overrides across calls.
+func (h *Hub) lifetimeExpired(sess *session.Session, users map[uuid.UUID]*session.UserTimeouts) bool {
+	user, ok := users[sess.UserID]
+	if !ok {
+		var err error
+		if user, err = h.sessionRepo.FindUserTimeouts(sess.UserID); err != nil {
+			log.Printf("hub: failed to load timeouts for user %s: %v", sess.UserID, err)
+		}
+		users[sess.UserID] = user
+	}
+	return session.ResolveTimeouts(h.timeouts, user, sess).LifetimeExpired(sess, time.Now())
+}
+
+// stopExpired marks an offline session that reached its maximum lifetime as
+// stopped instead of resuming it.
+func (h *Hub) stopExpired(sess *session.Session) {
+	log.Printf("hub: not resuming session %s: it reached its lifetime timeout", sess.ID)
+	sess.Status = session.StatusStopped
+	if err := h.sessionRepo.Update(sess); err != nil {
+		log.Printf("hub: failed to stop session %s: %v", sess.ID, err)
+		return
+	}
+
+	h.mu.RLock()
+	relay, exists := h.sessions[sess.ID]
+	h.mu.RUnlock()
+	if exists {
+		relay.mu.Lock()
+		relay.stoppedAt = time.Now()
+		relay.setStatus(session.StatusStopped, relay.stoppedAt)
+		relay.mu.Unlock()
+		relay.broadcast(ViewerMessage{Type: ViewerMsgTimeout, Event: "expired", Reason: session.TimeoutLifetime})
+		relay.broadcast(ViewerMessage{Type: ViewerMsgStatus, Status: string(session.StatusStopped)})
+	}
+	h.publishStatus(sess.UserID, sess.ID, session.StatusStopped, nil)
+}
+
+// warnTimeout tells viewers once per deadline that the session will be stopped.
+func (h *Hub) warnTimeout(sessionID uuid.UUID, deadline time.Time, reason string, remaining time.Duration) {
+	h.mu.RLock()
+	relay, exists := h.sessions[sessionID]
+	h.mu.RUnlock()
+	if !exists {
+		return
+	}
+
+	relay.mu.Lock()
+	warned := relay.warnedFor.Equal(deadline)
+	relay.warnedFor = deadline
+	relay.mu.Unlock()
+	if warned {
+		return
+	}
+	relay.broadcast(ViewerMessage{
+		Type:      ViewerMsgTimeout,
+		Event:     "warning",
+		Reason:    reason,
+		Remaining: int64(remaining.Round(time.Second) / time.Second),
+	})
+}
+
+// expire stops a session that reached its timeout.
+func (h *Hub) expire(sessionID uuid.UUID, reason string) {
+	relay, err := h.relayFor(sessionID)
+	if err != nil {
+		return
+	}
+
+	relay.mu.Lock()
+	killing := relay.killing
+	relay.killing = true
+	relay.mu.Unlock()
+	if killing {
+		return
+	}
+
+	log.Printf("hub: session %s reached its %s timeout, stopping it", sessionID, reason)
+	relay.broadcast(ViewerMessage{Type: ViewerMsgTimeout, Event: "expired", Reason: reason})
+	h.GracefulKill(sessionID, h.killGrace)
+}
+
+// GracefulKill interrupts a session's process with Ctrl-C and kills it if it is
+// still running after grace.
+func (h *Hub) GracefulKill(sessionID uuid.UUID, grace time.Duration) {
+	if err := h.sendInput(sessionID, base64.StdEncoding.EncodeToString([]byte{0x03}), false); err != nil {
+		log.Printf("hub: failed to interrupt session %s: %v", sessionID, err)
+	}
+
+	time.AfterFunc(grace, func() {
+		h.mu.RLock()
+		relay, exists := h.sessions[sessionID]
+		h.mu.RUnlock()
+		if !exists {
+			return
+		}
+
+		relay.mu.Lock()
+		running := relay.status == session.StatusRunning && !relay.removed
+		relay.mu.Unlock()
+		if running {
+			log.Printf("hub: session %s did not exit after Ctrl-C, killing it", sessionID)
+			if err := h.KillSession(sessionID); err != nil {
+				log.Printf("hub: failed to kill session %s: %v", sessionID, err)
+				// Let the next timeout check try again.
+				relay.mu.Lock()
+				relay.killing = false
+				relay.mu.Unlock()
+			}
+		}
+	})
+}
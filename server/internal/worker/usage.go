@@ -0,0 +1,106 @@
+package worker
+
+import (
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/moltty/server/internal/session"
+	"github.com/moltty/server/internal/usage"
+)
+
+// SetUsageRepository enables usage metering: every run of a session is recorded as
+// an interval with the bytes relayed in it.
+func (h *Hub) SetUsageRepository(r *usage.Repository) {
+	h.usageRepo = r
+}
+
+// startUsage opens a usage interval for a session that started on a worker.
+func (h *Hub) startUsage(sessionID, userID, workerID uuid.UUID, at time.Time) {
+	if h.usageRepo == nil {
+		return
+	}
+	if err := h.usageRepo.Start(sessionID, userID, workerID, at); err != nil {
+		log.Printf("hub: failed to start usage interval for session %s: %v", sessionID, err)
+	}
+}
+
+// endUsage flushes a session's counters into its open usage interval and closes it.
+// relay may be nil.
+func (h *Hub) endUsage(sessionID uuid.UUID, relay *SessionRelay, at time.Time, reason string) {
+	if h.usageRepo == nil {
+		return
+	}
+	if relay != nil {
+		h.flushRelayStats(relay, at)
+	}
+	if err := h.usageRepo.End(sessionID, at, reason); err != nil {
+		log.Printf("hub: failed to end usage interval for session %s: %v", sessionID, err)
+	}
+}
+
+// addUsageBytes adds relayed bytes to a session's open interval. A running session
+// without one, e.g. after a server restart, gets a new interval.
+func (h *Hub) addUsageBytes(relay *SessionRelay, workerID uuid.UUID, running bool, stats session.ActivityStats, now time.Time) {
+	if h.usageRepo == nil {
+		return
+	}
+	ok, err := h.usageRepo.AddBytes(relay.SessionID, stats.BytesIn, stats.BytesOut)
+	if err != nil {
+		log.Printf("hub: failed to store usage for session %s: %v", relay.SessionID, err)
+		return
+	}
+	if ok || !running {
+		return
+	}
+	err = h.usageRepo.Create(&usage.Interval{
+		SessionID: relay.SessionID,
+		UserID:    relay.UserID,
+		WorkerID:  workerID,
+		StartedAt: now.Add(-stats.Running),
+		BytesIn:   stats.BytesIn,
+		BytesOut:  stats.BytesOut,
+	})
+	if err != nil {
+		log.Printf("hub: failed to start usage interval for session %s: %v", relay.SessionID, err)
+	}
+}
+
+// splitUsage ends open intervals that started before today (UTC) at midnight, so
+// that each interval falls on a single day. Sessions that are still running carry
+// on in a new interval; others were lost track of and are closed.
+func (h *Hub) splitUsage(now time.Time) {
+	if h.usageRepo == nil {
+		return
+	}
+	midnight := now.UTC().Truncate(24 * time.Hour)
+	ivs, err := h.usageRepo.FindOpenBefore(midnight)
+	if err != nil {
+		log.Printf("hub: failed to find usage intervals to split: %v", err)
+		return
+	}
+
+	for i := range ivs {
+		iv := &ivs[i]
+		h.mu.RLock()
+		relay, exists := h.sessions[iv.SessionID]
+		h.mu.RUnlock()
+
+		running := false
+		if exists {
+			relay.mu.Lock()
+			running = relay.status == session.StatusRunning
+			relay.mu.Unlock()
+		}
+		reason := usage.EndDay
+		if !running {
+			reason = usage.EndLost
+		}
+		// Intervals that span several days (the hub was down) are split one day at a
+		// time, on consecutive ticks.
+		at := iv.StartedAt.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		if err := h.usageRepo.Split(iv, at, reason, running); err != nil {
+			log.Printf("hub: failed to split usage interval %s: %v", iv.ID, err)
+		}
+	}
+}
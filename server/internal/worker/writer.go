@@ -0,0 +1,188 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
+	"github.com/moltty/server/internal/metrics"
+)
+
+// Priority orders messages on a worker connection. Lower values are sent first.
+type Priority int
+
+const (
+	// PriorityHigh is for keystrokes and signals (input, resize, kill).
+	PriorityHigh Priority = iota
+	// PriorityNormal is for control traffic such as spawn and ping.
+	PriorityNormal
+
+	numPriorities
+)
+
+// Queue sizes per priority; senders wait up to sendTimeout when a queue is full.
+var queueSizes = [numPriorities]int{256, 256}
+
+// sendTimeout is how long Send waits for room in a full queue before giving up.
+var sendTimeout = 2 * time.Second
+
+var (
+	workerQueueDepth = metrics.NewGaugeVec("moltty_worker_queue_depth",
+		"Messages waiting to be written to a worker connection.", "worker")
+	workerMessages = metrics.NewCounter("moltty_worker_messages_total",
+		"Messages written to worker connections.")
+)
+
+var (
+	// ErrWorkerClosed is returned when sending to a worker whose connection has closed.
+	ErrWorkerClosed = errors.New("worker connection closed")
+	// ErrWorkerBusy is returned when a worker's queue stays full for sendTimeout.
+	ErrWorkerBusy = errors.New("worker queue full")
+)
+
+// messageWriter is the part of a websocket connection the writer uses.
+type messageWriter interface {
+	WriteMessage(messageType int, data []byte) error
+	Close() error
+}
+
+// newWorkerConn wraps a worker connection and starts its writer goroutine.
+func newWorkerConn(workerID, userID uuid.UUID, conn *websocket.Conn) *WorkerConn {
+	wc := startWorkerConn(workerID, userID, conn)
+	wc.Conn = conn
+	return wc
+}
+
+// startWorkerConn starts a writer goroutine that writes to out.
+func startWorkerConn(workerID, userID uuid.UUID, out messageWriter) *WorkerConn {
+	wc := &WorkerConn{
+		WorkerID:   workerID,
+		UserID:     userID,
+		SessionIDs: make(map[uuid.UUID]bool),
+		out:        out,
+		done:       make(chan struct{}),
+		depth:      workerQueueDepth.With(workerID.String()),
+	}
+	for i := range wc.queues {
+		wc.queues[i] = make(chan []byte, queueSizes[i])
+	}
+	go wc.writeLoop()
+	return wc
+}
+
+// Send queues a message for the worker. It waits up to sendTimeout while the
+// queue for the priority is full and fails once the connection is closed.
+// Callers must handle ErrWorkerBusy: the message was not sent.
+func (wc *WorkerConn) Send(p Priority, msg ServerMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-wc.done:
+		return ErrWorkerClosed
+	default:
+	}
+	if !wc.addQueued(1) {
+		return ErrWorkerClosed
+	}
+	select {
+	case wc.queues[p] <- data:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(sendTimeout)
+	defer timer.Stop()
+	select {
+	case wc.queues[p] <- data:
+		return nil
+	case <-wc.done:
+		wc.addQueued(-1)
+		return ErrWorkerClosed
+	case <-timer.C:
+		wc.addQueued(-1)
+		log.Printf("hub: dropping %s message for worker %s: queue full", msg.Type, wc.WorkerID)
+		return ErrWorkerBusy
+	}
+}
+
+// addQueued tracks messages entering and leaving the queues. Once the writer has
+// stopped nothing more is counted, and it reports false.
+func (wc *WorkerConn) addQueued(n int64) bool {
+	wc.queueMu.Lock()
+	defer wc.queueMu.Unlock()
+	if wc.drained {
+		return false
+	}
+	wc.queued += n
+	wc.depth.Add(n)
+	return true
+}
+
+// queueDepth returns the number of messages waiting to be written.
+func (wc *WorkerConn) queueDepth() int64 {
+	wc.queueMu.Lock()
+	defer wc.queueMu.Unlock()
+	return wc.queued
+}
+
+// close stops the writer. Queued messages are discarded.
+func (wc *WorkerConn) close() {
+	wc.closeOnce.Do(func() {
+		close(wc.done)
+	})
+}
+
+// writeLoop is the only goroutine writing to the worker connection. It always
+// drains higher priority queues before looking at lower ones.
+func (wc *WorkerConn) writeLoop() {
+	defer wc.discard()
+	for {
+		data, ok := wc.next()
+		if !ok {
+			return
+		}
+		wc.addQueued(-1)
+		if err := wc.out.WriteMessage(websocket.TextMessage, data); err != nil {
+			log.Printf("hub: failed to write to worker %s: %v", wc.WorkerID, err)
+			// Closing the connection makes the read loop fail and unregister the worker.
+			wc.out.Close()
+			wc.close()
+			return
+		}
+		workerMessages.Inc()
+	}
+}
+
+// discard drops whatever is still queued when the writer stops. Messages that
+// Send queues afterwards are never counted.
+func (wc *WorkerConn) discard() {
+	wc.queueMu.Lock()
+	defer wc.queueMu.Unlock()
+	wc.depth.Add(-wc.queued)
+	wc.queued = 0
+	wc.drained = true
+}
+
+// next returns the highest priority queued message, waiting if all queues are empty.
+func (wc *WorkerConn) next() ([]byte, bool) {
+	for _, q := range wc.queues {
+		select {
+		case data := <-q:
+			return data, true
+		default:
+		}
+	}
+	select {
+	case data := <-wc.queues[PriorityHigh]:
+		return data, true
+	case data := <-wc.queues[PriorityNormal]:
+		return data, true
+	case <-wc.done:
+		return nil, false
+	}
+}
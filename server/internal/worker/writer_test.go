@@ -0,0 +1,197 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// fakeWorker records the messages written to it. While gate is set, every write
+// waits for a value on it.
+type fakeWorker struct {
+	gate chan struct{}
+
+	mu       sync.Mutex
+	written  []string
+	closed   bool
+	writeErr error
+}
+
+func (f *fakeWorker) WriteMessage(_ int, data []byte) error {
+	if f.gate != nil {
+		<-f.gate
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.writeErr != nil {
+		return f.writeErr
+	}
+	var msg ServerMessage
+	json.Unmarshal(data, &msg)
+	f.written = append(f.written, msg.Data)
+	return nil
+}
+
+func (f *fakeWorker) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fakeWorker) messages() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.written...)
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWorkerConnPriority(t *testing.T) {
+	type send struct {
+		p    Priority
+		data string
+	}
+	tests := []struct {
+		name  string
+		sends []send
+		want  []string
+	}{
+		{
+			name:  "high before normal",
+			sends: []send{{PriorityNormal, "n1"}, {PriorityHigh, "h1"}, {PriorityNormal, "n2"}, {PriorityHigh, "h2"}},
+			want:  []string{"h1", "h2", "n1", "n2"},
+		},
+		{
+			name:  "fifo within a priority",
+			sends: []send{{PriorityHigh, "a"}, {PriorityHigh, "b"}, {PriorityHigh, "c"}},
+			want:  []string{"a", "b", "c"},
+		},
+		{
+			name:  "normal only",
+			sends: []send{{PriorityNormal, "x"}, {PriorityNormal, "y"}},
+			want:  []string{"x", "y"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fw := &fakeWorker{gate: make(chan struct{})}
+			wc := startWorkerConn(uuid.New(), uuid.New(), fw)
+			defer wc.close()
+
+			// Hold the writer on a first message so the rest pile up in the queues.
+			if err := wc.Send(PriorityNormal, ServerMessage{Data: "first"}); err != nil {
+				t.Fatal(err)
+			}
+			waitFor(t, func() bool { return wc.queueDepth() == 0 })
+			for _, s := range tt.sends {
+				if err := wc.Send(s.p, ServerMessage{Data: s.data}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := wc.queueDepth(); got != int64(len(tt.sends)) {
+				t.Errorf("queue depth = %d, want %d", got, len(tt.sends))
+			}
+			close(fw.gate)
+
+			want := append([]string{"first"}, tt.want...)
+			waitFor(t, func() bool { return len(fw.messages()) == len(want) })
+			if got := fw.messages(); !reflect.DeepEqual(got, want) {
+				t.Errorf("written = %q, want %q", got, want)
+			}
+			if got := wc.queueDepth(); got != 0 {
+				t.Errorf("queue depth after writing = %d, want 0", got)
+			}
+		})
+	}
+}
+
+func TestWorkerConnSendFailures(t *testing.T) {
+	defer func(sizes [numPriorities]int, timeout time.Duration) {
+		queueSizes, sendTimeout = sizes, timeout
+	}(queueSizes, sendTimeout)
+	queueSizes = [numPriorities]int{1, 1}
+	sendTimeout = 20 * time.Millisecond
+
+	tests := []struct {
+		name      string
+		close     bool // close the connection before the last send
+		wantErr   error
+		wantDepth int64
+	}{
+		{name: "queue stays full", wantErr: ErrWorkerBusy, wantDepth: 1},
+		{name: "connection closed", close: true, wantErr: ErrWorkerClosed, wantDepth: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fw := &fakeWorker{gate: make(chan struct{})}
+			defer close(fw.gate)
+			wc := startWorkerConn(uuid.New(), uuid.New(), fw)
+			defer wc.close()
+
+			// One message held by the writer, one filling the queue.
+			wc.Send(PriorityHigh, ServerMessage{Data: "held"})
+			waitFor(t, func() bool { return wc.queueDepth() == 0 })
+			if err := wc.Send(PriorityHigh, ServerMessage{Data: "queued"}); err != nil {
+				t.Fatal(err)
+			}
+			if tt.close {
+				wc.close()
+				fw.gate <- struct{}{}
+				waitFor(t, func() bool { return wc.queueDepth() == 0 })
+			}
+
+			err := wc.Send(PriorityHigh, ServerMessage{Data: "extra"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Send() = %v, want %v", err, tt.wantErr)
+			}
+			if got := wc.queueDepth(); got != tt.wantDepth {
+				t.Errorf("queue depth = %d, want %d", got, tt.wantDepth)
+			}
+			if got := wc.depth.Value(); got != tt.wantDepth {
+				t.Errorf("gauge = %d, want %d", got, tt.wantDepth)
+			}
+		})
+	}
+}
+
+func TestWorkerConnWriteError(t *testing.T) {
+	fw := &fakeWorker{writeErr: errors.New("broken pipe")}
+	wc := startWorkerConn(uuid.New(), uuid.New(), fw)
+
+	wc.Send(PriorityNormal, ServerMessage{Data: "lost"})
+	waitFor(t, func() bool {
+		fw.mu.Lock()
+		defer fw.mu.Unlock()
+		return fw.closed
+	})
+	<-wc.done
+
+	// Nothing sent after the writer stopped is counted.
+	waitFor(t, func() bool {
+		wc.queueMu.Lock()
+		defer wc.queueMu.Unlock()
+		return wc.drained
+	})
+	if err := wc.Send(PriorityHigh, ServerMessage{Data: "late"}); !errors.Is(err, ErrWorkerClosed) {
+		t.Errorf("Send() after close = %v, want ErrWorkerClosed", err)
+	}
+	if got := wc.depth.Value(); got != 0 {
+		t.Errorf("gauge = %d, want 0", got)
+	}
+}